@@ -0,0 +1,368 @@
+package csvql
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/expression"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/parse"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+// Advice recommends persisting an index on a column, or keeping the file of
+// its table sorted by it, given the queries that would benefit from it.
+//
+// Costs are estimated in rows read or compared: a full scan of a table costs
+// as many rows as it has, an index lookup as many as there are rows per
+// distinct value, and sorting n rows n*log2(n). Joins run as nested loops
+// unless the inner side is indexed.
+type Advice struct {
+	Table, Column string
+	// Kind is "index" for columns compared by equality, including in joins,
+	// and "sort" for the ones in ranges and ORDER BY clauses.
+	Kind string
+	// Queries is the number of queries benefiting from the advice, and Cost
+	// and CostWith their estimated cost without and with it.
+	Queries        int
+	Cost, CostWith int64
+}
+
+// Benefit returns the fraction of the cost saved by following the advice.
+func (a *Advice) Benefit() float64 {
+	if a.Cost == 0 {
+		return 0
+	}
+	return float64(a.Cost-a.CostWith) / float64(a.Cost)
+}
+
+// AdviceSchema is the schema of the rows describing advice.
+var AdviceSchema = sql.Schema{
+	{Name: "table", Type: sql.Text},
+	{Name: "column", Type: sql.Text},
+	{Name: "kind", Type: sql.Text},
+	{Name: "queries", Type: sql.Int64},
+	{Name: "cost", Type: sql.Int64},
+	{Name: "cost_with", Type: sql.Int64},
+	{Name: "benefit", Type: sql.Text},
+}
+
+// Row returns the advice as a row with AdviceSchema.
+func (a *Advice) Row() sql.Row {
+	return sql.NewRow(a.Table, a.Column, a.Kind, int64(a.Queries), a.Cost, a.CostWith,
+		formatValue(math.Round(a.Benefit()*1000)/10)+"%")
+}
+
+func init() {
+	statements = append(statements, statement{
+		re:  regexp.MustCompile(`(?is)^\s*show\s+index\s+advice\s*;?\s*$`),
+		run: (*Engine).showAdvice,
+	})
+}
+
+// showAdvice returns the advice for the recent queries run by the server on
+// the current database.
+func (e *Engine) showAdvice(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	db, err := e.Catalog.Database(e.Analyzer.CurrentDatabase)
+	if err != nil {
+		return nil, nil, err
+	}
+	advice, err := Advise(ctx, db, e.log.queries())
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not advise: %v", err)
+	}
+	rows := make([]sql.Row, len(advice))
+	for i, adv := range advice {
+		rows[i] = adv.Row()
+	}
+	return AdviceSchema, sql.RowsToRowIter(rows...), nil
+}
+
+// Advise analyzes the given queries, run against the tables in db, and
+// returns the indexes and sort orders that would most reduce their cost,
+// from the most to the least beneficial. Queries that can not be parsed,
+// such as csvql specific statements, are ignored.
+func Advise(ctx *sql.Context, db sql.Database, queries []string) ([]*Advice, error) {
+	keys, err := ForeignKeys(db)
+	if err != nil {
+		return nil, err
+	}
+	a := &advisor{
+		ctx:    ctx,
+		db:     db,
+		tables: db.Tables(),
+		keys:   keys,
+		stats:  make(map[string]*tableStats),
+		advice: make(map[adviceKey]*Advice),
+	}
+	for _, q := range queries {
+		branches, _, _ := splitUnion(q)
+		for _, b := range branches {
+			node, err := parse.Parse(ctx, rewrite(b))
+			if err != nil {
+				continue
+			}
+			if err := a.analyze(node); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var advice []*Advice
+	for _, adv := range a.advice {
+		if adv.CostWith < adv.Cost {
+			advice = append(advice, adv)
+		}
+	}
+	sort.Slice(advice, func(i, j int) bool {
+		si, sj := advice[i].Cost-advice[i].CostWith, advice[j].Cost-advice[j].CostWith
+		if si != sj {
+			return si > sj
+		}
+		if advice[i].Table != advice[j].Table {
+			return advice[i].Table < advice[j].Table
+		}
+		return advice[i].Column < advice[j].Column
+	})
+	return advice, nil
+}
+
+type adviceKey struct{ table, column, kind string }
+
+// tableStats are the number of rows in a table, and an estimate of the
+// distinct values in each of its columns.
+type tableStats struct {
+	rows     int64
+	distinct map[string]int64
+}
+
+type advisor struct {
+	ctx    *sql.Context
+	db     sql.Database
+	tables map[string]sql.Table
+	keys   []*ForeignKey
+	stats  map[string]*tableStats
+	advice map[adviceKey]*Advice
+	// seen are the advice that already count the query being analyzed.
+	seen map[adviceKey]bool
+	// aliases maps the names used in the query being analyzed to tables.
+	aliases map[string]string
+}
+
+// analyze adds the costs of a query to the advice that would reduce them.
+func (a *advisor) analyze(node sql.Node) error {
+	a.seen = make(map[adviceKey]bool)
+	a.aliases = make(map[string]string)
+	plan.Inspect(node, func(n sql.Node) bool {
+		switch n := n.(type) {
+		case *plan.TableAlias:
+			if t, ok := n.Child.(*plan.UnresolvedTable); ok && a.tables[t.Name()] != nil {
+				a.aliases[n.Name()] = t.Name()
+			}
+		case *plan.UnresolvedTable:
+			if a.tables[n.Name()] != nil {
+				a.aliases[n.Name()] = n.Name()
+			}
+		}
+		return true
+	})
+
+	var err error
+	plan.Inspect(node, func(n sql.Node) bool {
+		if err != nil {
+			return false
+		}
+		switch n := n.(type) {
+		case *plan.Filter:
+			err = a.condition(n.Expression)
+		case *plan.InnerJoin:
+			err = a.condition(n.Cond)
+		case *plan.Sort:
+			if len(n.SortFields) > 0 {
+				if t, col := a.column(n.SortFields[0].Column); t != "" {
+					err = a.order(t, col)
+				}
+			}
+		}
+		return err == nil
+	})
+	return err
+}
+
+// condition adds the costs of the comparisons in a condition.
+func (a *advisor) condition(e sql.Expression) error {
+	switch e := e.(type) {
+	case *expression.And:
+		if err := a.condition(e.Left); err != nil {
+			return err
+		}
+		return a.condition(e.Right)
+	case *expression.Equals:
+		lt, lc := a.column(e.Left())
+		rt, rc := a.column(e.Right())
+		switch {
+		case lt != "" && rt != "" && lt != rt:
+			return a.join(lt, lc, rt, rc)
+		case lt != "" && rt == "":
+			return a.lookup(lt, lc, 1)
+		case rt != "" && lt == "":
+			return a.lookup(rt, rc, 1)
+		}
+	case *expression.In:
+		if t, col := a.column(e.Left()); t != "" {
+			values := 1
+			if tuple, ok := e.Right().(expression.Tuple); ok {
+				values = len(tuple)
+			}
+			return a.lookup(t, col, values)
+		}
+	case *expression.GreaterThan, *expression.GreaterThanOrEqual,
+		*expression.LessThan, *expression.LessThanOrEqual:
+		c := e.(expression.Comparer)
+		lt, lc := a.column(c.Left())
+		rt, rc := a.column(c.Right())
+		switch {
+		case lt != "" && rt == "":
+			return a.rangeScan(lt, lc)
+		case rt != "" && lt == "":
+			return a.rangeScan(rt, rc)
+		}
+	case *expression.Between:
+		if t, col := a.column(e.Val); t != "" {
+			return a.rangeScan(t, col)
+		}
+	}
+	return nil
+}
+
+// column returns the table and name of the column an expression refers to,
+// or empty strings if it is not a column of a known table.
+func (a *advisor) column(e sql.Expression) (table, column string) {
+	c, ok := e.(*expression.UnresolvedColumn)
+	if !ok {
+		return "", ""
+	}
+	if c.Table() != "" {
+		table = a.aliases[c.Table()]
+		if table == "" || !a.tables[table].Schema().Contains(c.Name(), table) {
+			return "", ""
+		}
+		return table, c.Name()
+	}
+
+	// Unqualified columns must belong to a single table in the query.
+	for _, t := range a.aliases {
+		if a.tables[t].Schema().Contains(c.Name(), t) {
+			if table != "" && table != t {
+				return "", ""
+			}
+			table = t
+		}
+	}
+	if table == "" {
+		return "", ""
+	}
+	return table, c.Name()
+}
+
+// tableStats returns the statistics of a table, profiling it the first time
+// unless they were cached.
+func (a *advisor) tableStats(name string) (*tableStats, error) {
+	if s, ok := a.stats[name]; ok {
+		return s, nil
+	}
+	profiles, err := CachedProfile(a.ctx, a.db, name, 0)
+	if err != nil {
+		return nil, err
+	}
+	s := &tableStats{distinct: make(map[string]int64)}
+	for _, p := range profiles {
+		s.rows = p.Count
+		s.distinct[p.Name] = p.Distinct
+	}
+	a.stats[name] = s
+	return s, nil
+}
+
+// add adds the cost of a query, without and with the advice, to it.
+func (a *advisor) add(table, column, kind string, cost, with float64) {
+	k := adviceKey{table, column, kind}
+	adv, ok := a.advice[k]
+	if !ok {
+		adv = &Advice{Table: table, Column: column, Kind: kind}
+		a.advice[k] = adv
+	}
+	if !a.seen[k] {
+		a.seen[k] = true
+		adv.Queries++
+	}
+	adv.Cost += int64(cost)
+	adv.CostWith += int64(math.Ceil(with))
+}
+
+// lookup adds the cost of comparing a column to the given number of values.
+func (a *advisor) lookup(table, column string, values int) error {
+	s, err := a.tableStats(table)
+	if err != nil {
+		return err
+	}
+	n := float64(s.rows)
+	a.add(table, column, "index", n, float64(values)*n/math.Max(1, float64(s.distinct[column])))
+	return nil
+}
+
+// rangeSelectivity is the fraction of the rows assumed to be in a range.
+const rangeSelectivity = 1.0 / 3
+
+// rangeScan adds the cost of finding the rows in a range of a column.
+func (a *advisor) rangeScan(table, column string) error {
+	s, err := a.tableStats(table)
+	if err != nil {
+		return err
+	}
+	n := float64(s.rows)
+	a.add(table, column, "sort", n, n*rangeSelectivity+log2(n))
+	return nil
+}
+
+// order adds the cost of sorting the rows of a table by a column.
+func (a *advisor) order(table, column string) error {
+	s, err := a.tableStats(table)
+	if err != nil {
+		return err
+	}
+	n := float64(s.rows)
+	a.add(table, column, "sort", n+n*log2(n), n)
+	return nil
+}
+
+// join adds the cost of joining two tables on a column of each, which an
+// index on the column of the larger one would turn into lookups.
+func (a *advisor) join(lt, lc, rt, rc string) error {
+	ls, err := a.tableStats(lt)
+	if err != nil {
+		return err
+	}
+	rs, err := a.tableStats(rt)
+	if err != nil {
+		return err
+	}
+
+	outer, inner := JoinSide{lt, lc, ls.rows}, JoinSide{rt, rc, rs.rows}
+	if outer.Rows > inner.Rows {
+		outer, inner = inner, outer
+	}
+	rows, _ := EstimateJoinRows(a.keys, outer, inner)
+	a.add(inner.Table, inner.Column, "index",
+		float64(outer.Rows)*float64(inner.Rows), float64(outer.Rows+rows))
+	return nil
+}
+
+func log2(n float64) float64 {
+	if n < 2 {
+		return 1
+	}
+	return math.Log2(n)
+}
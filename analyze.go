@@ -0,0 +1,220 @@
+package csvql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/expression"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/parse"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+func init() {
+	table := namePart + `(?:\.` + namePart + ")?"
+	statements = append(statements,
+		statement{
+			re:  regexp.MustCompile(`(?is)^\s*analyze\s+(?:(?:no_write_to_binlog|local)\s+)?tables?\s+(` + table + `(?:\s*,\s*` + table + `)*)\s*;?\s*$`),
+			run: (*Engine).analyzeTables,
+		},
+		statement{
+			re:  regexp.MustCompile(`(?is)^\s*(?:explain|describe|desc)(?:\s+format\s*=\s*\w+)?\s+(select\s.*?)\s*;?\s*$`),
+			run: (*Engine).explain,
+		},
+	)
+}
+
+// AnalyzeSchema is the schema of the rows of ANALYZE TABLE, as in MySQL.
+var AnalyzeSchema = sql.Schema{
+	{Name: "Table", Type: sql.Text},
+	{Name: "Op", Type: sql.Text},
+	{Name: "Msg_type", Type: sql.Text},
+	{Name: "Msg_text", Type: sql.Text},
+}
+
+// analyzeTables runs ANALYZE TABLE t1[, t2...], reading all the rows of each
+// table to profile its columns, with their number of rows, minimum and
+// maximum values, estimated distinct values, and NULL values, as Warm does.
+// The profiles are kept under .csvql/stats until the files of the table
+// change, and used by SHOW TABLE STATUS, EXPLAIN, and the advisor. As in
+// MySQL, the tables that can not be analyzed are reported in their rows,
+// such as the ones the user can not change, or only read some rows of.
+func (e *Engine) analyzeTables(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	var rows []sql.Row
+	for _, name := range strings.Split(m[1], ",") {
+		name = strings.ToLower(strings.Replace(strings.TrimSpace(name), "`", "", -1))
+		msgType, msg := "status", "OK"
+		if err := e.analyzeTable(ctx, name); err != nil {
+			msgType, msg = "Error", err.Error()
+		}
+		rows = append(rows, sql.NewRow(name, "analyze", msgType, msg))
+	}
+	return AnalyzeSchema, sql.RowsToRowIter(rows...), nil
+}
+
+// analyzeTable profiles a table of the current database, or of the one its
+// name is qualified with, keeping its profiles.
+func (e *Engine) analyzeTable(ctx *sql.Context, name string) error {
+	if err := e.checkWrite(ctx, name); err != nil {
+		return err
+	}
+	if err := e.checkProfiles(ctx, name); err != nil {
+		return err
+	}
+	db, err := e.Catalog.Database(e.Analyzer.CurrentDatabase)
+	if err != nil {
+		return err
+	}
+	tname := name
+	if _, ok := db.Tables()[name]; !ok {
+		if dot := strings.Index(name, "."); dot > 0 {
+			if db, err = e.Catalog.Database(name[:dot]); err != nil {
+				return sql.ErrTableNotFound.New(name)
+			}
+			tname = name[dot+1:]
+		}
+	}
+	t, ok := db.Tables()[tname]
+	if !ok {
+		return sql.ErrTableNotFound.New(name)
+	}
+	cdb, isDB := db.(*database)
+	ct, isTable := t.(*table)
+	if !isDB || !isTable || len(ct.pseudo) > 0 || ct.archived() {
+		return fmt.Errorf("the profiles of table %s can not be kept", name)
+	}
+
+	// The files are checked before reading them, so the profiles are not
+	// taken for the ones of files changed meanwhile.
+	states, err := fileStates(ct)
+	if err != nil {
+		return fmt.Errorf("could not analyze %s: %v", name, err)
+	}
+	profiles, err := Profile(ctx, t, warmTopK)
+	if err != nil {
+		return fmt.Errorf("could not analyze %s: %v", name, err)
+	}
+	if err := writeStats(cdb.statsPath(tname), &cachedStats{states, warmTopK, profiles}); err != nil {
+		return fmt.Errorf("could not keep the profiles of %s: %v", name, err)
+	}
+	return nil
+}
+
+// explain runs EXPLAIN SELECT ..., returning the plan of a query, followed by
+// the number of rows of the tables it reads, and the profiles of the columns
+// it filters, joins, or sorts them by, when they are known, as after ANALYZE
+// TABLE, and the user can read all the rows of the tables.
+func (e *Engine) explain(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	var node sql.Node
+	branches, distinct, tail := splitUnion(m[1])
+	if len(branches) == 1 {
+		parsed, err := parse.Parse(ctx, rewrite(m[1]))
+		if err != nil {
+			return nil, nil, err
+		}
+		if node, err = e.Analyzer.Analyze(ctx, parsed); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		var err error
+		if node, err = e.union(ctx, branches, distinct, tail); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var rows []sql.Row
+	for _, line := range strings.Split(strings.TrimRight(node.String(), "\n"), "\n") {
+		rows = append(rows, sql.NewRow(line))
+	}
+	for _, line := range e.estimates(node) {
+		rows = append(rows, sql.NewRow(line))
+	}
+	return sql.Schema{{Name: "plan", Type: sql.Text}}, sql.RowsToRowIter(rows...), nil
+}
+
+// estimates describes the tables read by a plan whose profiles are known,
+// with their number of rows and the profiles of the columns used by the
+// expressions of the plan.
+func (e *Engine) estimates(node sql.Node) []string {
+	used := make(map[string]bool)
+	plan.InspectExpressions(node, func(ex sql.Expression) bool {
+		if f, ok := ex.(*expression.GetField); ok {
+			used[strings.ToLower(f.Table()+"."+f.Name())] = true
+		}
+		return true
+	})
+
+	var lines []string
+	seen := make(map[string]bool)
+	plan.Inspect(node, func(n sql.Node) bool {
+		rt, ok := n.(*plan.ResolvedTable)
+		if !ok {
+			return true
+		}
+		t := fileTable(rt.Table)
+		if t == nil || seen[t.name] || grantFiltered(rt.Table) {
+			return true
+		}
+		seen[t.name] = true
+		profiles, ok := e.tableStats(t)
+		if !ok || len(profiles) == 0 {
+			return true
+		}
+		lines = append(lines, fmt.Sprintf("%s: %d rows", t.name, profiles[0].Count))
+		for _, p := range profiles {
+			if !used[strings.ToLower(t.name+"."+p.Name)] {
+				continue
+			}
+			line := fmt.Sprintf("  %s: %d distinct, %.1f%% null", p.Name, p.Distinct, 100*p.NullRatio())
+			if p.Min != nil {
+				line += fmt.Sprintf(", from %v to %v", p.Min, p.Max)
+			}
+			lines = append(lines, line)
+		}
+		return true
+	})
+	return lines
+}
+
+// fileTable returns the table read from files a table of a plan is, or reads
+// with the grants of a user, or tracking the progress of the query, if any.
+func fileTable(t sql.Table) *table {
+	switch t := t.(type) {
+	case *table:
+		return t
+	case *grantedTable:
+		return fileTable(t.Table)
+	case *plan.ProcessTable:
+		return fileTable(t.Table)
+	}
+	return nil
+}
+
+// grantFiltered reports whether a table of a plan only reads the rows the
+// grants of the user let them, so its profiles, taken from all the rows, are
+// not shown.
+func grantFiltered(t sql.Table) bool {
+	switch t := t.(type) {
+	case *grantedTable:
+		return true
+	case *plan.ProcessTable:
+		return grantFiltered(t.Table)
+	}
+	return false
+}
+
+// tableStats returns the profiles kept for a table of the databases of the
+// engine, if its files did not change since they were computed.
+func (e *Engine) tableStats(t *table) ([]*ColumnProfile, bool) {
+	for _, db := range e.Catalog.Databases {
+		cdb, ok := db.(*database)
+		if !ok {
+			continue
+		}
+		if ct, ok := cdb.Tables()[t.name].(*table); ok && ct.path == t.path {
+			return cdb.freshStats(t.name, ct)
+		}
+	}
+	return nil, false
+}
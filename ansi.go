@@ -0,0 +1,98 @@
+package csvql
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// ansiQuotes reports whether the sql_mode of the session includes
+// ANSI_QUOTES, directly or through the ANSI combination mode.
+func ansiQuotes(ctx *sql.Context) bool {
+	_, v := ctx.Session.Get("sql_mode")
+	if v == nil {
+		return false
+	}
+	for _, mode := range strings.Split(fmt.Sprint(v), ",") {
+		switch strings.ToUpper(strings.TrimSpace(mode)) {
+		case "ANSI_QUOTES", "ANSI":
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteANSIQuotes replaces the identifiers delimited by double quotes in a
+// query by the same identifiers delimited by backticks, which is what the
+// parser understands. String literals, backticked identifiers and comments
+// are kept as they are.
+func rewriteANSIQuotes(query string) string {
+	if !strings.Contains(query, `"`) {
+		return query
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '#' || c == '-' && strings.HasPrefix(query[i:], "-- "):
+			// Copy the comment up to the end of its line.
+			j := strings.IndexByte(query[i:], '\n')
+			if j < 0 {
+				j = len(query) - i - 1
+			}
+			b.WriteString(query[i : i+j+1])
+			i += j
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			j := strings.Index(query[i+2:], "*/")
+			if j < 0 {
+				j = len(query) - i - 1
+			} else {
+				j += 3
+			}
+			b.WriteString(query[i : i+j+1])
+			i += j
+		case c == '\'' || c == '`':
+			// Copy the literal or identifier up to its closing quote.
+			j := i + 1
+			for ; j < len(query); j++ {
+				if c == '\'' && query[j] == '\\' {
+					j++
+					continue
+				}
+				if query[j] == c {
+					if j+1 < len(query) && query[j+1] == c {
+						j++
+						continue
+					}
+					break
+				}
+			}
+			if j >= len(query) {
+				j = len(query) - 1
+			}
+			b.WriteString(query[i : j+1])
+			i = j
+		case c == '"':
+			var name strings.Builder
+			j := i + 1
+			for ; j < len(query); j++ {
+				if query[j] == '"' {
+					if j+1 < len(query) && query[j+1] == '"' {
+						name.WriteByte('"')
+						j++
+						continue
+					}
+					break
+				}
+				name.WriteByte(query[j])
+			}
+			b.WriteString(quoteIdentifier(name.String()))
+			i = j
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,28 @@
+package csvql
+
+import "testing"
+
+func TestRewriteANSIQuotes(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{`SELECT "a b" FROM "t"`, "SELECT `a b` FROM `t`"},
+		{`SELECT "say ""hi""" FROM t`, "SELECT `say \"hi\"` FROM t"},
+		{`SELECT 'a "b"', "c" FROM t`, "SELECT 'a \"b\"', `c` FROM t"},
+		{`SELECT 'it\'s "x"', "c" FROM t`, "SELECT 'it\\'s \"x\"', `c` FROM t"},
+		{"SELECT `a \"b\"` FROM t", "SELECT `a \"b\"` FROM t"},
+		// Comments are kept as they are.
+		{"SELECT \"a\" -- the \"a\" column\nFROM t", "SELECT `a` -- the \"a\" column\nFROM t"},
+		{"SELECT \"a\" # it's \"a\"\nFROM \"t\"", "SELECT `a` # it's \"a\"\nFROM `t`"},
+		{`SELECT /* "a" isn't b */ "a" FROM t`, "SELECT /* \"a\" isn't b */ `a` FROM t"},
+		{`SELECT "a" FROM t -- "t"`, "SELECT `a` FROM t -- \"t\""},
+		{`SELECT "a" /* "t"`, "SELECT `a` /* \"t\""},
+		// A minus followed by another one is not a comment without a space.
+		{`SELECT "a"--"b" FROM t`, "SELECT `a`--`b` FROM t"},
+	}
+	for _, tt := range tests {
+		if got := rewriteANSIQuotes(tt.query); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package csvql
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// readFile is a file the rows of a table are read from, either on disk or in
+// an archive.
+type readFile interface {
+	io.ReadCloser
+	Name() string
+	Stat() (os.FileInfo, error)
+}
+
+// archive is an archive whose files are read as tables, without extracting
+// them.
+type archive interface {
+	// files returns the files in the archive, with their paths in it.
+	files() []string
+	// open returns a reader of the contents of a file in the archive.
+	open(name string) (io.ReadCloser, os.FileInfo, error)
+}
+
+// archives are the archives opened by the databases, by their paths. The
+// paths of the files in them are the ones of the archives followed by the
+// ones in the archives, as in data.zip/orders.csv.
+var archives = struct {
+	sync.RWMutex
+	m map[string]archive
+}{m: make(map[string]archive)}
+
+// isArchive reports whether a path is the one of an archive that can be read
+// as a database: a ZIP archive, or a tar one, which can be compressed, as in
+// bundle.tar.gz or bundle.tgz.
+func isArchive(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".zip", ".tar", ".tgz":
+		return true
+	}
+	base, c := SplitCompression(path)
+	return c != "" && strings.EqualFold(filepath.Ext(base), ".tar")
+}
+
+// openArchive opens the archive at a path, or returns the one already open.
+func openArchive(path string) (archive, error) {
+	path = filepath.Clean(path)
+	archives.Lock()
+	defer archives.Unlock()
+	if a, ok := archives.m[path]; ok {
+		return a, nil
+	}
+	var a archive
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		a, err = openZip(path)
+	} else {
+		a, err = openTar(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open archive %s: %v", path, err)
+	}
+	archives.m[path] = a
+	return a, nil
+}
+
+// archived returns the archive holding a file and the path of the file in
+// it, if it is in one.
+func archived(path string) (archive, string, bool) {
+	archives.RLock()
+	defer archives.RUnlock()
+	for dir, a := range archives.m {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return a, filepath.ToSlash(path[len(dir)+1:]), true
+		}
+	}
+	return nil, "", false
+}
+
+// openFile opens a file to read the rows of a table, which can be in one of
+// the archives opened, or an object streamed from a cloud storage service.
+func openFile(path string) (readFile, error) {
+	if remoteScheme(path) != "" {
+		return openObject(path)
+	}
+	a, name, ok := archived(path)
+	if !ok {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	r, info, err := a.open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &archivedFile{ReadCloser: r, name: path, info: info}, nil
+}
+
+// statFile returns the information of a file that can be in one of the
+// archives opened.
+func statFile(path string) (os.FileInfo, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+// archivedFile is a file being read from an archive.
+type archivedFile struct {
+	io.ReadCloser
+	name string
+	info os.FileInfo
+}
+
+func (f *archivedFile) Name() string               { return f.name }
+func (f *archivedFile) Stat() (os.FileInfo, error) { return f.info, nil }
+
+// archived reports whether the files of the table are in an archive, or
+// streamed from a cloud storage service, so rows can only be read from them
+// in order.
+func (t *table) archived() bool {
+	_, _, ok := archived(t.path)
+	return ok || remoteScheme(t.path) != ""
+}
+
+// archiveFormat reports whether the files of a format can be read from
+// archives, which only give their contents in order. Parquet files and
+// workbooks need to be read in any order.
+func archiveFormat(format string) bool {
+	return format != "parquet" && format != "xlsx"
+}
+
+// newArchiveDatabase returns a database with the tables of the files in an
+// archive. Since it has no folder where to keep them, it has no views nor
+// indexes.
+func newArchiveDatabase(name, path string) (sql.Database, error) {
+	db := &database{
+		name:   name,
+		path:   filepath.Clean(path),
+		tables: make(map[string]sql.Table),
+		views:  make(map[string]*view),
+		txs:    make(map[sql.Session]*transaction),
+	}
+	if err := db.addArchive(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// addArchive adds the tables of the files in an archive, named after their
+// paths in it, as addFiles does. Hidden files and folders, and the __MACOSX
+// folder that macOS adds to the archives it creates, are skipped.
+func (db *database) addArchive() error {
+	a, err := openArchive(db.path)
+	if err != nil {
+		return err
+	}
+	names := a.files()
+	sort.Strings(names)
+	for _, name := range names {
+		hidden := false
+		for _, part := range strings.Split(name, "/") {
+			hidden = hidden || strings.HasPrefix(part, ".") || part == "__MACOSX"
+		}
+		base, format := splitFormat(name)
+		if hidden || format == "" || !archiveFormat(format) {
+			continue
+		}
+		if dir := path.Dir(name); dir != "." {
+			base = strings.Replace(dir, "/", ".", -1) + "." + base
+		}
+		file := filepath.Join(db.path, filepath.FromSlash(name))
+		if err := db.addFile(file, base); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zipArchive is a ZIP archive, whose files are found in its central
+// directory, and only decompressed when they are read.
+type zipArchive struct {
+	entries map[string]*zip.File
+}
+
+func openZip(path string) (*zipArchive, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &zipArchive{entries: make(map[string]*zip.File)}
+	for _, f := range r.File {
+		if !f.FileInfo().IsDir() {
+			a.entries[f.Name] = f
+		}
+	}
+	return a, nil
+}
+
+func (a *zipArchive) files() []string {
+	var names []string
+	for name := range a.entries {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (a *zipArchive) open(name string) (io.ReadCloser, os.FileInfo, error) {
+	f, ok := a.entries[name]
+	if !ok {
+		return nil, nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, f.FileInfo(), nil
+}
+
+// tarArchive is a tar archive, which can be compressed. Since tar archives
+// have no central directory, the files are found by reading the archive from
+// its start, which is done again every time one of them is read.
+type tarArchive struct {
+	path        string
+	compression string
+	entries     map[string]os.FileInfo
+}
+
+func openTar(path string) (*tarArchive, error) {
+	a := &tarArchive{path: path, entries: make(map[string]os.FileInfo)}
+	if strings.EqualFold(filepath.Ext(path), ".tgz") {
+		a.compression = "gzip"
+	} else {
+		_, a.compression = SplitCompression(path)
+	}
+	r, err := a.scan(func(name string, hdr *tar.Header) bool {
+		a.entries[name] = hdr.FileInfo()
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.Close()
+	return a, nil
+}
+
+func (a *tarArchive) files() []string {
+	var names []string
+	for name := range a.entries {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (a *tarArchive) open(name string) (io.ReadCloser, os.FileInfo, error) {
+	info, ok := a.entries[name]
+	if !ok {
+		return nil, nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	r, err := a.scan(func(n string, hdr *tar.Header) bool { return n == name })
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, info, nil
+}
+
+// scan reads the regular files in the archive until found returns true for
+// one of them, and returns a reader of its contents, or of nothing if none
+// is found. The names of the files are cleaned, as in orders.csv for
+// ./orders.csv.
+func (a *tarArchive) scan(found func(name string, hdr *tar.Header) bool) (io.ReadCloser, error) {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := decompressReader(f, a.compression)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r := &tarReader{f: f, dr: dr, tr: tar.NewReader(dr)}
+	for {
+		hdr, err := r.tr.Next()
+		if err == io.EOF {
+			return r, nil
+		} else if err != nil {
+			r.Close()
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if found(strings.TrimPrefix(path.Clean("/"+hdr.Name), "/"), hdr) {
+			return r, nil
+		}
+	}
+}
+
+// tarReader reads a file in a tar archive.
+type tarReader struct {
+	f  *os.File
+	dr io.ReadCloser
+	tr *tar.Reader
+}
+
+func (r *tarReader) Read(p []byte) (int, error) { return r.tr.Read(p) }
+
+func (r *tarReader) Close() error {
+	r.dr.Close()
+	return r.f.Close()
+}
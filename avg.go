@@ -0,0 +1,98 @@
+package csvql
+
+import (
+	"fmt"
+	"math/big"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// newAvg returns the AVG(expr) aggregation, replacing the one of
+// go-mysql-server, which is NULL as soon as a value is. As in MySQL, NULL
+// values are skipped, and it is only NULL if they all are. The averages of
+// decimals are exact decimals, with 4 more digits of scale.
+func newAvg(arg sql.Expression) sql.Expression {
+	return &avg{arg}
+}
+
+type avg struct {
+	arg sql.Expression
+}
+
+func (a *avg) Resolved() bool             { return a.arg.Resolved() }
+func (a *avg) String() string             { return fmt.Sprintf("AVG(%s)", a.arg) }
+func (a *avg) IsNullable() bool           { return true }
+func (a *avg) Children() []sql.Expression { return []sql.Expression{a.arg} }
+
+func (a *avg) Type() sql.Type {
+	if d, ok := a.arg.Type().(decimalT); ok {
+		// As in MySQL, with its default div_precision_increment.
+		precision, scale := d.precision+4, d.scale+4
+		if precision > maxDecimalPrecision {
+			precision = maxDecimalPrecision
+		}
+		if scale > maxDecimalScale {
+			scale = maxDecimalScale
+		}
+		return decimalT{precision, scale}
+	}
+	return sql.Float64
+}
+
+func (a *avg) TransformUp(fn sql.TransformExprFunc) (sql.Expression, error) {
+	arg, err := a.arg.TransformUp(fn)
+	if err != nil {
+		return nil, err
+	}
+	return fn(&avg{arg})
+}
+
+// NewBuffer returns a buffer holding the sum of the values that are not NULL,
+// a float, or the exact number for decimals, and their number.
+func (a *avg) NewBuffer() sql.Row {
+	if _, ok := a.arg.Type().(decimalT); ok {
+		return sql.NewRow(new(big.Rat), int64(0))
+	}
+	return sql.NewRow(float64(0), int64(0))
+}
+
+func (a *avg) Update(ctx *sql.Context, buffer, row sql.Row) error {
+	v, err := a.arg.Eval(ctx, row)
+	if err != nil || v == nil {
+		return err
+	}
+	if _, ok := a.arg.Type().(decimalT); ok {
+		r, err := decimalRat(v)
+		if err != nil {
+			return fmt.Errorf("AVG: %v", err)
+		}
+		return a.Merge(ctx, buffer, sql.NewRow(r, int64(1)))
+	}
+	// As in MySQL, text that is not a number counts as 0.
+	f, err := sql.Float64.Convert(v)
+	if err != nil {
+		f = float64(0)
+	}
+	return a.Merge(ctx, buffer, sql.NewRow(f, int64(1)))
+}
+
+func (a *avg) Merge(ctx *sql.Context, buffer, partial sql.Row) error {
+	if r, ok := partial[0].(*big.Rat); ok {
+		buffer[0].(*big.Rat).Add(buffer[0].(*big.Rat), r)
+	} else {
+		buffer[0] = buffer[0].(float64) + partial[0].(float64)
+	}
+	buffer[1] = buffer[1].(int64) + partial[1].(int64)
+	return nil
+}
+
+func (a *avg) Eval(ctx *sql.Context, buffer sql.Row) (interface{}, error) {
+	if buffer[1].(int64) == 0 {
+		return nil, nil
+	}
+	if r, ok := buffer[0].(*big.Rat); ok {
+		n := new(big.Rat).SetInt64(buffer[1].(int64))
+		return a.Type().(decimalT).format(new(big.Rat).Quo(r, n))
+	}
+	return buffer[0].(float64) / float64(buffer[1].(int64)), nil
+}
@@ -0,0 +1,40 @@
+package csvql
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+func TestAvgDecimals(t *testing.T) {
+	e, _ := testEngine(t, map[string]string{"prices.csv": "item,price,qty\na,1.10,1\nb,2.25,2\nc,,\nd,3.00,4\n"})
+	queryRows(t, e, "CREATE TABLE exact (item TEXT, price DECIMAL(6,2))")
+	queryRows(t, e, "INSERT INTO exact VALUES ('a', 1.10), ('b', 2.25), ('c', NULL), ('d', 3.00)")
+
+	// As in MySQL, the average of DECIMAL(6,2) values is a DECIMAL(10,6).
+	schema, _, err := e.Query(sql.NewEmptyContext(), "SELECT AVG(price) FROM exact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(schema[0].Type); got != "DECIMAL(10,6)" {
+		t.Errorf("got type %s, want DECIMAL(10,6)", got)
+	}
+
+	tests := []struct {
+		query string
+		want  []sql.Row
+	}{
+		{"SELECT AVG(price) FROM exact", []sql.Row{{"2.116667"}}},
+		{"SELECT AVG(price) FROM exact WHERE price IS NULL", []sql.Row{{nil}}},
+		{"SELECT item, AVG(price) FROM exact GROUP BY item ORDER BY item", []sql.Row{{"a", "1.100000"}, {"b", "2.250000"}, {"c", nil}, {"d", "3.000000"}}},
+		// Other numbers are still averaged as floats.
+		{"SELECT AVG(qty) FROM prices", []sql.Row{{float64(7) / 3}}},
+	}
+	for _, tt := range tests {
+		if got := queryRows(t, e, tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
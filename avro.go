@@ -0,0 +1,611 @@
+package csvql
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// This file contains a reader of Avro object container files, whose schema
+// must be a record. See https://avro.apache.org/docs/current/spec.html for
+// the details of the format.
+
+const avroMagic = "Obj\x01"
+
+var errAvro = errors.New("invalid avro file")
+
+// avroSchema is the schema of an Avro value.
+type avroSchema struct {
+	// typ is the name of a primitive type, or record, enum, array, map,
+	// fixed, or union.
+	typ     string
+	logical string
+	scale   int
+	size    int
+	fields  []avroField
+	// items is the schema of the items of arrays or the values of maps.
+	items    *avroSchema
+	symbols  []string
+	branches []*avroSchema
+}
+
+type avroField struct {
+	name   string
+	schema *avroSchema
+}
+
+var avroPrimitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+// parseAvroSchema parses a schema decoded from JSON, given the named types
+// defined before it.
+func parseAvroSchema(v interface{}, names map[string]*avroSchema, namespace string) (*avroSchema, error) {
+	switch v := v.(type) {
+	case string:
+		if avroPrimitives[v] {
+			return &avroSchema{typ: v}, nil
+		}
+		if s, ok := names[v]; ok {
+			return s, nil
+		}
+		if s, ok := names[namespace+"."+v]; ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unknown type %s", v)
+	case []interface{}:
+		s := &avroSchema{typ: "union"}
+		for _, b := range v {
+			branch, err := parseAvroSchema(b, names, namespace)
+			if err != nil {
+				return nil, err
+			}
+			s.branches = append(s.branches, branch)
+		}
+		return s, nil
+	case map[string]interface{}:
+	default:
+		return nil, fmt.Errorf("invalid schema %v", v)
+	}
+
+	m := v.(map[string]interface{})
+	typ, _ := m["type"].(string)
+	if typ == "" || avroPrimitives[typ] {
+		s, err := parseAvroSchema(m["type"], names, namespace)
+		if err != nil {
+			return nil, err
+		}
+		if logical, ok := m["logicalType"].(string); ok {
+			c := *s
+			c.logical = logical
+			if scale, ok := m["scale"].(float64); ok {
+				c.scale = int(scale)
+			}
+			s = &c
+		}
+		return s, nil
+	}
+
+	s := &avroSchema{typ: typ}
+	if logical, ok := m["logicalType"].(string); ok {
+		s.logical = logical
+	}
+	if scale, ok := m["scale"].(float64); ok {
+		s.scale = int(scale)
+	}
+	switch typ {
+	case "record", "error", "enum", "fixed":
+		name, _ := m["name"].(string)
+		if ns, ok := m["namespace"].(string); ok && !strings.Contains(name, ".") {
+			namespace = ns
+		}
+		if !strings.Contains(name, ".") && namespace != "" {
+			name = namespace + "." + name
+		}
+		names[name] = s
+		names[name[strings.LastIndex(name, ".")+1:]] = s
+	}
+
+	var err error
+	switch typ {
+	case "record", "error":
+		s.typ = "record"
+		fields, _ := m["fields"].([]interface{})
+		for _, f := range fields {
+			f, _ := f.(map[string]interface{})
+			name, _ := f["name"].(string)
+			field := avroField{name: name}
+			if field.schema, err = parseAvroSchema(f["type"], names, namespace); err != nil {
+				return nil, err
+			}
+			s.fields = append(s.fields, field)
+		}
+	case "enum":
+		symbols, _ := m["symbols"].([]interface{})
+		for _, sym := range symbols {
+			name, _ := sym.(string)
+			s.symbols = append(s.symbols, name)
+		}
+	case "fixed":
+		size, _ := m["size"].(float64)
+		s.size = int(size)
+	case "array":
+		s.items, err = parseAvroSchema(m["items"], names, namespace)
+	case "map":
+		s.items, err = parseAvroSchema(m["values"], names, namespace)
+	default:
+		return nil, fmt.Errorf("unknown type %s", typ)
+	}
+	return s, err
+}
+
+// sqlType returns the type of the column holding values of the schema, which
+// is TEXT with the values as JSON for records, arrays, maps, and unions of
+// several types besides null.
+func (s *avroSchema) sqlType() sql.Type {
+	switch s.typ {
+	case "union":
+		var types []*avroSchema
+		for _, b := range s.branches {
+			if b.typ != "null" {
+				types = append(types, b)
+			}
+		}
+		if len(types) == 1 {
+			return types[0].sqlType()
+		}
+		return sql.Text
+	case "boolean":
+		return sql.Boolean
+	case "int":
+		if s.logical == "date" {
+			return sql.Date
+		}
+		return sql.Int32
+	case "long":
+		if strings.HasPrefix(s.logical, "timestamp-") || strings.HasPrefix(s.logical, "local-timestamp-") {
+			return sql.Timestamp
+		}
+		return sql.Int64
+	case "float":
+		return sql.Float32
+	case "double":
+		return sql.Float64
+	case "bytes", "fixed":
+		if s.logical == "decimal" {
+			return sql.Float64
+		}
+		return sql.Blob
+	default:
+		return sql.Text
+	}
+}
+
+// avroDecoder decodes the values in a block of an Avro file.
+type avroDecoder struct{ b []byte }
+
+func (d *avroDecoder) long() (int64, error) {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		return 0, errAvro
+	}
+	d.b = d.b[n:]
+	return v, nil
+}
+
+func (d *avroDecoder) bytes(n int64) ([]byte, error) {
+	if n < 0 || n > int64(len(d.b)) {
+		return nil, errAvro
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b, nil
+}
+
+// value decodes a value of the schema, which is nil, a bool, an int32, an
+// int64, a float32, a float64, a []byte, a string, a time.Time, a
+// map[string]interface{}, or a []interface{}.
+func (d *avroDecoder) value(s *avroSchema) (interface{}, error) {
+	switch s.typ {
+	case "null":
+		return nil, nil
+	case "boolean":
+		b, err := d.bytes(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case "int":
+		n, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if s.logical == "date" {
+			return time.Unix(n*24*60*60, 0).UTC(), nil
+		}
+		return int32(n), nil
+	case "long":
+		n, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		switch strings.TrimPrefix(s.logical, "local-") {
+		case "timestamp-millis":
+			return time.Unix(n/1e3, n%1e3*1e6).UTC(), nil
+		case "timestamp-micros":
+			return time.Unix(n/1e6, n%1e6*1e3).UTC(), nil
+		case "timestamp-nanos":
+			return time.Unix(0, n).UTC(), nil
+		}
+		return n, nil
+	case "float":
+		b, err := d.bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(b)), nil
+	case "double":
+		b, err := d.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case "bytes", "string", "fixed":
+		n := int64(s.size)
+		if s.typ != "fixed" {
+			var err error
+			if n, err = d.long(); err != nil {
+				return nil, err
+			}
+		}
+		b, err := d.bytes(n)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case s.logical == "decimal":
+			return decimalValue(b, int64(s.scale)), nil
+		case s.typ == "string":
+			return string(b), nil
+		}
+		return append([]byte{}, b...), nil
+	case "enum":
+		i, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(s.symbols)) {
+			return nil, errAvro
+		}
+		return s.symbols[i], nil
+	case "union":
+		i, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(s.branches)) {
+			return nil, errAvro
+		}
+		return d.value(s.branches[i])
+	case "record":
+		m := make(map[string]interface{}, len(s.fields))
+		for _, f := range s.fields {
+			v, err := d.value(f.schema)
+			if err != nil {
+				return nil, err
+			}
+			m[f.name] = v
+		}
+		return m, nil
+	case "array", "map":
+		var items []interface{}
+		m := make(map[string]interface{})
+		for {
+			n, err := d.long()
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				break
+			}
+			if n < 0 {
+				// The number of items is followed by their size.
+				n = -n
+				if _, err := d.long(); err != nil {
+					return nil, err
+				}
+			}
+			for i := int64(0); i < n; i++ {
+				var key string
+				if s.typ == "map" {
+					k, err := d.value(&avroSchema{typ: "string"})
+					if err != nil {
+						return nil, err
+					}
+					key = k.(string)
+				}
+				v, err := d.value(s.items)
+				if err != nil {
+					return nil, err
+				}
+				if s.typ == "map" {
+					m[key] = v
+				} else {
+					items = append(items, v)
+				}
+			}
+		}
+		if s.typ == "map" {
+			return m, nil
+		}
+		if items == nil {
+			items = []interface{}{}
+		}
+		return items, nil
+	}
+	return nil, errAvro
+}
+
+// avroFile is an Avro file being read.
+type avroFile struct {
+	f      readFile
+	r      *bufio.Reader
+	schema *avroSchema
+	codec  string
+	sync   []byte
+	// block holds the records left in the current block.
+	block avroDecoder
+	count int64
+}
+
+func openAvro(path string) (*avroFile, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %v", path, err)
+	}
+	af := &avroFile{f: f, r: bufio.NewReader(countingReader{f, &BytesRead})}
+	if err := af.readHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+	return af, nil
+}
+
+func (f *avroFile) readHeader() error {
+	magic := make([]byte, len(avroMagic))
+	if _, err := io.ReadFull(f.r, magic); err != nil || string(magic) != avroMagic {
+		return errAvro
+	}
+	meta := make(map[string][]byte)
+	for {
+		n, err := binary.ReadVarint(f.r)
+		if err != nil {
+			return errAvro
+		}
+		if n == 0 {
+			break
+		}
+		if n < 0 {
+			n = -n
+			if _, err := binary.ReadVarint(f.r); err != nil {
+				return errAvro
+			}
+		}
+		for i := int64(0); i < n; i++ {
+			key, err := f.readBytes()
+			if err != nil {
+				return err
+			}
+			value, err := f.readBytes()
+			if err != nil {
+				return err
+			}
+			meta[string(key)] = value
+		}
+	}
+	f.sync = make([]byte, 16)
+	if _, err := io.ReadFull(f.r, f.sync); err != nil {
+		return errAvro
+	}
+
+	var schema interface{}
+	if err := json.Unmarshal(meta["avro.schema"], &schema); err != nil {
+		return fmt.Errorf("invalid schema: %v", err)
+	}
+	s, err := parseAvroSchema(schema, make(map[string]*avroSchema), "")
+	if err != nil {
+		return fmt.Errorf("invalid schema: %v", err)
+	}
+	if s.typ != "record" {
+		return fmt.Errorf("the schema is a %s instead of a record", s.typ)
+	}
+	f.schema = s
+	f.codec = string(meta["avro.codec"])
+	switch f.codec {
+	case "", "null", "deflate", "snappy", "zstandard":
+	default:
+		return fmt.Errorf("%s compression not supported", f.codec)
+	}
+	return nil
+}
+
+func (f *avroFile) readBytes() ([]byte, error) {
+	n, err := binary.ReadVarint(f.r)
+	if err != nil || n < 0 || n > 1<<30 {
+		return nil, errAvro
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(f.r, b); err != nil {
+		return nil, errAvro
+	}
+	return b, nil
+}
+
+// next returns the fields of the next record.
+func (f *avroFile) next() (map[string]interface{}, error) {
+	for f.count == 0 {
+		n, err := binary.ReadVarint(f.r)
+		if err == io.EOF {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, errAvro
+		}
+		b, err := f.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		sync := make([]byte, 16)
+		if _, err := io.ReadFull(f.r, sync); err != nil || !bytes.Equal(sync, f.sync) {
+			return nil, errAvro
+		}
+		if b, err = f.decompress(b); err != nil {
+			return nil, err
+		}
+		f.block, f.count = avroDecoder{b}, n
+	}
+	f.count--
+	v, err := f.block.value(f.schema)
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]interface{}), nil
+}
+
+func (f *avroFile) decompress(b []byte) ([]byte, error) {
+	switch f.codec {
+	case "deflate":
+		return ioutil.ReadAll(flate.NewReader(bytes.NewReader(b)))
+	case "snappy":
+		// The block is followed by the CRC32 checksum of its contents.
+		if len(b) < 4 {
+			return nil, errAvro
+		}
+		d, err := snappy.Decode(nil, b[:len(b)-4])
+		if err != nil {
+			return nil, err
+		}
+		if crc32.ChecksumIEEE(d) != binary.BigEndian.Uint32(b[len(b)-4:]) {
+			return nil, errors.New("invalid checksum of snappy block")
+		}
+		return d, nil
+	case "zstandard":
+		return decodeZstd(b, 0)
+	}
+	return b, nil
+}
+
+func (f *avroFile) Close() error { return f.f.Close() }
+
+// avroColumns returns the columns of a table made of Avro files, which
+// must all have the same ones.
+func avroColumns(name string, paths []string) (sql.Schema, error) {
+	var schema sql.Schema
+	for i, path := range paths {
+		f, err := openAvro(path)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+		var cols sql.Schema
+		for _, field := range f.schema.fields {
+			cols = append(cols, &sql.Column{
+				Name:     strings.ToLower(field.name),
+				Type:     field.schema.sqlType(),
+				Nullable: field.schema.typ == "union" || field.schema.typ == "null",
+				Source:   name,
+			})
+		}
+		if i == 0 {
+			schema = cols
+			continue
+		}
+		same := len(cols) == len(schema)
+		for j := 0; same && j < len(cols); j++ {
+			same = cols[j].Name == schema[j].Name && cols[j].Type == schema[j].Type
+		}
+		if !same {
+			return nil, fmt.Errorf("could not add %s to table %s: its columns differ from the ones of %s", path, name, paths[0])
+		}
+	}
+	return schema, nil
+}
+
+// newAvroRowIter returns an iterator over the records of an Avro file
+// belonging to the table.
+func newAvroRowIter(t *table, path string) (sql.RowIter, error) {
+	f, err := openAvro(path)
+	if err != nil {
+		return nil, err
+	}
+	var src *rowSource
+	if len(t.pseudo) > 0 {
+		info, err := f.f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		src = &rowSource{path: path, info: info}
+	}
+	return &avroRowIter{t: t, f: f, src: src}, nil
+}
+
+type avroRowIter struct {
+	t   *table
+	f   *avroFile
+	src *rowSource
+}
+
+func (i *avroRowIter) Next() (sql.Row, error) {
+	record, err := i.f.next()
+	if err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", i.f.f.Name(), err)
+	}
+
+	fields := i.f.schema.fields
+	row := make(sql.Row, len(i.t.schema))
+	for j, col := range i.t.schema[:len(i.t.schema)-len(i.t.pseudo)] {
+		if j >= len(fields) {
+			break
+		}
+		v := record[fields[j].name]
+		if v != nil && col.Type == sql.Text {
+			switch v.(type) {
+			case map[string]interface{}, []interface{}:
+				b, err := json.Marshal(v)
+				if err != nil {
+					return nil, err
+				}
+				v = string(b)
+			default:
+				v = formatValue(v)
+			}
+		}
+		row[j] = v
+	}
+	if i.src != nil {
+		i.src.line++
+	}
+	for j, col := range i.t.pseudo {
+		row[len(row)-len(i.t.pseudo)+j] = col.value(i.src)
+	}
+	RowsRead.Add(1)
+	return row, nil
+}
+
+func (i *avroRowIter) Close() error { return i.f.Close() }
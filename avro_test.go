@@ -0,0 +1,108 @@
+package csvql
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// TestAvroRoundTrip reads the orders files, written by goavro with each codec
+// from the rows it expects.
+func TestAvroRoundTrip(t *testing.T) {
+	want := []sql.Row{
+		{
+			int64(1), "Ana", "gift", 4.5, float32(0.25), true,
+			time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 29, 13, 14, 15, 678000000, time.UTC),
+			time.Date(1969, 12, 31, 23, 59, 59, 999999000, time.UTC),
+			1234.56, -2.5, "SHIPPED", []byte{0, 255}, `["a","b"]`, `{"city":"Lisboa","zip":1000}`,
+		},
+		{
+			int64(-2), "Bo", nil, nil, float32(-1), false,
+			time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2038, 1, 19, 3, 14, 8, 1000, time.UTC),
+			0.0, 123456.789, "NEW", []byte{}, `[]`, nil,
+		},
+	}
+	for _, codec := range []string{"null", "deflate", "snappy"} {
+		got := tableRows(t, filepath.Join("testdata", "orders-"+codec+".avro"), "SELECT * FROM t ORDER BY id DESC")
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s codec: got rows\n%#v\nwant\n%#v", codec, got, want)
+		}
+	}
+}
+
+// TestAvroFixtures reads files of the tests of hamba/avro and goavro.
+func TestAvroFixtures(t *testing.T) {
+	// Arrays, maps, enums, fixed values and records, with the null and zstd
+	// codecs.
+	full := sql.Row{
+		`["string1","string2","string3","string4","string5"]`, `[1,2,3,4,5]`, "C",
+		`{"key1":1,"key2":2,"key3":3,"key4":4,"key5":5}`, "union value",
+		[]byte{1, 2, 3, 4, 1, 2, 3, 4, 1, 2, 3, 4, 1, 2, 3, 4},
+		`{"bool":true,"double":916734926348163,"float":7171.17,"int":666,"long":1925639126735,"string":"I am a test record"}`,
+	}
+	for _, file := range []string{"full.avro", "full-zstd.avro"} {
+		if got := tableRows(t, filepath.Join("testdata", file), "SELECT * FROM t"); !reflect.DeepEqual(got, []sql.Row{full}) {
+			t.Errorf("%s: got rows\n%#v\nwant\n%#v", file, got, []sql.Row{full})
+		}
+	}
+
+	// Many blocks compressed with deflate.
+	got := tableRows(t, filepath.Join("testdata", "quickstop-deflate.avro"), "SELECT COUNT(*), SUM(id), MIN(first), MAX(age) FROM t")
+	if want := []sql.Row{{int32(6001), float64(18009001), "Bob", int32(32)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("quickstop-deflate.avro: got %v, want %v", got, want)
+	}
+	got = tableRows(t, filepath.Join("testdata", "quickstop-deflate.avro"), "SELECT id, first, last, phone, age FROM t WHERE id = 2")
+	if want := []sql.Row{{int64(2), "Randal", "Graves", "(555) 123-5678", int32(30)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("quickstop-deflate.avro: got %v, want %v", got, want)
+	}
+}
+
+func TestAvroSnappyChecksum(t *testing.T) {
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddTable(db, "t="+filepath.Join("testdata", "snappy-invalid-crc.avro")); err != nil {
+		t.Fatal(err)
+	}
+	_, rows, err := NewEngine(db).Query(sql.NewEmptyContext(), "SELECT * FROM t")
+	if err == nil {
+		_, err = sql.RowIterToRows(rows)
+	}
+	if err == nil || !strings.Contains(err.Error(), "invalid checksum") {
+		t.Errorf("got error %v reading a snappy block with a wrong checksum, want an invalid checksum", err)
+	}
+}
+
+func TestAvroInvalidFiles(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "orders-null.avro"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The last block ends with a sync marker other than the one of the file.
+	sync := append([]byte(nil), b...)
+	sync[len(sync)-1] ^= 0xff
+	dir := t.TempDir()
+	for name, content := range map[string][]byte{
+		"text.avro":      []byte("id,name\n1,ana\n"),
+		"magic.avro":     []byte(avroMagic),
+		"truncated.avro": b[:len(b)-20],
+		"sync.avro":      sync,
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := tableError(t, path); err == nil || !strings.Contains(err.Error(), errAvro.Error()) {
+			t.Errorf("%s: got error %v, want %v", name, err, errAvro)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package csvql
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// azureTryTimeout is how long each request of the Azure Storage SDK, with
+// the reads of its response, can take before it is retried. Downloads taking
+// longer go on from where they were with new requests, while the ones that
+// stall fail after remoteTimeout, as the other requests to cloud storage
+// services do.
+const azureTryTimeout = time.Hour
+
+// azureStore reads the blobs in the containers of an Azure Storage account,
+// given in AZURE_STORAGE_ACCOUNT or AZURE_STORAGE_CONNECTION_STRING, as the
+// Azure CLI does.
+type azureStore struct {
+	endpoint string
+	// sas is the shared access signature added to the queries of the
+	// requests, when they are not signed with the account key.
+	sas      string
+	pipeline pipeline.Pipeline
+}
+
+// newAzureStore returns a store of the blobs in the Azure Storage account
+// given in the environment, authorized with its key or with a shared access
+// signature, if any.
+func newAzureStore() (objectStore, error) {
+	account := os.Getenv("AZURE_STORAGE_ACCOUNT")
+	key := os.Getenv("AZURE_STORAGE_KEY")
+	s := &azureStore{sas: os.Getenv("AZURE_STORAGE_SAS_TOKEN")}
+	if conn := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); conn != "" {
+		for _, part := range strings.Split(conn, ";") {
+			kv := strings.SplitN(part, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch strings.ToLower(strings.TrimSpace(kv[0])) {
+			case "accountname":
+				account = kv[1]
+			case "accountkey":
+				key = kv[1]
+			case "sharedaccesssignature":
+				s.sas = kv[1]
+			case "blobendpoint":
+				s.endpoint = strings.TrimSuffix(kv[1], "/")
+			}
+		}
+	}
+	if account == "" {
+		return nil, fmt.Errorf("could not find the Azure Storage account: set AZURE_STORAGE_ACCOUNT or AZURE_STORAGE_CONNECTION_STRING")
+	}
+	if s.endpoint == "" {
+		s.endpoint = "https://" + account + ".blob.core.windows.net"
+	}
+	s.sas = strings.TrimPrefix(s.sas, "?")
+
+	cred := azblob.NewAnonymousCredential()
+	if key != "" {
+		shared, err := azblob.NewSharedKeyCredential(account, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Azure Storage account key: %v", err)
+		}
+		cred, s.sas = shared, ""
+	}
+	// The SDK would otherwise write the requests that fail, such as the ones
+	// for missing blobs, to the system log.
+	pipeline.SetForceLogEnabled(false)
+	s.pipeline = azblob.NewPipeline(cred, azblob.PipelineOptions{
+		Retry:      azblob.RetryOptions{TryTimeout: azureTryTimeout},
+		HTTPSender: pipeline.FactoryFunc(sendAzure),
+	})
+	return s, nil
+}
+
+// sendAzure sends the requests of the Azure Storage SDK with sendRemote, so
+// they use the proxy and the timeouts of the other cloud storage services.
+func sendAzure(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+	return func(ctx context.Context, req pipeline.Request) (pipeline.Response, error) {
+		res, err := sendRemote(req.WithContext(ctx))
+		if err != nil {
+			err = pipeline.NewError(err, "HTTP request failed")
+		}
+		return pipeline.NewHTTPResponse(res), err
+	}
+}
+
+// container returns the URL of a container of the account.
+func (s *azureStore) container(name string) (azblob.ContainerURL, error) {
+	u, err := url.Parse(s.endpoint + "/" + url.PathEscape(name))
+	if err != nil {
+		return azblob.ContainerURL{}, fmt.Errorf("invalid Azure Storage endpoint: %v", err)
+	}
+	u.RawQuery = s.sas
+	return azblob.NewContainerURL(*u, s.pipeline), nil
+}
+
+func (s *azureStore) list(container, prefix string) ([]object, error) {
+	c, err := s.container(container)
+	if err != nil {
+		return nil, err
+	}
+	var objects []object
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		page, err := c.ListBlobsFlatSegment(context.Background(), marker, azblob.ListBlobsSegmentOptions{Prefix: prefix})
+		if err != nil {
+			return nil, azureError(err)
+		}
+		for _, b := range page.Segment.BlobItems {
+			o := object{key: b.Name, version: string(b.Properties.Etag), modified: b.Properties.LastModified}
+			if b.Properties.ContentLength != nil {
+				o.size = *b.Properties.ContentLength
+			}
+			objects = append(objects, o)
+		}
+		marker = page.NextMarker
+	}
+	return objects, nil
+}
+
+func (s *azureStore) stat(container, blob string) (object, error) {
+	c, err := s.container(container)
+	if err != nil {
+		return object{}, err
+	}
+	props, err := c.NewBlobURL(blob).GetProperties(context.Background(), azblob.BlobAccessConditions{})
+	if err != nil {
+		return object{}, azureError(err)
+	}
+	return object{
+		key:      blob,
+		version:  string(props.ETag()),
+		size:     props.ContentLength(),
+		modified: props.LastModified(),
+	}, nil
+}
+
+// open returns the body of the download of a blob, which the SDK requests
+// again from where it was read up to if the connection fails, as long as
+// the blob does not change.
+func (s *azureStore) open(container, blob string) (io.ReadCloser, error) {
+	c, err := s.container(container)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.NewBlobURL(blob).Download(context.Background(), 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		return nil, azureError(err)
+	}
+	return res.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3}), nil
+}
+
+// azureError returns the status and the error code of the failed requests
+// of the Azure Storage SDK, whose errors tell the whole request and response.
+func azureError(err error) error {
+	e, ok := err.(azblob.StorageError)
+	if !ok || e.Response() == nil {
+		return err
+	}
+	if code := e.ServiceCode(); code != "" {
+		return fmt.Errorf("%s: %s", e.Response().Status, code)
+	}
+	return fmt.Errorf("%s", e.Response().Status)
+}
@@ -0,0 +1,139 @@
+package csvql
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// fakeAzure serves the blobs of a container as the Blob service of an
+// emulator does, counting their downloads.
+type fakeAzure struct {
+	mu        sync.Mutex
+	blobs     map[string]string
+	downloads int
+	auths     []string
+}
+
+func (f *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.auths = append(f.auths, strings.SplitN(r.Header.Get("Authorization"), ":", 2)[0])
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat)
+	const prefix = "/account/container"
+	if r.URL.Path == prefix && r.URL.Query().Get("comp") == "list" {
+		type properties struct {
+			Etag     string `xml:"Etag"`
+			Size     int    `xml:"Content-Length"`
+			Modified string `xml:"Last-Modified"`
+		}
+		type blob struct {
+			Name       string
+			Properties properties
+		}
+		var page struct {
+			XMLName    xml.Name `xml:"EnumerationResults"`
+			Blobs      []blob   `xml:"Blobs>Blob"`
+			NextMarker string
+		}
+		for name, body := range f.blobs {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				page.Blobs = append(page.Blobs, blob{name, properties{`"1"`, len(body), modified}})
+			}
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(page)
+		return
+	}
+	body, ok := f.blobs[strings.TrimPrefix(r.URL.Path, prefix+"/")]
+	if !ok {
+		w.Header().Set("x-ms-error-code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("ETag", `"1"`)
+	w.Header().Set("Last-Modified", modified)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method == "GET" {
+		f.downloads++
+		w.Write([]byte(body))
+	}
+}
+
+func TestStreamAzureBlobs(t *testing.T) {
+	fake := &fakeAzure{blobs: map[string]string{
+		"logs/a.csv": "level\ninfo\nerror\n",
+		"logs/b.csv": "level\ninfo\n",
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	t.Setenv("AZURE_STORAGE_ACCOUNT", "")
+	t.Setenv("AZURE_STORAGE_KEY", "")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "AccountName=account;AccountKey=c2VjcmV0;BlobEndpoint="+srv.URL+"/account;")
+
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddTable(db, "logs=az://container/logs/*.csv"); err != nil {
+		t.Fatal(err)
+	}
+	e := NewEngine(db)
+
+	query := "SELECT _file, _file_size, COUNT(*) FROM logs GROUP BY _file, _file_size ORDER BY _file"
+	want := []sql.Row{{"az://container/logs/a.csv", int64(17), int32(2)}, {"az://container/logs/b.csv", int64(11), int32(1)}}
+	if rows := queryRows(t, e, query); !reflect.DeepEqual(rows, want) {
+		t.Errorf("got rows %v, want %v", rows, want)
+	}
+
+	// The blobs are read from the service by every query.
+	fake.mu.Lock()
+	before := fake.downloads
+	fake.blobs["logs/b.csv"] = "level\ninfo\nwarning\n"
+	fake.mu.Unlock()
+	rows := queryRows(t, e, "SELECT level FROM logs WHERE _file = 'az://container/logs/b.csv' ORDER BY level")
+	if want := []sql.Row{{"info"}, {"warning"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("got rows %v after the blob changed, want %v", rows, want)
+	}
+	if fake.downloads == before {
+		t.Error("the blobs were not read again by the query")
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	for _, auth := range fake.auths {
+		if auth != "SharedKey account" {
+			t.Fatalf("got a request authorized with %q, want SharedKey account", auth)
+		}
+	}
+}
+
+func TestAzureErrors(t *testing.T) {
+	srv := httptest.NewServer(&fakeAzure{blobs: map[string]string{}})
+	defer srv.Close()
+	t.Setenv("AZURE_STORAGE_ACCOUNT", "")
+	t.Setenv("AZURE_STORAGE_KEY", "")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "AccountName=account;SharedAccessSignature=sv=1&sig=x;BlobEndpoint="+srv.URL+"/account")
+
+	s, err := newAzureStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "404 Not Found: BlobNotFound"
+	if _, err := s.stat("container", "missing.csv"); err == nil || err.Error() != want {
+		t.Errorf("got error %v for a missing blob, want %s", err, want)
+	}
+	if _, err := s.open("container", "missing.csv"); err == nil || err.Error() != want {
+		t.Errorf("got error %v opening a missing blob, want %s", err, want)
+	}
+}
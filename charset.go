@@ -0,0 +1,258 @@
+package csvql
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// NewCharsetWriter returns a writer that converts the UTF-8 text written to
+// it to the given charset, such as windows-1252 or iso-8859-1, before writing
+// it to w. Characters that can not be represented in the charset are an
+// error. The writer must be closed to flush its remaining output.
+func NewCharsetWriter(w io.Writer, charset string) (io.WriteCloser, error) {
+	if isUTF8(charset) {
+		return nopCloser{w}, nil
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unknown charset %s", charset)
+	}
+	return &charsetWriter{w: transform.NewWriter(w, enc.NewEncoder()), charset: charset}, nil
+}
+
+// charsetWriter adds the charset to the errors of a transforming writer.
+type charsetWriter struct {
+	w       io.WriteCloser
+	charset string
+}
+
+func (w *charsetWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil {
+		err = fmt.Errorf("could not convert output to %s: %v", w.charset, err)
+	}
+	return n, err
+}
+
+func (w *charsetWriter) Close() error {
+	if err := w.w.Close(); err != nil {
+		return fmt.Errorf("could not convert output to %s: %v", w.charset, err)
+	}
+	return nil
+}
+
+// NewCharsetRowWriter is like NewRowWriter, but the text written is converted
+// to the given charset. Parquet files always store their text as UTF-8.
+func NewCharsetRowWriter(w io.Writer, format, charset string, schema sql.Schema) (RowWriter, error) {
+	if isUTF8(charset) {
+		return NewRowWriter(w, format, schema)
+	}
+	if format == "parquet" {
+		return nil, fmt.Errorf("charset %s not supported with the parquet format", charset)
+	}
+	cw, err := NewCharsetWriter(w, charset)
+	if err != nil {
+		return nil, err
+	}
+	rw, err := NewRowWriter(cw, format, schema)
+	if err != nil {
+		return nil, err
+	}
+	return &charsetRowWriter{RowWriter: rw, w: cw}, nil
+}
+
+// charsetRowWriter flushes the charset conversion once the rows are closed.
+type charsetRowWriter struct {
+	RowWriter
+	w io.WriteCloser
+}
+
+func (w *charsetRowWriter) Close() error {
+	if err := w.RowWriter.Close(); err != nil {
+		return err
+	}
+	return w.w.Close()
+}
+
+// isUTF8 reports whether the charset is empty or UTF-8.
+func isUTF8(charset string) bool {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "utf8mb4":
+		return true
+	}
+	return false
+}
+
+// tableEncoding is the encoding of the files of new databases, instead of the
+// one they are sniffed to have, if set.
+var tableEncoding string
+
+// SetEncoding sets the encoding of the text in the files of the databases
+// created afterwards, such as windows-1252, iso-8859-1, or shift_jis, which
+// is converted to UTF-8 while they are read. By default, files are read as
+// UTF-8, or UTF-16 when their first bytes tell so.
+func SetEncoding(encoding string) error {
+	enc, err := parseEncoding(encoding)
+	if err != nil {
+		return err
+	}
+	tableEncoding = enc
+	return nil
+}
+
+// parseEncoding returns the name of an encoding, or an empty string for
+// UTF-8.
+func parseEncoding(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if isUTF8(s) {
+		return "", nil
+	}
+	if _, err := htmlindex.Get(s); err != nil {
+		return "", fmt.Errorf("unknown encoding %q, expected one such as windows-1252, iso-8859-1, or shift_jis", s)
+	}
+	return s, nil
+}
+
+// utf8BOM is the byte order mark starting some UTF-8 files, such as the ones
+// written by Windows tools.
+const utf8BOM = "\xEF\xBB\xBF"
+
+// stripBOM discards the UTF-8 byte order mark at the start of br, if any, and
+// returns its length.
+func stripBOM(br *bufio.Reader) int64 {
+	if b, err := br.Peek(len(utf8BOM)); err == nil && string(b) == utf8BOM {
+		br.Discard(len(utf8BOM))
+		return int64(len(utf8BOM))
+	}
+	return 0
+}
+
+// sniffEncoding returns the encoding of the text starting with b, utf-16le or
+// utf-16be, as its byte order mark or the zeros of its ASCII characters tell,
+// or an empty string for UTF-8.
+func sniffEncoding(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xFF, 0xFE}):
+		return "utf-16le"
+	case bytes.HasPrefix(b, []byte{0xFE, 0xFF}):
+		return "utf-16be"
+	}
+	var even, odd int
+	for i := 0; i+1 < len(b); i += 2 {
+		if b[i] == 0 {
+			even++
+		}
+		if b[i+1] == 0 {
+			odd++
+		}
+	}
+	pairs := len(b) / 2
+	switch {
+	case pairs < 2:
+		return ""
+	case odd*2 > pairs && even*10 < pairs:
+		return "utf-16le"
+	case even*2 > pairs && odd*10 < pairs:
+		return "utf-16be"
+	}
+	return ""
+}
+
+// sniffLength is the number of bytes of the files sniffed for their encoding.
+const sniffLength = 4096
+
+// sniffFile returns the encoding of the text in a file, read in the dialect.
+func sniffFile(path string, d Dialect) (string, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not open %s: %v", path, err)
+	}
+	defer f.Close()
+	r, err := decompressReader(f, d.compression(path))
+	if err != nil {
+		return "", fmt.Errorf("could not read %s: %v", path, err)
+	}
+	defer r.Close()
+	b := make([]byte, sniffLength)
+	n, err := io.ReadFull(r, b)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("could not read %s: %v", path, err)
+	}
+	return sniffEncoding(b[:n]), nil
+}
+
+// sniffFiles returns the encoding of the files of a table, which must all
+// have the same one.
+func sniffFiles(name string, d Dialect, paths []string) (string, error) {
+	var first string
+	for i, path := range paths {
+		enc, err := sniffFile(path, d)
+		if err != nil {
+			return "", err
+		}
+		if i == 0 {
+			first = enc
+		} else if enc != first {
+			return "", fmt.Errorf("could not add %s to table %s: its encoding differs from the one of %s", path, name, paths[0])
+		}
+	}
+	return first, nil
+}
+
+// textReader returns a reader of the text of the file at path read from r,
+// decompressed and converted to UTF-8 as the dialect tells. It must be closed
+// once read, which does not close r.
+func (d Dialect) textReader(r io.Reader, path string) (io.ReadCloser, error) {
+	rc, err := decompressReader(r, d.compression(path))
+	if err != nil {
+		return nil, err
+	}
+	if d.Encoding != "" {
+		enc, err := htmlindex.Get(d.Encoding)
+		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("unknown encoding %s", d.Encoding)
+		}
+		rc = decodedReader{transform.NewReader(rc, enc.NewDecoder()), rc}
+	}
+	if d.LineEnd == "\r" {
+		rc = crReader{rc}
+	}
+	if d.SkipFooter > 0 {
+		rc = newFooterReader(rc, d.SkipFooter)
+	}
+	return rc, nil
+}
+
+// crReader reads the carriage returns ending the lines of old Mac files as
+// new lines.
+type crReader struct{ io.ReadCloser }
+
+func (r crReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	for i := range b[:n] {
+		if b[i] == '\r' {
+			b[i] = '\n'
+		}
+	}
+	return n, err
+}
+
+// decodedReader closes the reader of the text it decodes.
+type decodedReader struct {
+	io.Reader
+	c io.Closer
+}
+
+func (r decodedReader) Close() error { return r.c.Close() }
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
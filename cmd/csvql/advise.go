@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/campoy/csvql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// advise recommends indexes and sort orders for the CSV files in a directory,
+// given the queries in a log written by the server.
+func advise(args []string) error {
+	fs := flag.NewFlagSet("advise", flag.ExitOnError)
+	logPath := fs.String("log", "", "query log written by the server with --query-log")
+	format := fs.String("format", "table", fmt.Sprintf("output format, one of %v", csvql.Formats))
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: csvql advise --log queries.jsonl [flags] [dir]\n")
+		fs.PrintDefaults()
+	}
+
+	dirs, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(dirs) > 1 || *logPath == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dir := "."
+	if len(dirs) > 0 {
+		dir = dirs[0]
+	}
+
+	entries, err := csvql.ReadQueryLog(*logPath)
+	if err != nil {
+		return err
+	}
+	var queries []string
+	for _, e := range entries {
+		if e.Error == "" {
+			queries = append(queries, e.Query)
+		}
+	}
+
+	db, err := csvql.NewDatabase(dir)
+	if err != nil {
+		return fmt.Errorf("could not create database: %v", err)
+	}
+	advice, err := csvql.Advise(sql.NewEmptyContext(), db, queries)
+	if err != nil {
+		return err
+	}
+
+	w, err := csvql.NewRowWriter(os.Stdout, *format, csvql.AdviceSchema)
+	if err != nil {
+		return err
+	}
+	for _, a := range advice {
+		if err := w.Write(a.Row()); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
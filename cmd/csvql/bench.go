@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/campoy/csvql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// bench runs a query repeatedly over the tables in a directory and reports
+// how long it took, so different configurations can be compared.
+func bench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	query := fs.String("e", "", "query to run")
+	file := fs.String("f", "", "file containing the query to run")
+	n := fs.Int("n", 10, "number of measured runs")
+	warmup := fs.Int("warmup", 1, "number of runs before measuring")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: csvql bench [flags] (-e query | -f query.sql) [dir]\n")
+		fs.PrintDefaults()
+	}
+
+	dirs, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(dirs) > 1 || (*query == "") == (*file == "") || *n < 1 || *warmup < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *file != "" {
+		b, err := ioutil.ReadFile(*file)
+		if err != nil {
+			return fmt.Errorf("could not read query: %v", err)
+		}
+		*query = strings.TrimSpace(string(b))
+	}
+
+	dir := "."
+	if len(dirs) > 0 {
+		dir = dirs[0]
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("could not find path: %v", err)
+	}
+	db, err := csvql.NewDatabase(dir)
+	if err != nil {
+		return fmt.Errorf("could not create database: %v", err)
+	}
+	e := csvql.NewEngine(db)
+
+	for i := 0; i < *warmup; i++ {
+		if _, err := runOnce(e, *query); err != nil {
+			return err
+		}
+	}
+
+	var (
+		latencies []time.Duration
+		total     time.Duration
+		rows      int64
+	)
+	bytes := csvql.BytesRead.Value()
+	for i := 0; i < *n; i++ {
+		start := time.Now()
+		r, err := runOnce(e, *query)
+		if err != nil {
+			return err
+		}
+		d := time.Since(start)
+		latencies = append(latencies, d)
+		total += d
+		rows += r
+	}
+	bytes = csvql.BytesRead.Value() - bytes
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "runs\t%d (after %d warmup)\n", *n, *warmup)
+	fmt.Fprintf(w, "rows\t%d per run\n", rows/int64(*n))
+	fmt.Fprintf(w, "min\t%v\n", latencies[0])
+	for _, p := range []float64{50, 90, 99} {
+		fmt.Fprintf(w, "p%g\t%v\n", p, percentile(latencies, p))
+	}
+	fmt.Fprintf(w, "max\t%v\n", latencies[len(latencies)-1])
+	fmt.Fprintf(w, "mean\t%v\n", total/time.Duration(*n))
+	fmt.Fprintf(w, "rows/sec\t%.0f\n", float64(rows)/total.Seconds())
+	fmt.Fprintf(w, "bytes scanned\t%d per run (%.1f MB/s)\n", bytes/int64(*n), float64(bytes)/total.Seconds()/1e6)
+	return w.Flush()
+}
+
+// runOnce runs the query and reads all its rows, returning how many there
+// were.
+func runOnce(e *csvql.Engine, query string) (int64, error) {
+	_, rows, err := e.Query(sql.NewEmptyContext(), query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var n int64
+	for {
+		_, err := rows.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// percentile returns the p-th percentile of the given sorted durations, using
+// the nearest rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(p/100*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/campoy/csvql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+// convert parses a CSV file and writes its rows in a different format, with
+// the names of the columns in its header, and its values as they are written
+// unless the flags tell how they are typed.
+func convert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	to := fs.String("to", "", fmt.Sprintf("output format, one of %v (defaults to the extension of -o)", csvql.Formats))
+	charset := fs.String("charset", "utf-8", "character set of the output, such as windows-1252")
+	out := fs.String("o", "", "output file, compressed if it ends in .gz or .zst (defaults to standard output)")
+	files := addFileFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: csvql convert [flags] in.csv\n")
+		fs.PrintDefaults()
+	}
+
+	paths, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := files.apply(); err != nil {
+		return err
+	}
+	if err := readAsText(fs); err != nil {
+		return err
+	}
+	if len(paths) != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	base, compression := csvql.SplitCompression(*out)
+	format := *to
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(base), ".")
+	}
+	if format == "" {
+		return fmt.Errorf("missing output format, use --to")
+	}
+
+	t, err := csvql.NewTable(paths[0])
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("could not create %s: %v", *out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	cw, err := csvql.NewCompressWriter(w, compression)
+	if err != nil {
+		return err
+	}
+
+	rw, err := csvql.NewCharsetRowWriter(cw, format, *charset, sourceSchema(fs, t))
+	if err != nil {
+		return err
+	}
+	if err := copyRows(rw, sql.NewEmptyContext(), t); err != nil {
+		return err
+	}
+	if err := rw.Close(); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+// copyRows writes all the rows in the given table to w.
+func copyRows(w csvql.RowWriter, ctx *sql.Context, t sql.Table) error {
+	rows, err := plan.NewResolvedTable(t).RowIter(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/campoy/csvql"
+)
+
+func TestConvertSourceValues(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "orders.csv")
+	if err := ioutil.WriteFile(in, []byte("Order ID,Paid,Day\n1,yes,2024-1-2\n2,no,2024-01-03\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer csvql.SetInferRows(1000)
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		// The names of the columns and the values are kept as they are written.
+		{nil, "Order ID\tPaid\tDay\n1\tyes\t2024-1-2\n2\tno\t2024-01-03\n"},
+		{[]string{"--columns", "id,paid,day"}, "id\tpaid\tday\n1\tyes\t2024-1-2\n2\tno\t2024-01-03\n"},
+		// Types are only inferred when asked for.
+		{[]string{"--text-columns", "day"}, "Order ID\tPaid\tDay\n1\ttrue\t2024-1-2\n2\tfalse\t2024-01-03\n"},
+	}
+	for _, tt := range tests {
+		out := filepath.Join(dir, "out.tsv")
+		if err := convert(append(append([]string{"-o", out}, tt.args...), in)); err != nil {
+			t.Errorf("%v: %v", tt.args, err)
+			continue
+		}
+		got, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%v: got\n%s\nwant\n%s", tt.args, got, tt.want)
+		}
+	}
+
+	if err := convert([]string{"--to", "xml", in}); err == nil {
+		t.Error("converting to an unknown format did not fail")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/campoy/csvql"
+	"gopkg.in/src-d/go-mysql-server.v0/mem"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+// dedupe merges the rows of several CSV files, removing the duplicated ones.
+func dedupe(args []string) error {
+	fs := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	key := fs.String("key", "", "comma separated list of the columns identifying a row (defaults to the whole row)")
+	policy := fs.String("on-conflict", "first", "row kept when rows with the same key differ: first, last, or error")
+	format := fs.String("format", "csv", fmt.Sprintf("output format, one of %v", csvql.Formats))
+	charset := fs.String("charset", "utf-8", "character set of the output, such as windows-1252")
+	files := addFileFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: csvql dedupe [flags] file.csv...\n")
+		fs.PrintDefaults()
+	}
+
+	paths, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := files.apply(); err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	switch *policy {
+	case "first", "last", "error":
+	default:
+		return fmt.Errorf("unknown conflict policy %q, expected first, last, or error", *policy)
+	}
+
+	merged, err := mergeTables(paths)
+	if err != nil {
+		return err
+	}
+
+	var rows sql.RowIter
+	if *key == "" {
+		// Identical rows are removed by the engine, hashing whole rows.
+		_, rows, err = newEngine(merged).Query(sql.NewEmptyContext(), "SELECT DISTINCT * FROM merged")
+	} else {
+		rows, err = dedupeByKey(merged, strings.Split(*key, ","), *policy)
+	}
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	w, err := csvql.NewCharsetRowWriter(os.Stdout, *format, *charset, merged.Schema())
+	if err != nil {
+		return err
+	}
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// mergeTables returns an in memory table with the rows of all the given files.
+// Columns are matched by name, and missing ones are NULL. The columns whose
+// types differ between files are read as text.
+func mergeTables(paths []string) (*mem.Table, error) {
+	var tables []sql.Table
+	var schema sql.Schema
+	for _, path := range paths {
+		t, err := csvql.NewTable(path)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, t)
+		for _, col := range t.Schema() {
+			if i := indexOf(schema, col.Name); i < 0 {
+				schema = append(schema, &sql.Column{Name: col.Name, Type: col.Type, Nullable: true, Source: "merged"})
+			} else if schema[i].Type != col.Type {
+				// The types inferred for the column differ between files.
+				schema[i].Type = sql.Text
+			}
+		}
+	}
+
+	merged := mem.NewTable("merged", schema)
+	ctx := sql.NewEmptyContext()
+	for _, t := range tables {
+		idx := make([]int, len(schema))
+		for i, col := range schema {
+			idx[i] = indexOf(t.Schema(), col.Name)
+		}
+
+		rows, err := plan.NewResolvedTable(t).RowIter(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			row, err := rows.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			out := make(sql.Row, len(schema))
+			for i, j := range idx {
+				if j < 0 || row[j] == nil {
+					continue
+				}
+				if out[i], err = schema[i].Type.Convert(row[j]); err != nil {
+					rows.Close()
+					return nil, err
+				}
+			}
+			if err := merged.Insert(ctx, out); err != nil {
+				rows.Close()
+				return nil, err
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+// dedupeByKey returns the rows in t keeping only one per key, in the order in
+// which the keys first appear. The policy decides which row is kept when
+// several rows with the same key differ.
+func dedupeByKey(t sql.Table, keys []string, policy string) (sql.RowIter, error) {
+	var idx []int
+	for _, name := range keys {
+		i := indexOf(t.Schema(), name)
+		if i < 0 {
+			return nil, fmt.Errorf("unknown key column %s", name)
+		}
+		idx = append(idx, i)
+	}
+
+	rows, err := plan.NewResolvedTable(t).RowIter(sql.NewEmptyContext())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var kept []sql.Row
+	positions := make(map[string]int)
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		values := make([]string, len(idx))
+		for i, j := range idx {
+			values[i] = fmt.Sprint(row[j])
+		}
+		k := strings.Join(values, "\x00")
+
+		pos, ok := positions[k]
+		switch {
+		case !ok:
+			positions[k] = len(kept)
+			kept = append(kept, row)
+		case sameRow(kept[pos], row):
+		case policy == "last":
+			kept[pos] = row
+		case policy == "error":
+			return nil, fmt.Errorf("conflicting rows for key %s: %v and %v", strings.Join(values, ", "), kept[pos], row)
+		}
+	}
+	return sql.RowsToRowIter(kept...), nil
+}
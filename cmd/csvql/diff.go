@@ -0,0 +1,387 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/campoy/csvql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// diff compares the rows in two CSV files identified by a key, writing the
+// rows that were added, removed, or changed.
+func diff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	key := fs.String("key", "", "comma separated list of the columns identifying a row")
+	format := fs.String("format", "table", fmt.Sprintf("output format, one of %v", csvql.Formats))
+	charset := fs.String("charset", "utf-8", "character set of the output, such as windows-1252")
+	columns := fs.Bool("columns", false, "report a row per changed column rather than per changed row")
+	files := addFileFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: csvql diff --key id a.csv b.csv\n")
+		fs.PrintDefaults()
+	}
+
+	paths, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := files.apply(); err != nil {
+		return err
+	}
+	if *key == "" || len(paths) != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	d := &differ{columns: *columns, format: *format, charset: *charset, textColumns: *files.textColumns}
+	if err := d.diffFiles(os.Stdout, paths[0], paths[1], strings.Split(*key, ",")); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%d added, %d removed, %d changed\n", d.added, d.removed, d.changed)
+	if d.added+d.removed+d.changed > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// diffFiles writes to out the differences between the rows of the files at
+// paths a and b, identified by the given key columns.
+func (d *differ) diffFiles(out io.Writer, pathA, pathB string, keys []string) error {
+	a, b, err := keyedTables(pathA, pathB, keys, d.textColumns)
+	if err != nil {
+		return err
+	}
+
+	// Rows are compared on the union of the columns in both files.
+	for _, t := range []sql.Table{a, b} {
+		for _, col := range t.Schema() {
+			if !contains(d.names, col.Name) {
+				d.names = append(d.names, col.Name)
+			}
+		}
+	}
+	for _, name := range keys {
+		d.keys = append(d.keys, index(d.names, name))
+		d.keyTypes = append(d.keyTypes, a.Schema()[indexOf(a.Schema(), name)].Type)
+	}
+
+	schema := sql.Schema{{Name: "op", Type: sql.Text}}
+	if d.columns {
+		for _, name := range keys {
+			schema = append(schema, &sql.Column{Name: name, Type: sql.Text, Nullable: true})
+		}
+		schema = append(schema,
+			&sql.Column{Name: "column", Type: sql.Text, Nullable: true},
+			&sql.Column{Name: "old", Type: sql.Text, Nullable: true},
+			&sql.Column{Name: "new", Type: sql.Text, Nullable: true},
+		)
+	} else {
+		for _, name := range d.names {
+			schema = append(schema, &sql.Column{Name: name, Type: sql.Text, Nullable: true})
+		}
+	}
+
+	w, err := csvql.NewCharsetRowWriter(out, d.format, d.charset, schema)
+	if err != nil {
+		return err
+	}
+	d.w = w
+
+	ra, err := sortedRows(a, keys, d.names)
+	if err != nil {
+		return err
+	}
+	defer ra.Close()
+	rb, err := sortedRows(b, keys, d.names)
+	if err != nil {
+		return err
+	}
+	defer rb.Close()
+
+	if err := d.run(ra, rb); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// keyedTables returns the tables of the files at paths a and b, whose key
+// columns are sorted and compared with the same type. The ones read with
+// other types from each file, such as 01 read as text from one and 1 as a
+// number from the other, are read again as text from both, as they are
+// written, besides the given text columns.
+func keyedTables(pathA, pathB string, keys []string, textColumns string) (sql.Table, sql.Table, error) {
+	text := []string{}
+	if textColumns != "" {
+		text = append(text, textColumns)
+	}
+	for reads := 0; ; reads++ {
+		a, err := csvql.NewTable(pathA)
+		if err != nil {
+			return nil, nil, err
+		}
+		b, err := csvql.NewTable(pathB)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var mismatched []string
+		for _, name := range keys {
+			ia, ib := indexOf(a.Schema(), name), indexOf(b.Schema(), name)
+			if ia < 0 || ib < 0 {
+				return nil, nil, fmt.Errorf("key column %s must be present in both files", name)
+			}
+			ta, tb := a.Schema()[ia].Type, b.Schema()[ib].Type
+			if reflect.DeepEqual(ta, tb) {
+				continue
+			}
+			// The columns of some formats, such as Parquet, have their own
+			// types, which are not read as text.
+			if reads > 0 {
+				return nil, nil, fmt.Errorf("key column %s can not be compared: it is %s in %s and %s in %s", name, ta, pathA, tb, pathB)
+			}
+			mismatched = append(mismatched, name)
+		}
+		if len(mismatched) == 0 {
+			return a, b, nil
+		}
+		if err := csvql.SetTextColumns(strings.Join(append(text, mismatched...), ",")); err != nil {
+			return nil, nil, err
+		}
+		defer csvql.SetTextColumns(textColumns)
+	}
+}
+
+// sortedRows returns the rows in the given table sorted by the key columns,
+// with their values in the order given by names. Columns not present in the
+// table are NULL.
+func sortedRows(t sql.Table, keys, names []string) (*keyedRows, error) {
+	query := fmt.Sprintf("SELECT * FROM %s ORDER BY %s", quote(t.Name()), quoteAll(keys))
+	schema, rows, err := newEngine(t).Query(sql.NewEmptyContext(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := make([]int, len(names))
+	for i, name := range names {
+		idx[i] = indexOf(schema, name)
+	}
+	return &keyedRows{RowIter: rows, name: t.Name(), idx: idx}, nil
+}
+
+type keyedRows struct {
+	sql.RowIter
+	name string
+	idx  []int
+	row  sql.Row
+	done bool
+}
+
+// next advances to the next row, projected to the columns being compared.
+func (r *keyedRows) next() error {
+	row, err := r.Next()
+	if err == io.EOF {
+		r.done = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	r.row = make(sql.Row, len(r.idx))
+	for i, j := range r.idx {
+		if j >= 0 {
+			r.row[i] = row[j]
+		}
+	}
+	return nil
+}
+
+type differ struct {
+	w        csvql.RowWriter
+	names    []string
+	keys     []int
+	keyTypes []sql.Type
+	columns  bool
+	// format and charset are the ones of the differences written, and
+	// textColumns the columns of the files read as text.
+	format, charset, textColumns string
+
+	added, removed, changed int
+}
+
+// run merges the two sorted sequences of rows, reporting the differences.
+func (d *differ) run(a, b *keyedRows) error {
+	if err := a.next(); err != nil {
+		return err
+	}
+	if err := b.next(); err != nil {
+		return err
+	}
+
+	for !a.done || !b.done {
+		var cmp int
+		switch {
+		case a.done:
+			cmp = 1
+		case b.done:
+			cmp = -1
+		default:
+			var err error
+			if cmp, err = d.compareKeys(a.row, b.row); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		switch {
+		case cmp < 0:
+			d.removed++
+			err = d.report("removed", a.row, nil)
+			if err == nil {
+				err = d.advance(a)
+			}
+		case cmp > 0:
+			d.added++
+			err = d.report("added", nil, b.row)
+			if err == nil {
+				err = d.advance(b)
+			}
+		default:
+			if !sameRow(a.row, b.row) {
+				d.changed++
+				err = d.report("changed", a.row, b.row)
+			}
+			if err == nil {
+				err = d.advance(a)
+			}
+			if err == nil {
+				err = d.advance(b)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// advance moves r to its next row, failing if its key is the same as the
+// current one.
+func (d *differ) advance(r *keyedRows) error {
+	prev := r.row
+	if err := r.next(); err != nil || r.done {
+		return err
+	}
+	cmp, err := d.compareKeys(prev, r.row)
+	if err != nil {
+		return err
+	}
+	if cmp == 0 {
+		return fmt.Errorf("duplicate key %v in %s", d.key(prev), r.name)
+	}
+	return nil
+}
+
+func (d *differ) compareKeys(a, b sql.Row) (int, error) {
+	for i, k := range d.keys {
+		va, vb := a[k], b[k]
+		switch {
+		case va == nil && vb == nil:
+			continue
+		case va == nil:
+			return -1, nil
+		case vb == nil:
+			return 1, nil
+		}
+		cmp, err := d.keyTypes[i].Compare(va, vb)
+		if err != nil || cmp != 0 {
+			return cmp, err
+		}
+	}
+	return 0, nil
+}
+
+func (d *differ) key(row sql.Row) []interface{} {
+	key := make([]interface{}, len(d.keys))
+	for i, k := range d.keys {
+		key[i] = row[k]
+	}
+	return key
+}
+
+// report writes the difference between the old and new versions of a row,
+// either of which is nil if the row was added or removed.
+func (d *differ) report(op string, old, new sql.Row) error {
+	if !d.columns {
+		row := new
+		if row == nil {
+			row = old
+		}
+		return d.w.Write(append(sql.Row{op}, row...))
+	}
+
+	row := old
+	if row == nil {
+		row = new
+	}
+	prefix := append(sql.Row{op}, d.key(row)...)
+	if old == nil || new == nil {
+		return d.w.Write(append(prefix, nil, nil, nil))
+	}
+
+	for i, name := range d.names {
+		if sameValue(old[i], new[i]) {
+			continue
+		}
+		if err := d.w.Write(append(prefix[:len(prefix):len(prefix)], name, old[i], new[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sameRow(a, b sql.Row) bool {
+	for i := range a {
+		if !sameValue(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameValue reports whether two values are equal, considering a missing
+// column the same as an empty value.
+func sameValue(a, b interface{}) bool {
+	if a == nil {
+		a = ""
+	}
+	if b == nil {
+		b = ""
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func indexOf(schema sql.Schema, name string) int {
+	for i, col := range schema {
+		if col.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func index(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func contains(names []string, name string) bool { return index(names, name) >= 0 }
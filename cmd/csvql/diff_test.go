@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffKeyTypes(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		// The ids of a are read as text, having a leading zero, and the ones
+		// of b as numbers.
+		"a.csv": "id,name\n01,ana\n2,bob\n10,cy\n",
+		"b.csv": "id,name\n1,ana\n2,bob\n9,dan\n10,cy\n",
+		"c.csv": "id,name\n1,ana\n2,bea\n9,dan\n10,cy\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		a, b                    string
+		want                    string
+		added, removed, changed int
+	}{
+		// Keys are compared as they are written, so 01 is not 1.
+		{"a.csv", "b.csv", "op,id,name\nremoved,01,ana\nadded,1,ana\nadded,9,dan\n", 2, 1, 0},
+		{"b.csv", "c.csv", "op,id,name\nchanged,2,bea\n", 0, 0, 1},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		d := &differ{format: "csv", charset: "utf-8"}
+		if err := d.diffFiles(&out, filepath.Join(dir, tt.a), filepath.Join(dir, tt.b), []string{"id"}); err != nil {
+			t.Errorf("%s %s: %v", tt.a, tt.b, err)
+			continue
+		}
+		if got := out.String(); got != tt.want {
+			t.Errorf("%s %s: got\n%s\nwant\n%s", tt.a, tt.b, out.String(), tt.want)
+		}
+		if d.added != tt.added || d.removed != tt.removed || d.changed != tt.changed {
+			t.Errorf("%s %s: got %d added, %d removed, %d changed", tt.a, tt.b, d.added, d.removed, d.changed)
+		}
+	}
+
+	d := &differ{format: "csv", charset: "utf-8"}
+	if err := d.diffFiles(&bytes.Buffer{}, filepath.Join(dir, "a.csv"), filepath.Join(dir, "b.csv"), []string{"code"}); err == nil || !strings.Contains(err.Error(), "both files") {
+		t.Errorf("got error %v for a missing key column", err)
+	}
+}
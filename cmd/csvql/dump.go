@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/campoy/csvql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// dump writes the SQL statements recreating the tables in a directory.
+func dump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: csvql dump [dir]\n")
+		fs.PrintDefaults()
+	}
+
+	paths, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(paths) > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	path := "."
+	if len(paths) > 0 {
+		path = paths[0]
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("could not find path: %v", err)
+	}
+	db, err := csvql.NewDatabase(path)
+	if err != nil {
+		return fmt.Errorf("could not create database: %v", err)
+	}
+	return csvql.Dump(sql.NewEmptyContext(), os.Stdout, db)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/campoy/csvql"
+	"gopkg.in/src-d/go-mysql-server.v0/mem"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// newEngine returns an engine whose current database contains the given
+// tables.
+func newEngine(tables ...sql.Table) *csvql.Engine {
+	db := mem.NewDatabase("csvql")
+	for _, t := range tables {
+		db.AddTable(t.Name(), t)
+	}
+	return csvql.NewEngine(db)
+}
+
+// quote returns the given name quoted as a SQL identifier.
+func quote(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// quoteAll returns the given names quoted as SQL identifiers and separated by
+// commas.
+func quoteAll(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quote(name)
+	}
+	return strings.Join(quoted, ", ")
+}
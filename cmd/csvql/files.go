@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/campoy/csvql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// fileFlags are the flags telling how the files of the tables are read.
+type fileFlags struct {
+	format, delimiter, encoding, compression *string
+	comment, locale, nulls, columns          *string
+	textColumns                              *string
+	dateFormat, timeZone, constraints        *string
+	cache, sshKey                            *string
+	cacheTTL                                 *time.Duration
+	noHeader, sanitize, noSchemaCache        *bool
+	mfaPrompt                                *bool
+	skipRows, skipFooter, inferRows          *int
+	schemas                                  *repeated
+}
+
+// addFileFlags adds the flags telling how the files of the tables are read to
+// fs, except the ones it already has, such as the -format of the output of a
+// command, which keep their meaning.
+func addFileFlags(fs *flag.FlagSet) *fileFlags {
+	own := fs
+	fs = flag.NewFlagSet(own.Name(), flag.ContinueOnError)
+
+	schemas := new(repeated)
+	fs.Var(schemas, "schema", "schema file describing the columns of a table, as [table=]file.yaml, instead of inferring their types, for the table named after the file by default")
+	f := &fileFlags{
+		schemas:       schemas,
+		format:        fs.String("format", "", "format of the files, csv, tsv, jsonl, parquet, avro, xlsx, or fixed, instead of the one given by their extensions"),
+		delimiter:     fs.String("delimiter", "", "delimiter separating the values in the files, instead of the one of their format"),
+		encoding:      fs.String("encoding", "", "encoding of the text in the files, such as windows-1252, iso-8859-1, or shift_jis, instead of UTF-8"),
+		comment:       fs.String("comment", "", "prefix of the lines skipped in delimited files, such as #"),
+		locale:        fs.String("locale", "", "locale the numbers of text files are written in, such as de for 1.234,56"),
+		nulls:         fs.String("null", "", "values read as NULL in text files, separated by commas, such as NULL,\\N,NA"),
+		noHeader:      fs.Bool("no-header", false, "read the first rows of delimited files as values, with the columns named c1, c2, and so on"),
+		sanitize:      fs.Bool("sanitize-headers", false, "read the names in the headers of delimited files as snake_case identifiers, such as order_id for Order ID"),
+		columns:       fs.String("columns", "", "names of the columns of delimited files, separated by commas, instead of the ones in their headers"),
+		skipRows:      fs.Int("skip-rows", 0, "number of lines skipped at the start of text files, before their headers"),
+		skipFooter:    fs.Int("skip-footer", 0, "number of lines skipped at the end of text files, such as totals"),
+		dateFormat:    fs.String("date-format", "", "layout of the dates or timestamps of delimited and JSON lines files, such as 02/01/2006 or %d/%m/%Y, tried before the usual ones"),
+		timeZone:      fs.String("timezone", "", "time zone of the sessions, used by NOW(), and the one the timestamps of text files written with their offsets are read in, such as Europe/Madrid, UTC, +02:00, or SYSTEM"),
+		constraints:   fs.String("constraints", "", "report or reject the rows of text files violating the types, not_null constraints, and patterns of their columns while they are read, instead of failing on values of other types"),
+		noSchemaCache: fs.Bool("no-schema-cache", false, "infer the types of the columns of text files every time, rather than keeping them in .csvql-schema files next to them until the files change"),
+		inferRows:     fs.Int("infer-rows", 1000, "number of rows of delimited and JSON lines files read to infer the types of their columns, 0 to read them as text"),
+		textColumns:   fs.String("text-columns", "", "columns of delimited and JSON lines files read as text, separated by commas, such as zip,account, instead of inferring their types"),
+		compression:   fs.String("compression", "", "compression of the files, gzip, zstd, bzip2, xz, or none, instead of the one given by their extensions"),
+		cache:         fs.String("cache-dir", "", "directory where the objects read from cloud storage are kept until they change, instead of downloading them every time"),
+		cacheTTL:      fs.Duration("cache-ttl", 0, "how long the objects kept in --cache-dir are read without checking whether they changed"),
+		sshKey:        fs.String("ssh-key", "", "private key authenticating to the SFTP servers of sftp:// tables, instead of the ones in the SSH agent and in ~/.ssh"),
+		mfaPrompt:     fs.Bool("aws-mfa-prompt", false, "ask on the standard input for the MFA codes of the AWS roles assumed to read s3:// tables, which can not be assumed otherwise"),
+	}
+	fs.VisitAll(func(fl *flag.Flag) {
+		if own.Lookup(fl.Name) == nil {
+			own.Var(fl.Value, fl.Name, fl.Usage)
+		}
+	})
+	return f
+}
+
+// apply sets how the files of the databases created afterwards are read.
+func (f *fileFlags) apply() error {
+	if err := csvql.SetFormat(*f.format); err != nil {
+		return err
+	}
+	if err := csvql.SetDelimiter(*f.delimiter); err != nil {
+		return err
+	}
+	if err := csvql.SetComment(*f.comment); err != nil {
+		return err
+	}
+	if err := csvql.SetNulls(*f.nulls); err != nil {
+		return err
+	}
+	if err := csvql.SetLocale(*f.locale); err != nil {
+		return err
+	}
+	csvql.SetNoHeader(*f.noHeader)
+	csvql.SetSanitizeHeaders(*f.sanitize)
+	if err := csvql.SetColumns(*f.columns); err != nil {
+		return err
+	}
+	if err := csvql.SetSkipRows(*f.skipRows); err != nil {
+		return err
+	}
+	if err := csvql.SetSkipFooter(*f.skipFooter); err != nil {
+		return err
+	}
+	if err := csvql.SetInferRows(*f.inferRows); err != nil {
+		return err
+	}
+	if err := csvql.SetTextColumns(*f.textColumns); err != nil {
+		return err
+	}
+	csvql.SetSchemaCache(!*f.noSchemaCache)
+	if err := csvql.SetDateFormat(*f.dateFormat); err != nil {
+		return err
+	}
+	if err := csvql.SetTimeZone(*f.timeZone); err != nil {
+		return err
+	}
+	if err := csvql.SetConstraints(*f.constraints); err != nil {
+		return err
+	}
+	for _, spec := range *f.schemas {
+		name, path := schemaTable(spec)
+		if err := csvql.SetSchema(name, path); err != nil {
+			return err
+		}
+	}
+	if err := csvql.SetCompression(*f.compression); err != nil {
+		return err
+	}
+	if *f.cache != "" {
+		if err := csvql.SetRemoteCache(*f.cache); err != nil {
+			return err
+		}
+	} else if *f.cacheTTL != 0 {
+		return fmt.Errorf("--cache-ttl requires --cache-dir")
+	}
+	csvql.SetRemoteCacheTTL(*f.cacheTTL)
+	if *f.sshKey != "" {
+		if err := csvql.SetSSHKey(*f.sshKey); err != nil {
+			return err
+		}
+	}
+	csvql.SetAWSMFAPrompt(*f.mfaPrompt)
+	return csvql.SetEncoding(*f.encoding)
+}
+
+// typeFlags are the flags telling how the values of the files are typed.
+var typeFlags = []string{"schema", "infer-rows", "text-columns", "date-format", "constraints"}
+
+// readAsText makes the files of the databases created afterwards be read with
+// every column as text, keeping their values as they are written, unless fs
+// has any of the typeFlags set.
+func readAsText(fs *flag.FlagSet) error {
+	for _, name := range typeFlags {
+		if isFlagSet(fs, name) {
+			return nil
+		}
+	}
+	return csvql.SetInferRows(0)
+}
+
+// sourceSchema returns the schema of a table with its columns named as in the
+// header of its file, unless fs sets other names for them.
+func sourceSchema(fs *flag.FlagSet, t sql.Table) sql.Schema {
+	header := csvql.TableHeader(t)
+	if isFlagSet(fs, "columns") || isFlagSet(fs, "sanitize-headers") {
+		header = nil
+	}
+	schema := make(sql.Schema, len(t.Schema()))
+	for i, col := range t.Schema() {
+		c := *col
+		if i < len(header) {
+			c.Name = header[i]
+		}
+		schema[i] = &c
+	}
+	return schema
+}
+
+// schemaTable returns the table and the file of a schema given with --schema,
+// as [table=]file, whose table is named after the file by default, as orders
+// for orders.schema.yaml.
+func schemaTable(spec string) (string, string) {
+	if kv := strings.SplitN(spec, "=", 2); len(kv) == 2 {
+		return kv[0], kv[1]
+	}
+	name := filepath.Base(spec)
+	for _, ext := range []string{".schema.yaml", ".schema.yml", ".schema.json"} {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext), spec
+		}
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name)), spec
+}
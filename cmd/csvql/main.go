@@ -1,43 +1,322 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/campoy/csvql"
-	sqle "gopkg.in/src-d/go-mysql-server.v0"
 	"gopkg.in/src-d/go-mysql-server.v0/server"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
 	"gopkg.in/src-d/go-vitess.v0/mysql"
 )
 
+// commands maps the name of each subcommand to the function running it.
+var commands = map[string]func(args []string) error{
+	"advise":   advise,
+	"bench":    bench,
+	"convert":  convert,
+	"dedupe":   dedupe,
+	"describe": stats,
+	"diff":     diff,
+	"dump":     dump,
+	"sample":   sample,
+	"schema":   schema,
+	"query":    query,
+	"serve":    serve,
+	"stats":    stats,
+	"validate": validate,
+	"warm":     warm,
+}
+
 func main() {
-	path := "."
 	if len(os.Args) > 1 {
-		path = os.Args[1]
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+	// csvql 'SELECT ...' runs the query, such as over the data piped to it.
+	for _, arg := range os.Args[1:] {
+		if isQuery(arg) {
+			if err := query(os.Args[1:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	if err := serve(os.Args[1:]); err != nil {
+		log.Fatal(err)
 	}
-	path, err := filepath.Abs(path)
+}
+
+// serve runs a MySQL server over the CSV files in a directory.
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	files := addFileFlags(fs)
+	var tables, databases, sqlite, mysqls repeated
+	fs.Var(&tables, "table", "table to add from a file outside the directory, as name=path[;option=value...]")
+	fs.Var(&databases, "db", "database to add from another directory, as name=dir, whose tables are read as name.table")
+	fs.Var(&sqlite, "attach-sqlite", "SQLite database to add, as [name=]file, whose tables are read as name.table, named after the file by default")
+	fs.Var(&mysqls, "attach-mysql", "MySQL database to add, as [name=]user:password@tcp(host:port)/db, whose tables are read as name.table, named as in the server by default")
+	httpAddr := fs.String("http", "", "address to serve the web query console on, such as localhost:8080")
+	configPath := fs.String("config", "", "YAML file with the queries to run on a schedule")
+	grants := fs.String("grants", "", "YAML file with the users allowed to connect and what they can read")
+	maxRows := fs.Int64("max-result-rows", 0, "maximum number of rows returned by a statement, 0 for no limit")
+	maxBytes := fs.Int64("max-result-bytes", 0, "maximum number of bytes returned by a statement, 0 for no limit")
+	queryLog := fs.String("query-log", "", "file where the queries run are appended as JSON lines")
+	tempDir := fs.String("temp-dir", os.TempDir(), "directory for temporary files, such as the runs of large sorts")
+	exportDir := fs.String("export-dir", "", "directory the results of queries can be exported to with INTO OUTFILE and COPY, which is disabled if empty")
+	sqlMode := fs.String("sql-mode", "", "initial sql_mode of the sessions, such as ANSI_QUOTES")
+	versions := fs.Int("keep-versions", 0, "number of previous versions of each written file to keep for AS OF queries")
+	deleteAll := fs.Bool("allow-delete-all", false, "run DELETE statements without a WHERE clause, removing all the rows of their tables")
+	refresh := fs.Duration("refresh-interval", 10*time.Second, "how often to check whether auto refreshed views are stale")
+	reload := fs.Duration("reload-interval", 2*time.Second, "how often to check whether the files of the tables changed, when their folders can not be watched, such as remote ones, 0 to never reload them")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: csvql [serve] [flags] [dir] [pattern...]\n")
+		fs.PrintDefaults()
+	}
+
+	pos, err := parseArgs(fs, args)
 	if err != nil {
-		log.Fatalf("could not find path: %v", err)
+		return err
 	}
-	db, err := csvql.NewDatabase(path)
+	path, patterns, err := splitPatterns(pos)
 	if err != nil {
-		log.Fatalf("could not create database: %v", err)
+		return err
 	}
+	tables = append(tables, patterns...)
 
-	engine := sqle.NewDefault()
-	engine.AddDatabase(db)
+	if err := csvql.SetTempDir(*tempDir); err != nil {
+		return err
+	}
+	csvql.SetRetainedVersions(*versions)
+	csvql.SetDeleteAll(*deleteAll)
+	if err := files.apply(); err != nil {
+		return err
+	}
+	dbs, err := namedDatabases(databases, sqlite, mysqls)
+	if err != nil {
+		return err
+	}
+	// The current database is the one of the directory, or the first one
+	// given with --db if there is none.
+	var db sql.Database
+	if path == "" && len(dbs) > 0 {
+		db, dbs = dbs[0], dbs[1:]
+	} else {
+		if path == "" {
+			path = "."
+		}
+		if path, err = filepath.Abs(path); err != nil {
+			return fmt.Errorf("could not find path: %v", err)
+		}
+		if db, err = csvql.NewDatabase(path); err != nil {
+			return fmt.Errorf("could not create database: %v", err)
+		}
+	}
+	for _, spec := range tables {
+		if err := csvql.AddTable(db, spec); err != nil {
+			return err
+		}
+	}
 
+	engine := csvql.NewEngine(append(dbs, db)...)
+	if err := engine.SetExportDir(*exportDir); err != nil {
+		return err
+	}
 	config := server.Config{
 		Protocol: "tcp",
 		Address:  "localhost:3306",
 		Auth:     new(mysql.AuthServerNone),
 	}
-	server, err := server.NewDefaultServer(config, engine)
+	if *grants != "" {
+		g, err := csvql.LoadGrants(*grants)
+		if err != nil {
+			return err
+		}
+		engine.SetGrants(g)
+		config.Auth = g.AuthServer()
+	}
+
+	if *queryLog != "" {
+		f, err := os.OpenFile(*queryLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("could not open query log: %v", err)
+		}
+		defer f.Close()
+		engine.LogQueries(f)
+	}
+
+	opts := csvql.ServerOptions{
+		MaxResultRows:  *maxRows,
+		MaxResultBytes: *maxBytes,
+		SQLMode:        *sqlMode,
+	}
+	server, err := csvql.NewServer(config, engine, opts)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if *httpAddr != "" {
+		go func() {
+			log.Printf("serving web console on http://%s", *httpAddr)
+			log.Fatal(http.ListenAndServe(*httpAddr, csvql.NewConsole(engine, opts)))
+		}()
+	}
+	go engine.WatchViews(*refresh)
+	if *reload > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go engine.WatchTables(ctx, *reload)
+	}
+	if *configPath != "" {
+		c, err := csvql.LoadConfig(*configPath)
+		if err != nil {
+			return err
+		}
+		engine.RunSchedules(c.Schedules)
 	}
 
 	log.Printf("starting server on %s", config.Address)
-	log.Fatal(server.Start())
+	return server.Start()
+}
+
+// splitPatterns splits the positional arguments into the directory of the
+// database, if any, and the specs of the tables of the files matching the
+// others, which are patterns such as 'logs/2024-*.csv'.
+func splitPatterns(args []string) (dir string, specs []string, err error) {
+	for _, arg := range args {
+		if !csvql.IsPattern(arg) {
+			if dir != "" {
+				return "", nil, fmt.Errorf("several directories given: %s and %s", dir, arg)
+			}
+			dir = arg
+			continue
+		}
+		spec, err := patternTable(arg)
+		if err != nil {
+			return "", nil, err
+		}
+		specs = append(specs, spec)
+	}
+	return dir, specs, nil
+}
+
+// patternTable returns the spec of the table of the files matching a
+// pattern, named after their directory, as logs for logs/2024-*.csv, or
+// after the start of their names, as sales for sales-*.csv.
+func patternTable(pattern string) (string, error) {
+	dir, base := filepath.Split(pattern)
+	name := filepath.Base(filepath.Clean(dir))
+	if dir == "" || name == "." || name == string(filepath.Separator) || csvql.IsPattern(name) {
+		if i := strings.IndexAny(base, "*?["); i >= 0 {
+			base = base[:i]
+		} else {
+			base = strings.TrimSuffix(base, filepath.Ext(base))
+		}
+		name = strings.TrimRight(base, "-_. ")
+	}
+	if name == "" {
+		return "", fmt.Errorf("could not name the table of %s, add it with --table name=%s", pattern, pattern)
+	}
+	return strings.ToLower(name) + "=" + pattern, nil
+}
+
+// namedDatabases returns the databases given with --db, as name=dir, the
+// SQLite ones given with --attach-sqlite, as [name=]file, and the MySQL ones
+// given with --attach-mysql, as [name=]dsn.
+func namedDatabases(specs, sqlite, mysqls []string) ([]sql.Database, error) {
+	var dbs []sql.Database
+	names := make(map[string]bool)
+	add := func(name string, db sql.Database, err error) error {
+		if err != nil {
+			return fmt.Errorf("could not create database %s: %v", name, err)
+		}
+		if names[db.Name()] {
+			return fmt.Errorf("database %s given twice", db.Name())
+		}
+		names[db.Name()] = true
+		dbs = append(dbs, db)
+		return nil
+	}
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || kv[1] == "" || !dbName.MatchString(kv[0]) {
+			return nil, fmt.Errorf("invalid database %q, expected name=dir with a name of letters, digits, and underscores", spec)
+		}
+		name := strings.ToLower(kv[0])
+		dir, err := filepath.Abs(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("could not find path: %v", err)
+		}
+		db, err := csvql.NewNamedDatabase(name, dir)
+		if err := add(name, db, err); err != nil {
+			return nil, err
+		}
+	}
+	for _, spec := range sqlite {
+		name, path := "", spec
+		if kv := strings.SplitN(spec, "=", 2); len(kv) == 2 && dbName.MatchString(kv[0]) {
+			name, path = strings.ToLower(kv[0]), kv[1]
+		}
+		db, err := csvql.NewSQLiteDatabase(name, path)
+		if err == nil && !dbName.MatchString(db.Name()) {
+			return nil, fmt.Errorf("invalid SQLite database %q, expected name=file with a name of letters, digits, and underscores", spec)
+		}
+		if err := add(path, db, err); err != nil {
+			return nil, err
+		}
+	}
+	for _, spec := range mysqls {
+		name, dsn := "", spec
+		if kv := strings.SplitN(spec, "=", 2); len(kv) == 2 && dbName.MatchString(kv[0]) {
+			name, dsn = strings.ToLower(kv[0]), kv[1]
+		}
+		db, err := csvql.NewMySQLDatabase(name, dsn)
+		if err == nil && !dbName.MatchString(db.Name()) {
+			return nil, fmt.Errorf("invalid MySQL database %q, expected name=dsn with a name of letters, digits, and underscores", spec)
+		}
+		// Passwords are not shown in errors.
+		if err := add(dsn[strings.LastIndex(dsn, "@")+1:], db, err); err != nil {
+			return nil, err
+		}
+	}
+	return dbs, nil
+}
+
+// dbName matches the names of databases, which qualify the names of their
+// tables.
+var dbName = regexp.MustCompile(`^\w+$`)
+
+// repeated is a flag that can be given several times.
+type repeated []string
+
+func (r *repeated) String() string     { return strings.Join(*r, ", ") }
+func (r *repeated) Set(s string) error { *r = append(*r, s); return nil }
+
+// parseArgs parses the flags in args, allowing them to appear before, after,
+// or in between positional arguments, and returns the positional ones.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var pos []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return pos, nil
+		}
+		pos = append(pos, args[0])
+		args = args[1:]
+	}
 }
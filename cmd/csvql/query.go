@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/campoy/csvql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// query runs a query over the data piped to the standard input, read as a
+// table named stdin when the query reads it or - is given, the tables in a directory, if given, and the ones of the
+// files matching patterns, and writes its results to the standard output.
+func query(args []string) error {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	files := addFileFlags(fs)
+	var tables, databases, sqlite, mysqls repeated
+	fs.Var(&tables, "table", "table to add from a file outside the directory, as name=path[;option=value...]")
+	fs.Var(&databases, "db", "database to add from another directory, as name=dir, whose tables are read as name.table")
+	fs.Var(&sqlite, "attach-sqlite", "SQLite database to add, as [name=]file, whose tables are read as name.table, named after the file by default")
+	fs.Var(&mysqls, "attach-mysql", "MySQL database to add, as [name=]user:password@tcp(host:port)/db, whose tables are read as name.table, named as in the server by default")
+	stdin := fs.String("stdin", "stdin", "name of the table read from the standard input, and the options to read it, as name[;option=value...]")
+	to := fs.String("to", "table", fmt.Sprintf("format of the results, one of %v", csvql.Formats))
+	tempDir := fs.String("temp-dir", os.TempDir(), "directory for temporary files, such as the copy of the standard input")
+	exportDir := fs.String("export-dir", "", "directory the results of queries can be exported to with INTO OUTFILE and COPY, which is disabled if empty")
+	deleteAll := fs.Bool("allow-delete-all", false, "run DELETE statements without a WHERE clause, removing all the rows of their tables")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: csvql [query] [flags] 'SELECT ...' [dir] [pattern...] [-]\n")
+		fs.PrintDefaults()
+	}
+
+	pos, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	// The standard input is only read when - is given, or the query reads
+	// its table, so the queries run by scripts, whose standard input is
+	// rarely a terminal, do not wait on it.
+	readStdin := false
+	var rest []string
+	for _, arg := range pos[1:] {
+		if arg == "-" {
+			readStdin = true
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	path, patterns, err := splitPatterns(rest)
+	if err != nil {
+		return err
+	}
+	tables = append(tables, patterns...)
+
+	// The standard input is copied to a directory of its own, removed when
+	// done, which is the database unless another directory is given.
+	if err := csvql.SetTempDir(*tempDir); err != nil {
+		return err
+	}
+	work, err := ioutil.TempDir(*tempDir, "csvql-query-")
+	if err != nil {
+		return fmt.Errorf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(work)
+	if err := csvql.SetTempDir(work); err != nil {
+		return err
+	}
+	dir := work
+	if path != "" {
+		if dir, err = filepath.Abs(path); err != nil {
+			return fmt.Errorf("could not find path: %v", err)
+		}
+	}
+	csvql.SetDeleteAll(*deleteAll)
+	if err := files.apply(); err != nil {
+		return err
+	}
+
+	dbs, err := namedDatabases(databases, sqlite, mysqls)
+	if err != nil {
+		return err
+	}
+	db, err := csvql.NewDatabase(dir)
+	if err != nil {
+		return fmt.Errorf("could not create database: %v", err)
+	}
+	for _, spec := range tables {
+		if err := csvql.AddTable(db, spec); err != nil {
+			return err
+		}
+	}
+	if readStdin || piped(os.Stdin) && readsTable(pos[0], strings.SplitN(*stdin, ";", 2)[0]) {
+		if err := csvql.AddReader(db, *stdin, os.Stdin); err != nil {
+			return err
+		}
+	}
+
+	engine := csvql.NewEngine(append(dbs, db)...)
+	if err := engine.SetExportDir(*exportDir); err != nil {
+		return err
+	}
+	schema, rows, err := engine.Query(sql.NewEmptyContext(), pos[0])
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	w, err := csvql.NewRowWriter(os.Stdout, *to, schema)
+	if err != nil {
+		return err
+	}
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// piped reports whether a file is a pipe or a regular file, rather than a
+// terminal.
+func piped(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice == 0
+}
+
+// readsTable reports whether a query mentions a table, by its name, quoted
+// or not, and not qualified by the one of a database.
+func readsTable(query, name string) bool {
+	name = regexp.QuoteMeta(strings.TrimSpace(name))
+	return regexp.MustCompile("(?i)(?:^|[^\\w.$`])(?:`" + name + "`|" + name + ")(?:$|[^\\w$`])").MatchString(query)
+}
+
+// isQuery reports whether an argument is a query, or a statement changing the
+// tables, rather than a directory.
+func isQuery(arg string) bool {
+	fields := strings.Fields(arg)
+	if len(fields) < 2 {
+		return false
+	}
+	switch strings.ToLower(fields[0]) {
+	case "select", "with", "show", "describe", "desc", "explain", "analyze",
+		"insert", "update", "delete", "create", "drop", "refresh", "copy":
+		return true
+	}
+	return false
+}
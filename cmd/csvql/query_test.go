@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestIsQuery(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want bool
+	}{
+		{"SELECT * FROM t", true},
+		{"with t AS (SELECT 1) SELECT * FROM t", true},
+		{"SHOW TABLES", true},
+		{"DESCRIBE t", true},
+		{"EXPLAIN SELECT * FROM t", true},
+		{"ANALYZE TABLE t", true},
+		{"INSERT INTO t VALUES (1)", true},
+		{"UPDATE t SET a = 1 WHERE b = 2", true},
+		{"DELETE FROM t WHERE a = 1", true},
+		{"CREATE TABLE t (a INT)", true},
+		{"CREATE TABLE t AS SELECT * FROM u", true},
+		{"COPY (SELECT * FROM t) TO 'out.csv'", true},
+		{"DROP MATERIALIZED VIEW v", true},
+		{"data", false},
+		{"select", false},
+		{"my data", false},
+		{"logs/2024-*.csv", false},
+	}
+	for _, test := range tests {
+		if got := isQuery(test.arg); got != test.want {
+			t.Errorf("isQuery(%q) = %v, want %v", test.arg, got, test.want)
+		}
+	}
+}
+
+func TestReadsTable(t *testing.T) {
+	tests := []struct {
+		query, name string
+		want        bool
+	}{
+		{"SELECT name FROM stdin WHERE age > 30", "stdin", true},
+		{"SELECT * FROM `logs`", "logs", true},
+		{"select * from LOGS join users on logs.id = users.id", "logs", true},
+		{"SELECT * FROM people", "stdin", false},
+		{"SELECT stdin_count FROM stdins", "stdin", false},
+		{"SELECT * FROM work.stdin", "stdin", false},
+		{"SELECT * FROM `my logs`", "logs", false},
+	}
+	for _, test := range tests {
+		if got := readsTable(test.query, test.name); got != test.want {
+			t.Errorf("readsTable(%q, %q) = %v, want %v", test.query, test.name, got, test.want)
+		}
+	}
+}
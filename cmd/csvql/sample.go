@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/campoy/csvql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+// sample writes a uniformly random sample of the rows in a CSV file, keeping
+// them in their original order, and their values and header as they are
+// written unless the flags tell how they are typed.
+func sample(args []string) error {
+	fs := flag.NewFlagSet("sample", flag.ExitOnError)
+	n := fs.Int("n", 1000, "number of rows in the sample")
+	seed := fs.Int64("seed", 0, "seed for the random generator (defaults to a random one, which is reported)")
+	format := fs.String("format", "csv", fmt.Sprintf("output format, one of %v", csvql.Formats))
+	charset := fs.String("charset", "utf-8", "character set of the output, such as windows-1252")
+	files := addFileFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: csvql sample [flags] file.csv\n")
+		fs.PrintDefaults()
+	}
+
+	paths, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := files.apply(); err != nil {
+		return err
+	}
+	if err := readAsText(fs); err != nil {
+		return err
+	}
+	if len(paths) != 1 || *n < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if !isFlagSet(fs, "seed") {
+		*seed = time.Now().UnixNano()
+		fmt.Fprintf(os.Stderr, "sampling with --seed %d\n", *seed)
+	}
+	rnd := rand.New(rand.NewSource(*seed))
+
+	t, err := csvql.NewTable(paths[0])
+	if err != nil {
+		return err
+	}
+	return writeSample(os.Stdout, t, sourceSchema(fs, t), *n, rnd, *format, *charset)
+}
+
+// writeSample writes a sample of n rows of a table to w, chosen with rnd, in
+// the given format and charset.
+func writeSample(w io.Writer, t sql.Table, schema sql.Schema, n int, rnd *rand.Rand, format, charset string) error {
+	rows, err := plan.NewResolvedTable(t).RowIter(sql.NewEmptyContext())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// Reservoir sampling, keeping the position of each row in the file.
+	type sampled struct {
+		pos int64
+		row sql.Row
+	}
+	reservoir := make([]sampled, 0, n)
+	for pos := int64(0); ; pos++ {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(reservoir) < n {
+			reservoir = append(reservoir, sampled{pos, row})
+		} else if j := rnd.Int63n(pos + 1); j < int64(n) {
+			reservoir[j] = sampled{pos, row}
+		}
+	}
+	sort.Slice(reservoir, func(i, j int) bool { return reservoir[i].pos < reservoir[j].pos })
+
+	rw, err := csvql.NewCharsetRowWriter(w, format, charset, schema)
+	if err != nil {
+		return err
+	}
+	for _, s := range reservoir {
+		if err := rw.Write(s.row); err != nil {
+			return err
+		}
+	}
+	return rw.Close()
+}
+
+// isFlagSet reports whether the flag with the given name was explicitly set.
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
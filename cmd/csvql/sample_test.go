@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/campoy/csvql"
+)
+
+func TestSampleRawRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.csv")
+	content := "Order ID,Paid,Amount\n1,yes,1.50\n2,no,2.00\n3,yes,3.25\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer csvql.SetInferRows(1000)
+
+	tests := []struct {
+		args []string
+		n    int
+		want string
+	}{
+		{nil, 10, content},
+		// The header and the values are kept as they are written.
+		{nil, 2, "Order ID,Paid,Amount\n"},
+		// Types are only inferred when asked for.
+		{[]string{"--infer-rows", "10"}, 10, "Order ID,Paid,Amount\n1,true,1.5\n2,false,2\n3,true,3.25\n"},
+		{[]string{"--sanitize-headers"}, 10, "order_id,paid,amount\n1,yes,1.50\n2,no,2.00\n3,yes,3.25\n"},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("sample", flag.ContinueOnError)
+		files := addFileFlags(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		if err := files.apply(); err != nil {
+			t.Fatal(err)
+		}
+		if err := readAsText(fs); err != nil {
+			t.Fatal(err)
+		}
+		table, err := csvql.NewTable(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := writeSample(&out, table, sourceSchema(fs, table), tt.n, rand.New(rand.NewSource(1)), "csv", "utf-8"); err != nil {
+			t.Errorf("%v: %v", tt.args, err)
+			continue
+		}
+		got := out.String()
+		if tt.n < 3 {
+			// The rows sampled are some of the ones of the file, in their order.
+			lines := strings.SplitAfter(got, "\n")
+			if !strings.HasPrefix(got, tt.want) || strings.Count(got, "\n") != tt.n+1 || !inOrder(content, lines[1:]) {
+				t.Errorf("%v: got\n%s", tt.args, got)
+			}
+		} else if got != tt.want {
+			t.Errorf("%v: got\n%s\nwant\n%s", tt.args, got, tt.want)
+		}
+	}
+}
+
+// inOrder reports whether the lines are found in s in the same order.
+func inOrder(s string, lines []string) bool {
+	for _, line := range lines {
+		i := strings.Index(s, line)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(line):]
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/campoy/csvql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// schema groups the subcommands managing schema files.
+func schema(args []string) error {
+	if len(args) == 0 || args[0] != "infer" {
+		fmt.Fprintf(os.Stderr, "usage: csvql schema infer [flags] file.csv...\n")
+		os.Exit(2)
+	}
+	return inferSchema(args[1:])
+}
+
+// inferSchema infers the schema of CSV files and writes it to their sidecar
+// schema files.
+func inferSchema(args []string) error {
+	fs := flag.NewFlagSet("schema infer", flag.ExitOnError)
+	force := fs.Bool("force", false, "overwrite existing schema files")
+	stdout := fs.Bool("stdout", false, "write the schemas to the standard output instead")
+	symbols := fs.Bool("symbols", false, "infer numbers with currency symbols, thousands separators, or percent signs")
+	nanAsNull := fs.Bool("nan-as-null", false, "read NaN values in float columns as NULL")
+	locale := fs.String("locale", "", "locale the numbers are written in, such as de for 1.234,56")
+	identifiers := fs.Bool("identifiers", false, "keep as text the integers all written with the same number of digits, from 5 on, such as account numbers")
+	files := addFileFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: csvql schema infer [flags] file.csv...\n")
+		fs.PrintDefaults()
+	}
+
+	paths, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := files.apply(); err != nil {
+		return err
+	}
+	// The files are read as text, since their types are inferred below.
+	if err := csvql.SetInferRows(0); err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	for _, path := range paths {
+		out := csvql.SchemaPath(path)
+		if *stdout {
+			out = "/dev/stdout"
+		} else if _, err := os.Stat(out); err == nil && !*force {
+			return fmt.Errorf("%s already exists, use --force to overwrite it", out)
+		}
+
+		t, err := csvql.NewTable(path)
+		if err != nil {
+			return err
+		}
+		s, err := csvql.InferSchema(sql.NewEmptyContext(), t, csvql.InferOptions{
+			Symbols:     *symbols,
+			NaNAsNull:   *nanAsNull,
+			Locale:      *locale,
+			Identifiers: *identifiers,
+		})
+		if err != nil {
+			return err
+		}
+		if err := s.WriteFile(out, "schema inferred by csvql from "+path); err != nil {
+			return fmt.Errorf("could not write %s: %v", out, err)
+		}
+		if !*stdout {
+			fmt.Fprintf(os.Stderr, "wrote %s\n", out)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/campoy/csvql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// stats profiles the columns in a CSV file, writing a row per column.
+func stats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	topK := fs.Int("k", 5, "number of most frequent values to report per column")
+	format := fs.String("format", "table", fmt.Sprintf("output format, one of %v", csvql.Formats))
+	charset := fs.String("charset", "utf-8", "character set of the output, such as windows-1252")
+	files := addFileFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: csvql stats [flags] file.csv\n")
+		fs.PrintDefaults()
+	}
+
+	paths, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := files.apply(); err != nil {
+		return err
+	}
+	if len(paths) != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	t, err := csvql.NewTable(paths[0])
+	if err != nil {
+		return err
+	}
+	profiles, err := csvql.Profile(sql.NewEmptyContext(), t, *topK)
+	if err != nil {
+		return err
+	}
+
+	schema := sql.Schema{
+		{Name: "column", Type: sql.Text},
+		{Name: "count", Type: sql.Int64},
+		{Name: "null_ratio", Type: sql.Float64},
+		{Name: "distinct", Type: sql.Int64},
+		{Name: "min", Type: sql.Text, Nullable: true},
+		{Name: "max", Type: sql.Text, Nullable: true},
+		{Name: "mean", Type: sql.Float64, Nullable: true},
+		{Name: "top", Type: sql.Text},
+	}
+	w, err := csvql.NewCharsetRowWriter(os.Stdout, *format, *charset, schema)
+	if err != nil {
+		return err
+	}
+	for _, p := range profiles {
+		var mean interface{}
+		if p.Numeric {
+			mean = p.Mean
+		}
+		var top []string
+		for _, v := range p.TopValues {
+			top = append(top, fmt.Sprintf("%s (%d)", v.Value, v.Count))
+		}
+
+		row := sql.NewRow(p.Name, p.Count, p.NullRatio(), p.Distinct, p.Min, p.Max, mean, strings.Join(top, ", "))
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/campoy/csvql"
+)
+
+// validate checks CSV files against a schema, writing every violation found
+// as a JSON object per line to the standard output.
+func validate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	schema := fs.String("schema", "", "YAML or JSON file describing the expected columns")
+	files := addFileFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: csvql validate --schema schema.yaml file.csv...\n")
+		fs.PrintDefaults()
+	}
+
+	paths, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := files.apply(); err != nil {
+		return err
+	}
+	if *schema == "" || len(paths) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	s, err := csvql.LoadSchema(*schema)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	var violations int
+	for _, path := range paths {
+		err := csvql.Validate(path, s, func(v csvql.Violation) {
+			violations++
+			enc.Encode(v)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if violations > 0 {
+		fmt.Fprintf(os.Stderr, "found %d violations in %d files\n", violations, len(paths))
+		os.Exit(1)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/campoy/csvql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// warm refreshes the stale materialized views and profiles the tables of a
+// directory, so the first queries of a server over it are not slower.
+func warm(args []string) error {
+	fs := flag.NewFlagSet("warm", flag.ExitOnError)
+	tables := fs.String("tables", "", "comma separated tables to warm, all of them by default")
+	format := fs.String("format", "table", fmt.Sprintf("output format, one of %v", csvql.Formats))
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: csvql warm [flags] [dir]\n")
+		fs.PrintDefaults()
+	}
+
+	dirs, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(dirs) > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dir := "."
+	if len(dirs) > 0 {
+		dir = dirs[0]
+	}
+
+	db, err := csvql.NewDatabase(dir)
+	if err != nil {
+		return fmt.Errorf("could not create database: %v", err)
+	}
+	var names []string
+	for _, name := range strings.Split(*tables, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	results, err := csvql.NewEngine(db).Warm(sql.NewEmptyContext(), names...)
+	if err != nil {
+		return err
+	}
+
+	schema := sql.Schema{
+		{Name: "table", Type: sql.Text},
+		{Name: "refreshed", Type: sql.Boolean},
+		{Name: "rows", Type: sql.Int64},
+		{Name: "seconds", Type: sql.Float64},
+	}
+	w, err := csvql.NewRowWriter(os.Stdout, *format, schema)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if err := w.Write(sql.NewRow(r.Table, r.Refreshed, r.Rows, r.Duration.Seconds())); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
@@ -0,0 +1,194 @@
+package csvql
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// compressions maps the extensions of compressed files to their compression.
+var compressions = map[string]string{
+	".gz":   "gzip",
+	".gzip": "gzip",
+	".zst":  "zstd",
+	".zstd": "zstd",
+	".bz2":  "bzip2",
+	".xz":   "xz",
+}
+
+// readCompressions are the compressions of the files that can be read.
+var readCompressions = map[string]bool{
+	"gzip":  true,
+	"zstd":  true,
+	"bzip2": true,
+	"xz":    true,
+	"none":  true,
+}
+
+// tableCompression is the compression of the files of new databases,
+// instead of the one given by their extensions, if set.
+var tableCompression string
+
+// SetCompression sets the compression of the files of the databases created
+// afterwards, gzip, zstd, bzip2, xz, or none, regardless of their extensions.
+// By default, .gz files are decompressed with gzip, .zst files with zstd,
+// .bz2 files with bzip2, and .xz files with xz.
+func SetCompression(compression string) error {
+	c, err := parseCompression(compression)
+	if err != nil {
+		return err
+	}
+	tableCompression = c
+	return nil
+}
+
+func parseCompression(s string) (string, error) {
+	c := strings.ToLower(strings.TrimSpace(s))
+	if c != "" && !readCompressions[c] {
+		return "", fmt.Errorf("unknown compression %q, expected gzip, zstd, bzip2, xz, or none", s)
+	}
+	return c, nil
+}
+
+// compression returns the compression of a file read in the dialect, or an
+// empty string if it is not compressed.
+func (d Dialect) compression(path string) string {
+	switch d.Compression {
+	case "":
+		_, c := SplitCompression(path)
+		return c
+	case "none":
+		return ""
+	}
+	return d.Compression
+}
+
+// SplitCompression returns the path without the extension of its compression,
+// if any, and the name of the compression.
+func SplitCompression(path string) (base, compression string) {
+	path = objectName(path)
+	ext := strings.ToLower(filepath.Ext(path))
+	if c, ok := compressions[ext]; ok {
+		return strings.TrimSuffix(path, filepath.Ext(path)), c
+	}
+	return path, ""
+}
+
+// compressed reports whether any of the files of the table is compressed, so
+// rows can not be appended to it nor found by their offsets.
+func (t *table) compressed() bool {
+	for _, path := range t.files {
+		if t.dialect.compression(path) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// decompressReader returns a reader of the contents of a file read from r,
+// decompressed with the given compression, if any. It must be closed once
+// read, which does not close r.
+func decompressReader(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case "":
+		return ioutil.NopCloser(r), nil
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReadCloser{d}, nil
+	case "bzip2":
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	case "xz":
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(xr), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %s", compression)
+	}
+}
+
+// zstdReadCloser stops the goroutines of a zstd stream decoder when closed.
+type zstdReadCloser struct{ *zstd.Decoder }
+
+func (r zstdReadCloser) Close() error {
+	r.Decoder.Close()
+	return nil
+}
+
+// NewCompressWriter returns a writer compressing what is written to it with
+// the given compression, gzip or zstd, before writing it to w. With no
+// compression, it writes to w directly. The writer must be closed to flush
+// its remaining output.
+func NewCompressWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "":
+		return nopCloser{w}, nil
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("could not create zstd writer: %v", err)
+		}
+		return zw, nil
+	default:
+		return nil, fmt.Errorf("unknown compression %s", compression)
+	}
+}
+
+var zstdDecoder struct {
+	once sync.Once
+	d    *zstd.Decoder
+	err  error
+}
+
+// decodeZstd decompresses a zstd frame, whose contents have the given size
+// if known.
+func decodeZstd(b []byte, size int64) ([]byte, error) {
+	zstdDecoder.once.Do(func() { zstdDecoder.d, zstdDecoder.err = zstd.NewReader(nil) })
+	if zstdDecoder.err != nil {
+		return nil, zstdDecoder.err
+	}
+	if size < 0 || size > math.MaxInt32 {
+		size = 0
+	}
+	return zstdDecoder.d.DecodeAll(b, make([]byte, 0, size))
+}
+
+// exportFormat returns the format of a file given its path, ignoring the
+// extension of its compression.
+func exportFormat(path string) string {
+	base, _ := SplitCompression(path)
+	return strings.TrimPrefix(filepath.Ext(base), ".")
+}
+
+// writeCompressedRows is like writeRows, but it compresses the output as the
+// extension of the path tells.
+func writeCompressedRows(w io.Writer, path, format, charset string, schema sql.Schema, rows sql.RowIter) (int64, error) {
+	_, compression := SplitCompression(path)
+	cw, err := NewCompressWriter(w, compression)
+	if err != nil {
+		return 0, err
+	}
+	n, err := writeRows(cw, format, charset, schema, rows)
+	if cerr := cw.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
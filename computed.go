@@ -0,0 +1,132 @@
+package csvql
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/expression"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/expression/function"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/parse"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+// computedColumn is a column of a table whose values are computed from the
+// ones of the previous columns of its rows, rather than read from its files.
+type computedColumn struct {
+	expr sql.Expression
+	// typ is the type the values are converted to, or nil to keep the one of
+	// the expression.
+	typ sql.Type
+}
+
+var (
+	functionsOnce sync.Once
+	allFunctions  *sql.Catalog
+)
+
+// functionCatalog returns a catalog with the functions of queries, resolving
+// the ones of the computed columns.
+func functionCatalog() *sql.Catalog {
+	functionsOnce.Do(func() {
+		allFunctions = sql.NewCatalog()
+		allFunctions.RegisterFunctions(function.Defaults)
+		registerFunctions(allFunctions.FunctionRegistry)
+	})
+	return allFunctions
+}
+
+// parseExpression parses a SQL expression, as found in a SELECT clause.
+func parseExpression(s string) (sql.Expression, error) {
+	n, err := parse.Parse(sql.NewEmptyContext(), "SELECT "+s+" FROM t")
+	if err != nil {
+		return nil, err
+	}
+	p, ok := n.(*plan.Project)
+	if !ok || len(p.Projections) != 1 {
+		return nil, fmt.Errorf("not an expression of the columns of a row: %s", s)
+	}
+	return p.Projections[0], nil
+}
+
+// addComputedColumns adds to a table the columns of a schema computed with
+// their expressions, which can use the columns of its files and the computed
+// columns before them.
+func (t *table) addComputedColumns(s *TableSchema) error {
+	for _, col := range s.Columns {
+		if col.Expression == "" {
+			continue
+		}
+		name := strings.ToLower(col.Name)
+		if sql.Schema(t.schema).Contains(name, t.name) {
+			return fmt.Errorf("could not compute column %s of table %s: its files have it", name, t.name)
+		}
+		e, err := parseExpression(col.Expression)
+		if err == nil {
+			e, err = resolvePredicate(functionCatalog(), e, t.schema)
+		}
+		if err == nil {
+			e, err = e.TransformUp(nullArithmetic)
+		}
+		if err != nil {
+			return fmt.Errorf("could not compute column %s of table %s: %v", name, t.name, err)
+		}
+		c := computedColumn{expr: e}
+		typ := e.Type()
+		if col.Type != "" {
+			if typ, err = ParseType(col.Type); err != nil {
+				return fmt.Errorf("could not compute column %s of table %s: %v", name, t.name, err)
+			}
+			c.typ = typ
+		}
+		t.schema = append(t.schema, &sql.Column{Name: name, Type: typ, Nullable: !col.NotNull, Source: t.name})
+		t.computed = append(t.computed, c)
+	}
+	return nil
+}
+
+// nullArithmetic makes the arithmetic of an expression NULL when any of its
+// operands is, as in MySQL, rather than an error, as in go-mysql-server.
+func nullArithmetic(e sql.Expression) (sql.Expression, error) {
+	if a, ok := e.(*expression.Arithmetic); ok {
+		return &nullSafeArithmetic{a}, nil
+	}
+	return e, nil
+}
+
+type nullSafeArithmetic struct {
+	*expression.Arithmetic
+}
+
+func (a *nullSafeArithmetic) Eval(ctx *sql.Context, row sql.Row) (interface{}, error) {
+	for _, e := range a.Children() {
+		v, err := e.Eval(ctx, row)
+		if err != nil || v == nil {
+			return nil, err
+		}
+	}
+	return a.Arithmetic.Eval(ctx, row)
+}
+
+// compute sets the values of the computed columns of a row, after the n
+// values read from the files, returning their errors.
+func (t *table) compute(ctx *sql.Context, row sql.Row, n int) []string {
+	var errs []string
+	for i, c := range t.computed {
+		name := t.schema[n+i].Name
+		v, err := c.expr.Eval(ctx, row[:n+i])
+		if err == nil && c.typ != nil {
+			v, err = c.typ.Convert(v)
+		}
+		if err == nil && v == nil && !t.schema[n+i].Nullable {
+			err = fmt.Errorf("missing %s value", TypeName(t.schema[n+i].Type))
+		}
+		if err != nil {
+			errs = append(errs, name+": "+err.Error())
+			v = nil
+		}
+		row[n+i] = v
+	}
+	return errs
+}
@@ -0,0 +1,614 @@
+package csvql
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// consolePageRows is the number of rows in a page of results of the console,
+// unless another one is requested, and consoleMaxPageRows the largest.
+const (
+	consolePageRows    = 100
+	consoleMaxPageRows = 1000
+)
+
+// consoleCursorTTL is how long the results of a query are kept for their
+// next pages after one was read, and consoleMaxCursors how many are kept at
+// once, closing the ones read the longest ago.
+const (
+	consoleCursorTTL  = 5 * time.Minute
+	consoleMaxCursors = 64
+)
+
+// NewConsole returns an HTTP handler serving a web page where queries can be
+// run with the engine, with the tables listed on a side bar, results shown in
+// pages, and downloaded as CSV. With grants, users log in with HTTP basic
+// authentication and only see what their grants allow.
+//
+// Besides the page at /, it serves:
+//
+//	GET  /api/tables                         the tables and their columns
+//	POST /api/query  {query, limit}          the first page of the results of a query
+//	POST /api/query  {cursor, limit}         their next page
+//	POST /api/download  query=...            all the results of a query as CSV
+//
+// The requests made by pages of other sites are rejected, and downloads are
+// only allowed for the statements which do not write.
+func NewConsole(e *Engine, opts ServerOptions) http.Handler {
+	c := &console{e: e, opts: opts, cursors: make(map[string]*consoleCursor)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", c.page)
+	mux.HandleFunc("/api/tables", c.tables)
+	mux.HandleFunc("/api/query", c.post(c.query))
+	mux.HandleFunc("/api/download", c.post(c.download))
+	return c.auth(mux)
+}
+
+type console struct {
+	e    *Engine
+	opts ServerOptions
+
+	mu      sync.Mutex
+	cursors map[string]*consoleCursor
+}
+
+// consoleCursor holds the rows of a query whose pages have not all been
+// read yet.
+type consoleCursor struct {
+	cursor
+	user   string
+	cancel context.CancelFunc
+	// next is the row read after the last page, to know there are more.
+	next sql.Row
+	last time.Time
+}
+
+// auth checks the user and password of each request against the grants, if
+// any, before handling it.
+func (c *console) auth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.e.grants != nil {
+			name, password, ok := r.BasicAuth()
+			u := c.e.grants.user(name)
+			if !ok || u == nil || u.Password != password {
+				w.Header().Set("WWW-Authenticate", `Basic realm="csvql"`)
+				http.Error(w, "access denied", http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// post only lets POST requests of the pages of the console through, since
+// other sites can make browsers send any request with the credentials of
+// the console.
+func (c *console) post(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !sameOrigin(r) {
+			consoleError(w, fmt.Errorf("cross-origin requests are not allowed"), http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// sameOrigin reports whether a request was made by the console, or by a
+// client other than a browser, rather than by the page of another site,
+// which browsers tell in the Origin and Sec-Fetch-Site headers of POST
+// requests.
+func sameOrigin(r *http.Request) bool {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err == nil && u.Host == r.Host
+	}
+	site := r.Header.Get("Sec-Fetch-Site")
+	return site == "" || site == "same-origin" || site == "none"
+}
+
+// context returns the context to run the queries of a request in, with a new
+// session of the user that made it.
+func (c *console) context(ctx context.Context, r *http.Request) *sql.Context {
+	user, _, _ := r.BasicAuth()
+	s := sql.NewSession(r.RemoteAddr, user)
+	if c.opts.SQLMode != "" {
+		s.Set("sql_mode", sql.Text, c.opts.SQLMode)
+	}
+	return sql.NewContext(ctx, sql.WithSession(s))
+}
+
+func (c *console) page(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, consolePage)
+}
+
+type consoleTable struct {
+	Name    string          `json:"name"`
+	Columns []consoleColumn `json:"columns"`
+}
+
+type consoleColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func (c *console) tables(w http.ResponseWriter, r *http.Request) {
+	db, err := c.e.Catalog.Database(c.e.Analyzer.CurrentDatabase)
+	if err != nil {
+		consoleError(w, err, http.StatusInternalServerError)
+		return
+	}
+	var u *UserGrants
+	if c.e.grants != nil {
+		user, _, _ := r.BasicAuth()
+		u = c.e.grants.user(user)
+	}
+
+	tables := []consoleTable{}
+	for name, t := range db.Tables() {
+		if u != nil {
+			if _, ok := u.table(name); !ok {
+				continue
+			}
+		}
+		ct := consoleTable{Name: name}
+		for _, col := range t.Schema() {
+			ct.Columns = append(ct.Columns, consoleColumn{col.Name, TypeName(col.Type)})
+		}
+		tables = append(tables, ct)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	consoleJSON(w, tables)
+}
+
+type consoleRequest struct {
+	Query  string `json:"query"`
+	Cursor string `json:"cursor"`
+	Limit  int64  `json:"limit"`
+}
+
+type consoleResult struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+	// More is set when there are rows after the ones returned, which are
+	// read with the cursor.
+	More     bool    `json:"more"`
+	Cursor   string  `json:"cursor,omitempty"`
+	Duration float64 `json:"duration_ms"`
+}
+
+// query returns the first page of the results of a query, or the next one
+// of the results of the cursor given. The rows of the pages are read from a
+// single run of the query, which is kept open until its last page is read.
+func (c *console) query(w http.ResponseWriter, r *http.Request) {
+	var req consoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		consoleError(w, fmt.Errorf("could not parse request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Limit <= 0 || req.Limit > consoleMaxPageRows {
+		req.Limit = consolePageRows
+	}
+
+	start := time.Now()
+	user, _, _ := r.BasicAuth()
+	var cur *consoleCursor
+	var rows [][]interface{}
+	var err error
+	if req.Cursor != "" {
+		if cur = c.takeCursor(req.Cursor, user); cur == nil {
+			consoleError(w, fmt.Errorf("the results of the query are no longer available, run it again"), http.StatusBadRequest)
+			return
+		}
+		rows, err = cur.page(req.Limit)
+	} else {
+		// The rows of the query are read after the request ends, so they
+		// are not read in its context.
+		bg, cancel := context.WithCancel(context.Background())
+		ctx := c.context(bg, r)
+		schema, it, qerr := c.open(ctx, req.Query)
+		if err = qerr; err == nil {
+			cur = &consoleCursor{cursor: cursor{schema: schema, rows: it}, user: user, cancel: cancel}
+			rows, err = cur.page(req.Limit)
+		} else {
+			cancel()
+		}
+		// The query is logged once its first page is read.
+		c.log(ctx, req.Query, start, int64(len(rows)), err)
+	}
+	if err != nil {
+		if cur != nil {
+			cur.close()
+		}
+		consoleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	res := consoleResult{Columns: []string{}, Rows: rows}
+	for _, col := range cur.schema {
+		res.Columns = append(res.Columns, col.Name)
+	}
+	if cur.done {
+		cur.close()
+	} else if res.Cursor, err = c.keepCursor(cur); err != nil {
+		cur.close()
+		consoleError(w, err, http.StatusInternalServerError)
+		return
+	}
+	res.More = !cur.done
+	res.Duration = float64(time.Since(start)) / float64(time.Millisecond)
+	consoleJSON(w, res)
+}
+
+// page reads the next page of the rows of a cursor, and the row after them,
+// if any, to know whether there are more.
+func (cur *consoleCursor) page(limit int64) ([][]interface{}, error) {
+	rows := [][]interface{}{}
+	for !cur.done {
+		row := cur.next
+		cur.next = nil
+		if row == nil {
+			var err error
+			if row, err = cur.rows.Next(); err == io.EOF {
+				cur.done = true
+				break
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if int64(len(rows)) == limit {
+			cur.next = row
+			break
+		}
+		values := make([]interface{}, len(row))
+		for i, v := range row {
+			if v != nil {
+				values[i] = formatValue(v)
+			}
+		}
+		rows = append(rows, values)
+	}
+	return rows, nil
+}
+
+// keepCursor keeps a cursor for the next pages of its results, returning
+// the id they are requested with. The cursors not read for a while are
+// closed.
+func (c *console) keepCursor(cur *consoleCursor) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not create cursor: %v", err)
+	}
+	id := hex.EncodeToString(b)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cur.last = time.Now()
+	c.cursors[id] = cur
+	for id, old := range c.cursors {
+		if time.Since(old.last) > consoleCursorTTL {
+			delete(c.cursors, id)
+			old.close()
+		}
+	}
+	for len(c.cursors) > consoleMaxCursors {
+		oldest := ""
+		for id, old := range c.cursors {
+			if oldest == "" || old.last.Before(c.cursors[oldest].last) {
+				oldest = id
+			}
+		}
+		c.cursors[oldest].close()
+		delete(c.cursors, oldest)
+	}
+	return id, nil
+}
+
+// takeCursor returns the cursor with the given id opened by the user, if it
+// is still kept, which is no longer kept until its next page is read.
+func (c *console) takeCursor(id, user string) *consoleCursor {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cur := c.cursors[id]
+	if cur == nil || cur.user != user || time.Since(cur.last) > consoleCursorTTL {
+		return nil
+	}
+	delete(c.cursors, id)
+	return cur
+}
+
+func (cur *consoleCursor) close() {
+	cur.rows.Close()
+	cur.cancel()
+}
+
+// readOnlyQuery matches the statements which only read, unless they are
+// SELECT ... INTO OUTFILE.
+var readOnlyQuery = regexp.MustCompile(`(?is)^[\s(]*(?:select|show|describe|desc|explain)\s`)
+
+// download writes all the results of a query as a CSV file. Only statements
+// which do not write can be downloaded.
+func (c *console) download(w http.ResponseWriter, r *http.Request) {
+	query := r.PostFormValue("query")
+	if !readOnlyQuery.MatchString(query) || outfileStatement.MatchString(query) {
+		consoleError(w, fmt.Errorf("only the results of SELECT, SHOW, DESCRIBE and EXPLAIN can be downloaded"), http.StatusBadRequest)
+		return
+	}
+	limited := c.opts.MaxResultRows > 0 || c.opts.MaxResultBytes > 0
+	_, err := c.run(c.context(r.Context(), r), query, func(schema sql.Schema, rows sql.RowIter) (int64, error) {
+		// With limits, the rows are read before sending any, so going over
+		// them fails the download rather than ending the file early.
+		if limited {
+			all, err := sql.RowIterToRows(rows)
+			if err != nil {
+				return 0, err
+			}
+			rows = sql.RowsToRowIter(all...)
+		}
+		row, err := rows.Next()
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		// Once the first row is sent, errors can only end the file early.
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="results.csv"`)
+		first := sql.RowsToRowIter()
+		if err == nil {
+			first = sql.RowsToRowIter(row)
+		}
+		n, err := writeRows(w, "csv", "", schema, &concatIter{first, rows})
+		if err != nil {
+			log.Printf("could not download results: %v", err)
+		}
+		return n, nil
+	})
+	if err != nil {
+		consoleError(w, err, http.StatusBadRequest)
+	}
+}
+
+// run runs a query in a context, passing its results, limited by the options
+// of the console, to f, and logs it as the server does.
+func (c *console) run(ctx *sql.Context, query string, f func(sql.Schema, sql.RowIter) (int64, error)) (int64, error) {
+	start := time.Now()
+	n, err := func() (int64, error) {
+		schema, rows, err := c.open(ctx, query)
+		if err != nil {
+			return 0, err
+		}
+		defer rows.Close()
+		return f(schema, rows)
+	}()
+	c.log(ctx, query, start, n, err)
+	return n, err
+}
+
+// open runs a query in a context, returning its results limited by the
+// options of the console.
+func (c *console) open(ctx *sql.Context, query string) (sql.Schema, sql.RowIter, error) {
+	c.e.status.questions.Add(1)
+	schema, rows, err := c.e.Query(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	return schema, &limitedIter{RowIter: rows, schema: schema, maxRows: c.opts.MaxResultRows, maxBytes: c.opts.MaxResultBytes}, nil
+}
+
+// log adds a query that returned n rows, or failed, to the query log.
+func (c *console) log(ctx *sql.Context, query string, start time.Time, n int64, err error) {
+	entry := &QueryLogEntry{
+		Time:     start,
+		User:     ctx.Session.User(),
+		Query:    query,
+		Duration: float64(time.Since(start)) / float64(time.Millisecond),
+		Rows:     n,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if lerr := c.e.log.add(entry); lerr != nil {
+		log.Printf("could not log query: %v", lerr)
+	}
+}
+
+// limitedIter fails once more than maxRows are read, or more than maxBytes
+// in their values, as the server does, unless they are zero.
+type limitedIter struct {
+	sql.RowIter
+	schema            sql.Schema
+	maxRows, maxBytes int64
+	n, size           int64
+}
+
+func (it *limitedIter) Next() (sql.Row, error) {
+	row, err := it.RowIter.Next()
+	if err != nil {
+		return nil, err
+	}
+	it.n++
+	if it.maxRows > 0 && it.n > it.maxRows {
+		return nil, fmt.Errorf("result exceeds the limit of %d rows", it.maxRows)
+	}
+	if it.maxBytes > 0 {
+		for _, v := range rowToSQL(it.schema, row) {
+			it.size += int64(v.Len())
+		}
+		if it.size > it.maxBytes {
+			return nil, fmt.Errorf("result exceeds the limit of %d bytes", it.maxBytes)
+		}
+	}
+	return row, nil
+}
+
+// concatIter returns the rows of first, and then the ones of rest.
+type concatIter struct {
+	first, rest sql.RowIter
+}
+
+func (it *concatIter) Next() (sql.Row, error) {
+	if it.first != nil {
+		row, err := it.first.Next()
+		if err != io.EOF {
+			return row, err
+		}
+		it.first = nil
+	}
+	return it.rest.Next()
+}
+
+func (it *concatIter) Close() error { return it.rest.Close() }
+
+func consoleJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("could not write response: %v", err)
+	}
+}
+
+func consoleError(w http.ResponseWriter, err error, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+const consolePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>csvql</title>
+<style>
+body { margin: 0; font: 14px sans-serif; display: flex; height: 100vh; }
+#side { width: 240px; overflow: auto; background: #f4f4f4; padding: 8px; border-right: 1px solid #ddd; }
+#side details { margin-bottom: 4px; }
+#side summary { cursor: pointer; font-weight: bold; }
+#side li { color: #555; cursor: pointer; }
+#main { flex: 1; display: flex; flex-direction: column; padding: 8px; overflow: hidden; }
+textarea { width: 100%; height: 120px; font: 13px monospace; box-sizing: border-box; }
+#bar { margin: 6px 0; }
+#status { color: #555; margin-left: 8px; }
+#error { color: #b00; white-space: pre-wrap; }
+#results { flex: 1; overflow: auto; }
+table { border-collapse: collapse; font: 13px monospace; }
+th, td { border: 1px solid #ddd; padding: 2px 6px; text-align: left; }
+th { background: #eee; position: sticky; top: 0; }
+td.null { color: #aaa; }
+</style>
+</head>
+<body>
+<div id="side"></div>
+<div id="main">
+<textarea id="query" placeholder="SELECT ..."></textarea>
+<div id="bar">
+<button id="run">Run</button>
+<button id="prev" disabled>Previous</button>
+<button id="next" disabled>Next</button>
+<button id="download">Download CSV</button>
+<span id="status"></span>
+</div>
+<div id="error"></div>
+<div id="results"></div>
+</div>
+<script>
+var limit = 100, page = 0, pages = [];
+var $ = function(id) { return document.getElementById(id); };
+
+function el(tag, text, cls) {
+  var e = document.createElement(tag);
+  if (text !== undefined) e.textContent = text;
+  if (cls) e.className = cls;
+  return e;
+}
+
+fetch("api/tables").then(function(r) { return r.json(); }).then(function(tables) {
+  tables.forEach(function(t) {
+    var d = el("details"), s = el("summary", t.name), ul = el("ul");
+    s.ondblclick = function() { $("query").value = "SELECT * FROM " + t.name + " LIMIT 100"; run(); };
+    (t.columns || []).forEach(function(c) {
+      var li = el("li", c.name + " " + c.type);
+      li.onclick = function() { $("query").value += c.name; };
+      ul.appendChild(li);
+    });
+    d.appendChild(s); d.appendChild(ul); $("side").appendChild(d);
+  });
+});
+
+// The pages already read are kept, since the next ones are read from the
+// cursor of the previous one.
+function load(req) {
+  $("status").textContent = "running...";
+  $("error").textContent = "";
+  req.limit = limit;
+  fetch("api/query", {method: "POST", body: JSON.stringify(req)})
+    .then(function(r) { return r.json(); })
+    .then(function(res) {
+      $("status").textContent = "";
+      if (res.error) {
+        $("error").textContent = res.error;
+        if (page > 0 && !pages[page]) page--;
+        return;
+      }
+      pages[page] = res;
+      show();
+    });
+}
+
+function show() {
+  var res = pages[page], offset = page * limit;
+  var t = el("table"), tr = el("tr");
+  res.columns.forEach(function(c) { tr.appendChild(el("th", c)); });
+  t.appendChild(tr);
+  res.rows.forEach(function(row) {
+    var tr = el("tr");
+    row.forEach(function(v) { tr.appendChild(v === null ? el("td", "NULL", "null") : el("td", v)); });
+    t.appendChild(tr);
+  });
+  $("results").innerHTML = "";
+  $("results").appendChild(t);
+  $("prev").disabled = page === 0;
+  $("next").disabled = !res.more;
+  $("status").textContent = "rows " + (res.rows.length ? offset + 1 : 0) + "-" + (offset + res.rows.length) +
+    (res.more ? " of more" : "") + " in " + res.duration_ms.toFixed(1) + " ms";
+}
+
+function run() { page = 0; pages = []; load({query: $("query").value}); }
+
+function download() {
+  var f = el("form"), q = el("input");
+  f.method = "POST"; f.action = "api/download";
+  q.type = "hidden"; q.name = "query"; q.value = $("query").value;
+  f.appendChild(q); document.body.appendChild(f); f.submit(); f.remove();
+}
+
+$("run").onclick = run;
+$("prev").onclick = function() { page--; show(); };
+$("next").onclick = function() {
+  page++;
+  if (pages[page]) show(); else load({cursor: pages[page - 1].cursor});
+};
+$("download").onclick = download;
+$("query").onkeydown = function(e) { if (e.key === "Enter" && (e.ctrlKey || e.metaKey)) run(); };
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,176 @@
+package csvql
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// consoleEngine returns an engine over a table of 250 numbers.
+func consoleEngine(t *testing.T) (*Engine, string) {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("n\n")
+	for i := 1; i <= 250; i++ {
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	return testEngine(t, map[string]string{"numbers.csv": b.String()})
+}
+
+// consoleDo sends a request to a console as the given user, returning
+// the status code and the body of its response.
+func consoleDo(t *testing.T, h http.Handler, r *http.Request, user string) (int, string) {
+	t.Helper()
+	if user != "" {
+		r.SetBasicAuth(user, "")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code, w.Body.String()
+}
+
+// consoleQuery requests a page of results from a console as the given user.
+func consoleQuery(t *testing.T, h http.Handler, user, body string) (int, consoleResult) {
+	t.Helper()
+	code, resp := consoleDo(t, h, httptest.NewRequest("POST", "/api/query", strings.NewReader(body)), user)
+	var res consoleResult
+	if code == http.StatusOK {
+		if err := json.Unmarshal([]byte(resp), &res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return code, res
+}
+
+func TestConsolePages(t *testing.T) {
+	e, _ := consoleEngine(t)
+	h := NewConsole(e, ServerOptions{})
+
+	code, res := consoleQuery(t, h, "", `{"query": "SELECT n FROM numbers", "limit": 100}`)
+	if code != http.StatusOK || len(res.Rows) != 100 || !res.More || res.Cursor == "" {
+		t.Fatalf("got %d, %d rows, more %v, cursor %q", code, len(res.Rows), res.More, res.Cursor)
+	}
+	queries := e.status.queries.Value()
+
+	var got []string
+	for page := 2; res.More; page++ {
+		cursor := res.Cursor
+		if code, res = consoleQuery(t, h, "", `{"cursor": "`+cursor+`", "limit": 100}`); code != http.StatusOK {
+			t.Fatalf("page %d: got %d", page, code)
+		}
+		for _, row := range res.Rows {
+			got = append(got, row[0].(string))
+		}
+		// Cursors are only read once.
+		if code, _ := consoleQuery(t, h, "", `{"cursor": "`+cursor+`"}`); code != http.StatusBadRequest {
+			t.Errorf("reading a page twice got %d", code)
+		}
+	}
+	if len(got) != 150 || got[0] != "101" || got[149] != "250" || res.Cursor != "" {
+		t.Errorf("got rows %v and cursor %q, want 101 to 250 and none", got, res.Cursor)
+	}
+	if n := e.status.queries.Value(); n != queries {
+		t.Errorf("the query was run %d more times for the next pages", n-queries)
+	}
+}
+
+func TestConsoleCursorUsers(t *testing.T) {
+	e, _ := grantsEngine(t)
+	h := NewConsole(e, ServerOptions{})
+
+	_, res := consoleQuery(t, h, "admin", `{"query": "SELECT id FROM sales", "limit": 1}`)
+	if res.Cursor == "" {
+		t.Fatal("got no cursor for the next page")
+	}
+	if code, _ := consoleQuery(t, h, "reader", `{"cursor": "`+res.Cursor+`"}`); code != http.StatusBadRequest {
+		t.Errorf("another user reading the cursor got %d", code)
+	}
+	if code, _ := consoleQuery(t, h, "admin", `{"cursor": "`+res.Cursor+`"}`); code != http.StatusOK {
+		t.Errorf("the user reading the cursor got %d", code)
+	}
+}
+
+func TestConsoleRequests(t *testing.T) {
+	e, dir := consoleEngine(t)
+	h := NewConsole(e, ServerOptions{})
+	form := func(query string) *http.Request {
+		r := httptest.NewRequest("POST", "/api/download", strings.NewReader(url.Values{"query": {query}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	code, body := consoleDo(t, h, form("SELECT n FROM numbers WHERE n < 4"), "")
+	if want := "n\n1\n2\n3\n"; code != http.StatusOK || body != want {
+		t.Errorf("got %d %q, want %q", code, body, want)
+	}
+
+	// Statements which write are not downloaded, nor run.
+	out := filepath.Join(t.TempDir(), "out.csv")
+	for _, q := range []string{
+		"DELETE FROM numbers WHERE n > 0",
+		"SELECT n FROM numbers INTO OUTFILE '" + out + "'",
+		"CREATE TABLE copy AS SELECT n FROM numbers",
+		"COPY (SELECT n FROM numbers) TO '" + out + "'",
+	} {
+		if code, _ := consoleDo(t, h, form(q), ""); code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", q, code)
+		}
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Error("a download wrote a file")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "copy.csv")); err == nil {
+		t.Error("a download created a table")
+	}
+	if rows := queryRows(t, e, "SELECT COUNT(*) FROM numbers"); rows[0][0] != int32(250) {
+		t.Errorf("a download deleted rows, %v are left", rows[0][0])
+	}
+
+	// Only the POST requests of the console are allowed.
+	for _, r := range []*http.Request{
+		httptest.NewRequest("GET", "/api/download?query=SELECT+1", nil),
+		httptest.NewRequest("GET", "/api/query", nil),
+	} {
+		if code, _ := consoleDo(t, h, r, ""); code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: got %d", r.Method, r.URL, code)
+		}
+	}
+	cross := form("SELECT 1")
+	cross.Header.Set("Origin", "http://example.org")
+	if code, _ := consoleDo(t, h, cross, ""); code != http.StatusForbidden {
+		t.Errorf("a download of another site got %d", code)
+	}
+	cross = httptest.NewRequest("POST", "/api/query", strings.NewReader(`{"query": "DELETE FROM numbers"}`))
+	cross.Header.Set("Sec-Fetch-Site", "cross-site")
+	if code, _ := consoleDo(t, h, cross, ""); code != http.StatusForbidden {
+		t.Errorf("a query of another site got %d", code)
+	}
+	same := form("SELECT 1")
+	same.Header.Set("Origin", "http://"+same.Host)
+	if code, _ := consoleDo(t, h, same, ""); code != http.StatusOK {
+		t.Errorf("a download of the console got %d", code)
+	}
+}
+
+func TestConsoleLimits(t *testing.T) {
+	e, _ := consoleEngine(t)
+	for _, opts := range []ServerOptions{{MaxResultRows: 100}, {MaxResultBytes: 100}} {
+		h := NewConsole(e, opts)
+		r := httptest.NewRequest("POST", "/api/download", strings.NewReader("query=SELECT+n+FROM+numbers"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		code, body := consoleDo(t, h, r, "")
+		if code != http.StatusBadRequest || !strings.Contains(body, "exceeds the limit") {
+			t.Errorf("%+v: got %d %.40q, want the limit exceeded", opts, code, body)
+		}
+
+		if code, _ := consoleQuery(t, h, "", `{"query": "SELECT n FROM numbers", "limit": 1000}`); code != http.StatusBadRequest {
+			t.Errorf("%+v: got a page of %d", opts, code)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package csvql
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// tableConstraints is what is done by the scans of the text files of new
+// databases with the rows violating the constraints of their columns, if set.
+var tableConstraints string
+
+// SetConstraints sets what is done by the scans of the delimited and JSON
+// lines files of the databases created afterwards with the rows violating the
+// types, not_null constraints, and patterns of their columns, given by their
+// schemas or inferred: report logs them, with their line and the violations,
+// reading the values that do not have the type of their column as NULL, and
+// reject skips them too. Either way, they are counted by RowsInvalid. By
+// default, the values that do not have their type are errors, unless the
+// _error pseudo column is read, and patterns are not checked.
+func SetConstraints(mode string) error {
+	mode, err := parseConstraints(mode)
+	if err != nil {
+		return err
+	}
+	tableConstraints = mode
+	return nil
+}
+
+func parseConstraints(mode string) (string, error) {
+	switch mode = strings.ToLower(strings.TrimSpace(mode)); mode {
+	case "", "report", "reject":
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid constraints %q, expected report or reject", mode)
+}
+
+// setConstraints sets what is done with the rows of a table violating the
+// constraints of its columns, given by its constraints option or
+// SetConstraints.
+func (d *Dialect) setConstraints(opts map[string]string) error {
+	if d.lines() {
+		d.Constraints = tableConstraints
+	}
+	s, ok := opts["constraints"]
+	if !ok {
+		return nil
+	}
+	if !d.lines() {
+		return fmt.Errorf("%s files have typed columns", d.Format)
+	}
+	mode, err := parseConstraints(s)
+	if err != nil {
+		return err
+	}
+	d.Constraints = mode
+	return nil
+}
+
+// constraintChecks are the constraints checked for the columns of a table
+// while its rows are read, besides their types.
+type constraintChecks struct {
+	reject   bool
+	notNull  []bool
+	patterns []*regexp.Regexp
+}
+
+// useConstraints makes the scans of a table check the constraints of the
+// columns of its schema, if its dialect tells what to do with the rows
+// violating them.
+func (t *table) useConstraints() error {
+	if t.dialect.Constraints == "" {
+		return nil
+	}
+	c := &constraintChecks{
+		reject:   t.dialect.Constraints == "reject",
+		notNull:  make([]bool, len(t.schema)),
+		patterns: make([]*regexp.Regexp, len(t.schema)),
+	}
+	if s := t.dialect.Schema; s != nil {
+		for _, col := range s.Columns {
+			i := sql.Schema(t.schema).IndexOf(strings.ToLower(col.Name), t.name)
+			if i < 0 || col.Expression != "" {
+				continue
+			}
+			c.notNull[i] = col.NotNull
+			if col.Pattern == "" {
+				continue
+			}
+			p, err := regexp.Compile(col.Pattern)
+			if err != nil {
+				return fmt.Errorf("could not use the schema of table %s: invalid pattern of column %s: %v", t.name, col.Name, err)
+			}
+			c.patterns[i] = p
+		}
+	}
+	t.checks = c
+	return nil
+}
+
+// violation returns the constraint of the column i of a table violated by a
+// value, read from the given text, or an empty string if it has none.
+func (t *table) violation(i int, s string, v interface{}) string {
+	if t.checks == nil {
+		return ""
+	}
+	if v == nil || s == "" {
+		if t.checks.notNull[i] {
+			return "null value in not null column"
+		}
+		return ""
+	}
+	if p := t.checks.patterns[i]; p != nil && !p.MatchString(s) {
+		return fmt.Sprintf("value %q does not match pattern %s", s, p)
+	}
+	return ""
+}
+
+// invalid reports the violations of the constraints of a table in a line of
+// one of its files, and whether the row is rejected.
+func (t *table) invalid(line int, path string, errs []string) bool {
+	RowsInvalid.Add(1)
+	action := "is read"
+	if t.checks.reject {
+		action = "is rejected"
+	}
+	log.Printf("line %d of %s violates the schema of table %s, and %s: %s", line, path, t.name, action, strings.Join(errs, "; "))
+	return t.checks.reject
+}
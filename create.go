@@ -0,0 +1,281 @@
+package csvql
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+func init() {
+	table := "(" + namePart + `(?:\.` + namePart + ")?)"
+	statements = append(statements,
+		statement{
+			re:  regexp.MustCompile(`(?is)^\s*create\s+table\s+(if\s+not\s+exists\s+)?` + table + `\s+(?:as\s+)?(\(*\s*select\s.*?)\s*;?\s*$`),
+			run: (*Engine).createTableAs,
+		},
+		statement{
+			re:  regexp.MustCompile(`(?is)^\s*create\s+table\s+(if\s+not\s+exists\s+)?` + table + `\s*\((.*)\)[^()]*?;?\s*$`),
+			run: (*Engine).createTable,
+		},
+	)
+}
+
+var (
+	// columnDefinition matches the definition of a column in CREATE TABLE,
+	// with its name and the rest of it.
+	columnDefinition = regexp.MustCompile("(?s)^\\s*(`(?:[^`]|``)+`|[\\w$]+)\\s+(.*?)\\s*$")
+	// columnType matches the type starting the definition of a column.
+	columnType = regexp.MustCompile(`(?is)^(\w+(?:\s*\([^)]*\))?(?:\s+unsigned)?)`)
+	// generatedColumn matches the start of the expression of a generated
+	// column, as written by SHOW CREATE TABLE.
+	generatedColumn = regexp.MustCompile(`(?is)\bgenerated\s+always\s+as\s*\(`)
+)
+
+// constraintKeywords are the first words of the definitions of CREATE TABLE
+// that are not columns, but keys and constraints, which are not enforced.
+var constraintKeywords = map[string]bool{
+	"primary": true, "key": true, "index": true, "unique": true, "constraint": true,
+	"foreign": true, "check": true, "fulltext": true,
+}
+
+// typeAliases maps the MySQL types that are not in schema files to the ones
+// with the same values.
+var typeAliases = map[string]string{
+	"char":       "text",
+	"tinytext":   "text",
+	"mediumtext": "text",
+	"longtext":   "text",
+	"tinyint":    "int",
+	"smallint":   "int",
+	"mediumint":  "int",
+	"real":       "float",
+}
+
+// createTable runs CREATE TABLE [IF NOT EXISTS] table (column type, ...),
+// creating a CSV file with the names of the columns in its header in the
+// directory of the current database, or the one the name of the table is
+// qualified with, read as a table whose columns have the given types until
+// the database is opened again, when they are inferred from its rows. The
+// generated columns, as written by SHOW CREATE TABLE, are computed ones.
+func (e *Engine) createTable(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	name := strings.ToLower(strings.Replace(m[2], "`", "", -1))
+	db, tname, err := e.createdTable(ctx, name)
+	if err != nil {
+		if m[1] != "" && sql.ErrTableAlreadyExists.Is(err) {
+			return rowsAffected(0)
+		}
+		return nil, nil, err
+	}
+	s, err := parseColumnDefinitions(m[3])
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create table %s: %v", name, err)
+	}
+
+	var header []string
+	for _, col := range s.Columns {
+		if col.Expression == "" {
+			header = append(header, col.Name)
+		}
+	}
+	if len(header) == 0 {
+		return nil, nil, fmt.Errorf("could not create table %s: it has no columns", name)
+	}
+	path := filepath.Join(db.path, tname+".csv")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create %s: %v", path, err)
+	}
+	w := csv.NewWriter(f)
+	if err := w.Write(header); err == nil {
+		w.Flush()
+		err = w.Error()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = db.addCreated(tname, path, s)
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, nil, fmt.Errorf("could not create table %s: %v", name, err)
+	}
+	return rowsAffected(0)
+}
+
+// createTableAs runs CREATE TABLE [IF NOT EXISTS] table AS SELECT ...,
+// writing the results of a query to a new CSV file in the directory of the
+// current database, or the one the name of the table is qualified with, read
+// as a table whose columns have the types of the results until the database
+// is opened again. The file is only in place once all the rows are written.
+func (e *Engine) createTableAs(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	name := strings.ToLower(strings.Replace(m[2], "`", "", -1))
+	db, tname, err := e.createdTable(ctx, name)
+	if err != nil {
+		if m[1] != "" && sql.ErrTableAlreadyExists.Is(err) {
+			return rowsAffected(0)
+		}
+		return nil, nil, err
+	}
+	schema, rows, err := e.selectQuery(ctx, unparen(m[3]))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	s := &TableSchema{}
+	for i, col := range schema {
+		for _, prev := range schema[:i] {
+			if strings.EqualFold(prev.Name, col.Name) {
+				return nil, nil, fmt.Errorf("could not create table %s: the query has several columns named %s", name, col.Name)
+			}
+		}
+		s.Columns = append(s.Columns, &ColumnSchema{Name: col.Name, Type: TypeName(col.Type)})
+	}
+
+	// The file is created first, so no other one takes its name while the
+	// rows are written.
+	path := filepath.Join(db.path, tname+".csv")
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create %s: %v", path, err)
+	}
+	out.Close()
+	f, err := tempFile("table")
+	if err != nil {
+		os.Remove(path)
+		return nil, nil, fmt.Errorf("could not create table %s: %v", name, err)
+	}
+	n, err := writeRows(f, "csv", "", schema, rows)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = moveFile(f.Name(), path)
+	}
+	if err == nil {
+		err = db.addCreated(tname, path, s)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		os.Remove(path)
+		return nil, nil, fmt.Errorf("could not create table %s: %v", name, err)
+	}
+	return rowsAffected(n)
+}
+
+// createdTable returns the database a new table with the given name, which
+// can be qualified with the one of the database, is created in, and its name
+// there.
+func (e *Engine) createdTable(ctx *sql.Context, name string) (*database, string, error) {
+	dbName, tname := e.Analyzer.CurrentDatabase, name
+	if dot := strings.Index(name, "."); dot > 0 {
+		dbName, tname = name[:dot], name[dot+1:]
+	}
+	db, err := e.Catalog.Database(dbName)
+	if err != nil {
+		return nil, "", err
+	}
+	cdb, ok := db.(*database)
+	if !ok {
+		return nil, "", fmt.Errorf("can not create tables in database %s", db.Name())
+	}
+	if err := e.checkWrite(ctx, name); err != nil {
+		return nil, "", err
+	}
+	if _, ok := cdb.Tables()[tname]; ok {
+		return nil, "", sql.ErrTableAlreadyExists.New(name)
+	}
+	return cdb, tname, nil
+}
+
+// addCreated adds the table of a file created by a statement, whose columns
+// have the types of the given schema, checking its file is still the only
+// one with its name.
+func (db *database) addCreated(name, path string, s *TableSchema) error {
+	d := formatDialect("csv")
+	d.Schema = s
+	t, err := newFileTable(name, d, path)
+	if err != nil {
+		return err
+	}
+	t.db = db
+	t.watch(nil, func() (*table, error) { return newFileTable(name, d, path) })
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.tables[name]; ok {
+		return sql.ErrTableAlreadyExists.New(name)
+	}
+	db.tables[name] = t
+	return nil
+}
+
+// parseColumnDefinitions parses the definitions of the columns of CREATE TABLE into
+// the schema of a table, skipping its keys and constraints.
+func parseColumnDefinitions(defs string) (*TableSchema, error) {
+	s := &TableSchema{}
+	for _, def := range splitTopLevel(defs, ',') {
+		m := columnDefinition.FindStringSubmatch(def)
+		if m == nil {
+			return nil, fmt.Errorf("could not parse column %s", strings.TrimSpace(def))
+		}
+		if constraintKeywords[strings.ToLower(m[1])] {
+			continue
+		}
+		col := &ColumnSchema{Name: strings.Replace(strings.Trim(m[1], "`"), "``", "`", -1)}
+		typ := columnType.FindString(m[2])
+		if typ == "" {
+			return nil, fmt.Errorf("column %s has no type", col.Name)
+		}
+		var err error
+		if col.Type, err = schemaType(typ); err != nil {
+			return nil, fmt.Errorf("column %s: %v", col.Name, err)
+		}
+		rest := m[2][len(typ):]
+		if loc := generatedColumn.FindStringIndex(rest); loc != nil {
+			end := closingParen(rest[loc[1]-1:])
+			if end < 0 {
+				return nil, fmt.Errorf("could not parse the expression of column %s", col.Name)
+			}
+			col.Expression = strings.TrimSpace(rest[loc[1] : loc[1]-1+end])
+			rest = rest[:loc[0]] + rest[loc[1]+end:]
+		}
+		if i, _ := topLevelKeyword(rest, 0, "not", "null"); i >= 0 {
+			col.NotNull = true
+		}
+		s.Columns = append(s.Columns, col)
+	}
+	return s, nil
+}
+
+// schemaType returns the name in schema files of a MySQL type, such as
+// VARCHAR(255) or DECIMAL(10,2).
+func schemaType(typ string) (string, error) {
+	// The unsigned integers are read as signed ones.
+	name := strings.TrimSpace(strings.TrimSuffix(strings.ToLower(typ), "unsigned"))
+	if t, ok, err := parseDecimalType(name); ok {
+		if err != nil {
+			return "", err
+		}
+		return TypeName(t), nil
+	}
+	if i := strings.Index(name, "("); i >= 0 {
+		name = strings.TrimSpace(name[:i])
+	}
+	if alias, ok := typeAliases[name]; ok {
+		name = alias
+	}
+	t, err := ParseType(name)
+	if err != nil {
+		return "", err
+	}
+	return TypeName(t), nil
+}
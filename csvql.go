@@ -2,80 +2,409 @@
 package csvql
 
 import (
-	"encoding/csv"
+	"bufio"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"gopkg.in/src-d/go-mysql-server.v0/sql"
 )
 
 type database struct {
-	path   string
+	// name is the one given to the database, if it is not its path.
+	name string
+	path string
+
+	mu     sync.RWMutex
 	tables map[string]sql.Table
+	views  map[string]*view
+	// txs are the transactions in progress in each session.
+	txs map[sql.Session]*transaction
+	// wmu serializes the commits writing to the files.
+	wmu sync.Mutex
 }
 
-// NewDatabase returns a database containing a table per CSV file in the given folder.
+// NewDatabase returns a database containing a table per CSV file in the given
+// folder, and in its subfolders, whose tables are named after them, as in
+// sales.orders for sales/orders.csv. Subfolders partitioned as in Hive, with
+// a folder per value of their partition keys, as in
+// orders/date=2024-01-01/part-0.csv, are a single table, whose partition keys
+// are columns after the ones in the files. The files of the partitions that
+// the conditions on their keys rule out are not read.
+//
+// The folder can also be a ZIP archive, or a tar one, which can be compressed
+// as in bundle.tar.gz, whose files are tables read without extracting them,
+// which can not be written to.
 func NewDatabase(dir string) (sql.Database, error) {
-	fis, err := ioutil.ReadDir(dir)
-	if err != nil {
+	return NewNamedDatabase("", dir)
+}
+
+// NewNamedDatabase returns a database as NewDatabase does, with the given
+// name instead of the path of its folder, so its tables can be read with
+// qualified names, such as hr.people, from queries on other databases of the
+// same Engine.
+func NewNamedDatabase(name, dir string) (sql.Database, error) {
+	if isArchive(dir) && fileExists(dir) {
+		return newArchiveDatabase(name, dir)
+	}
+	if _, err := ioutil.ReadDir(dir); err != nil {
 		return nil, fmt.Errorf("could not read directory %s: %v", dir, err)
 	}
 
-	tables := make(map[string]sql.Table)
+	db := &database{
+		name:   name,
+		path:   dir,
+		tables: make(map[string]sql.Table),
+		views:  make(map[string]*view),
+		txs:    make(map[sql.Session]*transaction),
+	}
+	if err := db.recover(); err != nil {
+		return nil, err
+	}
+	if err := db.addFiles(dir, ""); err != nil {
+		return nil, err
+	}
+	if err := db.loadViews(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// addFiles adds the tables of the files in a folder and its subfolders, with
+// the given prefix in their names. Hidden folders, such as the one where
+// csvql keeps its indexes, are skipped.
+func (db *database) addFiles(dir, prefix string) error {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("could not read directory %s: %v", dir, err)
+	}
 	for _, fi := range fis {
-		name := fi.Name()
-		if filepath.Ext(name) != ".csv" {
+		path := filepath.Join(dir, fi.Name())
+		if fi.IsDir() {
+			if strings.HasPrefix(fi.Name(), ".") {
+				continue
+			}
+			if isPartitioned(path) {
+				name := prefix + fi.Name()
+				t, err := newPartitionedTable(name, path, nil)
+				if err != nil {
+					return fmt.Errorf("could not add table %s: %v", name, err)
+				}
+				t.watch(partitionFiles(path, ""), func() (*table, error) {
+					return newPartitionedTable(name, path, nil)
+				})
+				if _, ok := db.tables[name]; ok {
+					return fmt.Errorf("could not add %s: table %s already has a file", path, name)
+				}
+				db.tables[name] = t
+				continue
+			}
+			if err := db.addFiles(path, prefix+fi.Name()+"."); err != nil {
+				return err
+			}
 			continue
 		}
+		name, format := splitFormat(fi.Name())
+		if format == "" && !hasLayout(path) {
+			continue
+		}
+		if isFIFO(path) {
+			t, err := newStreamTable(prefix+name, path, nil)
+			if err != nil {
+				return fmt.Errorf("could not add table %s: %v", prefix+name, err)
+			}
+			db.tables[t.name] = t
+			continue
+		}
+		if err := db.addFile(path, prefix+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addFile adds the table of a file with the given name, or the ones of the
+// worksheets of a workbook.
+func (db *database) addFile(path, name string) error {
+	tables, err := fileTables(path, name)
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		if _, ok := db.tables[t.name]; ok {
+			return fmt.Errorf("could not add %s: table %s already has a file", path, t.name)
+		}
+		if t.seekable() {
+			t.db = db
+		}
+		if !t.archived() {
+			t.watch(nil, reloadFileTable(path, name, t.name))
+		}
+		db.tables[t.name] = t
+	}
+	return nil
+}
 
-		t, err := NewTable(filepath.Join(dir, name))
+// fileTables returns the table of a file with the given name, or the ones of
+// the worksheets of a workbook.
+func fileTables(path, name string) ([]*table, error) {
+	d, err := fileDialect(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.Format == "xlsx" {
+		return workbookTables(name, d, path)
+	}
+	t, err := newFileTable(name, d, path)
+	if err != nil {
+		return nil, err
+	}
+	return []*table{t}, nil
+}
+
+// reloadFileTable returns a function building again the table of a file
+// added by addFile, which can be one of the worksheets of a workbook.
+func reloadFileTable(path, name, tableName string) func() (*table, error) {
+	return func() (*table, error) {
+		tables, err := fileTables(path, name)
 		if err != nil {
 			return nil, err
 		}
-		tables[t.Name()] = t
+		for _, t := range tables {
+			if t.name == tableName {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("%s no longer has table %s", path, tableName)
 	}
+}
 
-	return &database{path: dir, tables: tables}, nil
+func (db *database) Name() string {
+	if db.name != "" {
+		return db.name
+	}
+	return db.path
 }
 
-func (db *database) Name() string                 { return db.path }
-func (db *database) Tables() map[string]sql.Table { return db.tables }
+// Tables returns a copy of the tables in the database, since they can change
+// while it is being used.
+func (db *database) Tables() map[string]sql.Table {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	tables := make(map[string]sql.Table, len(db.tables))
+	for name, t := range db.tables {
+		tables[name] = t
+	}
+	return tables
+}
 
-// NewTable returns a table containing the rows in the given CSV file.
+// NewTable returns a table containing the rows in the given CSV file, or TSV,
+// JSON lines, or Parquet file, or the first worksheet of an Excel workbook, as
+// its extension tells. It is read as the files of new databases are, in the
+// dialect sniffed from it and with the types of its columns inferred.
 func NewTable(path string) (sql.Table, error) {
-	name := strings.TrimSuffix(filepath.Base(path), ".csv")
-	t := &table{name: name, path: path}
+	name, _ := splitFormat(path)
+	d, err := fileDialect(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return newFileTable(name, d, path)
+}
 
-	f, err := os.Open(path)
+// NewMultiFileTable returns a table containing the rows in all the given CSV
+// files, whose columns are matched by name. The files are read concurrently.
+func NewMultiFileTable(name string, paths ...string) (sql.Table, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("could not create table %s: no files given", name)
+	}
+	d, err := fileDialect(paths[0], nil)
 	if err != nil {
-		return nil, fmt.Errorf("could not open %s: %v", path, err)
+		return nil, err
 	}
-	defer f.Close()
+	return newFileTable(name, d, paths...)
+}
 
-	cr := csv.NewReader(f)
-	cols, err := cr.Read()
+// formatDialect returns the dialect of a format, or the one of CSV files if
+// it has none.
+func formatDialect(format string) Dialect {
+	if d, ok := dialects[format]; ok {
+		return d
+	}
+	return dialects["csv"]
+}
+
+// newFileTable returns a table containing the rows in all the given files,
+// written in the given dialect.
+func newFileTable(name string, d Dialect, paths ...string) (*table, error) {
+	if d.text() && d.Encoding == "" {
+		enc, err := sniffFiles(name, d, paths)
+		if err != nil {
+			return nil, err
+		}
+		d.Encoding = enc
+	}
+	if s, ok := tableSchemas[name]; ok && d.Schema == nil {
+		if !d.lines() {
+			return nil, fmt.Errorf("could not set the schema of table %s: %s files have typed columns", name, d.Format)
+		}
+		d.Schema = s
+	}
+	t := &table{name: name, path: paths[0], files: paths, dialect: d}
+	if d.Format == "parquet" {
+		schema, err := parquetSchema(name, paths)
+		if err != nil {
+			return nil, err
+		}
+		t.schema = schema
+		return t, nil
+	}
+	if d.Format == "fixed" {
+		t.schema = fixedSchema(name, d)
+		return t, nil
+	}
+	if d.Format == "avro" {
+		schema, err := avroColumns(name, paths)
+		if err != nil {
+			return nil, err
+		}
+		t.schema = schema
+		return t, nil
+	}
+	if d.Format == "xlsx" {
+		schema, err := sheetSchema(name, d, paths)
+		if err != nil {
+			return nil, err
+		}
+		t.schema = schema
+		return t, nil
+	}
+
+	var cols []string
+	var err error
+	if d.delimited() {
+		cols, err = t.unionColumns(paths)
+	} else {
+		cols, err = jsonColumns(paths, d)
+	}
 	if err != nil {
 		return nil, err
 	}
 	for _, col := range cols {
 		t.schema = append(t.schema, &sql.Column{
-			Name:   strings.ToLower(strings.TrimSpace(col)),
+			Name:   col,
 			Type:   sql.Text,
 			Source: name,
 		})
 	}
-
+	t.useFields()
+	switch {
+	case d.Schema != nil:
+		if err := t.useSchema(d.Schema); err != nil {
+			return nil, err
+		}
+	case d.InferRows > 0:
+		if err := t.inferTypes(); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.useDateFormats(); err != nil {
+		return nil, err
+	}
+	if err := t.useConstraints(); err != nil {
+		return nil, err
+	}
 	return t, nil
 }
 
+// readHeader returns the names of the columns of a file, and the ones in its
+// header as they are written, if it has one.
+func readHeader(path string, d Dialect) (cols, header []string, err error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	r, err := d.textReader(f, path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+	defer r.Close()
+	br := bufio.NewReader(r)
+	d.skipStart(br)
+	record, err := d.reader(br).Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !d.NoHeader {
+		header = append([]string(nil), record...)
+	}
+	if d.Columns != nil {
+		if len(d.Columns) != len(record) {
+			return nil, nil, fmt.Errorf("could not name the columns of %s: it has %d columns, not %d", path, len(record), len(d.Columns))
+		}
+		return d.Columns, header, nil
+	}
+	cols = make([]string, len(record))
+	for i, col := range record {
+		switch {
+		case d.NoHeader:
+			cols[i] = fmt.Sprintf("c%d", i+1)
+		case d.SanitizeHeaders:
+			cols[i] = sanitizeName(col, i)
+		default:
+			cols[i] = strings.ToLower(strings.TrimSpace(col))
+		}
+	}
+	return cols, header, nil
+}
+
 type table struct {
-	name   string
-	path   string
-	schema []*sql.Column
+	name string
+	path string
+	// files are the files holding the rows, the first of which is path.
+	files   []string
+	dialect Dialect
+	schema  []*sql.Column
+	// header are the names of the columns in the header of delimited files,
+	// as they are written, if they have one, and fields the positions of the
+	// columns in the records of the files whose columns are not the ones of
+	// the table, or -1 for the ones they do not have.
+	header []string
+	fields map[string][]int
+	// computed are the columns computed from the other ones, after the ones
+	// of the files.
+	computed []computedColumn
+	// checks are the constraints checked while the rows are read, if any.
+	checks *constraintChecks
+	// pseudo are the pseudo columns at the end of the schema, starting with
+	// the partition keys of the files, if they are partitioned.
+	pseudo     []pseudoColumn
+	partitions *partitionedFiles
+	// formats are the ones of the values of the columns of text files whose
+	// types were inferred, or nil for the columns read as text.
+	formats []*valueFormat
+	// stream copies the rows written to the named pipe of the table, if any,
+	// to its file.
+	stream *stream
+	// db is the database the table belongs to, if rows can be inserted in it,
+	// and named is true if it was named by AddTable rather than after its
+	// file.
+	db    *database
+	named bool
+	// watcher builds the table again for WatchTables once its files change
+	// from states, if it is watched.
+	watcher *watcher
+	states  []fileState
+	// lookup is the index lookup giving the rows to read, if any.
+	lookup sql.IndexLookup
+	// projection are the columns read from Parquet files, or all if nil, and
+	// filters the ones their rows must pass.
+	projection []string
+	filters    []sql.Expression
 }
 
 func (t *table) Name() string       { return t.name }
@@ -87,7 +416,31 @@ func (t *table) Partitions(ctx *sql.Context) (sql.PartitionIter, error) {
 }
 
 func (t *table) PartitionRows(ctx *sql.Context, p sql.Partition) (sql.RowIter, error) {
-	return newRowIter(t.path)
+	pruned, err := t.prunedFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if t.lookup != nil {
+		// Lookups of stale indexes are ignored, since the filters they come
+		// from are still applied to the rows.
+		if rows, err := t.indexedRows(ctx, p, pruned); rows != nil || err != nil {
+			return rows, err
+		}
+	}
+	if len(t.files) == 1 && pruned == nil {
+		return newRowIter(ctx, t, t.path)
+	}
+	var sources []opener
+	for i, path := range t.files {
+		if pruned != nil && pruned[i] {
+			continue
+		}
+		path := path
+		sources = append(sources, func(ctx *sql.Context) (sql.RowIter, error) {
+			return newRowIter(ctx, t, path)
+		})
+	}
+	return newParallelIter(ctx, sources), nil
 }
 
 type partitionIter struct{ done bool }
@@ -105,29 +458,162 @@ type partition struct{}
 
 func (p partition) Key() []byte { return []byte("key") }
 
-func newRowIter(path string) (sql.RowIter, error) {
-	f, err := os.Open(path)
+// readerPool holds the buffered readers used to scan files, which are reused
+// across scans instead of allocating new buffers every time.
+var readerPool = sync.Pool{
+	New: func() interface{} { return bufio.NewReaderSize(nil, 64<<10) },
+}
+
+// rowSlab is the number of rows whose values are allocated at once, so large
+// scans allocate less often.
+const rowSlab = 64
+
+func newRowIter(ctx *sql.Context, t *table, path string) (sql.RowIter, error) {
+	switch t.dialect.Format {
+	case "parquet":
+		return newParquetRowIter(ctx, t, path)
+	case "avro":
+		return newAvroRowIter(t, path)
+	case "fixed":
+		return newFixedRowIter(t, path)
+	case "xlsx":
+		return newSheetRowIter(t, path)
+	}
+	f, err := openFile(path)
 	if err != nil {
 		return nil, err
 	}
-	r := csv.NewReader(f)
-	r.Read() // skip titles
-	return &rowIter{f, r}, nil
+	var src *rowSource
+	if len(t.pseudo) > 0 {
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		src = &rowSource{path: path, info: info}
+	}
+	var raw io.Reader = f
+	if t.stream != nil {
+		// Only the complete lines received are read.
+		size, err := t.stream.received()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		raw = io.LimitReader(f, size)
+	}
+	in, err := t.dialect.textReader(countingReader{raw, &BytesRead}, path)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+	br := readerPool.Get().(*bufio.Reader)
+	br.Reset(in)
+	t.dialect.skipStart(br)
+	// The csv package does not allocate a new buffer when given a large
+	// enough bufio.Reader.
+	r := t.reader(br, path)
+	if t.dialect.header() {
+		r.Read() // skip titles
+	}
+	nr, _ := r.(nullReader)
+	return &rowIter{ctx: ctx, t: t, f: f, in: in, br: br, r: r, nr: nr, pseudo: t.pseudo, src: src, skipped: t.dialect.SkipRows, lenient: hasErrorColumn(t.pseudo) || t.checks != nil}, nil
 }
 
 type rowIter struct {
-	io.Closer
-	*csv.Reader
+	ctx *sql.Context
+	t   *table
+	f   readFile
+	// in reads the decompressed contents of f.
+	in     io.ReadCloser
+	br     *bufio.Reader
+	r      recordReader
+	nr     nullReader
+	pseudo []pseudoColumn
+	src    *rowSource
+	// skipped is the number of lines skipped before the ones r reads.
+	skipped int
+	// lenient is set when the values that can not be converted are NULL, and
+	// their errors the ones of the _error column, or violations of the
+	// constraints of the table.
+	lenient bool
+	// slab holds the values of the next rows.
+	slab []interface{}
 }
 
 func (r *rowIter) Next() (sql.Row, error) {
-	cols, err := r.Read()
+	for {
+		row, err := r.next()
+		if row != nil || err != nil {
+			return row, err
+		}
+	}
+}
+
+// next returns the next row, or nil if it is rejected for violating the
+// constraints of the table.
+func (r *rowIter) next() (sql.Row, error) {
+	cols, err := r.r.Read()
 	if err != nil {
 		return nil, err
 	}
-	args := make([]interface{}, len(cols))
+	n := len(cols) + len(r.t.computed) + len(r.pseudo)
+	if len(r.slab) < n {
+		r.slab = make([]interface{}, n*rowSlab)
+	}
+	// The rows are kept by the callers, so they can not be reused, but the
+	// capacity is limited so appending to them does not overwrite the next.
+	args := r.slab[:n:n]
+	r.slab = r.slab[n:]
+	var nulls []bool
+	if r.nr != nil {
+		nulls = r.nr.nulls()
+	}
+	var errs []string
 	for i, col := range cols {
-		args[i] = strings.TrimSpace(col)
+		s := strings.TrimSpace(col)
+		if nulls != nil && nulls[i] {
+			args[i], s = nil, ""
+		} else if args[i], err = r.t.columnValue(i, s); err != nil {
+			if r.lenient {
+				errs = append(errs, r.t.schema[i].Name+": "+err.Error())
+				continue
+			}
+			line := r.skipped + recordLine(r.r)
+			return nil, fmt.Errorf("could not read column %s in line %d of %s: %v", r.t.schema[i].Name, line, r.f.Name(), err)
+		}
+		if msg := r.t.violation(i, s, args[i]); msg != "" {
+			errs = append(errs, r.t.schema[i].Name+": "+msg)
+		}
+	}
+	if len(r.t.computed) > 0 {
+		cerrs := r.t.compute(r.ctx, args, len(cols))
+		if len(cerrs) > 0 && !r.lenient {
+			line := r.skipped + recordLine(r.r)
+			return nil, fmt.Errorf("could not compute column %s in line %d of %s", cerrs[0], line, r.f.Name())
+		}
+		errs = append(errs, cerrs...)
+	}
+	if len(errs) > 0 && r.t.checks != nil && r.t.invalid(r.skipped+recordLine(r.r), r.f.Name(), errs) {
+		return nil, nil
+	}
+	if r.src != nil {
+		r.src.line = int64(r.skipped + recordLine(r.r))
+		r.src.errors = errs
+	}
+	for i, col := range r.pseudo {
+		args[len(cols)+len(r.t.computed)+i] = col.value(r.src)
+	}
+	RowsRead.Add(1)
+	return sql.Row(args), nil
+}
+
+func (r *rowIter) Close() error {
+	if r.br != nil {
+		r.br.Reset(nil)
+		readerPool.Put(r.br)
+		r.br = nil
 	}
-	return sql.NewRow(args...), err
+	r.in.Close()
+	return r.f.Close()
 }
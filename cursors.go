@@ -0,0 +1,141 @@
+package csvql
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// cursor is an open query whose rows are fetched in pages, so huge results
+// can be read without holding them in memory.
+type cursor struct {
+	mu     sync.Mutex
+	schema sql.Schema
+	rows   sql.RowIter
+	done   bool
+}
+
+// cursors holds the open cursors of each session.
+type cursors struct {
+	mu       sync.Mutex
+	sessions map[sql.Session]map[string]*cursor
+}
+
+func init() {
+	statements = append(statements,
+		statement{
+			re:  regexp.MustCompile(`(?is)^\s*declare\s+` + "`?(\\w+)`?" + `\s+cursor\s+for\s+(select\s.*?)\s*;?\s*$`),
+			run: (*Engine).declareCursor,
+		},
+		statement{
+			re:  regexp.MustCompile(`(?is)^\s*fetch\s+(?:(?:next|forward)\s+)?(\d+\s+)?(?:from|in)\s+` + "`?(\\w+)`?" + `\s*;?\s*$`),
+			run: (*Engine).fetchCursor,
+		},
+		statement{
+			re:  regexp.MustCompile(`(?is)^\s*close\s+` + "`?(\\w+)`?" + `\s*;?\s*$`),
+			run: (*Engine).closeCursor,
+		},
+	)
+}
+
+func (e *Engine) declareCursor(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	name := strings.ToLower(m[1])
+	schema, rows, err := e.Query(ctx, m[2])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	e.cursors.mu.Lock()
+	defer e.cursors.mu.Unlock()
+	if e.cursors.sessions == nil {
+		e.cursors.sessions = make(map[sql.Session]map[string]*cursor)
+	}
+	open := e.cursors.sessions[ctx.Session]
+	if open == nil {
+		open = make(map[string]*cursor)
+		e.cursors.sessions[ctx.Session] = open
+	}
+	if _, ok := open[name]; ok {
+		rows.Close()
+		return nil, nil, fmt.Errorf("cursor %s is already open", name)
+	}
+	open[name] = &cursor{schema: schema, rows: rows}
+	return rowsAffected(0)
+}
+
+// cursor returns the cursor with the given name opened in the session.
+func (e *Engine) cursor(s sql.Session, name string) (*cursor, error) {
+	e.cursors.mu.Lock()
+	defer e.cursors.mu.Unlock()
+	c, ok := e.cursors.sessions[s][name]
+	if !ok {
+		return nil, fmt.Errorf("cursor %s is not open", name)
+	}
+	return c, nil
+}
+
+// fetchCursor returns the next rows of a cursor, one by default. Once all of
+// them have been returned it returns no rows, until the cursor is closed.
+func (e *Engine) fetchCursor(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	n := 1
+	if s := strings.TrimSpace(m[1]); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil {
+			return nil, nil, err
+		}
+	}
+	c, err := e.cursor(ctx.Session, strings.ToLower(m[2]))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var rows []sql.Row
+	for len(rows) < n && !c.done {
+		row, err := c.rows.Next()
+		if err == io.EOF {
+			c.done = true
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		rows = append(rows, row)
+	}
+	return c.schema, sql.RowsToRowIter(rows...), nil
+}
+
+func (e *Engine) closeCursor(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	name := strings.ToLower(m[1])
+	if _, err := e.cursor(ctx.Session, name); err != nil {
+		return nil, nil, err
+	}
+
+	e.cursors.mu.Lock()
+	c := e.cursors.sessions[ctx.Session][name]
+	delete(e.cursors.sessions[ctx.Session], name)
+	e.cursors.mu.Unlock()
+	if err := c.rows.Close(); err != nil {
+		return nil, nil, err
+	}
+	return rowsAffected(0)
+}
+
+// CloseCursors closes all the cursors opened in the given session, which
+// should be called once it is finished.
+func (e *Engine) CloseCursors(s sql.Session) {
+	e.cursors.mu.Lock()
+	open := e.cursors.sessions[s]
+	delete(e.cursors.sessions, s)
+	e.cursors.mu.Unlock()
+
+	for _, c := range open {
+		c.rows.Close()
+	}
+}
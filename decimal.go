@@ -0,0 +1,249 @@
+package csvql
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/analyzer"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/expression"
+	"gopkg.in/src-d/go-vitess.v0/sqltypes"
+	"gopkg.in/src-d/go-vitess.v0/vt/proto/query"
+)
+
+// The precision and scale of decimals are at most the ones of MySQL.
+const (
+	maxDecimalPrecision = 65
+	maxDecimalScale     = 30
+)
+
+// Decimal returns the type of DECIMAL(precision, scale) columns, holding
+// exact numbers with up to precision digits, scale of them after the point.
+// Their values are kept as text with all the digits of their scale, such as
+// 1234.50, so they are summed, compared, and sorted exactly, unlike floats.
+func Decimal(precision, scale int) (sql.Type, error) {
+	if precision < 1 || precision > maxDecimalPrecision {
+		return nil, fmt.Errorf("invalid precision %d of DECIMAL, expected 1 to %d", precision, maxDecimalPrecision)
+	}
+	if scale < 0 || scale > maxDecimalScale || scale > precision {
+		return nil, fmt.Errorf("invalid scale %d of DECIMAL(%d), expected at most %d and %d", scale, precision, maxDecimalScale, precision)
+	}
+	return decimalT{precision, scale}, nil
+}
+
+// parseDecimalType parses the name of a decimal type, as decimal(10,2) or
+// numeric(10,2), whose precision is 10 and scale 0 by default, as in MySQL.
+func parseDecimalType(name string) (sql.Type, bool, error) {
+	name = strings.ToLower(strings.Replace(name, " ", "", -1))
+	var args string
+	switch {
+	case name == "decimal", name == "numeric":
+	case strings.HasPrefix(name, "decimal(") && strings.HasSuffix(name, ")"):
+		args = name[len("decimal(") : len(name)-1]
+	case strings.HasPrefix(name, "numeric(") && strings.HasSuffix(name, ")"):
+		args = name[len("numeric(") : len(name)-1]
+	default:
+		return nil, false, nil
+	}
+	precision, scale := 10, 0
+	if args != "" {
+		parts := strings.Split(args, ",")
+		var err error
+		if len(parts) > 2 {
+			return nil, true, fmt.Errorf("unknown type %q", name)
+		}
+		if precision, err = strconv.Atoi(parts[0]); err != nil {
+			return nil, true, fmt.Errorf("unknown type %q", name)
+		}
+		if len(parts) == 2 {
+			if scale, err = strconv.Atoi(parts[1]); err != nil {
+				return nil, true, fmt.Errorf("unknown type %q", name)
+			}
+		}
+	}
+	t, err := Decimal(precision, scale)
+	return t, true, err
+}
+
+// isNumeric reports whether a type is a number, decimals included.
+func isNumeric(t sql.Type) bool {
+	_, ok := t.(decimalT)
+	return ok || sql.IsNumber(t)
+}
+
+type decimalT struct {
+	precision, scale int
+}
+
+func (decimalT) Type() query.Type { return sqltypes.Decimal }
+
+func (t decimalT) String() string { return fmt.Sprintf("DECIMAL(%d,%d)", t.precision, t.scale) }
+
+func (t decimalT) SQL(v interface{}) sqltypes.Value {
+	return sqltypes.MakeTrusted(sqltypes.Decimal, []byte(sql.MustConvert(t, v).(string)))
+}
+
+// Convert returns a value as text with the digits of the scale of the type,
+// rounding half away from zero the ones past it.
+func (t decimalT) Convert(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	r, err := decimalRat(v)
+	if err != nil {
+		return nil, err
+	}
+	return t.format(r)
+}
+
+// Compare compares the exact values of two numbers, without rounding them to
+// the scale of the type, so decimals can be compared with any number.
+func (t decimalT) Compare(a, b interface{}) (int, error) {
+	ra, err := decimalRat(a)
+	if err != nil {
+		return 0, err
+	}
+	rb, err := decimalRat(b)
+	if err != nil {
+		return 0, err
+	}
+	return ra.Cmp(rb), nil
+}
+
+// format returns a number as a value of the type.
+func (t decimalT) format(r *big.Rat) (string, error) {
+	n := new(big.Int).Mul(r.Num(), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(t.scale)), nil))
+	q, m := new(big.Int).QuoRem(new(big.Int).Abs(n), r.Denom(), new(big.Int))
+	if m.Lsh(m, 1).Cmp(r.Denom()) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	digits := q.String()
+	if len(digits) > t.precision && q.Sign() != 0 {
+		return "", fmt.Errorf("value %s is out of the range of %s", r.FloatString(t.scale), t)
+	}
+	if len(digits) <= t.scale {
+		digits = strings.Repeat("0", t.scale-len(digits)+1) + digits
+	}
+	s := digits
+	if t.scale > 0 {
+		s = digits[:len(digits)-t.scale] + "." + digits[len(digits)-t.scale:]
+	}
+	if n.Sign() < 0 && q.Sign() != 0 {
+		s = "-" + s
+	}
+	return s, nil
+}
+
+// decimalPattern matches the numbers that can be decimals, in plain or
+// scientific notation.
+var decimalPattern = regexp.MustCompile(`^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+
+// decimalRat returns the exact value of a number, or of its text. Floats are
+// the shortest decimals that are read as them.
+func decimalRat(v interface{}) (*big.Rat, error) {
+	r := new(big.Rat)
+	switch v := v.(type) {
+	case string:
+		s := strings.TrimSpace(v)
+		if !decimalPattern.MatchString(s) {
+			return nil, fmt.Errorf("invalid decimal %q", v)
+		}
+		r.SetString(s)
+	case []byte:
+		return decimalRat(string(v))
+	case int:
+		r.SetInt64(int64(v))
+	case int8:
+		r.SetInt64(int64(v))
+	case int16:
+		r.SetInt64(int64(v))
+	case int32:
+		r.SetInt64(int64(v))
+	case int64:
+		r.SetInt64(v)
+	case uint8:
+		r.SetInt64(int64(v))
+	case uint16:
+		r.SetInt64(int64(v))
+	case uint32:
+		r.SetInt64(int64(v))
+	case uint64:
+		r.SetInt(new(big.Int).SetUint64(v))
+	case float32:
+		return decimalRat(float64(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("invalid decimal %v", v)
+		}
+		r.SetString(strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		if v {
+			r.SetInt64(1)
+		}
+	default:
+		return nil, sql.ErrInvalidType.New(reflect.TypeOf(v))
+	}
+	return r, nil
+}
+
+// compareDecimals is an analyzer rule making the comparisons of decimals with
+// other numbers, and with decimals of other types, compare their exact values,
+// as go-mysql-server compares the values of different types as floats or
+// text.
+func compareDecimals(ctx *sql.Context, a *analyzer.Analyzer, n sql.Node) (sql.Node, error) {
+	return n.TransformUp(func(n sql.Node) (sql.Node, error) {
+		return n.TransformExpressionsUp(func(e sql.Expression) (sql.Expression, error) {
+			c, ok := e.(expression.Comparer)
+			if !ok {
+				return e, nil
+			}
+			left, right := c.Left(), c.Right()
+			lt, ldec := left.Type().(decimalT)
+			rt, rdec := right.Type().(decimalT)
+			switch {
+			case ldec && left.Type() != right.Type():
+				right = &asDecimal{right, lt}
+			case rdec && !ldec:
+				left = &asDecimal{left, rt}
+			default:
+				return e, nil
+			}
+			switch e.(type) {
+			case *expression.Equals:
+				return expression.NewEquals(left, right), nil
+			case *expression.LessThan:
+				return expression.NewLessThan(left, right), nil
+			case *expression.LessThanOrEqual:
+				return expression.NewLessThanOrEqual(left, right), nil
+			case *expression.GreaterThan:
+				return expression.NewGreaterThan(left, right), nil
+			case *expression.GreaterThanOrEqual:
+				return expression.NewGreaterThanOrEqual(left, right), nil
+			}
+			return e, nil
+		})
+	})
+}
+
+// asDecimal is an expression whose values are compared as the ones of a
+// decimal type, as they are.
+type asDecimal struct {
+	sql.Expression
+	typ decimalT
+}
+
+func (e *asDecimal) Type() sql.Type             { return e.typ }
+func (e *asDecimal) Children() []sql.Expression { return []sql.Expression{e.Expression} }
+
+func (e *asDecimal) TransformUp(fn sql.TransformExprFunc) (sql.Expression, error) {
+	child, err := e.Expression.TransformUp(fn)
+	if err != nil {
+		return nil, err
+	}
+	return fn(&asDecimal{child, e.typ})
+}
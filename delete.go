@@ -0,0 +1,60 @@
+package csvql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// deleteAll tells whether DELETE statements without a WHERE clause are run.
+var deleteAll = false
+
+// SetDeleteAll sets whether DELETE statements without a WHERE clause remove
+// all the rows of their tables, instead of failing, so they are not run by
+// mistake. They fail by default.
+func SetDeleteAll(allowed bool) { deleteAll = allowed }
+
+func init() {
+	statements = append(statements, statement{
+		re:  regexp.MustCompile(`(?is)^\s*delete\s+from\s+(` + namePart + `(?:\.` + namePart + `)?)(?:\s+where\s+(.+?))?\s*;?\s*$`),
+		run: (*Engine).delete,
+	})
+}
+
+// delete runs DELETE FROM table [WHERE condition], removing the rows of a
+// table matching the condition from its file, as UPDATE rewrites it. The rows
+// the grants of the user do not let them read are not removed.
+func (e *Engine) delete(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	name := strings.ToLower(strings.Replace(m[1], "`", "", -1))
+	if m[2] == "" && !deleteAll {
+		return nil, nil, fmt.Errorf("could not delete from %s: DELETE without a WHERE clause is not allowed", name)
+	}
+	t, err := e.writableTable(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	var cond sql.Expression
+	if m[2] != "" {
+		if cond, err = parsePredicate(m[2]); err != nil {
+			return nil, nil, fmt.Errorf("could not parse DELETE from %s: %v", name, err)
+		}
+		if cond, err = resolvePredicate(e.Catalog, cond, t.Schema()); err != nil {
+			return nil, nil, fmt.Errorf("could not delete from %s: %v", name, err)
+		}
+	}
+	granted, err := e.grantedRows(ctx, name, t)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n, err := t.db.rewrite(ctx, t, nil, func(row sql.Row) (sql.Row, bool, error) {
+		ok, err := matches(ctx, row, granted, cond)
+		return nil, !ok, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return rowsAffected(n)
+}
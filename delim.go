@@ -0,0 +1,341 @@
+package csvql
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// recordReader reads the records of a delimited file.
+type recordReader interface {
+	// Read returns the next record, which is only valid until the next call.
+	Read() ([]string, error)
+	// InputOffset returns the offset in the input where the next record
+	// starts.
+	InputOffset() int64
+}
+
+// recordWriter writes the records of a delimited file.
+type recordWriter interface {
+	Write(record []string) error
+	Flush()
+	Error() error
+}
+
+// nullReader is implemented by the readers of formats telling NULL values
+// apart from empty ones.
+type nullReader interface {
+	// nulls returns which values of the last record read are NULL.
+	nulls() []bool
+}
+
+// nullWriter is implemented by the writers of formats telling NULL values
+// apart from empty ones.
+type nullWriter interface {
+	// writeNulls writes a record whose values are NULL where nulls is set.
+	writeNulls(record []string, nulls []bool) error
+}
+
+// recordLine returns the number of the line the last record read by r starts
+// at, counting from the start of its input.
+func recordLine(r recordReader) int {
+	switch r := r.(type) {
+	case *csv.Reader:
+		line, _ := r.FieldPos(0)
+		return line
+	case *delimReader:
+		return r.start
+	case *jsonlReader:
+		return r.line
+	case *tokenReader:
+		return recordLine(r.recordReader)
+	case *fieldReader:
+		return recordLine(r.recordReader)
+	}
+	return 0
+}
+
+// lineReader reads a file line by line, keeping track of its position.
+type lineReader struct {
+	r      *bufio.Reader
+	offset int64
+	// line is the number of the last line read, and raw its contents.
+	line int
+	raw  string
+}
+
+func (r *lineReader) InputOffset() int64 { return r.offset }
+
+// readLine returns the next line, including its line ending if any.
+func (r *lineReader) readLine() (string, error) {
+	line, err := r.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	r.offset += int64(len(line))
+	r.line++
+	r.raw = line
+	return line, nil
+}
+
+// delimReader reads records whose values are separated by a delimiter, of
+// several characters or quoted differently from CSV files. Values can be
+// quoted, to include the delimiter, quotes written twice, or new lines, and
+// any character following the escape, if any, is taken literally.
+type delimReader struct {
+	lineReader
+	delim string
+	// quote encloses the quoted values, which can not be quoted if empty,
+	// and escape precedes the characters taken literally, if set.
+	quote  string
+	escape string
+	// comment starts the lines skipped, if set.
+	comment string
+	// fields is the number of values in each record, given by the first.
+	fields int
+	record []string
+	// start is the number of the line the last record read starts at.
+	start int
+}
+
+func newDelimReader(r io.Reader, delim, quote, escape string) *delimReader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &delimReader{lineReader: lineReader{r: br}, delim: delim, quote: quote, escape: escape}
+}
+
+func (r *delimReader) Read() ([]string, error) {
+	line, err := r.readLine()
+	for err == nil && (trimEOL(line) == "" || r.comment != "" && strings.HasPrefix(line, r.comment)) {
+		line, err = r.readLine()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	start := r.line
+	r.start = start
+	r.record = r.record[:0]
+	for {
+		value, rest, err := r.readValue(line, start)
+		if err != nil {
+			return nil, err
+		}
+		r.record = append(r.record, value)
+		if trimEOL(rest) == "" {
+			break
+		}
+		if !strings.HasPrefix(rest, r.delim) {
+			col := len(r.raw) - len(rest) + 1
+			return nil, &csv.ParseError{StartLine: start, Line: r.line, Column: col, Err: csv.ErrQuote}
+		}
+		line = rest[len(r.delim):]
+	}
+
+	if r.fields == 0 {
+		r.fields = len(r.record)
+	} else if len(r.record) != r.fields {
+		return r.record, &csv.ParseError{StartLine: start, Line: start, Column: 1, Err: csv.ErrFieldCount}
+	}
+	return r.record, nil
+}
+
+// readValue reads the value at the start of line, reading the next lines if
+// it has new lines, and returns the rest of the line after it.
+func (r *delimReader) readValue(line string, start int) (value, rest string, err error) {
+	quoted := r.quote != "" && strings.HasPrefix(line, r.quote)
+	if !quoted {
+		rest := trimEOL(line)
+		end := strings.Index(rest, r.delim)
+		if end < 0 {
+			end = len(rest)
+		}
+		if r.escape == "" || !strings.Contains(rest[:end], r.escape) {
+			return rest[:end], line[end:], nil
+		}
+	} else {
+		line = line[len(r.quote):]
+	}
+
+	var b strings.Builder
+	for {
+		// The value ends at the closing quote, or at the delimiter or the
+		// end of the line if it is not quoted.
+		end, endLen := -1, 0
+		if quoted {
+			end, endLen = strings.Index(line, r.quote), len(r.quote)
+		} else {
+			text := trimEOL(line)
+			if end, endLen = strings.Index(text, r.delim), 0; end < 0 {
+				end = len(text)
+			}
+		}
+		esc := -1
+		if r.escape != "" {
+			esc = strings.Index(line, r.escape)
+		}
+
+		switch {
+		case esc >= 0 && (end < 0 || esc < end):
+			b.WriteString(line[:esc])
+			line = line[esc+len(r.escape):]
+			c, size := utf8.DecodeRuneInString(line)
+			switch {
+			case line == "":
+				return "", "", &csv.ParseError{StartLine: start, Line: r.line, Column: len(r.raw), Err: csv.ErrQuote}
+			case strings.HasPrefix(line, "\r\n") || c == '\n':
+				// An escaped line ending goes on in the next line.
+				b.WriteByte('\n')
+				if line, err = r.readLine(); err == io.EOF {
+					return "", "", &csv.ParseError{StartLine: start, Line: r.line, Column: len(r.raw) + 1, Err: csv.ErrQuote}
+				} else if err != nil {
+					return "", "", err
+				}
+				continue
+			}
+			b.WriteRune(unescape(c))
+			line = line[size:]
+		case end < 0:
+			// The quoted value goes on in the next line.
+			b.WriteString(strings.TrimSuffix(line, "\r\n"))
+			if strings.HasSuffix(line, "\r\n") {
+				b.WriteByte('\n')
+			}
+			if line, err = r.readLine(); err == io.EOF {
+				return "", "", &csv.ParseError{StartLine: start, Line: r.line, Column: len(r.raw) + 1, Err: csv.ErrQuote}
+			} else if err != nil {
+				return "", "", err
+			}
+		default:
+			b.WriteString(line[:end])
+			line = line[end+endLen:]
+			if !quoted || !strings.HasPrefix(line, r.quote) {
+				return b.String(), line, nil
+			}
+			b.WriteString(r.quote)
+			line = line[len(r.quote):]
+		}
+	}
+}
+
+// unescape returns the character written as c after an escape, which is
+// itself besides n, r, and t, for new lines, carriage returns, and tabs.
+func unescape(c rune) rune {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	}
+	return c
+}
+
+func trimEOL(s string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(s, "\n"), "\r")
+}
+
+// delimWriter writes records whose values are separated by a delimiter,
+// quoting or escaping the values as delimReader expects.
+type delimWriter struct {
+	w       *bufio.Writer
+	delim   string
+	quote   string
+	escape  string
+	comment string
+	err     error
+}
+
+func newDelimWriter(w io.Writer, delim, quote, escape string) *delimWriter {
+	return &delimWriter{w: bufio.NewWriter(w), delim: delim, quote: quote, escape: escape}
+}
+
+// escapes are the characters written after the escape, besides the ones
+// taken literally.
+var escapes = map[rune]rune{'\n': 'n', '\r': 'r', '\t': 't'}
+
+func (w *delimWriter) Write(record []string) error {
+	if w.err != nil {
+		return w.err
+	}
+	for i, value := range record {
+		if i > 0 {
+			w.w.WriteString(w.delim)
+		}
+		// A record with a single empty value would be an empty line, which
+		// is skipped when reading.
+		empty := len(record) == 1 && value == ""
+		// So would the records starting with the comment.
+		commented := i == 0 && w.comment != "" && strings.HasPrefix(value, w.comment)
+		special := strings.Contains(value, w.delim) || strings.ContainsAny(value, "\r\n") ||
+			w.quote != "" && strings.Contains(value, w.quote) ||
+			w.escape != "" && strings.Contains(value, w.escape) || commented
+		switch {
+		case special && w.escape != "":
+			if commented {
+				w.w.WriteString(w.escape)
+			}
+			w.writeEscaped(value)
+		case (special || empty) && w.quote != "":
+			w.w.WriteString(w.quote)
+			w.w.WriteString(strings.Replace(value, w.quote, w.quote+w.quote, -1))
+			w.w.WriteString(w.quote)
+		case special:
+			w.err = fmt.Errorf("could not write %q: values with the delimiter, new lines, or the comment can not be written without quotes", value)
+			return w.err
+		default:
+			w.w.WriteString(value)
+		}
+	}
+	_, w.err = w.w.WriteString("\n")
+	return w.err
+}
+
+// writeEscaped writes a value preceding its delimiters, quotes, escapes, and
+// control characters with the escape.
+func (w *delimWriter) writeEscaped(value string) {
+	for value != "" {
+		switch {
+		case strings.HasPrefix(value, w.delim):
+			w.w.WriteString(w.escape)
+			w.w.WriteString(w.delim)
+			value = value[len(w.delim):]
+			continue
+		case w.quote != "" && strings.HasPrefix(value, w.quote):
+			w.w.WriteString(w.escape)
+			w.w.WriteString(w.quote)
+			value = value[len(w.quote):]
+			continue
+		case strings.HasPrefix(value, w.escape):
+			w.w.WriteString(w.escape)
+			w.w.WriteString(w.escape)
+			value = value[len(w.escape):]
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(value)
+		if e, ok := escapes[c]; ok {
+			w.w.WriteString(w.escape)
+			w.w.WriteRune(e)
+		} else {
+			w.w.WriteString(value[:size])
+		}
+		value = value[size:]
+	}
+}
+
+func (w *delimWriter) Flush() {
+	if err := w.w.Flush(); w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *delimWriter) Error() error { return w.err }
@@ -0,0 +1,135 @@
+package csvql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+func init() {
+	table := "(" + namePart + `(?:\.` + namePart + ")?)"
+	statements = append(statements,
+		statement{
+			re:  regexp.MustCompile(`(?is)^\s*(?:describe|desc)\s+` + table + `\s*;?\s*$`),
+			run: (*Engine).describe,
+		},
+		statement{
+			re:  regexp.MustCompile(`(?is)^\s*show\s+create\s+table\s+` + table + `\s*;?\s*$`),
+			run: (*Engine).showCreateTable,
+		},
+		statement{
+			re:  regexp.MustCompile(`(?is)^\s*show\s+(?:full\s+)?(?:columns|fields)\s+(?:from|in)\s+` + table + `(?:\s+(?:from|in)\s+` + "`?(\\w+)`?" + `)?\s*;?\s*$`),
+			run: (*Engine).describe,
+		},
+	)
+}
+
+// DescribeSchema is the schema of the rows describing the columns of a table,
+// as the ones of DESCRIBE in MySQL, followed by a value of each.
+var DescribeSchema = sql.Schema{
+	{Name: "Field", Type: sql.Text},
+	{Name: "Type", Type: sql.Text},
+	{Name: "Null", Type: sql.Text},
+	{Name: "Key", Type: sql.Text},
+	{Name: "Default", Type: sql.Text, Nullable: true},
+	{Name: "Extra", Type: sql.Text},
+	{Name: "Example", Type: sql.Text, Nullable: true},
+}
+
+// describeRows is the number of rows of a table read at most to find a value
+// of each of its columns.
+const describeRows = 100
+
+// describe runs DESCRIBE table, or SHOW COLUMNS FROM table [FROM database],
+// returning the types of the columns of a table, inferred or given by its
+// schema, whether they are nullable, and the first value found in them, so
+// the way a file is read can be checked before querying it.
+func (e *Engine) describe(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	name := strings.ToLower(strings.Replace(m[1], "`", "", -1))
+	if len(m) > 2 && m[2] != "" {
+		name = strings.ToLower(m[2]) + "." + name
+	}
+	t, err := catalogTable(e.Analyzer, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	schema := t.Schema()
+	examples, err := e.examples(ctx, name, len(schema))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ct, _ := t.(*table)
+	rows := make([]sql.Row, len(schema))
+	for i, col := range schema {
+		null := "NO"
+		if col.Nullable {
+			null = "YES"
+		}
+		extra := ""
+		if ct != nil {
+			if _, ok := ct.expression(i); ok {
+				extra = "VIRTUAL GENERATED"
+			}
+		}
+		rows[i] = sql.NewRow(col.Name, strings.ToLower(mysqlType(col.Type)), null, "", nil, extra, examples[i])
+	}
+	return DescribeSchema, sql.RowsToRowIter(rows...), nil
+}
+
+// examples returns the first value found in each of the n columns of a
+// table, or nil for the ones that only have NULL values in its first rows.
+// The rows are read as by any query, so the grants of the user apply.
+func (e *Engine) examples(ctx *sql.Context, name string, n int) ([]interface{}, error) {
+	_, rows, err := e.selectQuery(ctx, fmt.Sprintf("SELECT * FROM %s LIMIT %d", quoteIdentifier(name), describeRows))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	examples := make([]interface{}, n)
+	missing := n
+	for missing > 0 {
+		row, err := rows.Next()
+		// The values that can not be read, as the ones of other types than
+		// their column, are left to the queries to report.
+		if err != nil {
+			break
+		}
+		for i, v := range row {
+			if i < n && examples[i] == nil && v != nil {
+				examples[i] = formatValue(v)
+				missing--
+			}
+		}
+	}
+	return examples, nil
+}
+
+// CreateTableSchema is the schema of the row of SHOW CREATE TABLE.
+var CreateTableSchema = sql.Schema{
+	{Name: "Table", Type: sql.Text},
+	{Name: "Create Table", Type: sql.Text},
+}
+
+// showCreateTable runs SHOW CREATE TABLE table, returning a MySQL CREATE
+// TABLE statement with the columns of a table and their types, inferred or
+// given by its schema, so its files can be loaded into another database. The
+// computed columns are generated ones.
+func (e *Engine) showCreateTable(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	name := strings.ToLower(strings.Replace(m[1], "`", "", -1))
+	if err := e.checkRead(ctx, name); err != nil {
+		return nil, nil, err
+	}
+	t, err := catalogTable(e.Analyzer, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	var generated func(i int) (string, bool)
+	if ct, ok := t.(*table); ok {
+		generated = ct.expression
+	}
+	stmt := createTableStatement(t.Name(), t.Schema(), generated)
+	return CreateTableSchema, sql.RowsToRowIter(sql.NewRow(t.Name(), stmt)), nil
+}
@@ -0,0 +1,192 @@
+package csvql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+// dumpBatchSize is the number of rows in each INSERT statement of a dump.
+const dumpBatchSize = 1000
+
+// Dump writes the statements creating all the tables in the database and
+// inserting their rows, in a format that can be loaded by the mysql client.
+func Dump(ctx *sql.Context, w io.Writer, db sql.Database) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "-- csvql dump of database %s\n\n", db.Name())
+	fmt.Fprintf(bw, "SET NAMES utf8mb4;\n\n")
+
+	var names []string
+	for name := range db.Tables() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := dumpTable(ctx, bw, name, db.Tables()[name]); err != nil {
+			return fmt.Errorf("could not dump table %s: %v", name, err)
+		}
+	}
+	return bw.Flush()
+}
+
+func dumpTable(ctx *sql.Context, w *bufio.Writer, name string, t sql.Table) error {
+	fmt.Fprintf(w, "DROP TABLE IF EXISTS %s;\n", quoteIdentifier(name))
+	fmt.Fprintf(w, "%s;\n\n", CreateTableStatement(name, t.Schema()))
+
+	rows, err := plan.NewResolvedTable(t).RowIter(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var n int
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if n%dumpBatchSize == 0 {
+			if n > 0 {
+				w.WriteString(";\n")
+			}
+			fmt.Fprintf(w, "INSERT INTO %s VALUES ", quoteIdentifier(name))
+		} else {
+			w.WriteString(",")
+		}
+		w.WriteString("(")
+		for i, v := range row {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			w.WriteString(sqlLiteral(v))
+		}
+		w.WriteString(")")
+		n++
+	}
+	if n > 0 {
+		w.WriteString(";\n\n")
+	}
+	return nil
+}
+
+// CreateTableStatement returns a MySQL CREATE TABLE statement for a table with
+// the given name and schema.
+func CreateTableStatement(name string, schema sql.Schema) string {
+	return createTableStatement(name, schema, nil)
+}
+
+// createTableStatement returns a CREATE TABLE statement as
+// CreateTableStatement does, with the columns given an expression by
+// generated, if any, made generated ones.
+func createTableStatement(name string, schema sql.Schema, generated func(i int) (string, bool)) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CREATE TABLE %s (\n", quoteIdentifier(name))
+	for i, col := range schema {
+		fmt.Fprintf(&b, "  %s %s", quoteIdentifier(col.Name), mysqlType(col.Type))
+		if generated != nil {
+			if expr, ok := generated(i); ok {
+				fmt.Fprintf(&b, " GENERATED ALWAYS AS (%s) VIRTUAL", expr)
+			}
+		}
+		if !col.Nullable {
+			b.WriteString(" NOT NULL")
+		}
+		if i < len(schema)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// mysqlType returns the MySQL type used to store values of the given type.
+func mysqlType(t sql.Type) string {
+	if d, ok := t.(decimalT); ok {
+		return d.String()
+	}
+	switch t {
+	case sql.Int32:
+		return "INT"
+	case sql.Int64:
+		return "BIGINT"
+	case sql.Uint32:
+		return "INT UNSIGNED"
+	case sql.Uint64:
+		return "BIGINT UNSIGNED"
+	case sql.Float32:
+		return "FLOAT"
+	case sql.Float64:
+		return "DOUBLE"
+	case sql.Boolean:
+		return "BOOLEAN"
+	case sql.Date:
+		return "DATE"
+	case sql.Timestamp:
+		return "DATETIME"
+	case sql.Blob:
+		return "BLOB"
+	case sql.JSON:
+		return "JSON"
+	case UUID:
+		return "CHAR(36)"
+	default:
+		return "TEXT"
+	}
+}
+
+// quoteIdentifier returns the given name quoted as a MySQL identifier.
+func quoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+var literalEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`'`, `\'`,
+	"\x00", `\0`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\x1a", `\Z`,
+)
+
+// sqlLiteral returns the MySQL literal for the given value.
+func sqlLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(v)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		// MySQL has no literals for NaN and infinities.
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "NULL"
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		return "'" + formatValue(v) + "'"
+	case []byte:
+		return "'" + literalEscaper.Replace(string(v)) + "'"
+	default:
+		return "'" + literalEscaper.Replace(formatValue(v)) + "'"
+	}
+}
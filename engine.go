@@ -0,0 +1,266 @@
+package csvql
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	sqle "gopkg.in/src-d/go-mysql-server.v0"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/analyzer"
+)
+
+// Engine is a SQL engine over CSV databases. It extends the go-mysql-server
+// engine with statements that are specific to csvql.
+type Engine struct {
+	*sqle.Engine
+	grants    *Grants
+	exportDir string
+	cursors   cursors
+	log       queryLog
+	status    status
+}
+
+// NewEngine returns an engine with the given databases, the last of which is
+// the current one.
+func NewEngine(dbs ...sql.Database) *Engine {
+	e := &Engine{status: status{started: time.Now()}}
+	c := sql.NewCatalog()
+	a := analyzer.NewBuilder(c).
+		AddPreAnalyzeRule("bind_parameters", bindParameters).
+		AddPreAnalyzeRule("resolve_table_functions", resolveTableFunctions).
+		AddPreAnalyzeRule("resolve_time_travel", resolveTimeTravel).
+		AddPreAnalyzeRule("add_pseudo_columns", addPseudoColumns).
+		AddPreAnalyzeRule("insert_columns", insertColumns).
+		AddPreAnalyzeRule("apply_grants", e.applyGrants).
+		AddPreAnalyzeRule("resolve_qualified_tables", resolveQualifiedTables).
+		AddPostAnalyzeRule("compare_decimals", compareDecimals).
+		AddPostValidationRule("spill_sorts", spillSorts).
+		Build()
+
+	e.Engine = sqle.New(c, a, nil)
+	// The functions are registered after the default ones, some of which,
+	// such as AVG, they replace.
+	registerFunctions(c.FunctionRegistry)
+	// DATABASE() and SCHEMA() return the name of the current database, which
+	// the tools reading information_schema filter its rows with.
+	for _, name := range []string{"database", "schema"} {
+		f := &scalar{name: name, typ: sql.Text, sessionEval: e.currentDatabase}
+		c.FunctionRegistry.RegisterFunction(name, sql.FunctionN(f.call))
+	}
+	c.RegisterIndexDriver(&indexDriver{catalog: c})
+	e.AddDatabase(&informationSchema{e: e})
+	for _, db := range dbs {
+		e.AddDatabase(db)
+	}
+	if err := e.Init(); err != nil {
+		log.Printf("could not load indexes: %v", err)
+	}
+	return e
+}
+
+// statement is a csvql specific statement, recognized by a regular
+// expression and run with the submatches found.
+type statement struct {
+	re  *regexp.Regexp
+	run func(e *Engine, ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error)
+}
+
+const quotedString = `'((?:[^']|'')*)'`
+
+// copyValue matches the value of a COPY option, a word or a quoted string.
+const copyValue = `(?:[\w-]+|'[^']*')`
+
+var copyOption = regexp.MustCompile(`(?i)(\w+)\s+([\w-]+|'[^']*')`)
+
+// outfileStatement matches SELECT ... INTO OUTFILE statements.
+var outfileStatement = regexp.MustCompile(`(?is)^\s*(select\s.*?)\s+into\s+outfile\s+` + quotedString + `(?:\s+(?:character\s+set|charset)\s+([\w-]+))?(?:\s+format\s+(\w+))?\s*;?\s*$`)
+
+var statements = []statement{
+	{
+		re:  outfileStatement,
+		run: (*Engine).outfile,
+	},
+	{
+		re:  regexp.MustCompile(`(?is)^\s*copy\s*\((.*)\)\s*to\s+` + quotedString + `(?:\s+(?:with\s*)?\(?\s*(\w+\s+` + copyValue + `(?:\s*,\s*\w+\s+` + copyValue + `)*)\s*\)?)?\s*;?\s*$`),
+		run: (*Engine).copy,
+	},
+}
+
+// Query executes the given query, which can be any statement supported by
+// go-mysql-server, a UNION of SELECT statements, or one of the following:
+//
+//	SELECT ... INTO OUTFILE 'path' [CHARACTER SET charset] [FORMAT format]
+//	COPY (SELECT ...) TO 'path' [WITH (FORMAT format, ENCODING 'charset')]
+//
+// Tables can be read as they were at a given time with FROM t AS OF 'time',
+// if previous versions of their files are retained.
+//
+// When the sql_mode of the session includes ANSI_QUOTES, double quotes
+// delimit identifiers rather than strings.
+func (e *Engine) Query(ctx *sql.Context, query string) (sql.Schema, sql.RowIter, error) {
+	e.status.queries.Add(1)
+	ctx = withQueryTime(withScanCache(ctx))
+	if ansiQuotes(ctx) {
+		query = rewriteANSIQuotes(query)
+	}
+	for _, s := range statements {
+		if m := s.re.FindStringSubmatch(query); m != nil {
+			return s.run(e, ctx, m)
+		}
+	}
+	schema, rows, err := e.selectQuery(ctx, query)
+	if err != nil && isParseError(err) {
+		e.status.parseErrors.Add(1)
+	}
+	return schema, rows, err
+}
+
+// outfile runs a SELECT ... INTO OUTFILE statement.
+func (e *Engine) outfile(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	return e.export(ctx, m[1], unquote(m[2]), strings.ToLower(m[4]), m[3])
+}
+
+// copy runs a COPY statement, whose options are FORMAT and ENCODING.
+func (e *Engine) copy(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	var format, charset string
+	for _, o := range copyOption.FindAllStringSubmatch(m[3], -1) {
+		value := strings.Trim(o[2], "'")
+		switch strings.ToLower(o[1]) {
+		case "format":
+			format = strings.ToLower(value)
+		case "encoding":
+			charset = value
+		default:
+			return nil, nil, fmt.Errorf("unknown COPY option %s", o[1])
+		}
+	}
+	return e.export(ctx, m[1], unquote(m[2]), format, charset)
+}
+
+// export writes the results of a query to a new file in the server, using the
+// format given or, if none, the one corresponding to the file extension, and
+// converting its text to the given charset. Files ending in .gz or .zst are
+// compressed while they are written. It returns the number of rows written.
+func (e *Engine) export(ctx *sql.Context, query, path, format, charset string) (sql.Schema, sql.RowIter, error) {
+	if err := e.checkFiles(ctx); err != nil {
+		return nil, nil, err
+	}
+	path, err := e.exportPath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if format == "" {
+		format = exportFormat(path)
+	}
+
+	schema, rows, err := e.selectQuery(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create %s: %v", path, err)
+	}
+	n, err := writeCompressedRows(f, path, format, charset, schema, rows)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, nil, err
+	}
+
+	return rowsAffected(n)
+}
+
+// SetExportDir sets the directory the results of queries can be exported to
+// with INTO OUTFILE and COPY, which the names of the files are relative to.
+// Exporting is disabled while it is empty, as it is by default.
+func (e *Engine) SetExportDir(dir string) error {
+	if dir == "" {
+		e.exportDir = ""
+		return nil
+	}
+	dir, err := filepath.Abs(dir)
+	if err == nil {
+		dir, err = filepath.EvalSymlinks(dir)
+	}
+	if err != nil {
+		return fmt.Errorf("could not find export directory: %v", err)
+	}
+	e.exportDir = dir
+	return nil
+}
+
+// exportPath returns the path of the file a query is exported to, which must
+// be in the export directory, reached without .. nor symbolic links.
+func (e *Engine) exportPath(name string) (string, error) {
+	if e.exportDir == "" {
+		return "", fmt.Errorf("could not export to %s: exporting is disabled, as there is no export directory", name)
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(name), "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("could not export to %s: paths can not contain ..", name)
+		}
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(e.exportDir, path)
+	}
+	path = filepath.Clean(path)
+	dir := filepath.Dir(path)
+	if rel, err := filepath.Rel(e.exportDir, dir); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("could not export to %s: it is outside of the export directory", name)
+	}
+	// The file itself is created with O_EXCL, which does not follow links.
+	if real, err := filepath.EvalSymlinks(dir); err != nil {
+		return "", fmt.Errorf("could not export to %s: %v", name, err)
+	} else if real != dir {
+		return "", fmt.Errorf("could not export to %s: it is reached through a symbolic link", name)
+	}
+	return path, nil
+}
+
+// writeRows writes all the rows in the given format and charset, returning
+// how many were written.
+func writeRows(w io.Writer, format, charset string, schema sql.Schema, rows sql.RowIter) (int64, error) {
+	rw, err := NewCharsetRowWriter(w, format, charset, schema)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		if err := rw.Write(row); err != nil {
+			return n, err
+		}
+		n++
+		RowsWritten.Add(1)
+	}
+	return n, rw.Close()
+}
+
+// rewrite replaces the syntax of a query that the parser does not support by
+// the quoted table names resolved by the analyzer rules of csvql.
+func rewrite(query string) string {
+	return rewriteQualifiedTables(rewriteTimeTravel(rewriteTableFunctions(query)))
+}
+
+// unquote returns the content of a SQL string literal, once the surrounding
+// quotes have been removed.
+func unquote(s string) string { return strings.Replace(s, "''", "'", -1) }
@@ -0,0 +1,119 @@
+package csvql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// testEngine returns an engine whose current database is a new directory with
+// the given files, and the directory.
+func testEngine(t *testing.T, files map[string]string) (*Engine, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatalf("could not open %s: %v", dir, err)
+	}
+	return NewEngine(db), dir
+}
+
+// queryRows runs a query, failing the test if it does not, and returns all
+// the rows of its results.
+func queryRows(t *testing.T, e *Engine, query string) []sql.Row {
+	t.Helper()
+	_, rows, err := e.Query(sql.NewEmptyContext(), query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	all, err := sql.RowIterToRows(rows)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return all
+}
+
+// tableError returns the error adding the file at path as table t of a new
+// database, or else reading its rows.
+func tableError(t *testing.T, path string) error {
+	t.Helper()
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddTable(db, "t="+path); err != nil {
+		return err
+	}
+	_, rows, err := NewEngine(db).Query(sql.NewEmptyContext(), "SELECT * FROM t")
+	if err == nil {
+		_, err = sql.RowIterToRows(rows)
+	}
+	return err
+}
+
+// tableRows adds the file at path as table t of a new database, and returns
+// the rows of the results of a query on it.
+func tableRows(t *testing.T, path, query string) []sql.Row {
+	t.Helper()
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddTable(db, "t="+path); err != nil {
+		t.Fatal(err)
+	}
+	return queryRows(t, NewEngine(db), query)
+}
+
+func TestExportDir(t *testing.T) {
+	e, dir := testEngine(t, map[string]string{"people.csv": "name\nalice\n"})
+	if _, _, err := e.Query(sql.NewEmptyContext(), "SELECT * FROM people INTO OUTFILE 'out.csv'"); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("exporting without an export directory got error %v", err)
+	}
+
+	out := t.TempDir()
+	if err := os.Mkdir(filepath.Join(out, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dir, filepath.Join(out, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetExportDir(out); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"out.csv", "sub/out.csv", filepath.Join(out, "abs.csv")} {
+		queryRows(t, e, "COPY (SELECT * FROM people) TO '"+name+"'")
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(out, name)
+		}
+		if b, err := ioutil.ReadFile(path); err != nil || string(b) != "name\nalice\n" {
+			t.Errorf("%s: got %q and error %v", name, b, err)
+		}
+	}
+
+	for _, name := range []string{
+		"out.csv",
+		"../out.csv",
+		"sub/../../out.csv",
+		filepath.Join(dir, "out.csv"),
+		"link/out.csv",
+		"link",
+	} {
+		if _, _, err := e.Query(sql.NewEmptyContext(), "SELECT * FROM people INTO OUTFILE '"+name+"'"); err == nil {
+			t.Errorf("%s: exported", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.csv")); err == nil {
+		t.Error("a file was exported out of the export directory")
+	}
+}
@@ -0,0 +1,105 @@
+package csvql
+
+import (
+	"strings"
+)
+
+// unionColumns returns the columns of a table made of delimited files, whose
+// columns can be added over time: the ones of its first file, followed by the
+// ones of the other files it does not have, in the order they are found. The
+// names in the headers of the columns are kept in t.header, and the position
+// of each column in the records of the files with other columns in t.fields.
+func (t *table) unionColumns(paths []string) ([]string, error) {
+	var cols []string
+	index := make(map[string]int)
+	renamed := make(map[string][]string)
+	files := make([][]string, len(paths))
+	for i, path := range paths {
+		names, header, err := readHeader(path, t.dialect)
+		if err != nil {
+			return nil, err
+		}
+		// The files of a table usually have the same header, whose repeated
+		// names are renamed, and reported, once.
+		key := strings.Join(names, "\x00")
+		if r, ok := renamed[key]; ok {
+			names = r
+		} else {
+			names = uniqueNames(t.name, names)
+			renamed[key] = names
+		}
+		for j, name := range names {
+			if _, ok := index[name]; ok {
+				continue
+			}
+			index[name] = len(cols)
+			cols = append(cols, name)
+			if header != nil {
+				t.header = append(t.header, header[j])
+			}
+		}
+		files[i] = names
+	}
+
+	key := strings.Join(cols, "\x00")
+	for i, names := range files {
+		if strings.Join(names, "\x00") == key {
+			continue
+		}
+		fields := make([]int, len(cols))
+		for j := range fields {
+			fields[j] = -1
+		}
+		for j, name := range names {
+			fields[index[name]] = j
+		}
+		if t.fields == nil {
+			t.fields = make(map[string][]int)
+		}
+		t.fields[paths[i]] = fields
+	}
+	return cols, nil
+}
+
+// useFields makes NULL the columns of a table missing in some of its files.
+func (t *table) useFields() {
+	for _, fields := range t.fields {
+		for i, j := range fields {
+			if j < 0 {
+				t.schema[i].Nullable = true
+			}
+		}
+	}
+}
+
+// fieldReader reads the records of a file whose columns are not the ones of
+// its table as records of the table, whose values are NULL for the columns
+// the file does not have.
+type fieldReader struct {
+	recordReader
+	fields []int
+	record []string
+	null   []bool
+}
+
+func (r *fieldReader) Read() ([]string, error) {
+	record, err := r.recordReader.Read()
+	if err != nil {
+		return record, err
+	}
+	var nulls []bool
+	if nr, ok := r.recordReader.(nullReader); ok {
+		nulls = nr.nulls()
+	}
+	r.record, r.null = r.record[:0], r.null[:0]
+	for _, j := range r.fields {
+		if j < 0 || j >= len(record) {
+			r.record, r.null = append(r.record, ""), append(r.null, true)
+			continue
+		}
+		r.record, r.null = append(r.record, record[j]), append(r.null, nulls != nil && nulls[j])
+	}
+	return r.record, nil
+}
+
+func (r *fieldReader) nulls() []bool { return r.null }
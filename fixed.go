@@ -0,0 +1,289 @@
+package csvql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// layoutSuffix is appended to the name of a fixed-width file to get the one
+// of its layout, such as ACCOUNTS.DAT.layout.
+const layoutSuffix = ".layout"
+
+// FixedColumn is a column of a fixed-width file.
+type FixedColumn struct {
+	Name string
+	// Start is the position of the first character of the column in each
+	// line, from 1, and Width its number of characters.
+	Start, Width int
+	Type         sql.Type
+}
+
+// fixedTypes are the names of the types of fixed-width columns.
+var fixedTypes = map[string]sql.Type{
+	"text":      sql.Text,
+	"string":    sql.Text,
+	"char":      sql.Text,
+	"int":       sql.Int64,
+	"integer":   sql.Int64,
+	"float":     sql.Float64,
+	"double":    sql.Float64,
+	"decimal":   sql.Float64,
+	"date":      sql.Date,
+	"timestamp": sql.Timestamp,
+	"datetime":  sql.Timestamp,
+	"bool":      sql.Boolean,
+	"boolean":   sql.Boolean,
+}
+
+// parseLayout parses the layout of a fixed-width file, a column per line or
+// separated by commas, given by its name, start, width, and optionally type,
+// separated by colons or spaces:
+//
+//	id:1:8:int,name:9:30,opened:39:8:date
+//
+// Empty lines and the ones starting with # are ignored.
+func parseLayout(s string) ([]FixedColumn, error) {
+	var cols []FixedColumn
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, entry := range strings.Split(line, ",") {
+			fields := strings.FieldsFunc(entry, func(r rune) bool {
+				return r == ':' || r == ' ' || r == '\t' || r == '\r'
+			})
+			if len(fields) == 0 {
+				continue
+			}
+			if len(fields) < 3 || len(fields) > 4 {
+				return nil, fmt.Errorf("invalid column %q, expected name:start:width[:type]", strings.TrimSpace(entry))
+			}
+			col := FixedColumn{Name: strings.ToLower(fields[0]), Type: sql.Text}
+			var err error
+			if col.Start, err = strconv.Atoi(fields[1]); err != nil || col.Start < 1 {
+				return nil, fmt.Errorf("invalid start %q of column %s", fields[1], col.Name)
+			}
+			if col.Width, err = strconv.Atoi(fields[2]); err != nil || col.Width < 1 {
+				return nil, fmt.Errorf("invalid width %q of column %s", fields[2], col.Name)
+			}
+			if len(fields) == 4 {
+				typ, ok := fixedTypes[strings.ToLower(fields[3])]
+				if !ok {
+					return nil, fmt.Errorf("unknown type %q of column %s", fields[3], col.Name)
+				}
+				col.Type = typ
+			}
+			if seen[col.Name] {
+				return nil, fmt.Errorf("duplicate column %s", col.Name)
+			}
+			seen[col.Name] = true
+			cols = append(cols, col)
+		}
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("no columns")
+	}
+	return cols, nil
+}
+
+// hasLayout reports whether a file has a layout next to it, so it is read as
+// a fixed-width file.
+func hasLayout(path string) bool { return fileExists(layoutPath(path)) }
+
+// layoutPath returns the path of the layout of a file, ignoring the extension
+// of its compression.
+func layoutPath(path string) string {
+	base, _ := SplitCompression(path)
+	return base + layoutSuffix
+}
+
+// fileLayout returns the layout of a fixed-width file, given by the layout
+// option of its table, either the path of a file or the columns themselves,
+// or the file next to it.
+func fileLayout(path, opt string) ([]FixedColumn, error) {
+	spec, from := opt, "option"
+	if opt == "" || fileExists(opt) {
+		from = layoutPath(path)
+		if opt != "" {
+			from = opt
+		}
+		b, err := ioutil.ReadFile(from)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("fixed-width file %s has no layout: no layout option or %s file", path, layoutSuffix)
+		} else if err != nil {
+			return nil, fmt.Errorf("could not read layout of %s: %v", path, err)
+		}
+		spec = string(b)
+	}
+	cols, err := parseLayout(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid layout %s of %s: %v", from, path, err)
+	}
+	return cols, nil
+}
+
+func fileExists(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && !fi.IsDir()
+}
+
+// fixedSchema returns the columns of a table made of fixed-width files.
+func fixedSchema(name string, d Dialect) sql.Schema {
+	var schema sql.Schema
+	for _, col := range d.Layout {
+		schema = append(schema, &sql.Column{
+			Name:     col.Name,
+			Type:     col.Type,
+			Nullable: true,
+			Source:   name,
+		})
+	}
+	return schema
+}
+
+// fixedValue returns the value of a column, given the characters in it
+// without surrounding spaces. Empty values are NULL, besides text.
+func fixedValue(typ sql.Type, s string) (interface{}, error) {
+	if s == "" && typ != sql.Text {
+		return nil, nil
+	}
+	switch typ {
+	case sql.Text:
+		return s, nil
+	case sql.Int64:
+		// Numbers are often padded with zeros, which are not octal.
+		return strconv.ParseInt(strings.TrimPrefix(s, "+"), 10, 64)
+	case sql.Float64:
+		return strconv.ParseFloat(s, 64)
+	case sql.Boolean:
+		return strconv.ParseBool(s)
+	case sql.Date:
+		// Mainframes write dates without separators.
+		if t, err := time.Parse("20060102", s); err == nil {
+			return t, nil
+		}
+	}
+	return typ.Convert(s)
+}
+
+// newFixedRowIter returns an iterator over the lines of a fixed-width file
+// belonging to the table.
+func newFixedRowIter(t *table, path string) (sql.RowIter, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var src *rowSource
+	if len(t.pseudo) > 0 {
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		src = &rowSource{path: path, info: info}
+	}
+	r, err := t.dialect.textReader(countingReader{f, &BytesRead}, path)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+	br := bufio.NewReaderSize(r, 64<<10)
+	t.dialect.skipStart(br)
+	lr := &lineReader{r: br}
+	tokens := t.dialect.nullTokens(t.columns())
+	return &fixedRowIter{t: t, f: f, in: r, lr: lr, src: src, tokens: tokens}, nil
+}
+
+type fixedRowIter struct {
+	t   *table
+	f   readFile
+	in  io.ReadCloser
+	lr  *lineReader
+	src *rowSource
+	// tokens are the values read as NULL in each column, if any.
+	tokens [][]string
+}
+
+func (i *fixedRowIter) Next() (sql.Row, error) {
+	line, err := i.lr.readLine()
+	for err == nil && strings.TrimRight(line, "\r\n") == "" {
+		line, err = i.lr.readLine()
+	}
+	if err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", i.f.Name(), err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	// Positions count characters, which are bytes in ASCII lines.
+	var runes []rune
+	n := len(line)
+	if !isASCII(line) {
+		runes = []rune(line)
+		n = len(runes)
+	}
+	layout := i.t.dialect.Layout
+	row := make(sql.Row, len(i.t.schema))
+	var errs []string
+	for j, col := range layout {
+		start, end := col.Start-1, col.Start-1+col.Width
+		if start >= n {
+			continue
+		}
+		if end > n {
+			end = n
+		}
+		s := line[start:end]
+		if runes != nil {
+			s = string(runes[start:end])
+		}
+		if i.tokens != nil && isNull(s, i.tokens[j]) {
+			continue
+		}
+		s = localeValue(i.t.dialect.Locale, strings.TrimSpace(s))
+		v, err := fixedValue(col.Type, s)
+		if err != nil {
+			err = fmt.Errorf("invalid %s value %q", TypeName(col.Type), s)
+			if hasErrorColumn(i.t.pseudo) {
+				errs = append(errs, col.Name+": "+err.Error())
+				continue
+			}
+			return nil, fmt.Errorf("could not read column %s in line %d of %s: %v", col.Name, i.lr.line, i.f.Name(), err)
+		}
+		row[j] = v
+	}
+	if i.src != nil {
+		i.src.line = int64(i.t.dialect.SkipRows + i.lr.line)
+		i.src.errors = errs
+	}
+	for j, col := range i.t.pseudo {
+		row[len(layout)+j] = col.value(i.src)
+	}
+	RowsRead.Add(1)
+	return row, nil
+}
+
+func (i *fixedRowIter) Close() error {
+	i.in.Close()
+	return i.f.Close()
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,889 @@
+package csvql
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// Dialect describes how the rows of a file are written.
+type Dialect struct {
+	// Format is the format of the file: csv, tsv, jsonl, parquet, avro,
+	// xlsx, or fixed.
+	Format string
+	// Delimiter separates the values of a row in delimited formats. It can
+	// have several characters, such as ||.
+	Delimiter string
+	// Quote encloses the values of delimited formats with the delimiter,
+	// quotes, or new lines, which can not be quoted if empty, and Escape,
+	// if set, precedes the characters taken literally, as in \,.
+	Quote  string
+	Escape string
+	// Comment starts the lines of delimited files that are skipped, such as
+	// #, if set.
+	Comment string
+	// NoHeader reads the first row of delimited files as values, rather
+	// than the names of the columns, which are c1, c2, and so on.
+	NoHeader bool
+	// Columns are the names of the columns of delimited files, instead of
+	// the ones in their header or c1, c2, and so on, if set.
+	Columns []string
+	// SanitizeHeaders makes the names in the headers of delimited files
+	// snake_case identifiers, such as order_id for Order ID.
+	SanitizeHeaders bool
+	// SkipRows and SkipFooter are the numbers of lines skipped at the start
+	// of text files, before their headers, and at their end.
+	SkipRows   int
+	SkipFooter int
+	// Locale is the one the numbers of text files are written in, such as
+	// de for 1.234,56, which are read as 1234.56, if set.
+	Locale string
+	// Nulls are the values of text files read as NULL, such as \N, and
+	// ColumnNulls the ones of some columns, instead of Nulls.
+	Nulls       []string
+	ColumnNulls map[string][]string
+	// LineEnd is \r in files whose lines end with carriage returns only, as
+	// in old Mac files, or empty if they end with new lines.
+	LineEnd string
+	// Sheet is the worksheet read from xlsx workbooks, the first one if
+	// empty, and HeaderRow the number of its row with the names of the
+	// columns, from 1.
+	Sheet     string
+	HeaderRow int
+	// TextCells reads the cells of worksheets as text, instead of mapping
+	// the types of their values to the ones of the columns.
+	TextCells bool
+	// Layout are the columns of fixed-width files.
+	Layout []FixedColumn
+	// Compression is the compression of the files, gzip, zstd, bzip2, xz,
+	// or none, instead of the one given by their extensions, if set.
+	Compression string
+	// Encoding is the encoding of the text in the files, such as utf-16le,
+	// or UTF-8 if empty.
+	Encoding string
+	// InferRows is the number of rows read from the start of delimited and
+	// JSON lines files to infer the types of their columns, which are all
+	// TEXT if 0, and TextColumns the columns kept as text, whatever their
+	// values are.
+	InferRows   int
+	TextColumns []string
+	// Schema describes the columns of delimited and JSON lines files, whose
+	// types are not inferred then, if set.
+	Schema *TableSchema
+	// DateFormat is the layout of time.Parse of the dates or timestamps of
+	// delimited and JSON lines files, tried before the usual ones when their
+	// types are inferred, and ColumnDateFormats the ones of some columns,
+	// which are DATE or TIMESTAMP whatever their values are.
+	DateFormat        string
+	ColumnDateFormats map[string]string
+	// TimeZone is the one the timestamps of delimited and JSON lines files
+	// written with their offsets are read in, and written back with, if set.
+	TimeZone *time.Location
+	// Constraints is report or reject to check the constraints of the
+	// columns of delimited and JSON lines files while they are read, logging
+	// the rows violating them, and skipping them if reject, if set.
+	Constraints string
+}
+
+// dialects are the formats that can be read as tables.
+var dialects = map[string]Dialect{
+	"csv":     {Format: "csv", Delimiter: ",", Quote: `"`},
+	"tsv":     {Format: "tsv", Delimiter: "\t", Quote: `"`},
+	"jsonl":   {Format: "jsonl"},
+	"parquet": {Format: "parquet"},
+	"avro":    {Format: "avro"},
+	"xlsx":    {Format: "xlsx"},
+	"fixed":   {Format: "fixed"},
+}
+
+// delimited reports whether the values of the rows are separated by a
+// delimiter, rather than being JSON objects, Parquet or Avro files,
+// workbooks, or fixed-width lines.
+func (d Dialect) delimited() bool { return d.Delimiter != "" }
+
+// lines reports whether the files have a row per line, so rows can be
+// appended to them and found by their offsets. Parquet and Avro files,
+// workbooks, and fixed-width files can only be read.
+func (d Dialect) lines() bool { return d.delimited() || d.Format == "jsonl" }
+
+// text reports whether the files are text read line by line, which can be
+// compressed or in other encodings than UTF-8.
+func (d Dialect) text() bool { return d.lines() || d.Format == "fixed" }
+
+// header reports whether the first row of the files has the names of the
+// columns.
+func (d Dialect) header() bool { return d.delimited() && !d.NoHeader }
+
+// seekable reports whether the files of the table are read as they are
+// written, so rows can be appended to them and found by their offsets, unlike
+// the ones compressed, converted to UTF-8, or in archives.
+func (t *table) seekable() bool {
+	return t.dialect.lines() && t.dialect.Encoding == "" && t.dialect.LineEnd == "" && t.dialect.SkipFooter == 0 && !t.compressed() && !t.archived()
+}
+
+// formatExtensions maps the extensions of the files read as tables to their
+// format.
+var formatExtensions = map[string]string{
+	".csv":     "csv",
+	".tsv":     "tsv",
+	".tab":     "tsv",
+	".jsonl":   "jsonl",
+	".ndjson":  "jsonl",
+	".parquet": "parquet",
+	".avro":    "avro",
+	".xlsx":    "xlsx",
+}
+
+// tableFormat is the format in which the files of new databases are read,
+// instead of the one given by their extensions, if set.
+var tableFormat string
+
+// tableDelimiter is the delimiter of the values in the files of new
+// databases, instead of the one of their format, if set.
+var tableDelimiter string
+
+// tableNoHeader reads the first rows of the delimited files of new databases
+// as values, and tableColumns names their columns, if set.
+var (
+	tableNoHeader bool
+	tableColumns  []string
+)
+
+// SetFormat sets the format in which the files of the databases created
+// afterwards are read, csv, tsv, jsonl, parquet, avro, xlsx, or fixed,
+// regardless of their extensions. By default, the files with a layout next
+// to them, such as ACCOUNTS.DAT.layout, are read as fixed-width files, .tsv
+// and .tab files as TSV, .jsonl and .ndjson files as JSON lines, .parquet
+// files as Parquet, .avro files as Avro object container files, .xlsx files
+// as Excel workbooks, and the rest as CSV.
+func SetFormat(format string) error {
+	if _, ok := dialects[format]; !ok && format != "" {
+		return fmt.Errorf("unknown format %q, expected one of %v", format, formatNames())
+	}
+	tableFormat = format
+	return nil
+}
+
+// SetDelimiter sets the delimiter separating the values in the files of the
+// databases created afterwards, instead of the one of their format. It can
+// have several characters, such as || or ~|~, or be tab, comma, pipe,
+// semicolon, or space.
+func SetDelimiter(delimiter string) error {
+	if delimiter == "" {
+		tableDelimiter = ""
+		return nil
+	}
+	d, err := parseDelimiter(delimiter)
+	if err != nil {
+		return err
+	}
+	tableDelimiter = d
+	return nil
+}
+
+// tableComment starts the lines skipped in the delimited files of new
+// databases, if set.
+var tableComment string
+
+// SetComment sets the prefix of the lines skipped in the delimited files of
+// the databases created afterwards, such as # or //.
+func SetComment(comment string) error {
+	if strings.ContainsAny(comment, "\r\n") {
+		return fmt.Errorf("invalid comment %q", comment)
+	}
+	tableComment = comment
+	return nil
+}
+
+// SetNoHeader sets whether the first rows of the delimited files of the
+// databases created afterwards are values, rather than the names of the
+// columns, which are then c1, c2, and so on. By default, it is sniffed from
+// the values in the files.
+func SetNoHeader(noHeader bool) { tableNoHeader = noHeader }
+
+// SetColumns sets the names of the columns of the delimited files of the
+// databases created afterwards, separated by commas, instead of the ones in
+// their headers or c1, c2, and so on.
+func SetColumns(columns string) error {
+	if columns == "" {
+		tableColumns = nil
+		return nil
+	}
+	names, err := parseColumns(columns)
+	if err != nil {
+		return err
+	}
+	tableColumns = names
+	return nil
+}
+
+// delimiterNames are the names accepted for the delimiters that are awkward
+// to write in a command line or a table spec.
+var delimiterNames = map[string]string{
+	"tab":       "\t",
+	`\t`:        "\t",
+	"comma":     ",",
+	"pipe":      "|",
+	"semicolon": ";",
+	"space":     " ",
+}
+
+func parseDelimiter(s string) (string, error) {
+	if d, ok := delimiterNames[strings.ToLower(s)]; ok {
+		return d, nil
+	}
+	if !utf8.ValidString(s) || strings.ContainsAny(s, "\"\r\n\uFEFF") {
+		return "", fmt.Errorf("invalid delimiter %q", s)
+	}
+	return s, nil
+}
+
+// fileDialect returns the dialect a file is read in, given the options of its
+// table, if any, and the format and delimiter set for all of them.
+func fileDialect(path string, opts map[string]string) (Dialect, error) {
+	format := tableFormat
+	if f, ok := opts["format"]; ok {
+		format = strings.ToLower(f)
+	}
+	if format == "" && hasLayout(path) {
+		format = "fixed"
+	}
+	if format == "" {
+		if _, format = splitFormat(path); format == "" {
+			format = "csv"
+		}
+	}
+	d, ok := dialects[format]
+	if !ok {
+		return Dialect{}, fmt.Errorf("unknown format %q, expected one of %v", format, formatNames())
+	}
+
+	d.Compression = tableCompression
+	if s, ok := opts["compression"]; ok {
+		c, err := parseCompression(s)
+		if err != nil {
+			return Dialect{}, err
+		}
+		d.Compression = c
+	}
+	if d.compression(path) != "" && !d.text() {
+		return Dialect{}, fmt.Errorf("%s files can not be compressed", d.Format)
+	}
+
+	if d.text() {
+		d.Encoding = tableEncoding
+	}
+	if s, ok := opts["encoding"]; ok {
+		if !d.text() {
+			return Dialect{}, fmt.Errorf("%s files have no encoding", d.Format)
+		}
+		enc, err := parseEncoding(s)
+		if err != nil {
+			return Dialect{}, err
+		}
+		d.Encoding = enc
+	}
+
+	if tableDelimiter != "" && d.delimited() {
+		d.Delimiter = tableDelimiter
+	}
+	if s, ok := opts["delimiter"]; ok {
+		if !d.delimited() {
+			return Dialect{}, fmt.Errorf("%s files have no delimiter", d.Format)
+		}
+		delim, err := parseDelimiter(s)
+		if err != nil {
+			return Dialect{}, err
+		}
+		d.Delimiter = delim
+	}
+	if err := d.setQuoting(opts); err != nil {
+		return Dialect{}, err
+	}
+	if err := d.setSkips(opts); err != nil {
+		return Dialect{}, err
+	}
+	if err := d.setNulls(opts); err != nil {
+		return Dialect{}, err
+	}
+	if d.text() {
+		d.Locale = tableLocale
+	}
+	if s, ok := opts["locale"]; ok {
+		if !d.text() {
+			return Dialect{}, fmt.Errorf("%s files have typed numbers", d.Format)
+		}
+		l, err := parseLocale(s)
+		if err != nil {
+			return Dialect{}, err
+		}
+		d.Locale = l
+	}
+	if d.delimited() {
+		d.Comment = tableComment
+	}
+	if s, ok := opts["comment"]; ok {
+		if !d.delimited() {
+			return Dialect{}, fmt.Errorf("%s files have no comments", d.Format)
+		}
+		d.Comment = s
+	}
+	if err := d.setInferRows(opts); err != nil {
+		return Dialect{}, err
+	}
+	if err := d.setTextColumns(opts); err != nil {
+		return Dialect{}, err
+	}
+	if err := d.setSchema(opts); err != nil {
+		return Dialect{}, err
+	}
+	if err := d.setDateFormats(opts); err != nil {
+		return Dialect{}, err
+	}
+	if err := d.setTimeZone(opts); err != nil {
+		return Dialect{}, err
+	}
+	if err := d.setConstraints(opts); err != nil {
+		return Dialect{}, err
+	}
+	header, err := d.setHeader(opts)
+	if err != nil {
+		return Dialect{}, err
+	}
+	if d.delimited() {
+		// Formats given explicitly imply their delimiters.
+		_, format := opts["format"]
+		_, delim := opts["delimiter"]
+		_, quote := opts["quote"]
+		d = sniffDialect(path, d, map[string]bool{
+			"delimiter": format || delim || tableFormat != "" || tableDelimiter != "",
+			"quote":     quote,
+			"header":    header,
+		})
+		if err := checkComment(d); err != nil {
+			return Dialect{}, err
+		}
+	}
+
+	for _, opt := range []string{"sheet", "types"} {
+		if _, ok := opts[opt]; ok && d.Format != "xlsx" {
+			return Dialect{}, fmt.Errorf("%s files have no worksheets", d.Format)
+		}
+	}
+	if _, ok := opts["header"]; ok && d.Format != "xlsx" && !d.delimited() {
+		return Dialect{}, fmt.Errorf("%s files have no header", d.Format)
+	}
+	d.Sheet = opts["sheet"]
+	if s, ok := opts["header"]; ok && d.Format == "xlsx" {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 {
+			return Dialect{}, fmt.Errorf("invalid header row %q", s)
+		}
+		d.HeaderRow = n
+	}
+	switch t := strings.ToLower(strings.TrimSpace(opts["types"])); t {
+	case "", "cells":
+	case "text":
+		d.TextCells = true
+	default:
+		return Dialect{}, fmt.Errorf("invalid types %q, expected cells or text", t)
+	}
+
+	if _, ok := opts["layout"]; ok && d.Format != "fixed" {
+		return Dialect{}, fmt.Errorf("%s files have no layout", d.Format)
+	}
+	if d.Format == "fixed" {
+		layout, err := fileLayout(path, strings.TrimSpace(opts["layout"]))
+		if err != nil {
+			return Dialect{}, err
+		}
+		d.Layout = layout
+	}
+	return d, nil
+}
+
+// setQuoting sets the quote and the escape given by the options of a table,
+// where quote=none reads every quote literally.
+func (d *Dialect) setQuoting(opts map[string]string) error {
+	quote, hasQuote := opts["quote"]
+	escape, hasEscape := opts["escape"]
+	if !hasQuote && !hasEscape {
+		return nil
+	}
+	if !d.delimited() {
+		return fmt.Errorf("%s files have no quotes", d.Format)
+	}
+	if hasQuote {
+		if strings.ToLower(quote) == "none" {
+			quote = ""
+		} else if err := checkQuoting("quote", quote, d.Delimiter); err != nil {
+			return err
+		}
+		d.Quote = quote
+	}
+	if hasEscape {
+		if err := checkQuoting("escape", escape, d.Delimiter); err != nil {
+			return err
+		}
+		if escape == d.Quote {
+			return fmt.Errorf("invalid escape %q: it is the quote", escape)
+		}
+		d.Escape = escape
+	}
+	return nil
+}
+
+// setHeader sets whether the first row of delimited files has the names of
+// the columns, as given by the options of a table, where header=none reads it
+// as values, or by SetNoHeader, and the names given by the columns option or
+// SetColumns. It reports whether the header was given, rather than sniffed.
+func (d *Dialect) setHeader(opts map[string]string) (bool, error) {
+	cols, hasColumns := opts["columns"]
+	if !d.delimited() {
+		if hasColumns {
+			return false, fmt.Errorf("%s files have no header", d.Format)
+		}
+		return false, nil
+	}
+	d.NoHeader, d.Columns, d.SanitizeHeaders = tableNoHeader, tableColumns, tableSanitizeHeaders
+	if hasColumns {
+		names, err := parseColumns(cols)
+		if err != nil {
+			return false, err
+		}
+		d.Columns = names
+	}
+	s, ok := opts["header"]
+	if !ok {
+		return d.NoHeader, nil
+	}
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "1":
+		d.NoHeader = false
+	case "sanitize":
+		d.NoHeader, d.SanitizeHeaders = false, true
+	case "none":
+		d.NoHeader = true
+	default:
+		return false, fmt.Errorf("invalid header %q, expected 1, sanitize, or none", s)
+	}
+	return true, nil
+}
+
+// checkComment checks that the comment of delimited files can not be taken
+// for a value starting a line.
+func checkComment(d Dialect) error {
+	c := d.Comment
+	if c == "" {
+		return nil
+	}
+	if !utf8.ValidString(c) || strings.ContainsAny(c, "\r\n") ||
+		strings.HasPrefix(c, d.Delimiter) || d.Quote != "" && strings.HasPrefix(c, d.Quote) ||
+		d.Escape != "" && strings.HasPrefix(c, d.Escape) {
+		return fmt.Errorf("invalid comment %q", c)
+	}
+	return nil
+}
+
+// parseColumns parses the names of the columns of a file, separated by
+// commas.
+func parseColumns(s string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			return nil, fmt.Errorf("invalid columns %q: empty name", s)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("invalid columns %q: duplicate column %s", s, name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// checkQuoting checks that a quote or an escape is a single character, apart
+// from the delimiter.
+func checkQuoting(name, s, delim string) error {
+	if utf8.RuneCountInString(s) != 1 || !utf8.ValidString(s) || strings.ContainsAny(s, "\r\n") {
+		return fmt.Errorf("invalid %s %q, expected a single character", name, s)
+	}
+	if strings.Contains(delim, s) {
+		return fmt.Errorf("invalid %s %q: it is in the delimiter", name, s)
+	}
+	return nil
+}
+
+// tableOptions are the options accepted in table specs.
+var tableOptions = map[string]bool{
+	"delimiter":   true,
+	"quote":       true,
+	"escape":      true,
+	"format":      true,
+	"sheet":       true,
+	"header":      true,
+	"columns":     true,
+	"comment":     true,
+	"null":        true,
+	"locale":      true,
+	"skip-rows":   true,
+	"skip-footer": true,
+	"infer-rows":  true,
+	"text":        true,
+	"schema":      true,
+	"date-format": true,
+	"timezone":    true,
+	"constraints": true,
+	"types":       true,
+	"layout":      true,
+	"compression": true,
+	"encoding":    true,
+}
+
+// parseTableSpec parses a table spec, name=path[;option=value...].
+func parseTableSpec(spec string) (name, path string, opts map[string]string, err error) {
+	parts := strings.Split(spec, ";")
+	eq := strings.Index(parts[0], "=")
+	if eq <= 0 || eq == len(parts[0])-1 {
+		return "", "", nil, fmt.Errorf("invalid table %q, expected name=path[;option=value...]", spec)
+	}
+	name, path = strings.ToLower(strings.TrimSpace(parts[0][:eq])), strings.TrimSpace(parts[0][eq+1:])
+
+	opts = make(map[string]string)
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		if len(kv) != 2 || !tableOptions[key] && !strings.HasPrefix(key, nullOption) && !strings.HasPrefix(key, dateFormatOption) {
+			return "", "", nil, fmt.Errorf("invalid option %q of table %s", part, name)
+		}
+		opts[key] = kv[1]
+	}
+	return name, path, opts, nil
+}
+
+// AddTable adds a table to a database returned by NewDatabase, given a spec
+// with its name, the path of its file, and the options to read it:
+//
+//	logs=logs.psv;delimiter=|
+//
+// The options are format, csv, tsv, jsonl, parquet, avro, xlsx, or fixed,
+// delimiter, as in SetDelimiter, quote, the character quoting the values
+// instead of ", or none, escape, the character preceding the ones taken
+// literally, comment, the prefix of the lines skipped, compression, as in
+// SetCompression, encoding, as in SetEncoding, header, 1 or none, telling
+// whether the first row of delimited files has the names of the columns, or
+// sanitize, as in SetSanitizeHeaders,
+// columns, the names given to them, null, the values read as NULL, as in
+// SetNulls, or null.column for those of a column, locale, as in SetLocale,
+// infer-rows, as in SetInferRows, text, the columns kept as text, as in
+// SetTextColumns, schema, a schema file describing the columns instead, as in
+// SetSchema, date-format, as in SetDateFormat, or
+// date-format.column for the dates or timestamps of a column, timezone, as in
+// SetTimeZone, constraints, as in SetConstraints, and skip-rows and
+// skip-footer, the numbers of lines skipped at the start and the end of text
+// files. The tables of workbooks read their first worksheet, or the one given
+// by the sheet option, with the names of the columns in the row given by
+// header, the first one by default, and types=text reads the cells as text
+// instead of mapping their types. The columns of fixed-width files are given by
+// layout, either a file or the columns themselves, as in id:1:8:int,name:9:30,
+// instead of the file next to them.
+//
+// The path can have the wildcards of filepath.Match, as in logs/2024-*.csv,
+// to read the rows of all the files matching it, in the order of their names,
+// one after the other. They must have the same format and columns, and the
+// table is read only.
+//
+// The path can also be the URL of an object in Amazon S3, Google Cloud Storage, or
+// Azure Storage, as in s3://bucket/key, gs://bucket/key, or az://container/key,
+// or of a file on an SFTP server, as in sftp://user@host/path, or served over
+// HTTP or HTTPS, as in https://host/path,
+// or of several ones with wildcards in their keys, as in
+// s3://bucket/exports/2024-*.csv, whose rows are read one after the other.
+// They are streamed from their service by every query, or downloaded to the
+// cache set by SetRemoteCache, if any, and to temporary files for Parquet
+// files, workbooks, and files on SFTP servers, so the table is read only.
+//
+// The path can also be a folder, whose files are read as those of a single
+// table, partitioned as in Hive if it has subfolders such as date=2024-01-01.
+//
+// The path can also be a named pipe, whose table has the rows written to it
+// by the processes producing them, one after the other, which are kept in a
+// temporary file as they are received. Queries read the rows received until
+// they start, and the table is read only. AddTable waits for the first line,
+// with the names of the columns unless header=none is given.
+//
+// The table replaces the one of a file in the folder of the database with
+// the same name, if any, but not the ones added by AddTable.
+func AddTable(db sql.Database, spec string) error {
+	cdb, ok := db.(*database)
+	if !ok {
+		return fmt.Errorf("can not add tables to database %s", db.Name())
+	}
+	name, path, opts, err := parseTableSpec(spec)
+	if err != nil {
+		return err
+	}
+	if isFIFO(path) {
+		t, err := newStreamTable(name, path, opts)
+		if err != nil {
+			return fmt.Errorf("could not add table %s: %v", name, err)
+		}
+		if err := t.checkNulls(); err != nil {
+			return err
+		}
+		return cdb.addTable(t)
+	}
+	t, err := loadTable(name, path, opts)
+	if err != nil {
+		return err
+	}
+	// Remote objects are only downloaded again when the table is added.
+	if remoteScheme(path) == "" {
+		if t.seekable() && t.partitions == nil && len(t.files) == 1 {
+			t.db = cdb
+		}
+		var find func() ([]string, error)
+		if t.partitions != nil {
+			find = partitionFiles(path, opts["format"])
+		} else if IsPattern(path) {
+			find = func() ([]string, error) { return globFiles(path) }
+		}
+		t.watch(find, func() (*table, error) { return loadTable(name, path, opts) })
+	}
+	return cdb.addTable(t)
+}
+
+// loadTable returns the table with the given name of the file, folder, or
+// pattern at path, read with the given options, as in AddTable.
+func loadTable(name, path string, opts map[string]string) (*table, error) {
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		t, err := newPartitionedTable(name, path, opts)
+		if err != nil {
+			return nil, fmt.Errorf("could not add table %s: %v", name, err)
+		}
+		if err := t.checkNulls(); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+	paths := []string{path}
+	var err error
+	if remoteScheme(path) != "" {
+		paths, err = fetchObjects(path, streamedFormat(path, opts))
+	} else if IsPattern(path) {
+		paths, err = globFiles(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not add table %s: %v", name, err)
+	}
+	_, format := splitFormat(paths[0])
+	for _, p := range paths[1:] {
+		if _, f := splitFormat(p); f != format && opts["format"] == "" {
+			return nil, fmt.Errorf("could not add %s to table %s: its format differs from the one of %s", p, name, paths[0])
+		}
+	}
+	d, err := fileDialect(paths[0], opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not add table %s: %v", name, err)
+	}
+	t, err := newFileTable(name, d, paths...)
+	if err != nil {
+		return nil, fmt.Errorf("could not add table %s: %v", name, err)
+	}
+	if err := t.checkNulls(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// addTable adds a table given to AddTable, replacing the one of a file in the
+// folder of the database named as it, unless it was given to AddTable too or
+// is a view.
+func (db *database) addTable(t *table) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if old, ok := db.tables[t.name]; ok {
+		if ot, isFile := old.(*table); !isFile || ot.named || db.views[t.name] != nil {
+			return fmt.Errorf("could not add table %s: it already exists", t.name)
+		}
+	}
+	t.named = true
+	db.tables[t.name] = t
+	return nil
+}
+
+// AddReader adds a table with the rows read from r, such as the standard
+// input, to a database returned by NewDatabase, given a spec with its name and
+// the options to read it, as in AddTable:
+//
+//	stdin;delimiter=|
+//
+// Queries can read tables several times, so r is copied to a temporary file,
+// removed by CleanTempDir once the process exits. The table is read only.
+func AddReader(db sql.Database, spec string, r io.Reader) error {
+	parts := strings.SplitN(spec, ";", 2)
+	name := strings.TrimSpace(parts[0])
+	f, err := tempFile("reader")
+	if err != nil {
+		return fmt.Errorf("could not add table %s: %v", name, err)
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("could not add table %s: could not read it: %v", name, err)
+	}
+
+	spec = name + "=" + f.Name()
+	if len(parts) > 1 {
+		spec += ";" + parts[1]
+	}
+	if err := AddTable(db, spec); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	cdb := db.(*database)
+	cdb.mu.Lock()
+	defer cdb.mu.Unlock()
+	cdb.tables[strings.ToLower(name)].(*table).db = nil
+	return nil
+}
+
+func formatNames() []string {
+	var names []string
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsPattern reports whether a path has the wildcards of filepath.Match, so it
+// is a pattern matching several files.
+func IsPattern(path string) bool {
+	return strings.ContainsAny(path, `*?[`)
+}
+
+// globFiles returns the files matching a pattern, in order.
+func globFiles(pattern string) ([]string, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	var files []string
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			files = append(files, p)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match %s", pattern)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// splitFormat returns the name of the table read from a file, which is its
+// base name without extension, and the format given by the extension,
+// ignoring the one of its compression, as in logs.csv.gz. It returns an
+// empty format for extensions of other files.
+func splitFormat(path string) (name, format string) {
+	path, _ = SplitCompression(path)
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext), formatExtensions[strings.ToLower(ext)]
+}
+
+// csvQuoting reports whether the values are quoted as in CSV files, so they
+// can be read and written by the csv package, with a delimiter of a single
+// character.
+func (d Dialect) csvQuoting() bool {
+	_, size := utf8.DecodeRuneInString(d.Delimiter)
+	return size == len(d.Delimiter) && d.Quote == `"` && d.Escape == ""
+}
+
+// reader returns a reader of the records in r, with csv.Reader when the
+// values are quoted as in CSV files, which is faster.
+func (d Dialect) reader(r io.Reader) recordReader {
+	comment, size := utf8.DecodeRuneInString(d.Comment)
+	if !d.csvQuoting() || size != len(d.Comment) {
+		dr := newDelimReader(r, d.Delimiter, d.Quote, d.Escape)
+		dr.comment = d.Comment
+		return dr
+	}
+	comma, _ := utf8.DecodeRuneInString(d.Delimiter)
+	cr := csv.NewReader(r)
+	cr.Comma = comma
+	if d.Comment != "" {
+		cr.Comment = comment
+	}
+	cr.ReuseRecord = true
+	return cr
+}
+
+// writer returns a writer of records in the dialect, with csv.Writer when the
+// values are quoted as in CSV files, unless they can start with a comment,
+// which it does not quote.
+func (d Dialect) writer(w io.Writer) recordWriter {
+	if !d.csvQuoting() || d.Comment != "" {
+		dw := newDelimWriter(w, d.Delimiter, d.Quote, d.Escape)
+		dw.comment = d.Comment
+		return dw
+	}
+	comma, _ := utf8.DecodeRuneInString(d.Delimiter)
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	return cw
+}
+
+// columns returns the names of the columns read from the files of the table.
+func (t *table) columns() []string {
+	cols := make([]string, len(t.schema)-len(t.computed)-len(t.pseudo))
+	for i := range cols {
+		cols[i] = t.schema[i].Name
+	}
+	return cols
+}
+
+// reader returns a reader of the records in r, read from one of the files of
+// the table and written in its dialect. Delimited files start with their
+// header, unless NoHeader is set, which must be skipped.
+func (t *table) reader(r io.Reader, path string) recordReader {
+	var rr recordReader
+	if !t.dialect.delimited() {
+		rr = newJSONLReader(r, t.columns())
+	} else {
+		rr = t.dialect.reader(r)
+	}
+	if fields, ok := t.fields[path]; ok {
+		rr = &fieldReader{recordReader: rr, fields: fields}
+	}
+	if tokens := t.dialect.nullTokens(t.columns()); tokens != nil {
+		return &tokenReader{recordReader: rr, tokens: tokens}
+	}
+	return rr
+}
+
+func (t *table) writer(w io.Writer) recordWriter {
+	if !t.dialect.delimited() {
+		return &jsonRecordWriter{w: bufio.NewWriter(w), columns: t.columns()}
+	}
+	if tokens := t.dialect.nullTokens(t.columns()); tokens != nil {
+		return &tokenWriter{recordWriter: t.dialect.writer(w), tokens: tokens}
+	}
+	return t.dialect.writer(w)
+}
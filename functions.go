@@ -0,0 +1,142 @@
+package csvql
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// scalar is a SQL function computed from the values of its arguments. Unless
+// it is documented otherwise, it returns NULL when any argument is NULL.
+type scalar struct {
+	name string
+	typ  sql.Type
+	// min and max are the number of arguments accepted, max < 0 meaning any.
+	min, max int
+	// nulls is set for functions handling NULL arguments themselves.
+	nulls bool
+	eval  func(args []interface{}) (interface{}, error)
+	// sessionEval is used instead of eval by the functions depending on the
+	// query or its session, such as on its time zone.
+	sessionEval func(ctx *sql.Context, args []interface{}) (interface{}, error)
+}
+
+// functions are the SQL functions csvql adds to those in go-mysql-server.
+var functions []*scalar
+
+// registerFunctions adds all the csvql functions to the given registry.
+func registerFunctions(r sql.FunctionRegistry) {
+	for _, f := range functions {
+		r.RegisterFunction(f.name, sql.FunctionN(f.call))
+	}
+	r.RegisterFunction("topk", sql.FunctionN(newTopK))
+	r.RegisterFunction("avg", sql.Function1(newAvg))
+	r.RegisterFunction("sum", sql.Function1(newSum))
+}
+
+func (f *scalar) call(args ...sql.Expression) (sql.Expression, error) {
+	if len(args) < f.min || (f.max >= 0 && len(args) > f.max) {
+		arity := fmt.Sprint(f.min)
+		if f.max < 0 {
+			arity += " or more"
+		} else if f.max > f.min {
+			arity = fmt.Sprintf("%d to %d", f.min, f.max)
+		}
+		return nil, sql.ErrInvalidArgumentNumber.New(arity, len(args))
+	}
+	return &scalarCall{f, args}, nil
+}
+
+// scalarCall is the expression calling a scalar function.
+type scalarCall struct {
+	f    *scalar
+	args []sql.Expression
+}
+
+func (c *scalarCall) Resolved() bool {
+	for _, arg := range c.args {
+		if !arg.Resolved() {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *scalarCall) String() string {
+	args := make([]string, len(c.args))
+	for i, arg := range c.args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s)", c.f.name, strings.Join(args, ", "))
+}
+
+func (c *scalarCall) Type() sql.Type             { return c.f.typ }
+func (c *scalarCall) IsNullable() bool           { return true }
+func (c *scalarCall) Children() []sql.Expression { return c.args }
+
+func (c *scalarCall) Eval(ctx *sql.Context, row sql.Row) (interface{}, error) {
+	values := make([]interface{}, len(c.args))
+	for i, arg := range c.args {
+		v, err := arg.Eval(ctx, row)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil && !c.f.nulls {
+			return nil, nil
+		}
+		values[i] = v
+	}
+	var v interface{}
+	var err error
+	if c.f.sessionEval != nil {
+		v, err = c.f.sessionEval(ctx, values)
+	} else {
+		v, err = c.f.eval(values)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", c.f.name, err)
+	}
+	return v, nil
+}
+
+func (c *scalarCall) TransformUp(fn sql.TransformExprFunc) (sql.Expression, error) {
+	args := make([]sql.Expression, len(c.args))
+	for i, arg := range c.args {
+		arg, err := arg.TransformUp(fn)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = arg
+	}
+	return fn(&scalarCall{c.f, args})
+}
+
+// stringArg returns the i-th argument converted to a string, or def if there
+// are not that many arguments.
+func stringArg(args []interface{}, i int, def string) (string, error) {
+	if i >= len(args) {
+		return def, nil
+	}
+	if s, ok := args[i].([]byte); ok {
+		return string(s), nil
+	}
+	s, err := sql.Text.Convert(args[i])
+	if err != nil {
+		return "", err
+	}
+	return s.(string), nil
+}
+
+// intArg returns the i-th argument converted to an integer, or def if there
+// are not that many arguments.
+func intArg(args []interface{}, i int, def int64) (int64, error) {
+	if i >= len(args) {
+		return def, nil
+	}
+	n, err := sql.Int64.Convert(args[i])
+	if err != nil {
+		return 0, err
+	}
+	return n.(int64), nil
+}
@@ -0,0 +1,357 @@
+package csvql
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gcsScope is the OAuth scope of the tokens read by gcsStore.
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// gcsStore reads the objects in Google Cloud Storage with its JSON API, or
+// in the emulator at STORAGE_EMULATOR_HOST, as the Google Cloud libraries do.
+type gcsStore struct {
+	endpoint string
+	// creds are the application default credentials, or nil to send the
+	// requests without them, which is enough for public buckets.
+	creds *gcsCredentials
+}
+
+// gcsCredentials are the credentials of a service account, of a user logged
+// in with gcloud auth application-default login, or of the service account
+// of the instance given by the metadata server, of type "metadata", and the
+// access token they were last exchanged for.
+type gcsCredentials struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// newGCSStore returns a store of the objects in Google Cloud Storage, with
+// the credentials in the file given by GOOGLE_APPLICATION_CREDENTIALS, or in
+// the one written by gcloud, or else the ones of the metadata server when
+// running on Google Cloud, if any.
+func newGCSStore() (objectStore, error) {
+	s := &gcsStore{endpoint: "https://storage.googleapis.com"}
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		s.endpoint = strings.TrimSuffix(host, "/")
+		return s, nil
+	}
+
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		dir := os.Getenv("CLOUDSDK_CONFIG")
+		if dir == "" && runtime.GOOS == "windows" {
+			dir = filepath.Join(os.Getenv("APPDATA"), "gcloud")
+		} else if dir == "" {
+			home, _ := os.UserHomeDir()
+			dir = filepath.Join(home, ".config", "gcloud")
+		}
+		path = filepath.Join(dir, "application_default_credentials.json")
+		if !fileExists(path) {
+			if onGCE() {
+				s.creds = &gcsCredentials{Type: "metadata"}
+			}
+			return s, nil
+		}
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read Google credentials: %v", err)
+	}
+	s.creds = new(gcsCredentials)
+	if err := json.Unmarshal(b, s.creds); err != nil {
+		return nil, fmt.Errorf("could not parse Google credentials %s: %v", path, err)
+	}
+	if s.creds.Type != "service_account" && s.creds.Type != "authorized_user" {
+		return nil, fmt.Errorf("could not read Google credentials %s: unsupported type %q", path, s.creds.Type)
+	}
+	return s, nil
+}
+
+func (s *gcsStore) list(bucket, prefix string) ([]object, error) {
+	var objects []object
+	query := url.Values{"prefix": {prefix}, "fields": {"items(name,generation,size,updated),nextPageToken"}}
+	for {
+		var page struct {
+			Items         []gcsObject `json:"items"`
+			NextPageToken string      `json:"nextPageToken"`
+		}
+		if err := s.getJSON(s.objectURL(bucket, "", query), &page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			objects = append(objects, item.object())
+		}
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+func (s *gcsStore) stat(bucket, key string) (object, error) {
+	o := gcsObject{Name: key}
+	if err := s.getJSON(s.objectURL(bucket, key, url.Values{"fields": {"generation,size,updated"}}), &o); err != nil {
+		return object{}, err
+	}
+	return o.object(), nil
+}
+
+// gcsObject is the metadata of an object in the JSON API, whose sizes are
+// strings.
+type gcsObject struct {
+	Name       string    `json:"name"`
+	Generation string    `json:"generation"`
+	Size       string    `json:"size"`
+	Updated    time.Time `json:"updated"`
+}
+
+func (o gcsObject) object() object {
+	size, _ := strconv.ParseInt(o.Size, 10, 64)
+	return object{key: o.Name, version: o.Generation, size: size, modified: o.Updated}
+}
+
+// open streams the contents of an object as they are stored, without
+// decompressing them.
+func (s *gcsStore) open(bucket, key string) (io.ReadCloser, error) {
+	res, err := s.get(s.objectURL(bucket, key, url.Values{"alt": {"media"}}))
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// objectURL returns the URL of an object in the JSON API, or of the list of
+// objects in the bucket if the key is empty.
+func (s *gcsStore) objectURL(bucket, key string, query url.Values) string {
+	u := s.endpoint + "/storage/v1/b/" + url.PathEscape(bucket) + "/o"
+	if key != "" {
+		u += "/" + url.PathEscape(key)
+	}
+	return u + "?" + query.Encode()
+}
+
+// getJSON decodes the JSON document at a URL of the API into v.
+func (s *gcsStore) getJSON(u string, v interface{}) error {
+	res, err := s.get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("could not parse response: %v", err)
+	}
+	return nil
+}
+
+// get sends a GET request, authorized with the credentials if any, and
+// returns the response if it succeeds.
+func (s *gcsStore) get(u string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.creds != nil {
+		token, err := s.creds.accessToken()
+		if err != nil {
+			return nil, fmt.Errorf("could not authenticate to Google Cloud: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	res, err := sendRemote(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		return nil, googleError(res)
+	}
+	return res, nil
+}
+
+// googleError returns the error in a failed response of a Google API.
+func googleError(res *http.Response) error {
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		Description string `json:"error_description"`
+	}
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 64<<10))
+	if json.Unmarshal(b, &e) == nil {
+		if e.Error.Message != "" {
+			return fmt.Errorf("%s: %s", res.Status, e.Error.Message)
+		}
+		if e.Description != "" {
+			return fmt.Errorf("%s: %s", res.Status, e.Description)
+		}
+	}
+	return fmt.Errorf("%s", res.Status)
+}
+
+// metadataHost returns the host of the metadata server of Google Cloud, or
+// the one given by GCE_METADATA_HOST.
+func metadataHost() string {
+	if host := os.Getenv("GCE_METADATA_HOST"); host != "" {
+		return host
+	}
+	return "metadata.google.internal"
+}
+
+// onGCE reports whether the process runs on Google Cloud, as the Google
+// Cloud libraries do: with GCE_METADATA_HOST set, on a machine made by
+// Google, or where the name of the metadata server resolves.
+func onGCE() bool {
+	if os.Getenv("GCE_METADATA_HOST") != "" {
+		return true
+	}
+	if b, err := ioutil.ReadFile("/sys/class/dmi/id/product_name"); err == nil && strings.HasPrefix(strings.TrimSpace(string(b)), "Google") {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, "metadata.google.internal.")
+	return err == nil && len(addrs) > 0
+}
+
+// accessToken returns an OAuth access token, exchanging the credentials for
+// a new one when the last one is about to expire.
+func (c *gcsCredentials) accessToken() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+
+	req, err := c.tokenRequest()
+	if err != nil {
+		return "", err
+	}
+	res, err := sendRemote(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", googleError(res)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("invalid token response")
+	}
+	c.token = token.AccessToken
+	c.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
+
+// tokenRequest returns the request exchanging the credentials for an access
+// token.
+func (c *gcsCredentials) tokenRequest() (*http.Request, error) {
+	if c.Type == "metadata" {
+		req, err := http.NewRequest("GET", "http://"+metadataHost()+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		return req, nil
+	}
+
+	form := url.Values{}
+	tokenURI := c.TokenURI
+	if tokenURI == "" {
+		tokenURI = "https://oauth2.googleapis.com/token"
+	}
+	if c.Type == "service_account" {
+		assertion, err := c.assertion(tokenURI, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	} else {
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", c.ClientID)
+		form.Set("client_secret", c.ClientSecret)
+		form.Set("refresh_token", c.RefreshToken)
+	}
+	req, err := http.NewRequest("POST", tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// assertion returns the JSON Web Token, signed with the private key of the
+// service account, exchanged for an access token.
+func (c *gcsCredentials) assertion(aud string, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(c.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("invalid private key")
+	}
+	var key *rsa.PrivateKey
+	if k, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		key, _ = k.(*rsa.PrivateKey)
+	} else if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		key = k
+	}
+	if key == nil {
+		return "", fmt.Errorf("invalid private key: expected an RSA key")
+	}
+
+	iat := now.Unix()
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   c.ClientEmail,
+		"scope": gcsScope,
+		"aud":   aud,
+		"iat":   iat,
+		"exp":   iat + 3600,
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + enc.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
@@ -0,0 +1,124 @@
+package csvql
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// fakeGCS serves the objects of a bucket as the JSON API of Google Cloud
+// Storage does, counting the downloads of their contents.
+type fakeGCS struct {
+	mu        sync.Mutex
+	objects   map[string]string
+	downloads int
+	auths     []string
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.auths = append(f.auths, r.Header.Get("Authorization"))
+	const prefix = "/storage/v1/b/bucket/o"
+	if r.URL.Path == prefix {
+		var items []map[string]string
+		for name, body := range f.objects {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				items = append(items, map[string]string{"name": name, "generation": "1", "size": strconv.Itoa(len(body))})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+		return
+	}
+	body, ok := f.objects[strings.TrimPrefix(r.URL.Path, prefix+"/")]
+	if !ok {
+		http.Error(w, `{"error":{"message":"No such object"}}`, http.StatusNotFound)
+		return
+	}
+	if r.URL.Query().Get("alt") != "media" {
+		json.NewEncoder(w).Encode(map[string]string{"generation": "1", "size": strconv.Itoa(len(body))})
+		return
+	}
+	f.downloads++
+	w.Write([]byte(body))
+}
+
+func TestStreamGCSObjects(t *testing.T) {
+	fake := &fakeGCS{objects: map[string]string{
+		"logs/a.csv": "level\ninfo\nerror\n",
+		"logs/b.csv": "level\ninfo\n",
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	t.Setenv("STORAGE_EMULATOR_HOST", srv.URL)
+
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddTable(db, "logs=gs://bucket/logs/*.csv"); err != nil {
+		t.Fatal(err)
+	}
+	e := NewEngine(db)
+
+	query := "SELECT _file, _file_size, COUNT(*) FROM logs GROUP BY _file, _file_size ORDER BY _file"
+	want := []sql.Row{{"gs://bucket/logs/a.csv", int64(17), int32(2)}, {"gs://bucket/logs/b.csv", int64(11), int32(1)}}
+	if rows := queryRows(t, e, query); !reflect.DeepEqual(rows, want) {
+		t.Errorf("got rows %v, want %v", rows, want)
+	}
+
+	// The objects are read from the service by every query.
+	fake.mu.Lock()
+	before := fake.downloads
+	fake.objects["logs/b.csv"] = "level\ninfo\nwarning\n"
+	fake.mu.Unlock()
+	rows := queryRows(t, e, "SELECT level FROM logs WHERE _file = 'gs://bucket/logs/b.csv' ORDER BY level")
+	if want := []sql.Row{{"info"}, {"warning"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("got rows %v after the object changed, want %v", rows, want)
+	}
+	if fake.downloads == before {
+		t.Error("the objects were not read again by the query")
+	}
+}
+
+func TestGCSMetadataCredentials(t *testing.T) {
+	fake := &fakeGCS{objects: map[string]string{"a.csv": "x\n1\n"}}
+	var flavors []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/computeMetadata/v1/instance/service-accounts/default/token", func(w http.ResponseWriter, r *http.Request) {
+		flavors = append(flavors, r.Header.Get("Metadata-Flavor"))
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "instance-token", "expires_in": 3600})
+	})
+	mux.Handle("/", fake)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	t.Setenv("STORAGE_EMULATOR_HOST", "")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv("CLOUDSDK_CONFIG", t.TempDir())
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(srv.URL, "http://"))
+	store, err := newGCSStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := store.(*gcsStore)
+	s.endpoint = srv.URL
+	for i := 0; i < 2; i++ {
+		if _, err := s.stat("bucket", "a.csv"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(fake.auths, []string{"Bearer instance-token", "Bearer instance-token"}) {
+		t.Errorf("got authorizations %q, want the token of the instance", fake.auths)
+	}
+	if !reflect.DeepEqual(flavors, []string{"Google"}) {
+		t.Errorf("got %d token requests with Metadata-Flavor %q, want one with Google", len(flavors), flavors)
+	}
+}
@@ -1,6 +1,36 @@
 module github.com/campoy/csvql
 
+go 1.17
+
 require (
+	github.com/Azure/azure-pipeline-go v0.2.1
+	github.com/Azure/azure-storage-blob-go v0.8.0
+	github.com/aws/aws-sdk-go v1.28.0
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/klauspost/compress v1.11.13
+	github.com/opentracing/opentracing-go v1.0.2
+	github.com/ulikunitz/xz v0.5.10
+	golang.org/x/crypto v0.0.0-20180820150726-614d502a4dac
+	golang.org/x/text v0.3.0
 	gopkg.in/src-d/go-mysql-server.v0 v0.0.0-20180919134539-fe0ac6e55ce3
 	gopkg.in/src-d/go-vitess.v0 v0.0.0-20180222154500-2cb632cdef3c
+	gopkg.in/yaml.v2 v2.2.1
+)
+
+require (
+	github.com/golang/protobuf v1.2.0 // indirect
+	github.com/jmespath/go-jmespath v0.0.0-20180206201540-c2b33e8439af // indirect
+	github.com/mattn/go-ieproxy v0.0.0-20190610004146-91bb50d98149 // indirect
+	github.com/mitchellh/hashstructure v0.0.0-20170609045927-2bca23e0e452 // indirect
+	github.com/pkg/errors v0.8.0 // indirect
+	github.com/satori/go.uuid v1.2.0 // indirect
+	github.com/sirupsen/logrus v1.0.6 // indirect
+	github.com/spf13/cast v1.2.0 // indirect
+	golang.org/x/net v0.0.0-20180826012351-8a410e7b638d // indirect
+	golang.org/x/sys v0.0.0-20191005200804-aed5e4c7ecf9 // indirect
+	google.golang.org/genproto v0.0.0-20180817151627-c66870c02cf8 // indirect
+	google.golang.org/grpc v1.14.0 // indirect
+	gopkg.in/airbrake/gobrake.v2 v2.0.9 // indirect
+	gopkg.in/gemnasium/logrus-airbrake-hook.v2 v2.1.2 // indirect
+	gopkg.in/src-d/go-errors.v1 v1.0.0 // indirect
 )
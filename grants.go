@@ -0,0 +1,371 @@
+package csvql
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/analyzer"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/expression"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/parse"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+	"gopkg.in/src-d/go-vitess.v0/mysql"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Grants lists the users allowed to connect to a server, with the tables and
+// rows each of them can read, and the tables they can change, if any. It can
+// be read from a YAML file with LoadGrants:
+//
+//	users:
+//	  - name: acme
+//	    password: secret
+//	    tables:
+//	      orders: "customer = 'acme'"
+//	      products: ""
+//	    write: [orders]
+type Grants struct {
+	Users []*UserGrants `yaml:"users"`
+}
+
+// UserGrants describes what a single user can read, and write.
+type UserGrants struct {
+	Name     string `yaml:"name"`
+	Password string `yaml:"password"`
+	// Tables maps the name of each table the user can read to a predicate
+	// the rows returned must satisfy, or to an empty string for all of them.
+	// The name * matches any table not listed.
+	Tables map[string]string `yaml:"tables"`
+	// Write lists the tables the user can change, with INSERT, UPDATE,
+	// DELETE, and CREATE INDEX, or create, with CREATE TABLE and CREATE
+	// MATERIALIZED VIEW, which they can then refresh and drop. The name *
+	// matches any table. Only the rows the user can read are changed, and
+	// the ones inserted or updated must still be. Users can not change any
+	// table by default.
+	Write []string `yaml:"write"`
+	// Files lets the user write the results of queries to the files of the
+	// server, with SELECT INTO OUTFILE and COPY, and list them with files().
+	Files bool `yaml:"files"`
+
+	// filters are the parsed predicates in Tables.
+	filters map[string]sql.Expression
+}
+
+// LoadGrants reads the grants in the given YAML file, checking that all the
+// predicates can be parsed.
+func LoadGrants(path string) (*Grants, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read grants: %v", err)
+	}
+	var g Grants
+	if err := yaml.UnmarshalStrict(b, &g); err != nil {
+		return nil, fmt.Errorf("could not parse grants %s: %v", path, err)
+	}
+
+	for i, u := range g.Users {
+		if u.Name == "" {
+			return nil, fmt.Errorf("user %d in grants %s has no name", i+1, path)
+		}
+		u.filters = make(map[string]sql.Expression)
+		for table, predicate := range u.Tables {
+			if predicate == "" {
+				continue
+			}
+			f, err := parsePredicate(predicate)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse predicate for user %s on table %s: %v", u.Name, table, err)
+			}
+			u.filters[table] = f
+		}
+	}
+	return &g, nil
+}
+
+// parsePredicate parses a SQL boolean expression, as found in a WHERE clause.
+func parsePredicate(predicate string) (sql.Expression, error) {
+	n, err := parse.Parse(sql.NewEmptyContext(), "SELECT * FROM t WHERE "+predicate)
+	if err != nil {
+		return nil, err
+	}
+	var f sql.Expression
+	plan.Inspect(n, func(n sql.Node) bool {
+		if filter, ok := n.(*plan.Filter); ok && f == nil {
+			f = filter.Expression
+		}
+		return f == nil
+	})
+	if f == nil {
+		return nil, fmt.Errorf("not a predicate: %s", predicate)
+	}
+	return f, nil
+}
+
+// AuthServer returns the MySQL authentication server accepting the users in
+// the grants with their passwords.
+func (g *Grants) AuthServer() mysql.AuthServer {
+	a := mysql.NewAuthServerStatic()
+	for _, u := range g.Users {
+		a.Entries[u.Name] = []*mysql.AuthServerStaticEntry{{Password: u.Password}}
+	}
+	return a
+}
+
+// user returns the grants of the user with the given name, or nil if there
+// is none.
+func (g *Grants) user(name string) *UserGrants {
+	for _, u := range g.Users {
+		if u.Name == name {
+			return u
+		}
+	}
+	return nil
+}
+
+// table reports whether the user can read from the given table and, if so,
+// the predicate its rows must satisfy, or nil for none.
+func (u *UserGrants) table(name string) (sql.Expression, bool) {
+	key := name
+	if _, ok := u.Tables[key]; !ok {
+		key = "*"
+	}
+	if _, ok := u.Tables[key]; !ok {
+		return nil, false
+	}
+	return u.filters[key], true
+}
+
+// write reports whether the user can change the given table, or create it.
+func (u *UserGrants) write(name string) bool {
+	for _, t := range u.Write {
+		if t == name || t == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRead returns an error unless the user of the session can read the
+// given table, or some of its rows.
+func (e *Engine) checkRead(ctx *sql.Context, name string) error {
+	if e.grants == nil {
+		return nil
+	}
+	user := ctx.Session.User()
+	u := e.grants.user(user)
+	if u == nil {
+		return fmt.Errorf("access denied for user %s", user)
+	}
+	if _, ok := u.table(name); !ok {
+		return fmt.Errorf("access denied for user %s to table %s", user, name)
+	}
+	return nil
+}
+
+// checkWrite returns an error unless the user of the session can change the
+// given table, or create it.
+func (e *Engine) checkWrite(ctx *sql.Context, name string) error {
+	if e.grants == nil {
+		return nil
+	}
+	user := ctx.Session.User()
+	u := e.grants.user(user)
+	if u == nil {
+		return fmt.Errorf("access denied for user %s", user)
+	}
+	if !u.write(name) {
+		return fmt.Errorf("access denied for user %s to change table %s", user, name)
+	}
+	return nil
+}
+
+// checkProfiles returns an error unless the user of the session can read all
+// the rows of the given table, so its profiles, such as the minimum and
+// maximum values of its columns, do not tell about the others.
+func (e *Engine) checkProfiles(ctx *sql.Context, name string) error {
+	if e.grants == nil {
+		return nil
+	}
+	user := ctx.Session.User()
+	u := e.grants.user(user)
+	if u == nil {
+		return fmt.Errorf("access denied for user %s", user)
+	}
+	if filter, ok := u.table(name); !ok || filter != nil {
+		return fmt.Errorf("access denied for user %s to the profiles of table %s", user, name)
+	}
+	return nil
+}
+
+// checkFiles returns an error unless the user of the session can write and
+// list the files of the server.
+func (e *Engine) checkFiles(ctx *sql.Context) error {
+	if e.grants == nil {
+		return nil
+	}
+	user := ctx.Session.User()
+	if u := e.grants.user(user); u == nil || !u.Files {
+		return fmt.Errorf("access denied for user %s to the files of the server", user)
+	}
+	return nil
+}
+
+// informationSchemaTable reports whether a table name, qualified or not, is
+// the one of a table of information_schema, whose rows are the tables each
+// user can read.
+func informationSchemaTable(a *analyzer.Analyzer, name string) bool {
+	if dot := strings.Index(name, "."); dot >= 0 {
+		return strings.EqualFold(name[:dot], informationSchemaName)
+	}
+	return a.CurrentDatabase == informationSchemaName
+}
+
+// applyGrants is an analyzer rule checking that the user running a query can
+// read all the tables in it, and change the ones it writes, and filtering the
+// rows of the tables that have a predicate for the user.
+func (e *Engine) applyGrants(ctx *sql.Context, a *analyzer.Analyzer, n sql.Node) (sql.Node, error) {
+	if e.grants == nil {
+		return n, nil
+	}
+	user := ctx.Session.User()
+	u := e.grants.user(user)
+	if u == nil {
+		return nil, fmt.Errorf("access denied for user %s", user)
+	}
+
+	var err error
+	plan.Inspect(n, func(n sql.Node) bool {
+		var changed sql.Node
+		index := true
+		switch n := n.(type) {
+		case *plan.InsertInto:
+			changed, index = n.Left, false
+		case *plan.CreateIndex:
+			changed = n.Table
+		case *plan.DropIndex:
+			changed = n.Table
+		}
+		t, ok := changed.(sql.Nameable)
+		if !ok {
+			return true
+		}
+		if !u.write(t.Name()) {
+			err = fmt.Errorf("access denied for user %s to change table %s", user, t.Name())
+		} else if f, _ := u.table(t.Name()); f != nil && index {
+			// The indexes of a table have all its rows.
+			err = fmt.Errorf("access denied for user %s to index table %s, whose rows are filtered", user, t.Name())
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return n.TransformUp(func(n sql.Node) (sql.Node, error) {
+		var name string
+		switch n := n.(type) {
+		case *plan.UnresolvedTable:
+			if quotedCall.MatchString(n.Name()) {
+				// Table functions do not read any table.
+				return n, nil
+			}
+			if informationSchemaTable(a, n.Name()) {
+				return n, nil
+			}
+			name = n.Name()
+		case *plan.ResolvedTable:
+			if _, ok := n.Table.(*filesTable); ok && !u.Files {
+				return nil, fmt.Errorf("access denied for user %s to the files of the server", user)
+			}
+			// Tables already resolved by previous rules, like the ones with
+			// pseudo columns.
+			if _, ok := n.Table.(*table); !ok {
+				return n, nil
+			}
+			name = n.Name()
+		default:
+			return n, nil
+		}
+
+		filter, ok := u.table(name)
+		if !ok {
+			return nil, fmt.Errorf("access denied for user %s to table %s", user, name)
+		}
+		if filter == nil {
+			return n, nil
+		}
+
+		t, err := catalogTable(a, name)
+		if rt, ok := n.(*plan.ResolvedTable); ok {
+			t, err = rt.Table, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		f, err := resolvePredicate(a.Catalog, filter, t.Schema())
+		if err != nil {
+			return nil, fmt.Errorf("could not apply grants of user %s on table %s: %v", user, name, err)
+		}
+		return plan.NewResolvedTable(&grantedTable{t, f}), nil
+	})
+}
+
+// resolvePredicate resolves the columns and functions in a predicate parsed
+// by parsePredicate against the given schema.
+func resolvePredicate(c *sql.Catalog, e sql.Expression, schema sql.Schema) (sql.Expression, error) {
+	return e.TransformUp(func(e sql.Expression) (sql.Expression, error) {
+		switch e := e.(type) {
+		case *expression.UnresolvedColumn:
+			for i, col := range schema {
+				if strings.EqualFold(col.Name, e.Name()) {
+					return expression.NewGetFieldWithTable(i, col.Type, col.Source, col.Name, col.Nullable), nil
+				}
+			}
+			return nil, fmt.Errorf("unknown column %s", e.Name())
+		case *expression.UnresolvedFunction:
+			f, err := c.Function(e.Name())
+			if err != nil {
+				return nil, err
+			}
+			return f.Call(e.Arguments...)
+		default:
+			return e, nil
+		}
+	})
+}
+
+// grantedTable is a table whose rows are filtered by the grants of a user.
+// Filtering in the table, instead of adding a node to the plan, keeps the
+// rules expecting tables right below aliases or joins working.
+type grantedTable struct {
+	sql.Table
+	filter sql.Expression
+}
+
+func (t *grantedTable) PartitionRows(ctx *sql.Context, p sql.Partition) (sql.RowIter, error) {
+	rows, err := t.Table.PartitionRows(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return plan.NewFilterIter(ctx, t.filter, rows), nil
+}
+
+// Insert implements the sql.Inserter interface, inserting the rows the user
+// can read in the table.
+func (t *grantedTable) Insert(ctx *sql.Context, row sql.Row) error {
+	ins, ok := t.Table.(sql.Inserter)
+	if !ok {
+		return plan.ErrInsertIntoNotSupported.New()
+	}
+	if ok, err := matches(ctx, row, t.filter); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("access denied for user %s to insert rows they can not read in table %s", ctx.Session.User(), t.Name())
+	}
+	return ins.Insert(ctx, row)
+}
+
+// SetGrants restricts the tables and rows each user can read to the ones in
+// the given grants. A nil value removes any restriction.
+func (e *Engine) SetGrants(g *Grants) { e.grants = g }
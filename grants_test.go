@@ -0,0 +1,225 @@
+package csvql
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+const testGrants = `
+users:
+  - name: reader
+    tables:
+      sales: "region = 'eu'"
+  - name: writer
+    tables:
+      sales: "region = 'eu'"
+    write: [sales]
+  - name: admin
+    tables:
+      "*": ""
+    write: ["*"]
+    files: true
+`
+
+// grantsEngine returns an engine over a sales table restricted by testGrants,
+// and its directory.
+func grantsEngine(t *testing.T) (*Engine, string) {
+	t.Helper()
+	e, dir := testEngine(t, map[string]string{
+		"sales.csv": "id,region,amount\n1,eu,10\n2,us,20\n",
+		"other.csv": "id\n1\n",
+	})
+	path := filepath.Join(t.TempDir(), "grants.yaml")
+	if err := ioutil.WriteFile(path, []byte(testGrants), 0644); err != nil {
+		t.Fatal(err)
+	}
+	g, err := LoadGrants(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetGrants(g)
+	return e, dir
+}
+
+// userQuery runs a query as the given user, returning all the rows of its
+// results.
+func userQuery(e *Engine, user, query string) ([]sql.Row, error) {
+	ctx := sql.NewContext(context.Background(), sql.WithSession(sql.NewSession("localhost", user)))
+	_, rows, err := e.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return sql.RowIterToRows(rows)
+}
+
+func TestGrantsReadOnly(t *testing.T) {
+	e, dir := grantsEngine(t)
+	for _, query := range []string{
+		"UPDATE sales SET amount = 0",
+		"DELETE FROM sales WHERE id = 1",
+		"INSERT INTO sales VALUES (3, 'eu', 30)",
+		"CREATE INDEX sales_id ON sales USING csvql (id) WITH (async = false)",
+		"CREATE TABLE copied AS SELECT * FROM sales",
+		"CREATE TABLE created (id INT)",
+		"CREATE MATERIALIZED VIEW totals AS SELECT SUM(amount) FROM sales",
+		"SELECT * FROM sales INTO OUTFILE '" + filepath.Join(dir, "out.csv") + "'",
+		"COPY (SELECT * FROM sales) TO '" + filepath.Join(dir, "out.csv") + "'",
+		"SELECT * FROM files('" + dir + "')",
+	} {
+		_, err := userQuery(e, "reader", query)
+		if err == nil || !strings.Contains(err.Error(), "access denied") {
+			t.Errorf("%s: got error %v, want access denied", query, err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "sales.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "id,region,amount\n1,eu,10\n2,us,20\n"; got != want {
+		t.Errorf("sales.csv changed to %q", got)
+	}
+	if _, err := userQuery(e, "admin", "SELECT * FROM files('"+dir+"')"); err != nil {
+		t.Errorf("admin could not list the files: %v", err)
+	}
+}
+
+func TestGrantsWriteGrantedRows(t *testing.T) {
+	e, dir := grantsEngine(t)
+	if _, err := userQuery(e, "writer", "UPDATE sales SET amount = 0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userQuery(e, "writer", "INSERT INTO sales VALUES (3, 'eu', 30)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		"UPDATE sales SET region = 'us' WHERE id = 1",
+		"INSERT INTO sales VALUES (4, 'us', 40)",
+		"CREATE INDEX sales_id ON sales USING csvql (id) WITH (async = false)",
+		"INSERT INTO other VALUES (2)",
+	} {
+		_, err := userQuery(e, "writer", query)
+		if err == nil || !strings.Contains(err.Error(), "access denied") {
+			t.Errorf("%s: got error %v, want access denied", query, err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "sales.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "id,region,amount\n1,eu,0\n2,us,20\n3,eu,30\n"; got != want {
+		t.Errorf("got sales.csv %q, want %q", got, want)
+	}
+}
+
+func TestGrantsInformationSchema(t *testing.T) {
+	e, _ := grantsEngine(t)
+	rows, err := userQuery(e, "reader", "SELECT table_name FROM information_schema.tables")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []sql.Row{{"sales"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("got tables %v, want %v", rows, want)
+	}
+}
+
+func TestGrantsProfiles(t *testing.T) {
+	e, _ := grantsEngine(t)
+	// Only the users who can change a table, and read all its rows, can
+	// profile it.
+	for _, user := range []string{"reader", "writer", "admin"} {
+		rows, err := userQuery(e, user, "ANALYZE TABLE sales")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := rows[0][2], map[bool]string{true: "status", false: "Error"}[user == "admin"]; got != want {
+			t.Errorf("%s: got %v %v, want %s", user, got, rows[0][3], want)
+		}
+	}
+
+	explain := func(user string) string {
+		rows, err := userQuery(e, user, "EXPLAIN SELECT * FROM sales WHERE amount > 5")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for _, row := range rows {
+			lines = append(lines, row[0].(string))
+		}
+		return strings.Join(lines, "\n")
+	}
+	if got := explain("admin"); !strings.Contains(got, "sales: 2 rows") || !strings.Contains(got, "from 10 to 20") {
+		t.Errorf("admin got plan %q, want the profiles of sales", got)
+	}
+	if got := explain("reader"); strings.Contains(got, "rows") || strings.Contains(got, "from 10") {
+		t.Errorf("reader got plan %q, want no profiles of sales", got)
+	}
+}
+
+func TestGrantsShowCreateTable(t *testing.T) {
+	e, _ := grantsEngine(t)
+	if _, err := userQuery(e, "reader", "SHOW CREATE TABLE other"); err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("got error %v, want access denied", err)
+	}
+	rows, err := userQuery(e, "reader", "SHOW CREATE TABLE sales")
+	if err != nil || len(rows) != 1 || rows[0][0] != "sales" {
+		t.Errorf("got rows %v and error %v, want the statement of sales", rows, err)
+	}
+}
+
+func TestGrantsShowHeaders(t *testing.T) {
+	e, _ := grantsEngine(t)
+	if _, err := userQuery(e, "reader", "SHOW HEADERS FROM other"); err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("got error %v, want access denied", err)
+	}
+	if _, err := userQuery(e, "nobody", "SHOW HEADERS FROM sales"); err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("an unknown user got error %v, want access denied", err)
+	}
+	rows, err := userQuery(e, "reader", "SHOW HEADERS FROM sales")
+	if err != nil || len(rows) != 3 {
+		t.Errorf("got rows %v and error %v, want the headers of sales", rows, err)
+	}
+}
+
+func TestGrantsTableStatus(t *testing.T) {
+	e, dir := grantsEngine(t)
+	if _, err := userQuery(e, "admin", "ANALYZE TABLE sales, other"); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := userQuery(e, "admin", "SHOW TABLE STATUS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[1][0] != "sales" || rows[1][2] != int64(2) || rows[1][7] != filepath.Join(dir, "sales.csv") {
+		t.Errorf("admin got %v, want other and sales, with their rows and paths", rows)
+	}
+
+	// Users only see the tables they can read, without the paths of the
+	// files unless they can list them, nor the number of rows of the tables
+	// they only read some rows of.
+	rows, err = userQuery(e, "reader", "SHOW TABLE STATUS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0][0] != "sales" || rows[0][2] != nil || rows[0][7] != nil || rows[0][8] != nil {
+		t.Errorf("reader got %v, want sales without its rows and files", rows)
+	}
+	rows, err = userQuery(e, "reader", "SELECT table_name, table_rows, file_path, file_count FROM information_schema.tables")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []sql.Row{{"sales", nil, nil, nil}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("reader got %v, want %v", rows, want)
+	}
+	if _, err := userQuery(e, "nobody", "SHOW TABLE STATUS"); err == nil {
+		t.Error("an unknown user got the status of the tables")
+	}
+}
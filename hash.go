@@ -0,0 +1,127 @@
+package csvql
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+func init() {
+	functions = append(functions,
+		// MD5(str), SHA1(str), and SHA256(str) return the hex encoded digest
+		// of str, and SHA2(str, bits) the one of the SHA-2 variant with the
+		// given number of bits, as in MySQL.
+		&scalar{name: "md5", typ: sql.Text, min: 1, max: 1, eval: digest("md5")},
+		&scalar{name: "sha1", typ: sql.Text, min: 1, max: 1, eval: digest("sha1")},
+		&scalar{name: "sha", typ: sql.Text, min: 1, max: 1, eval: digest("sha1")},
+		&scalar{name: "sha256", typ: sql.Text, min: 1, max: 1, eval: digest("sha256")},
+		&scalar{name: "sha2", typ: sql.Text, min: 2, max: 2, eval: sha2},
+		// CRC32(str) returns the IEEE checksum of str.
+		&scalar{name: "crc32", typ: sql.Int64, min: 1, max: 1, eval: checksum},
+		// HEX(x) returns the hexadecimal representation of an integer, or of
+		// the bytes in a string, and UNHEX(str) the bytes it represents.
+		&scalar{name: "hex", typ: sql.Text, min: 1, max: 1, eval: toHex},
+		&scalar{name: "unhex", typ: sql.Blob, min: 1, max: 1, eval: fromHex},
+		// TO_BASE64(str) and FROM_BASE64(str) encode and decode strings using
+		// the standard base64 alphabet.
+		&scalar{name: "to_base64", typ: sql.Text, min: 1, max: 1, eval: toBase64},
+		&scalar{name: "from_base64", typ: sql.Blob, min: 1, max: 1, eval: fromBase64},
+	)
+}
+
+// digest returns the evaluation function of a hash function with the given
+// algorithm.
+func digest(algorithm string) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		s, err := stringArg(args, 0, "")
+		if err != nil {
+			return nil, err
+		}
+		h := hashes[algorithm]()
+		h.Write([]byte(s))
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+}
+
+func sha2(args []interface{}) (interface{}, error) {
+	bits, err := intArg(args, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch bits {
+	case 0, 256:
+		return digest("sha256")(args)
+	case 512:
+		return digest("sha512")(args)
+	default:
+		return nil, fmt.Errorf("unsupported number of bits %d, use 256 or 512", bits)
+	}
+}
+
+func checksum(args []interface{}) (interface{}, error) {
+	s, err := stringArg(args, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	return int64(crc32.ChecksumIEEE([]byte(s))), nil
+}
+
+func toHex(args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, uint:
+		n, err := sql.Int64.Convert(v)
+		if err != nil {
+			return nil, err
+		}
+		return strings.ToUpper(strconv.FormatUint(uint64(n.(int64)), 16)), nil
+	}
+	s, err := stringArg(args, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	return strings.ToUpper(hex.EncodeToString([]byte(s))), nil
+}
+
+// fromHex returns the bytes represented by a hexadecimal string or, as in
+// MySQL, NULL if it is not one.
+func fromHex(args []interface{}) (interface{}, error) {
+	s, err := stringArg(args, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, nil
+	}
+	return b, nil
+}
+
+func toBase64(args []interface{}) (interface{}, error) {
+	s, err := stringArg(args, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.EncodeToString([]byte(s)), nil
+}
+
+// fromBase64 decodes a base64 string or, as in MySQL, returns NULL if it is
+// not valid.
+func fromBase64(args []interface{}) (interface{}, error) {
+	s, err := stringArg(args, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, nil
+	}
+	return b, nil
+}
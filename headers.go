@@ -0,0 +1,155 @@
+package csvql
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// tableSanitizeHeaders makes the names in the headers of the delimited files
+// of new databases snake_case identifiers.
+var tableSanitizeHeaders bool
+
+// SetSanitizeHeaders sets whether the names in the headers of the delimited
+// files of the databases created afterwards are made snake_case identifiers,
+// which can be used in queries without quoting them: Order ID and OrderID are
+// read as order_id, Größe as grosse, and 2023 Sales as c_2023_sales. SHOW
+// HEADERS FROM table tells the names the columns of a table have in the
+// header of its files.
+func SetSanitizeHeaders(sanitize bool) { tableSanitizeHeaders = sanitize }
+
+// latinLetters are the ASCII letters written for the accented ones, as the
+// identifiers of queries can only have ASCII ones.
+var latinLetters = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a", "æ", "ae",
+	"ç", "c", "è", "e", "é", "e", "ê", "e", "ë", "e", "ì", "i", "í", "i",
+	"î", "i", "ï", "i", "ñ", "n", "ò", "o", "ó", "o", "ô", "o", "õ", "o",
+	"ö", "o", "ø", "o", "ù", "u", "ú", "u", "û", "u", "ü", "u", "ý", "y",
+	"ÿ", "y", "ß", "ss", "œ", "oe",
+	"À", "A", "Á", "A", "Â", "A", "Ã", "A", "Ä", "A", "Å", "A", "Æ", "AE",
+	"Ç", "C", "È", "E", "É", "E", "Ê", "E", "Ë", "E", "Ì", "I", "Í", "I",
+	"Î", "I", "Ï", "I", "Ñ", "N", "Ò", "O", "Ó", "O", "Ô", "O", "Õ", "O",
+	"Ö", "O", "Ø", "O", "Ù", "U", "Ú", "U", "Û", "U", "Ü", "U", "Ý", "Y",
+	"Œ", "OE",
+)
+
+// sanitizeName returns a name in a header as a snake_case identifier, or the
+// positional name of the column i if nothing of it is left. Accented letters
+// lose their accents, and the other characters separate words.
+func sanitizeName(name string, i int) string {
+	var b strings.Builder
+	runes := []rune(latinLetters.Replace(strings.TrimSpace(name)))
+	sep := false
+	for j, r := range runes {
+		if r > unicode.MaxASCII || !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			sep = b.Len() > 0
+			continue
+		}
+		// Words of camelCase and PascalCase names are separated, keeping
+		// acronyms together, as in HTTPStatus.
+		if unicode.IsUpper(r) && j > 0 && b.Len() > 0 {
+			prev := runes[j-1]
+			next := j+1 < len(runes) && unicode.IsLower(runes[j+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || unicode.IsUpper(prev) && next {
+				sep = true
+			}
+		}
+		if sep {
+			b.WriteByte('_')
+			sep = false
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	s := b.String()
+	switch {
+	case s == "":
+		return fmt.Sprintf("c%d", i+1)
+	case unicode.IsDigit([]rune(s)[0]):
+		return "c_" + s
+	}
+	return s
+}
+
+// uniqueNames renames the columns of a table named as previous ones in the
+// header of its files, as id_2 for the second id, warning about it, so every
+// column can be selected. The names of other columns are not taken.
+func uniqueNames(table string, cols []string) []string {
+	taken := make(map[string]bool, len(cols))
+	for _, col := range cols {
+		taken[col] = true
+	}
+	used := make(map[string]bool, len(cols))
+	names := make([]string, len(cols))
+	for i, col := range cols {
+		name := col
+		for n := 2; used[name] || name != col && taken[name]; n++ {
+			name = fmt.Sprintf("%s_%d", col, n)
+		}
+		if name != col {
+			log.Printf("table %s has several columns named %s, the column %d is renamed %s", table, col, i+1, name)
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+func init() {
+	statements = append(statements, statement{
+		re:  regexp.MustCompile(`(?is)^\s*show\s+headers\s+(?:from|in)\s+` + "`?(\\w+)`?" + `\s*;?\s*$`),
+		run: (*Engine).showHeaders,
+	})
+}
+
+// HeaderSchema is the schema of the rows telling the names of the columns of
+// a table in the headers of its files.
+var HeaderSchema = sql.Schema{
+	{Name: "column", Type: sql.Text},
+	{Name: "header", Type: sql.Text, Nullable: true},
+}
+
+// showHeaders returns the names of the columns of a table of the current
+// database, and the ones they have in the header of its files, which are NULL
+// for the files without headers.
+func (e *Engine) showHeaders(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	db, err := e.Catalog.Database(e.Analyzer.CurrentDatabase)
+	if err != nil {
+		return nil, nil, err
+	}
+	name := strings.ToLower(m[1])
+	if err := e.checkRead(ctx, name); err != nil {
+		return nil, nil, err
+	}
+	t, ok := db.Tables()[name]
+	if !ok {
+		return nil, nil, sql.ErrTableNotFound.New(name)
+	}
+	schema := t.Schema()
+	var header []string
+	if ct, ok := t.(*table); ok {
+		schema, header = schema[:len(schema)-len(ct.pseudo)], ct.header
+	}
+
+	var rows []sql.Row
+	for i, col := range schema {
+		if i < len(header) {
+			rows = append(rows, sql.NewRow(col.Name, header[i]))
+		} else {
+			rows = append(rows, sql.NewRow(col.Name, nil))
+		}
+	}
+	return HeaderSchema, sql.RowsToRowIter(rows...), nil
+}
+
+// TableHeader returns the names of the columns of a table in the header of its
+// files, as they are written, or nil if they have no header.
+func TableHeader(t sql.Table) []string {
+	if ct, ok := t.(*table); ok && ct.dialect.header() {
+		return ct.header
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package csvql
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// httpStore reads files served over HTTP or HTTPS, whose "buckets" are the
+// hosts serving them and keys their paths, with their queries if any, as in
+// https://example.com/exports/orders.csv?token=secret.
+type httpStore struct {
+	scheme string
+}
+
+func newHTTPStore(scheme string) func() (objectStore, error) {
+	return func() (objectStore, error) {
+		return &httpStore{scheme: scheme}, nil
+	}
+}
+
+func (s *httpStore) list(bucket, prefix string) ([]object, error) {
+	return nil, fmt.Errorf("files served over %s can not be listed", strings.ToUpper(s.scheme))
+}
+
+// stat returns the version of a file given by its ETag, or by its size and
+// modification time if the server gives none.
+func (s *httpStore) stat(bucket, key string) (object, error) {
+	res, err := s.do("HEAD", bucket, key)
+	if err != nil {
+		return object{}, err
+	}
+	res.Body.Close()
+	version := res.Header.Get("ETag")
+	if version == "" && res.Header.Get("Last-Modified") != "" {
+		version = res.Header.Get("Content-Length") + "-" + res.Header.Get("Last-Modified")
+	}
+	return headerObject(key, version, res.Header), nil
+}
+
+func (s *httpStore) open(bucket, key string) (io.ReadCloser, error) {
+	res, err := s.do("GET", bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// do sends a request for a file, and returns the response if it succeeds.
+func (s *httpStore) do(method, bucket, key string) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.scheme+"://"+bucket+"/"+key, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Files served compressed are read as they are, not decompressed.
+	req.Header.Set("Accept-Encoding", "identity")
+	res, err := sendRemote(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s", res.Status)
+	}
+	return res, nil
+}
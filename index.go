@@ -0,0 +1,600 @@
+package csvql
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// indexesDir is the directory, relative to the one of a database, where the
+// indexes of its tables are persisted, in a directory per table.
+var indexesDir = filepath.Join(".csvql", "indexes")
+
+// IndexDriverID is the name of the driver of the indexes created with
+// CREATE INDEX, which is also the default one.
+const IndexDriverID = "csvql"
+
+// locationSize is the size of the location of a row in an index: the number
+// of the file holding it and the offset where its record starts.
+const locationSize = 12
+
+// indexDriver persists the indexes of CSV tables next to their files. Each
+// index maps the values of the indexed expressions to the locations of the
+// rows holding them, and remembers the state of the files when it was
+// created, since it can not be used once they change.
+type indexDriver struct {
+	catalog *sql.Catalog
+}
+
+func (d *indexDriver) ID() string { return IndexDriverID }
+
+func (d *indexDriver) Create(db, name, id string, exprs []sql.Expression, config map[string]string) (sql.Index, error) {
+	t, err := d.catalog.Table(db, name)
+	if err != nil {
+		return nil, err
+	}
+	ct, ok := t.(*table)
+	if !ok {
+		return nil, fmt.Errorf("table %s can not be indexed", name)
+	}
+	states, err := fileStates(ct)
+	if err != nil {
+		return nil, fmt.Errorf("could not index %s: %v", name, err)
+	}
+	idx := &index{db: db, dir: d.dir(db), table: name, meta: indexMeta{ID: id, Files: states, Created: time.Now()}}
+	for _, e := range exprs {
+		idx.meta.Expressions = append(idx.meta.Expressions, e.String())
+	}
+	return idx, nil
+}
+
+func (d *indexDriver) LoadAll(db, table string) ([]sql.Index, error) {
+	paths, err := filepath.Glob(filepath.Join(d.dir(db), indexesDir, table, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var indexes []sql.Index
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read index: %v", err)
+		}
+		idx := &index{db: db, dir: d.dir(db), table: table}
+		if err := json.Unmarshal(b, &idx.meta); err != nil {
+			return nil, fmt.Errorf("could not parse index %s: %v", path, err)
+		}
+		indexes = append(indexes, idx)
+	}
+	return indexes, nil
+}
+
+// dir returns the directory of a database, where the indexes of its tables
+// are kept.
+func (d *indexDriver) dir(db string) string {
+	if sdb, err := d.catalog.Database(db); err == nil {
+		if cdb, ok := sdb.(*database); ok {
+			return cdb.path
+		}
+	}
+	return db
+}
+
+func (d *indexDriver) Save(ctx *sql.Context, i sql.Index, iter sql.PartitionIndexKeyValueIter) error {
+	idx, ok := i.(*index)
+	if !ok {
+		return fmt.Errorf("index %s was not created by the %s driver", i.ID(), IndexDriverID)
+	}
+	defer iter.Close()
+
+	keys := make(map[string][]byte)
+	for {
+		_, kvs, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for {
+			values, loc, err := kvs.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				kvs.Close()
+				return err
+			}
+			key := indexKey(values)
+			keys[key] = append(keys[key], loc...)
+		}
+		if err := kvs.Close(); err != nil {
+			return err
+		}
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(keys); err != nil {
+		return fmt.Errorf("could not encode index %s: %v", idx.ID(), err)
+	}
+	meta, err := json.Marshal(&idx.meta)
+	if err != nil {
+		return fmt.Errorf("could not encode index %s: %v", idx.ID(), err)
+	}
+	// The metadata is written last, so indexes are only loaded once complete.
+	if err := writeAtomic(idx.path(".idx"), data.Bytes()); err != nil {
+		return fmt.Errorf("could not write index %s: %v", idx.ID(), err)
+	}
+	if err := writeAtomic(idx.path(".json"), meta); err != nil {
+		return fmt.Errorf("could not write index %s: %v", idx.ID(), err)
+	}
+	idx.once.Do(func() { idx.keys = keys })
+	return nil
+}
+
+func (d *indexDriver) Delete(i sql.Index, partitions sql.PartitionIter) error {
+	idx, ok := i.(*index)
+	if !ok {
+		return fmt.Errorf("index %s was not created by the %s driver", i.ID(), IndexDriverID)
+	}
+	for _, ext := range []string{".json", ".idx"} {
+		if err := os.Remove(idx.path(ext)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not delete index %s: %v", idx.ID(), err)
+		}
+	}
+	return nil
+}
+
+// indexMeta is what is persisted about an index besides its keys.
+type indexMeta struct {
+	ID          string      `json:"id"`
+	Expressions []string    `json:"expressions"`
+	Files       []fileState `json:"files"`
+	Created     time.Time   `json:"created"`
+}
+
+type index struct {
+	db, table string
+	// dir is the directory of the database.
+	dir  string
+	meta indexMeta
+
+	// keys are the locations of the rows with each key, read from the disk
+	// the first time the index is used.
+	once sync.Once
+	keys map[string][]byte
+	err  error
+}
+
+func (idx *index) ID() string            { return idx.meta.ID }
+func (idx *index) Database() string      { return idx.db }
+func (idx *index) Table() string         { return idx.table }
+func (idx *index) Expressions() []string { return idx.meta.Expressions }
+func (idx *index) Driver() string        { return IndexDriverID }
+
+func (idx *index) Get(key ...interface{}) (sql.IndexLookup, error) {
+	return &indexLookup{idx: idx, key: indexKey(key)}, nil
+}
+
+func (idx *index) Has(p sql.Partition, key ...interface{}) (bool, error) {
+	keys, err := idx.load()
+	if err != nil {
+		return false, err
+	}
+	_, ok := keys[indexKey(key)]
+	return ok, nil
+}
+
+func (idx *index) path(ext string) string {
+	return filepath.Join(idx.dir, indexesDir, idx.table, idx.meta.ID+ext)
+}
+
+func (idx *index) load() (map[string][]byte, error) {
+	idx.once.Do(func() {
+		f, err := os.Open(idx.path(".idx"))
+		if err != nil {
+			idx.err = fmt.Errorf("could not read index %s: %v", idx.ID(), err)
+			return
+		}
+		defer f.Close()
+		if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&idx.keys); err != nil {
+			idx.err = fmt.Errorf("could not read index %s: %v", idx.ID(), err)
+		}
+	})
+	return idx.keys, idx.err
+}
+
+// fresh reports whether the files of the table did not change since the
+// index was created.
+func (idx *index) fresh(t *table) bool {
+	states, err := fileStates(t)
+	return err == nil && sameFiles(idx.meta.Files, states)
+}
+
+// size returns the size of the persisted index, in bytes.
+func (idx *index) size() int64 {
+	var n int64
+	for _, ext := range []string{".json", ".idx"} {
+		if fi, err := os.Stat(idx.path(ext)); err == nil {
+			n += fi.Size()
+		}
+	}
+	return n
+}
+
+// indexKey returns the key of the given values in an index. Values are
+// compared by their textual representation, as they are in the files.
+func indexKey(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = formatValue(v)
+	}
+	return strings.Join(parts, "\x00")
+}
+
+type indexLookup struct {
+	idx *index
+	key string
+}
+
+func (l *indexLookup) Indexes() []string { return []string{l.idx.ID()} }
+
+func (l *indexLookup) Values(p sql.Partition) (sql.IndexValueIter, error) {
+	keys, err := l.idx.load()
+	if err != nil {
+		return nil, err
+	}
+	return &locationIter{locs: keys[l.key]}, nil
+}
+
+type locationIter struct{ locs []byte }
+
+func (i *locationIter) Close() error { return nil }
+func (i *locationIter) Next() ([]byte, error) {
+	if len(i.locs) < locationSize {
+		return nil, io.EOF
+	}
+	loc := i.locs[:locationSize]
+	i.locs = i.locs[locationSize:]
+	return loc, nil
+}
+
+func encodeLocation(file int, offset int64) []byte {
+	b := make([]byte, locationSize)
+	binary.BigEndian.PutUint32(b, uint32(file))
+	binary.BigEndian.PutUint64(b[4:], uint64(offset))
+	return b
+}
+
+func decodeLocation(b []byte) (file int, offset int64) {
+	return int(binary.BigEndian.Uint32(b)), int64(binary.BigEndian.Uint64(b[4:]))
+}
+
+func (t *table) WithIndexLookup(l sql.IndexLookup) sql.Table {
+	c := *t
+	c.lookup = l
+	return &c
+}
+
+func (t *table) IndexLookup() sql.IndexLookup { return t.lookup }
+
+// IndexKeyValues returns the values of the given columns in every row of the
+// table, along with the location of the row.
+func (t *table) IndexKeyValues(ctx *sql.Context, colNames []string) (sql.PartitionIndexKeyValueIter, error) {
+	if !t.dialect.lines() {
+		return nil, fmt.Errorf("could not index %s: %s tables can not be indexed", t.name, t.dialect.Format)
+	}
+	if t.compressed() {
+		return nil, fmt.Errorf("could not index %s: compressed tables can not be indexed", t.name)
+	}
+	if t.archived() {
+		return nil, fmt.Errorf("could not index %s: tables in archives can not be indexed", t.name)
+	}
+	if t.dialect.Encoding != "" {
+		return nil, fmt.Errorf("could not index %s: %s tables can not be indexed", t.name, t.dialect.Encoding)
+	}
+	if t.dialect.LineEnd != "" {
+		return nil, fmt.Errorf("could not index %s: tables with lines ended by carriage returns can not be indexed", t.name)
+	}
+	if t.dialect.SkipFooter > 0 {
+		return nil, fmt.Errorf("could not index %s: tables with footers can not be indexed", t.name)
+	}
+	cols := make([]int, len(colNames))
+	for i, name := range colNames {
+		cols[i] = sql.Schema(t.schema).IndexOf(name, t.name)
+		if cols[i] < 0 {
+			return nil, fmt.Errorf("column %s not found in %s", name, t.name)
+		}
+	}
+	return &partitionKeyValueIter{t: t, cols: cols}, nil
+}
+
+type partitionKeyValueIter struct {
+	t    *table
+	cols []int
+	done bool
+}
+
+func (i *partitionKeyValueIter) Close() error { return nil }
+func (i *partitionKeyValueIter) Next() (sql.Partition, sql.IndexKeyValueIter, error) {
+	if i.done {
+		return nil, nil, io.EOF
+	}
+	i.done = true
+	return partition{}, &keyValueIter{t: i.t, cols: i.cols, file: -1}, nil
+}
+
+type keyValueIter struct {
+	t    *table
+	cols []int
+	file int
+	f    *os.File
+	r    recordReader
+	// skipped is the length of the byte order mark and the lines skipped at
+	// the start of the file, which the offsets of the records read do not
+	// count.
+	skipped int64
+}
+
+func (i *keyValueIter) Next() ([]interface{}, []byte, error) {
+	for {
+		if i.r == nil {
+			if i.file+1 >= len(i.t.files) {
+				return nil, nil, io.EOF
+			}
+			i.file++
+			f, err := os.Open(i.t.files[i.file])
+			if err != nil {
+				return nil, nil, err
+			}
+			i.f = f
+			br := bufio.NewReaderSize(countingReader{f, &BytesRead}, 64<<10)
+			i.skipped = i.t.dialect.skipStart(br)
+			i.r = i.t.reader(br, i.t.files[i.file])
+			if i.t.dialect.header() {
+				i.r.Read() // skip titles
+			}
+		}
+
+		offset := i.skipped + i.r.InputOffset()
+		record, err := i.r.Read()
+		if err == io.EOF {
+			i.f.Close()
+			i.f, i.r = nil, nil
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		// The keys of typed columns are the values as they are written by
+		// formatValue, as the ones of lookups.
+		values := make([]interface{}, len(i.cols))
+		for j, col := range i.cols {
+			if col >= len(record) {
+				continue
+			}
+			s := strings.TrimSpace(record[col])
+			values[j] = s
+			if !i.t.typed(col) {
+				continue
+			}
+			if values[j], err = i.t.columnValue(col, s); err != nil {
+				return nil, nil, fmt.Errorf("could not read column %s of %s: %v", i.t.schema[col].Name, i.f.Name(), err)
+			}
+		}
+		RowsRead.Add(1)
+		return values, encodeLocation(i.file, offset), nil
+	}
+}
+
+func (i *keyValueIter) Close() error {
+	if i.f != nil {
+		return i.f.Close()
+	}
+	return nil
+}
+
+// indexedRows returns the rows of the table found with its index lookup, or
+// nil if it has none that can be used.
+func (t *table) indexedRows(ctx *sql.Context, p sql.Partition, pruned []bool) (sql.RowIter, error) {
+	l, ok := t.lookup.(*indexLookup)
+	if !ok || !l.idx.fresh(t) {
+		return nil, nil
+	}
+	// The records read at the offsets of the index do not tell their lines,
+	// which the violations of constraints are reported with.
+	if t.checks != nil {
+		return nil, nil
+	}
+	for _, col := range t.pseudo {
+		if col.name == "_line" {
+			return nil, nil
+		}
+	}
+	locs, err := l.Values(p)
+	if err != nil {
+		return nil, err
+	}
+	return &lookupIter{ctx: ctx, t: t, locs: locs, file: -1, pruned: pruned}, nil
+}
+
+// lookupIter reads the rows at the locations given by an index.
+type lookupIter struct {
+	ctx  *sql.Context
+	t    *table
+	locs sql.IndexValueIter
+	file int
+	f    *os.File
+	src  *rowSource
+	br   *bufio.Reader
+	// pruned are the files whose rows are skipped, if any.
+	pruned []bool
+}
+
+func (i *lookupIter) Next() (sql.Row, error) {
+	loc, err := i.locs.Next()
+	if err != nil {
+		return nil, err
+	}
+	file, offset := decodeLocation(loc)
+	for file < len(i.pruned) && i.pruned[file] {
+		if loc, err = i.locs.Next(); err != nil {
+			return nil, err
+		}
+		file, offset = decodeLocation(loc)
+	}
+	if file != i.file {
+		if err := i.open(file); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := i.f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	i.br.Reset(countingReader{i.f, &BytesRead})
+	r := i.t.reader(i.br, i.f.Name())
+	cols, err := r.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("index of %s points past the end of %s", i.t.name, i.f.Name())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var nulls []bool
+	if nr, ok := r.(nullReader); ok {
+		nulls = nr.nulls()
+	}
+	row := make(sql.Row, len(cols)+len(i.t.computed)+len(i.t.pseudo))
+	lenient := hasErrorColumn(i.t.pseudo)
+	var errs []string
+	for j, col := range cols {
+		if nulls == nil || !nulls[j] {
+			if row[j], err = i.t.columnValue(j, strings.TrimSpace(col)); err != nil {
+				if lenient {
+					errs = append(errs, i.t.schema[j].Name+": "+err.Error())
+					continue
+				}
+				return nil, fmt.Errorf("could not read column %s of %s: %v", i.t.schema[j].Name, i.f.Name(), err)
+			}
+		}
+	}
+	if len(i.t.computed) > 0 {
+		cerrs := i.t.compute(i.ctx, row, len(cols))
+		if len(cerrs) > 0 && !lenient {
+			return nil, fmt.Errorf("could not compute column %s of %s", cerrs[0], i.f.Name())
+		}
+		errs = append(errs, cerrs...)
+	}
+	if i.src != nil {
+		i.src.errors = errs
+	}
+	for j, col := range i.t.pseudo {
+		row[len(cols)+len(i.t.computed)+j] = col.value(i.src)
+	}
+	RowsRead.Add(1)
+	return row, nil
+}
+
+func (i *lookupIter) open(file int) error {
+	if i.f != nil {
+		i.f.Close()
+		i.f = nil
+	}
+	if file >= len(i.t.files) {
+		return fmt.Errorf("index of %s points to a missing file", i.t.name)
+	}
+	f, err := os.Open(i.t.files[file])
+	if err != nil {
+		return err
+	}
+	i.f, i.file = f, file
+	if len(i.t.pseudo) > 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		i.src = &rowSource{path: f.Name(), info: info}
+	}
+	if i.br == nil {
+		i.br = readerPool.Get().(*bufio.Reader)
+	}
+	return nil
+}
+
+func (i *lookupIter) Close() error {
+	if i.br != nil {
+		i.br.Reset(nil)
+		readerPool.Put(i.br)
+		i.br = nil
+	}
+	if i.f != nil {
+		i.f.Close()
+	}
+	return i.locs.Close()
+}
+
+func init() {
+	statements = append(statements, statement{
+		re:  regexp.MustCompile(`(?is)^\s*show\s+(?:index|indexes|keys)\s+(?:from|in)\s+` + "`?(\\w+)`?" + `\s*;?\s*$`),
+		run: (*Engine).showIndexes,
+	})
+}
+
+// IndexSchema is the schema of the rows describing the indexes of a table.
+var IndexSchema = sql.Schema{
+	{Name: "table", Type: sql.Text},
+	{Name: "name", Type: sql.Text},
+	{Name: "columns", Type: sql.Text},
+	{Name: "size", Type: sql.Int64},
+	{Name: "status", Type: sql.Text},
+	{Name: "created", Type: sql.Timestamp},
+}
+
+// showIndexes describes the indexes of a table of the current database: the
+// expressions indexed, their size on disk, and whether they are still being
+// built, fresh, or stale because the files of the table changed since then.
+func (e *Engine) showIndexes(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	db, err := e.Catalog.Database(e.Analyzer.CurrentDatabase)
+	if err != nil {
+		return nil, nil, err
+	}
+	name := strings.ToLower(m[1])
+	t, ok := db.Tables()[name]
+	if !ok {
+		return nil, nil, sql.ErrTableNotFound.New(name)
+	}
+
+	var rows []sql.Row
+	for _, i := range e.Catalog.IndexesByTable(db.Name(), name) {
+		idx, ok := i.(*index)
+		if !ok {
+			rows = append(rows, sql.NewRow(name, i.ID(), strings.Join(i.Expressions(), ", "), nil, "unknown", nil))
+			e.Catalog.ReleaseIndex(i)
+			continue
+		}
+		status := "fresh"
+		if ct, ok := t.(*table); !e.Catalog.CanUseIndex(idx) {
+			status = "building"
+		} else if !ok || !idx.fresh(ct) {
+			status = "stale"
+		}
+		rows = append(rows, sql.NewRow(name, idx.ID(), strings.Join(idx.Expressions(), ", "), idx.size(), status, idx.meta.Created))
+		e.Catalog.ReleaseIndex(i)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][1].(string) < rows[j][1].(string) })
+	return IndexSchema, sql.RowsToRowIter(rows...), nil
+}
@@ -0,0 +1,470 @@
+package csvql
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+// candidate is a type, and the layout used to parse it, that values found in
+// a column could have.
+type candidate struct {
+	typ    sql.Type
+	format valueFormat
+}
+
+// candidates lists the types that can be inferred, from the most to the least
+// specific. Text is the fallback when none of them accepts all the values.
+var candidates = []candidate{
+	{sql.Int64, valueFormat{}},
+	{sql.Float64, valueFormat{}},
+	{sql.Boolean, valueFormat{}},
+	{sql.Date, valueFormat{layout: sql.DateLayout}},
+	{sql.Date, valueFormat{layout: "2006/01/02"}},
+	{sql.Date, valueFormat{layout: "01/02/2006"}},
+	{sql.Date, valueFormat{layout: "02.01.2006"}},
+	{sql.Timestamp, valueFormat{layout: sql.TimestampLayout}},
+	{sql.Timestamp, valueFormat{layout: "2006-01-02T15:04:05"}},
+	{sql.Timestamp, valueFormat{layout: time.RFC3339}},
+	{sql.Timestamp, valueFormat{layout: time.RFC3339Nano}},
+	{UUID, valueFormat{}},
+}
+
+// symbolCandidates are the candidates for numbers with symbols, considered
+// right after the plain numeric ones when enabled in the InferOptions.
+var symbolCandidates = []candidate{
+	{sql.Int64, valueFormat{symbols: true}},
+	{sql.Float64, valueFormat{symbols: true}},
+}
+
+// InferOptions configures the inference of schemas.
+type InferOptions struct {
+	// Symbols allows inferring as numeric the columns whose values contain
+	// currency symbols, thousands separators, or a trailing percent sign.
+	Symbols bool
+	// NaNAsNull treats NaN values as NULL, marking the float columns
+	// containing them as such.
+	NaNAsNull bool
+	// Locale is the locale numbers are written in, such as de for 1.234,56,
+	// marking the numeric columns as such.
+	Locale string
+	// Rows is the number of rows read from the start of the table, or all of
+	// them if 0.
+	Rows int
+	// DateFormat is the layout of the dates or timestamps, as the ones of
+	// time.Parse or strftime, tried before the usual ones.
+	DateFormat string
+	// Identifiers keeps as text the integer columns whose values are all
+	// written with the same number of digits, from 5 on, such as account
+	// numbers, which could have leading zeros in the next rows. Epoch
+	// timestamps and amounts are written like them too, so it is not set
+	// by default.
+	Identifiers bool
+}
+
+// candidates returns the candidate types to consider with the options.
+func (o InferOptions) candidates() []candidate {
+	cands := append([]candidate(nil), candidates[:2]...)
+	if o.Symbols {
+		cands = append(cands, symbolCandidates...)
+	}
+	cands = append(cands, candidates[2])
+	if o.DateFormat != "" {
+		cands = append(cands, candidate{layoutType(o.DateFormat), valueFormat{layout: o.DateFormat}})
+	}
+	cands = append(cands, candidates[3:]...)
+	for i := range cands {
+		if sql.IsNumber(cands[i].typ) {
+			cands[i].format.locale = o.Locale
+		}
+	}
+	return cands
+}
+
+// columnInference keeps track of the candidate types still accepting all the
+// values seen in a column.
+type columnInference struct {
+	opts       InferOptions
+	candidates []candidate
+	nulls      bool
+	nans       bool
+	values     bool
+	// localized is set when values are written differently in the locale.
+	localized bool
+	// digits is the number of digits of the values if they are all written
+	// with the same number of them only, or -1, and n the number of values.
+	digits, n int
+}
+
+func newColumnInference(opts InferOptions) *columnInference {
+	return &columnInference{opts: opts, candidates: opts.candidates()}
+}
+
+func (c *columnInference) add(value string) {
+	if c.opts.NaNAsNull && strings.EqualFold(value, "nan") {
+		// NaN is only valid in float columns, where it is read as NULL.
+		c.nans = true
+		kept := c.candidates[:0]
+		for _, cand := range c.candidates {
+			if sql.IsDecimal(cand.typ) {
+				kept = append(kept, cand)
+			}
+		}
+		c.candidates = kept
+		return
+	}
+	if value == "" {
+		c.nulls = true
+		return
+	}
+	c.values = true
+	if n, ok := localeNumber(c.opts.Locale, value); ok && n != value {
+		c.localized = true
+	}
+	switch {
+	case !onlyDigits(value), c.digits != 0 && c.digits != len(value):
+		c.digits = -1
+	case c.digits == 0:
+		c.digits = len(value)
+	}
+	c.n++
+
+	zeros := hasLeadingZeros(value)
+	kept := c.candidates[:0]
+	for _, cand := range c.candidates {
+		// Identifiers like zip codes or account numbers look numeric, but
+		// they would lose their leading zeros.
+		if zeros && sql.IsNumber(cand.typ) {
+			continue
+		}
+		if _, err := parseValue(cand.typ, cand.format, value); err == nil {
+			kept = append(kept, cand)
+		}
+	}
+	c.candidates = kept
+}
+
+// identifierDigits is the number of digits from which the integers written
+// with the same number of them, like account numbers, are taken for
+// identifiers, which are kept as text with the Identifiers option.
+const identifierDigits = 5
+
+// onlyDigits reports whether a value only has digits.
+func onlyDigits(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// hasLeadingZeros reports whether the value starts with a zero followed by
+// more digits, like 01234.
+func hasLeadingZeros(value string) bool {
+	value = strings.TrimLeft(value, "+-")
+	return len(value) > 1 && value[0] == '0' && value[1] >= '0' && value[1] <= '9'
+}
+
+func (c *columnInference) schema(name string) *ColumnSchema {
+	col := &ColumnSchema{Name: name, Type: TypeName(sql.Text), NotNull: (c.values || c.nans) && !c.nulls}
+	if !c.values || len(c.candidates) == 0 {
+		return col
+	}
+
+	best := c.candidates[0]
+	if c.opts.Identifiers && sql.IsInteger(best.typ) && c.digits >= identifierDigits && c.n > 1 {
+		// Identifiers whose first values have no leading zeros, such as
+		// 12345, are likely to have them in the next ones, such as 01234.
+		return col
+	}
+	col.Type = TypeName(best.typ)
+	if l := best.format.layout; l != sql.DateLayout && l != sql.TimestampLayout {
+		col.Format = l
+	}
+	col.Symbols = best.format.symbols
+	if c.localized {
+		col.Locale = best.format.locale
+	}
+	if c.nans {
+		col.NaNAsNull, col.NotNull = true, false
+	}
+	return col
+}
+
+// InferSchema scans the rows in the given table and returns a schema with the
+// most specific type able to hold all the values in each column. Columns
+// containing no empty values are inferred as not null, and numbers with
+// leading zeros, such as zip codes, are kept as text.
+func InferSchema(ctx *sql.Context, t sql.Table, opts InferOptions) (*TableSchema, error) {
+	s, _, err := inferSchema(ctx, t, opts)
+	return s, err
+}
+
+// sampleStats are the number of rows read to infer a schema, which are all
+// the ones of the table if complete is set.
+type sampleStats struct {
+	rows     int64
+	complete bool
+}
+
+func inferSchema(ctx *sql.Context, t sql.Table, opts InferOptions) (*TableSchema, sampleStats, error) {
+	var stats sampleStats
+	locale, err := parseLocale(opts.Locale)
+	if err != nil {
+		return nil, stats, err
+	}
+	opts.Locale = locale
+	if opts.DateFormat, err = dateLayout(opts.DateFormat); err != nil {
+		return nil, stats, err
+	}
+	columns := make([]*columnInference, len(t.Schema()))
+	for i := range columns {
+		columns[i] = newColumnInference(opts)
+	}
+
+	rows, err := plan.NewResolvedTable(t).RowIter(ctx)
+	if err != nil {
+		return nil, stats, err
+	}
+	defer rows.Close()
+
+	for ; opts.Rows == 0 || stats.rows < int64(opts.Rows); stats.rows++ {
+		row, err := rows.Next()
+		if err == io.EOF {
+			stats.complete = true
+			break
+		}
+		if err != nil {
+			return nil, stats, err
+		}
+		for i, v := range row {
+			columns[i].add(formatValue(v))
+		}
+	}
+
+	s := &TableSchema{}
+	for i, col := range t.Schema() {
+		s.Columns = append(s.Columns, columns[i].schema(col.Name))
+	}
+	return s, stats, nil
+}
+
+// tableInferRows is the number of rows read to infer the types of the columns
+// of the delimited and JSON lines files of new databases.
+var tableInferRows = 1000
+
+// SetInferRows sets the number of rows read from the start of the delimited
+// and JSON lines files of the databases created afterwards to infer the types
+// of their columns, BIGINT, DOUBLE, BOOLEAN, DATE, TIMESTAMP, or UUID, which
+// are TEXT if none of them holds all the values read. It is 1000 by default,
+// and 0 reads every column as TEXT.
+func SetInferRows(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid number of rows to infer types from %d", n)
+	}
+	tableInferRows = n
+	return nil
+}
+
+// setInferRows sets the number of rows the types of the columns are inferred
+// from, given by the infer-rows option of a table or by SetInferRows.
+func (d *Dialect) setInferRows(opts map[string]string) error {
+	if d.lines() {
+		d.InferRows = tableInferRows
+	}
+	s, ok := opts["infer-rows"]
+	if !ok {
+		return nil
+	}
+	if !d.lines() {
+		return fmt.Errorf("%s files have typed columns", d.Format)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid infer-rows %q, expected a number of rows", s)
+	}
+	d.InferRows = n
+	return nil
+}
+
+// tableTextColumns are the columns of the delimited and JSON lines files of
+// new databases kept as text, whatever their values are.
+var tableTextColumns []string
+
+// SetTextColumns sets the columns of the delimited and JSON lines files of the
+// databases created afterwards, separated by commas, whose types are not
+// inferred, so identifiers such as zip codes or account numbers are read as
+// they are written.
+func SetTextColumns(columns string) error {
+	if columns == "" {
+		tableTextColumns = nil
+		return nil
+	}
+	names, err := parseColumns(columns)
+	if err != nil {
+		return err
+	}
+	tableTextColumns = names
+	return nil
+}
+
+// setTextColumns sets the columns of a table kept as text, given by its text
+// option or SetTextColumns.
+func (d *Dialect) setTextColumns(opts map[string]string) error {
+	if d.lines() {
+		d.TextColumns = tableTextColumns
+	}
+	s, ok := opts["text"]
+	if !ok {
+		return nil
+	}
+	if !d.lines() {
+		return fmt.Errorf("%s files have typed columns", d.Format)
+	}
+	names, err := parseColumns(s)
+	if err != nil {
+		return err
+	}
+	d.TextColumns = names
+	return nil
+}
+
+// inferTypes sets the types of the columns of a table read from text files to
+// the ones inferred from its first rows, as many as the InferRows of its
+// dialect, except for its TextColumns. Numbers with leading zeros are kept as
+// text, and empty values are read as NULL in the columns that are not. They
+// are kept in a .csvql-schema file next to the files, and read from it while
+// the files, and the way they are read, do not change. The rows are then
+// converted to those types while they are read. The columns of the files
+// whose first rows can not be read are kept as text, so the errors are the
+// ones of queries.
+func (t *table) inferTypes() error {
+	text := make(map[string]bool)
+	for _, col := range t.dialect.TextColumns {
+		if sql.Schema(t.schema).IndexOf(col, t.name) < 0 {
+			return fmt.Errorf("could not add table %s: option text of an unknown column %s", t.name, col)
+		}
+		text[col] = true
+	}
+	s, err := t.cachedInference(InferOptions{Rows: t.dialect.InferRows, DateFormat: t.dialect.DateFormat})
+	if err != nil {
+		return nil
+	}
+	for i, col := range s.Columns {
+		if !text[t.schema[i].Name] {
+			t.setColumnType(i, col)
+		}
+	}
+	return nil
+}
+
+// setColumnType sets the type and the format of the column i of a table read
+// from text files to the ones of a column schema. Numbers are written in the
+// locale of the files unless the column has one, and timestamps with offsets
+// are read in the time zone of the files.
+func (t *table) setColumnType(i int, col *ColumnSchema) {
+	typ, err := ParseType(col.Type)
+	if err != nil || typ == sql.Text {
+		return
+	}
+	if t.formats == nil {
+		t.formats = make([]*valueFormat, len(t.schema))
+	}
+	f := col.format()
+	if isNumeric(typ) && f.locale == "" {
+		f.locale = t.dialect.Locale
+	}
+	if typ == sql.Timestamp && hasZone(f.layout) {
+		f.zone = t.dialect.TimeZone
+	}
+	t.schema[i].Type, t.schema[i].Nullable, t.formats[i] = typ, true, &f
+}
+
+// typed reports whether the column i of a table read from text files has an
+// inferred type.
+func (t *table) typed(i int) bool { return i < len(t.formats) && t.formats[i] != nil }
+
+// columnValue returns the value of the column i of a table read from text
+// files given the text in a row, converted to the type of the column if it is
+// typed, or with its numbers written as in the locale of the files otherwise.
+// Empty values are NULL in typed columns, unless their schema tells they can
+// not be.
+func (t *table) columnValue(i int, s string) (interface{}, error) {
+	if !t.typed(i) {
+		return localeValue(t.dialect.Locale, s), nil
+	}
+	if s == "" {
+		if !t.schema[i].Nullable {
+			return nil, fmt.Errorf("missing %s value", TypeName(t.schema[i].Type))
+		}
+		return nil, nil
+	}
+	v, err := parseValue(t.schema[i].Type, *t.formats[i], s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q", TypeName(t.schema[i].Type), s)
+	}
+	return v, nil
+}
+
+// typedRow returns a row inserted in a table with its values converted to the
+// types of their columns, so they can be read back, which NULL can only be in
+// the typed columns that are nullable.
+func (t *table) typedRow(row sql.Row) (sql.Row, error) {
+	if t.formats == nil {
+		return row, nil
+	}
+	typed := make(sql.Row, len(row))
+	for i, v := range row {
+		typed[i] = v
+		if !t.typed(i) {
+			continue
+		}
+		if v == nil {
+			if !t.schema[i].Nullable {
+				return nil, fmt.Errorf("column %s of table %s can not be NULL", t.schema[i].Name, t.name)
+			}
+			continue
+		}
+		var err error
+		if typed[i], err = t.schema[i].Type.Convert(v); err != nil {
+			return nil, fmt.Errorf("could not insert %v in column %s of %s: %v", v, t.schema[i].Name, t.name, err)
+		}
+	}
+	return typed, nil
+}
+
+// formatColumn returns the textual representation of a value written to the
+// column i of a table, with the layout of its dates and timestamps if it is
+// typed.
+func (t *table) formatColumn(i int, v interface{}) string {
+	tm, ok := v.(time.Time)
+	if !ok || !t.typed(i) {
+		return formatValue(v)
+	}
+	layout := t.formats[i].layout
+	switch {
+	case layout != "":
+	case t.schema[i].Type == sql.Date:
+		layout = sql.DateLayout
+	default:
+		layout = sql.TimestampLayout
+	}
+	if zone := t.formats[i].zone; zone != nil {
+		tm = inZone(tm, zone)
+	}
+	return tm.Format(layout)
+}
+
+// SchemaPath returns the path of the schema sidecar file corresponding to
+// the given CSV file: the same path with the extension replaced by
+// ".schema.yaml".
+func SchemaPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".schema.yaml"
+}
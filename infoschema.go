@@ -0,0 +1,348 @@
+package csvql
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// informationSchemaName is the name of the database describing the ones of
+// an engine.
+const informationSchemaName = "information_schema"
+
+// informationSchema is the database describing the databases of an engine,
+// their tables and their columns, as the information_schema one of MySQL, so
+// the tools introspecting it find the tables of csvql. The tables the grants
+// of a user do not let them read are not described to them.
+type informationSchema struct {
+	e *Engine
+}
+
+func (s *informationSchema) Name() string { return informationSchemaName }
+
+func (s *informationSchema) Tables() map[string]sql.Table {
+	return map[string]sql.Table{
+		"schemata": &infoTable{name: "schemata", schema: schemataSchema, rows: s.schemata},
+		"tables":   &infoTable{name: "tables", schema: tablesSchema, rows: s.tables},
+		"columns":  &infoTable{name: "columns", schema: columnsSchema, rows: s.columns},
+	}
+}
+
+var schemataSchema = sql.Schema{
+	{Name: "catalog_name", Type: sql.Text, Source: "schemata"},
+	{Name: "schema_name", Type: sql.Text, Source: "schemata"},
+	{Name: "default_character_set_name", Type: sql.Text, Source: "schemata"},
+	{Name: "default_collation_name", Type: sql.Text, Source: "schemata"},
+}
+
+// tablesSchema has the columns of the TABLES table of MySQL that csvql can
+// tell, followed by the files of the tables.
+var tablesSchema = sql.Schema{
+	{Name: "table_catalog", Type: sql.Text, Source: "tables"},
+	{Name: "table_schema", Type: sql.Text, Source: "tables"},
+	{Name: "table_name", Type: sql.Text, Source: "tables"},
+	{Name: "table_type", Type: sql.Text, Source: "tables"},
+	{Name: "engine", Type: sql.Text, Nullable: true, Source: "tables"},
+	{Name: "table_rows", Type: sql.Int64, Nullable: true, Source: "tables"},
+	{Name: "data_length", Type: sql.Int64, Nullable: true, Source: "tables"},
+	{Name: "update_time", Type: sql.Timestamp, Nullable: true, Source: "tables"},
+	{Name: "table_collation", Type: sql.Text, Source: "tables"},
+	{Name: "table_comment", Type: sql.Text, Source: "tables"},
+	{Name: "file_path", Type: sql.Text, Nullable: true, Source: "tables"},
+	{Name: "file_count", Type: sql.Int64, Nullable: true, Source: "tables"},
+}
+
+// columnsSchema has the columns of the COLUMNS table of MySQL that csvql can
+// tell, followed by the names of the columns in the headers of the files.
+var columnsSchema = sql.Schema{
+	{Name: "table_catalog", Type: sql.Text, Source: "columns"},
+	{Name: "table_schema", Type: sql.Text, Source: "columns"},
+	{Name: "table_name", Type: sql.Text, Source: "columns"},
+	{Name: "column_name", Type: sql.Text, Source: "columns"},
+	{Name: "ordinal_position", Type: sql.Int64, Source: "columns"},
+	{Name: "column_default", Type: sql.Text, Nullable: true, Source: "columns"},
+	{Name: "is_nullable", Type: sql.Text, Source: "columns"},
+	{Name: "data_type", Type: sql.Text, Source: "columns"},
+	{Name: "column_type", Type: sql.Text, Source: "columns"},
+	{Name: "character_set_name", Type: sql.Text, Nullable: true, Source: "columns"},
+	{Name: "collation_name", Type: sql.Text, Nullable: true, Source: "columns"},
+	{Name: "column_key", Type: sql.Text, Source: "columns"},
+	{Name: "extra", Type: sql.Text, Source: "columns"},
+	{Name: "generation_expression", Type: sql.Text, Source: "columns"},
+	{Name: "column_comment", Type: sql.Text, Source: "columns"},
+	{Name: "file_header", Type: sql.Text, Nullable: true, Source: "columns"},
+}
+
+// infoTable is a table of the information_schema database, whose rows are
+// the ones of the catalog when it is read.
+type infoTable struct {
+	name   string
+	schema sql.Schema
+	rows   func(ctx *sql.Context) []sql.Row
+}
+
+func (t *infoTable) Name() string       { return t.name }
+func (t *infoTable) String() string     { return informationSchemaName + "." + t.name }
+func (t *infoTable) Schema() sql.Schema { return t.schema }
+
+func (t *infoTable) Partitions(ctx *sql.Context) (sql.PartitionIter, error) {
+	return &partitionIter{}, nil
+}
+
+func (t *infoTable) PartitionRows(ctx *sql.Context, p sql.Partition) (sql.RowIter, error) {
+	return sql.RowsToRowIter(t.rows(ctx)...), nil
+}
+
+// catalogTables calls f with the databases of the engine, and the tables of
+// each that the user of the session can read, in the order of their names.
+func (s *informationSchema) catalogTables(ctx *sql.Context, f func(db sql.Database, name string, t sql.Table)) {
+	var u *UserGrants
+	if s.e.grants != nil {
+		if u = s.e.grants.user(ctx.Session.User()); u == nil {
+			return
+		}
+	}
+	for _, db := range s.e.Catalog.Databases {
+		tables := db.Tables()
+		names := make([]string, 0, len(tables))
+		for name := range tables {
+			if u != nil {
+				if _, ok := u.table(s.e.grantedName(db, name)); !ok {
+					continue
+				}
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			f(db, name, tables[name])
+		}
+	}
+}
+
+// grantedName returns the name a table of a database is given in the grants,
+// which is the qualified one for the tables of other databases than the
+// current one.
+func (e *Engine) grantedName(db sql.Database, name string) string {
+	if db.Name() != e.Analyzer.CurrentDatabase {
+		return db.Name() + "." + name
+	}
+	return name
+}
+
+func (s *informationSchema) schemata(ctx *sql.Context) []sql.Row {
+	var rows []sql.Row
+	for _, db := range s.e.Catalog.Databases {
+		rows = append(rows, sql.NewRow("def", db.Name(), "utf8mb4", "utf8mb4_0900_bin"))
+	}
+	return rows
+}
+
+func (s *informationSchema) tables(ctx *sql.Context) []sql.Row {
+	var u *UserGrants
+	if s.e.grants != nil {
+		u = s.e.grants.user(ctx.Session.User())
+	}
+	var rows []sql.Row
+	s.catalogTables(ctx, func(db sql.Database, name string, t sql.Table) {
+		f := describeFiles(db, name, t)
+		f.hide(u, s.e.grantedName(db, name))
+		rows = append(rows, sql.NewRow("def", db.Name(), name, f.typ, f.engine, f.rows, f.size, f.mtime, "utf8mb4_0900_bin", "", f.path, f.files))
+	})
+	return rows
+}
+
+func init() {
+	statements = append(statements, statement{
+		re:  regexp.MustCompile(`(?is)^\s*show\s+table\s+status(?:\s+(?:from|in)\s+(\w+|` + "`[^`]+`" + `))?(?:\s+like\s+` + quotedString + `)?\s*;?\s*$`),
+		run: (*Engine).showTableStatus,
+	})
+}
+
+// TableStatusSchema is the schema of the rows of SHOW TABLE STATUS, with the
+// columns of MySQL that csvql can tell, followed by the files of the tables.
+var TableStatusSchema = sql.Schema{
+	{Name: "Name", Type: sql.Text},
+	{Name: "Engine", Type: sql.Text, Nullable: true},
+	{Name: "Rows", Type: sql.Int64, Nullable: true},
+	{Name: "Data_length", Type: sql.Int64, Nullable: true},
+	{Name: "Update_time", Type: sql.Timestamp, Nullable: true},
+	{Name: "Collation", Type: sql.Text},
+	{Name: "Comment", Type: sql.Text},
+	{Name: "Path", Type: sql.Text, Nullable: true},
+	{Name: "Files", Type: sql.Int64, Nullable: true},
+	{Name: "Compression", Type: sql.Text, Nullable: true},
+}
+
+// showTableStatus runs SHOW TABLE STATUS [FROM database] [LIKE 'pattern'],
+// returning the tables of the current database, or the given one, whose
+// names match the pattern, if any, with the format, compression, size, and
+// last modification of their files, and their number of rows when it is
+// known without reading them. As in information_schema, only the tables the
+// user can read are returned, and only what their grants let them know.
+func (e *Engine) showTableStatus(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	var u *UserGrants
+	if e.grants != nil {
+		if u = e.grants.user(ctx.Session.User()); u == nil {
+			return nil, nil, fmt.Errorf("access denied for user %s", ctx.Session.User())
+		}
+	}
+	name := e.Analyzer.CurrentDatabase
+	if m[1] != "" {
+		name = strings.Trim(m[1], "`")
+	}
+	db, err := e.Catalog.Database(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	var like *regexp.Regexp
+	if m[2] != "" {
+		like = likePattern(unquote(m[2]))
+	}
+	tables := db.Tables()
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		if like != nil && !like.MatchString(name) {
+			continue
+		}
+		if u != nil {
+			if _, ok := u.table(e.grantedName(db, name)); !ok {
+				continue
+			}
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	rows := make([]sql.Row, len(names))
+	for i, name := range names {
+		f := describeFiles(db, name, tables[name])
+		f.hide(u, e.grantedName(db, name))
+		// As in MySQL, the comments of views tell they are.
+		comment := ""
+		if f.typ != "BASE TABLE" {
+			comment = f.typ
+		}
+		rows[i] = sql.NewRow(name, f.engine, f.rows, f.size, f.mtime, "utf8mb4_0900_bin", comment, f.path, f.files, f.compression)
+	}
+	return TableStatusSchema, sql.RowsToRowIter(rows...), nil
+}
+
+// tableFiles describes a table and its files, with nil for what is not known.
+type tableFiles struct {
+	typ                 string
+	engine, compression interface{}
+	path, files         interface{}
+	rows, size, mtime   interface{}
+}
+
+// hide removes from the description of a table what the user with the given
+// grants can not know, if any: the paths of its files, unless they can list
+// the files of the server, and its number of rows, unless they can read them
+// all.
+func (f *tableFiles) hide(u *UserGrants, name string) {
+	if u == nil {
+		return
+	}
+	if !u.Files {
+		f.path, f.files = nil, nil
+	}
+	if filter, _ := u.table(name); filter != nil {
+		f.rows = nil
+	}
+}
+
+// describeFiles returns the kind of a table of a database, the format and
+// compression of its files, their number and total size, the last time one
+// changed, and its number of rows, if known without reading them.
+func describeFiles(db sql.Database, name string, t sql.Table) tableFiles {
+	f := tableFiles{typ: "BASE TABLE"}
+	switch t := t.(type) {
+	case *infoTable:
+		f.typ = "SYSTEM VIEW"
+	case *sqliteTable:
+		f.engine, f.path = "SQLITE", t.path
+	case *table:
+		if cdb, ok := db.(*database); ok {
+			if _, err := cdb.view(name); err == nil {
+				f.typ = "VIEW"
+			}
+		}
+		f.engine, f.path, f.files = strings.ToUpper(t.dialect.Format), t.path, int64(len(t.files))
+		if c := t.dialect.compression(t.path); c != "" {
+			f.compression = c
+		}
+		if n, ok := t.knownRows(db, name); ok {
+			f.rows = n
+		}
+		if states, err := fileStates(t); err == nil {
+			var size int64
+			var latest time.Time
+			for _, st := range states {
+				size += st.Size
+				if st.ModTime.After(latest) {
+					latest = st.ModTime
+				}
+			}
+			f.size, f.mtime = size, latest.UTC()
+		}
+	}
+	return f
+}
+
+func (s *informationSchema) columns(ctx *sql.Context) []sql.Row {
+	var rows []sql.Row
+	s.catalogTables(ctx, func(db sql.Database, name string, t sql.Table) {
+		ct, _ := t.(*table)
+		for i, col := range t.Schema() {
+			nullable := "NO"
+			if col.Nullable {
+				nullable = "YES"
+			}
+			colType := mysqlType(col.Type)
+			dataType := colType
+			if j := strings.IndexAny(colType, " ("); j > 0 {
+				dataType = colType[:j]
+			}
+			var charset, collation interface{}
+			if col.Type == sql.Text {
+				charset, collation = "utf8mb4", "utf8mb4_0900_bin"
+			}
+			extra, expr := "", ""
+			var header interface{}
+			if ct != nil {
+				if e, ok := ct.expression(i); ok {
+					extra, expr = "VIRTUAL GENERATED", e
+				}
+				if i < len(ct.header) {
+					header = ct.header[i]
+				}
+			}
+			rows = append(rows, sql.NewRow("def", db.Name(), name, col.Name, int64(i+1), nil, nullable,
+				strings.ToLower(dataType), strings.ToLower(colType), charset, collation, "", extra, expr, "", header))
+		}
+	})
+	return rows
+}
+
+// expression returns the expression the column i of a table is computed
+// with, as written in its schema, if it is a computed one.
+func (t *table) expression(i int) (string, bool) {
+	n := len(t.schema) - len(t.computed) - len(t.pseudo)
+	if i < n || i >= n+len(t.computed) || t.dialect.Schema == nil {
+		return "", false
+	}
+	for _, col := range t.dialect.Schema.Columns {
+		if col.Expression != "" && strings.EqualFold(col.Name, t.schema[i].Name) {
+			return col.Expression, true
+		}
+	}
+	return "", false
+}
+
+func (e *Engine) currentDatabase(ctx *sql.Context, args []interface{}) (interface{}, error) {
+	return e.Analyzer.CurrentDatabase, nil
+}
@@ -0,0 +1,380 @@
+package csvql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/analyzer"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/expression"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+// journalFile is the path, relative to the database, of the journal listing
+// the files being written by a commit, and their size before it started.
+// If the process stops before the commit finishes, the files are truncated
+// back to those sizes the next time the database is opened.
+var journalFile = filepath.Join(".csvql", "journal")
+
+// journalEntry is the state of a file before a commit appended rows to it.
+type journalEntry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// transaction holds the rows inserted in each table of a database by a
+// session, until they are committed.
+type transaction struct {
+	tables []*table
+	rows   map[*table][]sql.Row
+}
+
+func (tx *transaction) add(t *table, row sql.Row) {
+	if _, ok := tx.rows[t]; !ok {
+		tx.tables = append(tx.tables, t)
+	}
+	tx.rows[t] = append(tx.rows[t], row)
+}
+
+func init() {
+	statements = append(statements,
+		statement{
+			re:  regexp.MustCompile(`(?is)^\s*(?:begin(?:\s+work)?|start\s+transaction)\s*;?\s*$`),
+			run: (*Engine).begin,
+		},
+		statement{
+			re:  regexp.MustCompile(`(?is)^\s*commit(?:\s+work)?\s*;?\s*$`),
+			run: (*Engine).commit,
+		},
+		statement{
+			re:  regexp.MustCompile(`(?is)^\s*rollback(?:\s+work)?\s*;?\s*$`),
+			run: (*Engine).rollback,
+		},
+	)
+}
+
+func (e *Engine) begin(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	db, err := e.csvDatabase()
+	if err != nil {
+		return nil, nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.txs[ctx.Session]; ok {
+		return nil, nil, fmt.Errorf("a transaction is already in progress")
+	}
+	db.txs[ctx.Session] = &transaction{rows: make(map[*table][]sql.Row)}
+	return rowsAffected(0)
+}
+
+func (e *Engine) commit(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	db, err := e.csvDatabase()
+	if err != nil {
+		return nil, nil, err
+	}
+	tx := db.endTransaction(ctx.Session)
+	if tx == nil {
+		return rowsAffected(0)
+	}
+	n, err := db.commit(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rowsAffected(n)
+}
+
+func (e *Engine) rollback(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	db, err := e.csvDatabase()
+	if err != nil {
+		return nil, nil, err
+	}
+	db.endTransaction(ctx.Session)
+	return rowsAffected(0)
+}
+
+// Rollback discards the transactions in progress in the session, as done
+// when its connection is closed.
+func (e *Engine) Rollback(s sql.Session) {
+	for _, db := range e.Catalog.Databases {
+		if db, ok := db.(*database); ok {
+			db.endTransaction(s)
+		}
+	}
+}
+
+// endTransaction removes and returns the transaction of the session, if any.
+func (db *database) endTransaction(s sql.Session) *transaction {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	tx := db.txs[s]
+	delete(db.txs, s)
+	return tx
+}
+
+// insertColumns is an analyzer rule listing every column of the table in the
+// INSERT statements that do not list them, since go-mysql-server would
+// otherwise insert the default values instead of the ones given. The columns
+// left out of the list of a statement are set to NULL, rather than to the
+// zero values of their types, as 0 or 1970-01-01.
+func insertColumns(ctx *sql.Context, a *analyzer.Analyzer, n sql.Node) (sql.Node, error) {
+	return n.TransformUp(func(n sql.Node) (sql.Node, error) {
+		ins, ok := n.(*plan.InsertInto)
+		if !ok {
+			return n, nil
+		}
+		ut, ok := ins.Left.(*plan.UnresolvedTable)
+		if !ok {
+			return n, nil
+		}
+		t, err := catalogTable(a, ut.Name())
+		if err != nil {
+			return n, nil
+		}
+		schema := t.Schema()
+		ct, ok := t.(*table)
+		if ok {
+			// The values of computed columns are not written.
+			schema = schema[:len(schema)-len(ct.computed)]
+		}
+		var cols []string
+		for _, col := range schema {
+			cols = append(cols, col.Name)
+		}
+		if len(ins.Columns) == 0 {
+			return plan.NewInsertInto(ins.Left, ins.Right, cols), nil
+		}
+		if !ok {
+			return n, nil
+		}
+
+		values := make([]sql.Expression, len(schema))
+		for i, col := range schema {
+			values[i] = expression.NewLiteral(nil, col.Type)
+		}
+		for j, name := range ins.Columns {
+			i := indexFold(cols, name)
+			if i < 0 {
+				return nil, fmt.Errorf("unknown column %s in table %s", name, ct.name)
+			}
+			values[i] = expression.NewGetField(j, schema[i].Type, schema[i].Name, true)
+		}
+		return plan.NewInsertInto(ins.Left, plan.NewProject(values, ins.Right), cols), nil
+	})
+}
+
+// indexFold returns the position of a name in a list, ignoring their case, or
+// -1 if it is not in it.
+func indexFold(names []string, name string) int {
+	for i, n := range names {
+		if strings.EqualFold(n, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Insert implements the sql.Inserter interface, appending the row to the
+// file when the session has no transaction in progress, or staging it until
+// the transaction is committed otherwise.
+func (t *table) Insert(ctx *sql.Context, row sql.Row) error {
+	if t.db == nil {
+		return fmt.Errorf("table %s is read only", t.name)
+	}
+	row, err := t.typedRow(row[:len(t.schema)-len(t.computed)-len(t.pseudo)])
+	if err != nil {
+		return err
+	}
+
+	t.db.mu.Lock()
+	tx, ok := t.db.txs[ctx.Session]
+	if ok {
+		tx.add(t, row)
+	}
+	t.db.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	tx = &transaction{rows: make(map[*table][]sql.Row)}
+	tx.add(t, row)
+	_, err = t.db.commit(tx)
+	return err
+}
+
+// commit appends the rows in the transaction to the files of their tables,
+// all or none of them, and returns how many were written.
+func (db *database) commit(tx *transaction) (int64, error) {
+	db.wmu.Lock()
+	defer db.wmu.Unlock()
+
+	var entries []journalEntry
+	var data [][]byte
+	var n int64
+	for _, t := range tx.tables {
+		if err := db.retain(t.name, t.path); err != nil {
+			return 0, err
+		}
+		b, err := encodeRows(t, tx.rows[t])
+		if err != nil {
+			return 0, err
+		}
+		path, err := filepath.Abs(t.path)
+		if err != nil {
+			return 0, err
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return 0, fmt.Errorf("could not write to %s: %v", t.path, err)
+		}
+		entries = append(entries, journalEntry{Path: path, Size: fi.Size()})
+		data = append(data, b)
+		n += int64(len(tx.rows[t]))
+	}
+	if err := db.writeJournal(entries); err != nil {
+		return 0, err
+	}
+
+	for i, entry := range entries {
+		if err := appendFile(entry.Path, data[i]); err != nil {
+			if rerr := db.recover(); rerr != nil {
+				log.Printf("could not roll back commit: %v", rerr)
+			}
+			return 0, fmt.Errorf("could not commit: %v", err)
+		}
+	}
+	if err := os.Remove(filepath.Join(db.path, journalFile)); err != nil {
+		return 0, fmt.Errorf("could not remove journal: %v", err)
+	}
+	RowsWritten.Add(n)
+	return n, nil
+}
+
+// encodeRows returns the records of the rows in the dialect of the table,
+// preceded by a new line if its file does not end with one.
+func encodeRows(t *table, rows []sql.Row) ([]byte, error) {
+	path := t.path
+	var buf bytes.Buffer
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %v", path, err)
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, fi.Size()-1); err != nil {
+			return nil, fmt.Errorf("could not read %s: %v", path, err)
+		}
+		if last[0] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+
+	w := t.writer(&buf)
+	nw, _ := w.(nullWriter)
+	// The first file has the first columns of the table, but not the ones
+	// only found in the others.
+	fields := t.fields[path]
+	var record []string
+	var nulls []bool
+	for _, row := range rows {
+		record, nulls = record[:0], nulls[:0]
+		for i, v := range row {
+			if fields != nil && fields[i] < 0 {
+				if v != nil {
+					return nil, fmt.Errorf("could not insert in table %s: %s has no column %s", t.name, path, t.schema[i].Name)
+				}
+				continue
+			}
+			record = append(record, t.formatColumn(i, v))
+			nulls = append(nulls, v == nil)
+		}
+		if nw != nil {
+			err = nw.writeNulls(record, nulls)
+		} else {
+			err = w.Write(record)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// appendFile appends the data to the file, waiting for it to be on disk.
+func appendFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeJournal atomically writes the journal of a commit.
+func (db *database) writeJournal(entries []journalEntry) error {
+	path := filepath.Join(db.path, journalFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create journal: %v", err)
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "journal.*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create journal: %v", err)
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("could not write journal: %v", err)
+	}
+	return nil
+}
+
+// recover undoes the commit left unfinished in the journal, if any, by
+// truncating the files it was appending to.
+func (db *database) recover() error {
+	path := filepath.Join(db.path, journalFile)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not read journal: %v", err)
+	}
+
+	var entries []journalEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return fmt.Errorf("could not parse journal %s: %v", path, err)
+	}
+	for _, entry := range entries {
+		if err := os.Truncate(entry.Path, entry.Size); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not roll back %s: %v", entry.Path, err)
+		}
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,52 @@
+package csvql
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+func TestInsertOmittedColumns(t *testing.T) {
+	e, dir := testEngine(t, map[string]string{
+		"t.csv": "id,n,d,name\n1,5,2024-01-01,a\n",
+	})
+	queryRows(t, e, "INSERT INTO t (name, id) VALUES ('b', 2)")
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "t.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "id,n,d,name\n1,5,2024-01-01,a\n2,,,b\n"; got != want {
+		t.Errorf("got file %q, want %q", got, want)
+	}
+	rows := queryRows(t, e, "SELECT id, n, d, name FROM t WHERE id = 2")
+	if want := []sql.Row{{int64(2), nil, nil, "b"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("got rows %v, want %v", rows, want)
+	}
+}
+
+func TestInsertOmittedNotNullColumn(t *testing.T) {
+	e, _ := testEngine(t, nil)
+	queryRows(t, e, "CREATE TABLE t (id INT NOT NULL, n INT)")
+
+	_, _, err := e.Query(sql.NewEmptyContext(), "INSERT INTO t (n) VALUES (1)")
+	if err == nil || !strings.Contains(err.Error(), "can not be NULL") {
+		t.Errorf("got error %v, want one telling id can not be NULL", err)
+	}
+	queryRows(t, e, "INSERT INTO t (id) VALUES (1)")
+	rows := queryRows(t, e, "SELECT id, n FROM t")
+	if want := []sql.Row{{int64(1), nil}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("got rows %v, want %v", rows, want)
+	}
+}
+
+func TestInsertUnknownColumn(t *testing.T) {
+	e, _ := testEngine(t, map[string]string{"t.csv": "id,name\n1,a\n"})
+	if _, _, err := e.Query(sql.NewEmptyContext(), "INSERT INTO t (id, nope) VALUES (2, 'b')"); err == nil {
+		t.Errorf("inserting in an unknown column did not fail")
+	}
+}
@@ -0,0 +1,243 @@
+package csvql
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// jsonSampleLines is the number of lines of each JSON lines file whose keys
+// are the columns of its table.
+const jsonSampleLines = 1000
+
+var errNotObject = errors.New("expected a JSON object")
+
+// jsonColumns returns the columns of a table made of JSON lines files: the
+// flattened keys of the objects in the first lines of each, in the order they
+// are found.
+func jsonColumns(paths []string, d Dialect) ([]string, error) {
+	var cols []string
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		err := sampleKeys(path, d, func(name string) {
+			if !seen[name] {
+				seen[name] = true
+				cols = append(cols, name)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cols, nil
+}
+
+// sampleKeys calls f with the flattened keys of the objects in the first
+// lines of a JSON lines file.
+func sampleKeys(path string, d Dialect, f func(name string)) error {
+	file, err := openFile(path)
+	if err != nil {
+		return fmt.Errorf("could not open %s: %v", path, err)
+	}
+	defer file.Close()
+	r, err := d.textReader(file, path)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %v", path, err)
+	}
+	defer r.Close()
+
+	br := bufio.NewReader(r)
+	d.skipStart(br)
+	lr := &lineReader{r: br}
+	for n := 0; n < jsonSampleLines; {
+		line, err := lr.readLine()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("could not read %s: %v", path, err)
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		n++
+		err = flattenObject(line, func(name, value string, null bool) { f(name) })
+		if err != nil {
+			return fmt.Errorf("could not parse line %d of %s: %v", lr.line, path, err)
+		}
+	}
+	return nil
+}
+
+// flattenObject calls f with every value of the JSON object in s, in order.
+// The values of nested objects are named after the path of keys leading to
+// them, joined by dots, and arrays are kept as JSON.
+func flattenObject(s string, f func(name, value string, null bool)) error {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return errNotObject
+	}
+	return flattenFields(dec, "", f)
+}
+
+// flattenFields reads the fields of an object up to its closing brace.
+func flattenFields(dec *json.Decoder, prefix string, f func(name, value string, null bool)) error {
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		name := prefix + strings.ToLower(tok.(string))
+		if tok, err = dec.Token(); err != nil {
+			return err
+		}
+		switch v := tok.(type) {
+		case json.Delim:
+			if v == '{' {
+				if err := flattenFields(dec, name+".", f); err != nil {
+					return err
+				}
+				continue
+			}
+			var values []interface{}
+			for dec.More() {
+				var v interface{}
+				if err := dec.Decode(&v); err != nil {
+					return err
+				}
+				values = append(values, v)
+			}
+			if _, err := dec.Token(); err != nil {
+				return err
+			}
+			if values == nil {
+				values = []interface{}{}
+			}
+			b, err := json.Marshal(values)
+			if err != nil {
+				return err
+			}
+			f(name, string(b), false)
+		case string:
+			f(name, v, false)
+		case json.Number:
+			f(name, v.String(), false)
+		case bool:
+			f(name, strconv.FormatBool(v), false)
+		case nil:
+			f(name, "", true)
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// jsonlReader reads the objects in a JSON lines file as records with the
+// values of the given columns.
+type jsonlReader struct {
+	lineReader
+	columns map[string]int
+	record  []string
+	null    []bool
+}
+
+func newJSONLReader(r io.Reader, columns []string) *jsonlReader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	jr := &jsonlReader{
+		lineReader: lineReader{r: br},
+		columns:    make(map[string]int, len(columns)),
+		record:     make([]string, len(columns)),
+		null:       make([]bool, len(columns)),
+	}
+	for i, col := range columns {
+		jr.columns[col] = i
+	}
+	return jr
+}
+
+func (r *jsonlReader) Read() ([]string, error) {
+	line, err := r.readLine()
+	for err == nil && strings.TrimSpace(line) == "" {
+		line, err = r.readLine()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Missing keys are NULL.
+	for i := range r.record {
+		r.record[i], r.null[i] = "", true
+	}
+	err = flattenObject(line, func(name, value string, null bool) {
+		if i, ok := r.columns[name]; ok {
+			r.record[i], r.null[i] = value, null
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not parse line %d: %v", r.line, err)
+	}
+	return r.record, nil
+}
+
+func (r *jsonlReader) nulls() []bool { return r.null }
+
+// jsonRecordWriter writes records as JSON objects, one per line, nesting
+// the values of the columns whose names have dots.
+type jsonRecordWriter struct {
+	w       *bufio.Writer
+	columns []string
+	err     error
+}
+
+func (w *jsonRecordWriter) Write(record []string) error {
+	return w.writeNulls(record, nil)
+}
+
+func (w *jsonRecordWriter) writeNulls(record []string, nulls []bool) error {
+	if w.err != nil {
+		return w.err
+	}
+	obj := make(map[string]interface{})
+	for i, value := range record {
+		var v interface{} = value
+		if nulls != nil && nulls[i] {
+			v = nil
+		}
+		parent := obj
+		parts := strings.Split(w.columns[i], ".")
+		for _, p := range parts[:len(parts)-1] {
+			child, ok := parent[p].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				parent[p] = child
+			}
+			parent = child
+		}
+		parent[parts[len(parts)-1]] = v
+	}
+	b, err := json.Marshal(obj)
+	if err == nil {
+		_, err = w.w.Write(append(b, '\n'))
+	}
+	w.err = err
+	return err
+}
+
+func (w *jsonRecordWriter) Flush() {
+	if err := w.w.Flush(); w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *jsonRecordWriter) Error() error { return w.err }
@@ -0,0 +1,141 @@
+package csvql
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// dateFormatOption is the prefix of the options of a table giving the layout
+// of the dates or timestamps of one of its columns, as in
+// date-format.born=02/01/2006.
+const dateFormatOption = "date-format."
+
+// tableDateFormat is the layout of the dates or timestamps of the text files
+// of new databases, tried before the usual ones.
+var tableDateFormat string
+
+// SetDateFormat sets the layout of the dates or timestamps of the delimited
+// and JSON lines files of the databases created afterwards, either as the
+// ones of time.Parse, such as 02/01/2006, or the ones of strftime, such as
+// %d/%m/%Y. The columns whose values all have it are inferred as DATE, or as
+// TIMESTAMP if it has a time of the day too, before trying the usual layouts.
+func SetDateFormat(layout string) error {
+	if layout == "" {
+		tableDateFormat = ""
+		return nil
+	}
+	l, err := dateLayout(layout)
+	if err != nil {
+		return err
+	}
+	tableDateFormat = l
+	return nil
+}
+
+// setDateFormats sets the layout of the dates of a table, given by its
+// date-format option or SetDateFormat, and the ones of its columns, given by
+// the date-format.column options.
+func (d *Dialect) setDateFormats(opts map[string]string) error {
+	if d.lines() {
+		d.DateFormat = tableDateFormat
+	}
+	for opt, s := range opts {
+		col := strings.TrimPrefix(opt, dateFormatOption)
+		if opt != "date-format" && col == opt {
+			continue
+		}
+		if !d.lines() {
+			return fmt.Errorf("%s files have typed dates", d.Format)
+		}
+		layout, err := dateLayout(s)
+		if err != nil {
+			return err
+		}
+		if opt == "date-format" {
+			d.DateFormat = layout
+			continue
+		}
+		if d.ColumnDateFormats == nil {
+			d.ColumnDateFormats = make(map[string]string)
+		}
+		d.ColumnDateFormats[col] = layout
+	}
+	return nil
+}
+
+// useDateFormats makes the columns of a table given a layout with their
+// date-format options dates or timestamps, whatever their values are.
+func (t *table) useDateFormats() error {
+	for col, layout := range t.dialect.ColumnDateFormats {
+		i := sql.Schema(t.schema).IndexOf(col, t.name)
+		if i < 0 {
+			return fmt.Errorf("option %s%s of an unknown column", dateFormatOption, col)
+		}
+		t.setColumnType(i, &ColumnSchema{Type: TypeName(layoutType(layout)), Format: layout})
+	}
+	return nil
+}
+
+// layoutType returns the type of the values with a layout, TIMESTAMP if it
+// has a time of the day, or DATE otherwise.
+func layoutType(layout string) sql.Type {
+	day := time.Date(2006, 1, 2, 0, 0, 0, 0, time.UTC)
+	if day.Format(layout) == day.Add(13*time.Hour+4*time.Minute+5*time.Second).Format(layout) {
+		return sql.Date
+	}
+	return sql.Timestamp
+}
+
+// strftimeLayouts are the layouts of time.Parse of the directives of strftime.
+var strftimeLayouts = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'd': "02",
+	'e': "_2",
+	'j': "002",
+	'b': "Jan",
+	'h': "Jan",
+	'B': "January",
+	'a': "Mon",
+	'A': "Monday",
+	'H': "15",
+	'I': "03",
+	'M': "04",
+	'S': "05",
+	'f': "000000",
+	'p': "PM",
+	'z': "-0700",
+	'Z': "MST",
+	'F': "2006-01-02",
+	'T': "15:04:05",
+	'D': "01/02/06",
+	'%': "%",
+}
+
+// dateLayout returns the layout of time.Parse given one of time.Parse, or of
+// strftime if it has any % directives.
+func dateLayout(s string) (string, error) {
+	if !strings.Contains(s, "%") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i++; i == len(s) {
+			return "", fmt.Errorf("invalid date format %q: it ends with %%", s)
+		}
+		layout, ok := strftimeLayouts[s[i]]
+		if !ok {
+			return "", fmt.Errorf("invalid date format %q: unknown directive %%%c", s, s[i])
+		}
+		b.WriteString(layout)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,111 @@
+package csvql
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+func init() {
+	// files(pattern) returns a table with the path, name, extension, size,
+	// and modification time of the files matching a pattern, or in a
+	// directory and its subdirectories, whose paths are the ones of the
+	// _file pseudo column of the tables read from them.
+	tableFunctions["files"] = listFiles
+}
+
+func listFiles(args []string) (sql.Table, error) {
+	if len(args) != 1 {
+		return nil, sql.ErrInvalidArgumentNumber.New(1, len(args))
+	}
+	if _, err := filepath.Match(args[0], ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", args[0], err)
+	}
+	return &filesTable{pattern: args[0]}, nil
+}
+
+// filesTable is the table returned by files, whose files are listed when it
+// is read.
+type filesTable struct{ pattern string }
+
+func (t *filesTable) Name() string   { return "files" }
+func (t *filesTable) String() string { return fmt.Sprintf("files(%q)", t.pattern) }
+func (t *filesTable) Schema() sql.Schema {
+	return sql.Schema{
+		{Name: "path", Type: sql.Text, Source: t.Name()},
+		{Name: "name", Type: sql.Text, Source: t.Name()},
+		{Name: "extension", Type: sql.Text, Source: t.Name()},
+		{Name: "size", Type: sql.Int64, Source: t.Name()},
+		{Name: "mtime", Type: sql.Timestamp, Source: t.Name()},
+	}
+}
+
+func (t *filesTable) Partitions(ctx *sql.Context) (sql.PartitionIter, error) {
+	return &partitionIter{}, nil
+}
+
+func (t *filesTable) PartitionRows(ctx *sql.Context, p sql.Partition) (sql.RowIter, error) {
+	paths, err := t.paths()
+	if err != nil {
+		return nil, err
+	}
+	return &fileRows{paths: paths}, nil
+}
+
+// paths returns the absolute paths of the files matching the pattern of the
+// table, in order, or the ones in the directory it names.
+func (t *filesTable) paths() ([]string, error) {
+	var paths []string
+	if fi, err := os.Stat(t.pattern); err == nil && fi.IsDir() {
+		err := filepath.Walk(t.pattern, func(path string, fi os.FileInfo, err error) error {
+			if err == nil && !fi.IsDir() {
+				paths = append(paths, path)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not list the files in %s: %v", t.pattern, err)
+		}
+	} else if paths, err = filepath.Glob(t.pattern); err != nil {
+		return nil, fmt.Errorf("could not list the files matching %s: %v", t.pattern, err)
+	}
+	for i, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = abs
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// fileRows are the rows of a files table, one per file, skipping the
+// directories and the files removed since they were listed.
+type fileRows struct {
+	paths []string
+	i     int
+}
+
+func (r *fileRows) Close() error { return nil }
+func (r *fileRows) Next() (sql.Row, error) {
+	for ; r.i < len(r.paths); r.i++ {
+		path := r.paths[r.i]
+		fi, err := os.Stat(path)
+		if os.IsNotExist(err) || err == nil && fi.IsDir() {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.i++
+		ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+		return sql.NewRow(path, fi.Name(), ext, fi.Size(), fi.ModTime().UTC()), nil
+	}
+	return nil, io.EOF
+}
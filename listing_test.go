@@ -0,0 +1,52 @@
+package csvql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+func TestJoinFilesWithPatternTable(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "logs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"a.csv": "level\ninfo\nerror\n",
+		"b.csv": "level\ninfo\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "logs", name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The paths of the pattern and of files() are relative, as in the
+	// README.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddTable(db, "logs=logs/*.csv"); err != nil {
+		t.Fatal(err)
+	}
+	e := NewEngine(db)
+
+	rows := queryRows(t, e, `SELECT f.name, COUNT(*) FROM files('logs/*.csv') f
+		JOIN logs ON logs._file = f.path GROUP BY f.name ORDER BY f.name`)
+	want := []sql.Row{{"a.csv", int32(2)}, {"b.csv", int32(1)}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got rows %v, want %v", rows, want)
+	}
+}
@@ -0,0 +1,151 @@
+package csvql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// numberLocale is the way numbers are written in a locale, with the decimal
+// separator and the ones grouping the thousands.
+type numberLocale struct {
+	decimal string
+	groups  []string
+}
+
+// numberLocales maps the languages and countries to the way they write
+// numbers. Locales such as de_DE or de-AT are looked up by their language.
+var numberLocales = map[string]numberLocale{
+	"en": {".", []string{","}},
+	"ja": {".", []string{","}},
+	"zh": {".", []string{","}},
+	"ch": {".", []string{"'", "’"}},
+	"de": {",", []string{"."}},
+	"es": {",", []string{"."}},
+	"it": {",", []string{"."}},
+	"nl": {",", []string{"."}},
+	"pt": {",", []string{"."}},
+	"da": {",", []string{"."}},
+	"id": {",", []string{"."}},
+	"tr": {",", []string{"."}},
+	"fr": {",", spaces},
+	"ru": {",", spaces},
+	"pl": {",", spaces},
+	"cs": {",", spaces},
+	"sv": {",", spaces},
+	"fi": {",", spaces},
+	"nb": {",", spaces},
+}
+
+// spaces are the spaces grouping thousands, including the non-breaking ones.
+var spaces = []string{" ", "\u00a0", "\u202f"}
+
+// tableLocale is the locale of the numbers in the text files of new
+// databases, if set.
+var tableLocale string
+
+// SetLocale sets the locale in which the numbers of the text files of the
+// databases created afterwards are written, such as de for 1.234,56, so they
+// are read as 1234.56. By default, they are read as they are.
+func SetLocale(locale string) error {
+	l, err := parseLocale(locale)
+	if err != nil {
+		return err
+	}
+	tableLocale = l
+	return nil
+}
+
+// parseLocale returns the name of the way numbers are written in a locale,
+// such as de_DE or fr-CA, or an empty string for the default one.
+func parseLocale(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || s == "c" || s == "posix" {
+		return "", nil
+	}
+	if i := strings.IndexAny(s, "_-."); i > 0 {
+		// Swiss numbers are written the same in every language.
+		if strings.HasPrefix(s[i+1:], "ch") {
+			return "ch", nil
+		}
+		s = s[:i]
+	}
+	if _, ok := numberLocales[s]; !ok {
+		return "", fmt.Errorf("unknown locale %q, expected one of %v", s, localeNames())
+	}
+	return s, nil
+}
+
+func localeNames() []string {
+	var names []string
+	for name := range numberLocales {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// localeNumber returns a number written in the given locale as it is written
+// in SQL, without its thousands separators and with a decimal point, or false
+// if it is not a number.
+func localeNumber(locale, s string) (string, bool) {
+	l, ok := numberLocales[locale]
+	if !ok || s == "" {
+		return s, false
+	}
+	var b strings.Builder
+	if s[0] == '-' || s[0] == '+' {
+		b.WriteByte(s[0])
+		s = s[1:]
+	}
+	intPart, frac := s, ""
+	if i := strings.Index(s, l.decimal); i >= 0 {
+		intPart, frac = s[:i], s[i+len(l.decimal):]
+		if frac == "" || !isDigits(frac) {
+			return s, false
+		}
+	}
+	// Thousands come in groups of three digits after the first one.
+	for i, group := range splitGroups(intPart, l.groups) {
+		if group == "" || !isDigits(group) || i > 0 && len(group) != 3 {
+			return s, false
+		}
+		b.WriteString(group)
+	}
+	if frac != "" {
+		b.WriteByte('.')
+		b.WriteString(frac)
+	}
+	return b.String(), true
+}
+
+// localeValue returns a value of a text file, changing the numbers written in
+// the locale, if any, as localeNumber does.
+func localeValue(locale, s string) string {
+	if locale != "" {
+		if n, ok := localeNumber(locale, s); ok {
+			return n
+		}
+	}
+	return s
+}
+
+// splitGroups splits the integer part of a number at its thousands
+// separators, which must all be the same.
+func splitGroups(s string, seps []string) []string {
+	for _, sep := range seps {
+		if strings.Contains(s, sep) {
+			return strings.Split(s, sep)
+		}
+	}
+	return []string{s}
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,154 @@
+package csvql
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+func init() {
+	functions = append(functions,
+		// MASK(str[, unmasked[, char]]) replaces every letter and digit in str,
+		// except the last unmasked ones, with char (* by default). Any other
+		// character is kept, so formats remain recognizable.
+		&scalar{name: "mask", typ: sql.Text, min: 1, max: 3, eval: mask},
+		// REDACT(str) replaces the whole value with [REDACTED], and
+		// REDACT(str, pattern[, replacement]) only the matches of the regular
+		// expression.
+		&scalar{name: "redact", typ: sql.Text, min: 1, max: 3, eval: redact},
+		// HMAC(str, key[, algorithm]) returns the hex encoded keyed hash of str,
+		// using sha256 by default.
+		&scalar{name: "hmac", typ: sql.Text, min: 2, max: 3, eval: hmacHex},
+		// PSEUDONYMIZE(str, key) returns a short token derived from the keyed
+		// hash of str, so equal values get equal tokens and joins keep working.
+		&scalar{name: "pseudonymize", typ: sql.Text, min: 2, max: 2, eval: pseudonymize},
+	)
+}
+
+func mask(args []interface{}) (interface{}, error) {
+	s, err := stringArg(args, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	unmasked, err := intArg(args, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	char, err := stringArg(args, 2, "*")
+	if err != nil {
+		return nil, err
+	}
+	if utf8.RuneCountInString(char) != 1 {
+		return nil, fmt.Errorf("mask character must be a single character, got %q", char)
+	}
+	c, _ := utf8.DecodeRuneInString(char)
+
+	masked := []rune(s)
+	for i := len(masked) - 1; i >= 0; i-- {
+		r := masked[i]
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			continue
+		}
+		if unmasked > 0 {
+			unmasked--
+			continue
+		}
+		masked[i] = c
+	}
+	return string(masked), nil
+}
+
+// redacted is the default replacement for redacted values.
+const redacted = "[REDACTED]"
+
+// patterns caches the compiled regular expressions used by REDACT, since they
+// are usually the same for every row.
+var patterns sync.Map
+
+func redact(args []interface{}) (interface{}, error) {
+	if len(args) == 1 {
+		return redacted, nil
+	}
+	s, err := stringArg(args, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := stringArg(args, 1, "")
+	if err != nil {
+		return nil, err
+	}
+	replacement, err := stringArg(args, 2, redacted)
+	if err != nil {
+		return nil, err
+	}
+
+	re, ok := patterns.Load(pattern)
+	if !ok {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		re, _ = patterns.LoadOrStore(pattern, compiled)
+	}
+	return re.(*regexp.Regexp).ReplaceAllLiteralString(s, replacement), nil
+}
+
+// hashes maps the names of the supported hash algorithms to their
+// constructors.
+var hashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func hmacHex(args []interface{}) (interface{}, error) {
+	s, err := stringArg(args, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	key, err := stringArg(args, 1, "")
+	if err != nil {
+		return nil, err
+	}
+	// Without a key, anyone could compute the hashes of guessed values.
+	if key == "" {
+		return nil, fmt.Errorf("missing key of the keyed hash")
+	}
+	algorithm, err := stringArg(args, 2, "sha256")
+	if err != nil {
+		return nil, err
+	}
+	h, ok := hashes[strings.ToLower(algorithm)]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm %q", algorithm)
+	}
+
+	mac := hmac.New(h, []byte(key))
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// pseudonymLength is the number of hex characters in a pseudonym, enough to
+// make collisions unlikely below billions of distinct values.
+const pseudonymLength = 16
+
+func pseudonymize(args []interface{}) (interface{}, error) {
+	h, err := hmacHex(args)
+	if err != nil {
+		return nil, err
+	}
+	return h.(string)[:pseudonymLength], nil
+}
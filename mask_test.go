@@ -0,0 +1,34 @@
+package csvql
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+func TestKeyedHashes(t *testing.T) {
+	e, _ := testEngine(t, map[string]string{"people.csv": "email\nana@example.org\nbob@example.org\n"})
+
+	rows := queryRows(t, e, "SELECT HMAC(email, 'secret'), PSEUDONYMIZE(email, 'secret'), PSEUDONYMIZE(email, 'other') FROM people")
+	for _, row := range rows {
+		h, p := row[0].(string), row[1].(string)
+		if len(h) != 64 || len(p) != pseudonymLength || !strings.HasPrefix(h, p) || row[2] == p {
+			t.Errorf("got hash %s and pseudonyms %s and %v", h, p, row[2])
+		}
+	}
+
+	for _, tt := range []struct{ query, want string }{
+		{"SELECT HMAC(email, '') FROM people", "missing key"},
+		{"SELECT PSEUDONYMIZE(email, '') FROM people", "missing key"},
+		{"SELECT HMAC(email, 'secret', 'crc32') FROM people", "unknown hash algorithm"},
+	} {
+		_, iter, err := e.Query(sql.NewEmptyContext(), tt.query)
+		if err == nil {
+			_, err = sql.RowIterToRows(iter)
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %s", tt.query, err, tt.want)
+		}
+	}
+}
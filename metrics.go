@@ -0,0 +1,42 @@
+package csvql
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// Counters tracking the work done by all the tables in this package since the
+// process started.
+var (
+	// BytesRead is the number of bytes read from the files backing tables.
+	BytesRead Counter
+	// RowsRead is the number of rows read from the files backing tables.
+	RowsRead Counter
+	// RowsWritten is the number of rows written to files, by exports and
+	// materialized views.
+	RowsWritten Counter
+	// RowsInvalid is the number of rows read violating the constraints of
+	// the columns of their tables, when they are checked.
+	RowsInvalid Counter
+)
+
+// Counter is a monotonically increasing counter, safe for concurrent use.
+type Counter struct{ n int64 }
+
+// Add increments the counter by n.
+func (c *Counter) Add(n int64) { atomic.AddInt64(&c.n, n) }
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() int64 { return atomic.LoadInt64(&c.n) }
+
+// countingReader is a reader adding the number of bytes read to a counter.
+type countingReader struct {
+	r io.Reader
+	c *Counter
+}
+
+func (r countingReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.c.Add(int64(n))
+	return n, err
+}
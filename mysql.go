@@ -0,0 +1,289 @@
+package csvql
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-vitess.v0/mysql"
+	"gopkg.in/src-d/go-vitess.v0/sqltypes"
+	querypb "gopkg.in/src-d/go-vitess.v0/vt/proto/query"
+)
+
+// NewMySQLDatabase returns a database with the tables of a database in a
+// MySQL server, given by a DSN such as user:password@tcp(host:3306)/db, as
+// the ones of the Go MySQL driver, or user@unix(/path/mysqld.sock)/db. It
+// is named as the database in the server, or as given if name is not empty,
+// so its tables can be joined with the ones of the other databases of the
+// same Engine with qualified names, as in prod.customers.
+//
+// The tables are read only, and every query reads all of their rows from the
+// server, when they are first read.
+func NewMySQLDatabase(name, dsn string) (sql.Database, error) {
+	params, err := parseMySQLDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = strings.ToLower(params.DbName)
+	}
+	c, err := mysql.Connect(context.Background(), params)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to MySQL server %s: %v", mysqlAddress(params), err)
+	}
+	defer c.Close()
+
+	res, err := c.ExecuteFetch("SHOW TABLES", -1, false)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the tables of %s: %v", params.DbName, err)
+	}
+	db := &database{
+		name:   name,
+		path:   mysqlAddress(params) + "/" + params.DbName,
+		tables: make(map[string]sql.Table),
+		views:  make(map[string]*view),
+		txs:    make(map[sql.Session]*transaction),
+	}
+	for _, row := range res.Rows {
+		t := &mysqlTable{name: strings.ToLower(row[0].ToString()), remote: row[0].ToString(), params: params}
+		fields, err := c.ExecuteFetch("SELECT * FROM "+mysqlQuote(t.remote)+" LIMIT 0", 0, true)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the columns of table %s: %v", t.remote, err)
+		}
+		for _, f := range fields.Fields {
+			t.columns = append(t.columns, f.Name)
+			t.schema = append(t.schema, &sql.Column{
+				Name:     strings.ToLower(f.Name),
+				Type:     mysqlColumnType(f.Type),
+				Nullable: f.Flags&uint32(querypb.MySqlFlag_NOT_NULL_FLAG) == 0,
+				Source:   t.name,
+			})
+		}
+		db.tables[t.name] = t
+	}
+	return db, nil
+}
+
+// parseMySQLDSN parses a DSN such as user:password@tcp(host:3306)/db, whose
+// address is localhost:3306 by default.
+func parseMySQLDSN(dsn string) (*mysql.ConnParams, error) {
+	// DSNs are not shown in errors, as they have passwords.
+	invalid := fmt.Errorf("invalid MySQL DSN, expected user:password@tcp(host:port)/db")
+	params := &mysql.ConnParams{Host: "localhost", Port: 3306, Charset: "utf8"}
+	at := strings.LastIndex(dsn, "@")
+	slash := strings.LastIndex(dsn, "/")
+	if at >= 0 {
+		kv := strings.SplitN(dsn[:at], ":", 2)
+		params.Uname = kv[0]
+		if len(kv) == 2 {
+			params.Pass = kv[1]
+		}
+	}
+	// Slashes can be in the path of a socket, but not in the name of the
+	// database.
+	if slash <= at || slash == len(dsn)-1 {
+		return nil, invalid
+	}
+	params.DbName = dsn[slash+1:]
+	if strings.ContainsAny(params.DbName, "?&") {
+		return nil, fmt.Errorf("invalid MySQL DSN: options are not supported")
+	}
+
+	addr := dsn[at+1 : slash]
+	open := strings.Index(addr, "(")
+	if addr != "" && (open < 0 || !strings.HasSuffix(addr, ")")) {
+		return nil, invalid
+	}
+	switch {
+	case addr == "":
+	case addr[:open] == "tcp":
+		host, port, err := net.SplitHostPort(addr[open+1 : len(addr)-1])
+		if err != nil {
+			host, port = addr[open+1:len(addr)-1], "3306"
+		}
+		if params.Port, err = strconv.Atoi(port); err != nil {
+			return nil, invalid
+		}
+		params.Host = host
+	case addr[:open] == "unix":
+		params.UnixSocket = addr[open+1 : len(addr)-1]
+	default:
+		return nil, invalid
+	}
+	return params, nil
+}
+
+func mysqlAddress(params *mysql.ConnParams) string {
+	if params.UnixSocket != "" {
+		return params.UnixSocket
+	}
+	return net.JoinHostPort(params.Host, strconv.Itoa(params.Port))
+}
+
+func mysqlQuote(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// mysqlColumnType returns the type of the columns of a MySQL type. Decimals
+// are DOUBLE, and the types without an equivalent TEXT.
+func mysqlColumnType(t querypb.Type) sql.Type {
+	switch t {
+	case sqltypes.Int8, sqltypes.Int16, sqltypes.Int24, sqltypes.Int32, sqltypes.Year:
+		return sql.Int32
+	case sqltypes.Uint8, sqltypes.Uint16, sqltypes.Uint24, sqltypes.Uint32:
+		return sql.Uint32
+	case sqltypes.Decimal:
+		return sql.Float64
+	case sqltypes.Datetime:
+		return sql.Timestamp
+	case sqltypes.Blob, sqltypes.Binary, sqltypes.VarBinary:
+		return sql.Blob
+	}
+	if typ, err := sql.MysqlTypeToType(t); err == nil && typ != sql.Null {
+		return typ
+	}
+	return sql.Text
+}
+
+// mysqlTable is a table of a database in a MySQL server.
+type mysqlTable struct {
+	name string
+	// remote is the name of the table in the server, and columns the ones of
+	// its columns, as they are written there.
+	remote  string
+	columns []string
+	params  *mysql.ConnParams
+	schema  sql.Schema
+}
+
+func (t *mysqlTable) Name() string { return t.name }
+func (t *mysqlTable) String() string {
+	return mysqlAddress(t.params) + "/" + t.params.DbName + "." + t.remote
+}
+func (t *mysqlTable) Schema() sql.Schema { return t.schema }
+
+func (t *mysqlTable) Partitions(ctx *sql.Context) (sql.PartitionIter, error) {
+	return &partitionIter{}, nil
+}
+
+// PartitionRows reads the rows of the table from the server, with a
+// connection of their own, unless they were already read by the query, as
+// the tables of joins are for every row of the other tables.
+func (t *mysqlTable) PartitionRows(ctx *sql.Context, p sql.Partition) (sql.RowIter, error) {
+	cache, _ := ctx.Value(scanCacheKey{}).(*scanCache)
+	if rows, ok := cache.get(t); ok {
+		return sql.RowsToRowIter(rows...), nil
+	}
+	c, err := mysql.Connect(ctx, t.params)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to MySQL server %s: %v", mysqlAddress(t.params), err)
+	}
+	cols := make([]string, len(t.columns))
+	for i, col := range t.columns {
+		cols[i] = mysqlQuote(col)
+	}
+	if err := c.ExecuteStreamFetch("SELECT " + strings.Join(cols, ", ") + " FROM " + mysqlQuote(t.remote)); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("could not read table %s: %v", t, err)
+	}
+	return &mysqlRowIter{t: t, c: c, cache: cache}, nil
+}
+
+type mysqlRowIter struct {
+	t *mysqlTable
+	c *mysql.Conn
+	// rows are the ones read so far, kept in cache once all of them are,
+	// unless there are too many.
+	rows  []sql.Row
+	cache *scanCache
+}
+
+func (i *mysqlRowIter) Next() (sql.Row, error) {
+	values, err := i.c.FetchNext()
+	if err != nil {
+		return nil, fmt.Errorf("could not read table %s: %v", i.t, err)
+	}
+	if values == nil {
+		i.cache.put(i.t, i.rows)
+		i.rows = nil
+		return nil, io.EOF
+	}
+	row := make(sql.Row, len(values))
+	for j, v := range values {
+		if row[j], err = mysqlValue(v, i.t.schema[j].Type); err != nil {
+			return nil, fmt.Errorf("could not read column %s of table %s: %v", i.t.schema[j].Name, i.t, err)
+		}
+	}
+	if i.cache != nil && len(i.rows) < scanCacheRows {
+		i.rows = append(i.rows, row)
+	} else {
+		i.cache, i.rows = nil, nil
+	}
+	return row, nil
+}
+
+// Close closes the connection, rather than reading the rows left first.
+func (i *mysqlRowIter) Close() error {
+	i.c.Close()
+	return nil
+}
+
+// mysqlValue converts a value read from a MySQL server to the type of its
+// column. Zero dates, which are not valid ones, are NULL.
+func mysqlValue(v sqltypes.Value, typ sql.Type) (interface{}, error) {
+	if v.IsNull() {
+		return nil, nil
+	}
+	s := v.ToString()
+	if (typ == sql.Timestamp || typ == sql.Date) && strings.HasPrefix(s, "0000-00-00") {
+		return nil, nil
+	}
+	if typ == sql.Blob {
+		return v.ToBytes(), nil
+	}
+	return typ.Convert(s)
+}
+
+// scanCacheRows is the maximum number of rows of a table read from a server
+// kept while a query runs.
+const scanCacheRows = 100000
+
+type scanCacheKey struct{}
+
+// scanCache keeps the rows of the tables read from servers while a query
+// runs, so they are only read once.
+type scanCache struct {
+	mu   sync.Mutex
+	rows map[sql.Table][]sql.Row
+}
+
+// withScanCache returns a context for a query with a cache of its own.
+func withScanCache(ctx *sql.Context) *sql.Context {
+	nc := *ctx
+	nc.Context = context.WithValue(ctx.Context, scanCacheKey{}, &scanCache{rows: make(map[sql.Table][]sql.Row)})
+	return &nc
+}
+
+func (c *scanCache) get(t sql.Table) ([]sql.Row, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rows, ok := c.rows[t]
+	return rows, ok
+}
+
+func (c *scanCache) put(t sql.Table, rows []sql.Row) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rows[t] = rows
+}
@@ -0,0 +1,153 @@
+package csvql
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// nullOption is the prefix of the options of a table giving the NULL tokens
+// of one of its columns, as in null.age=NA.
+const nullOption = "null."
+
+// tableNulls are the values read as NULL in the text files of new databases.
+var tableNulls []string
+
+// SetNulls sets the values read as NULL in the text files of the databases
+// created afterwards, separated by commas, such as NULL,\N,NA. The first one
+// is written for the NULL values of the rows inserted.
+func SetNulls(tokens string) error {
+	if tokens == "" {
+		tableNulls = nil
+		return nil
+	}
+	nulls, err := parseNulls(tokens)
+	if err != nil {
+		return err
+	}
+	tableNulls = nulls
+	return nil
+}
+
+func parseNulls(s string) ([]string, error) {
+	var tokens []string
+	for _, token := range strings.Split(s, ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("invalid NULL values %q", s)
+	}
+	return tokens, nil
+}
+
+// setNulls sets the values read as NULL, given by the null option of a table,
+// or SetNulls, and the ones of its columns, given by the null.column options.
+func (d *Dialect) setNulls(opts map[string]string) error {
+	if d.text() {
+		d.Nulls = tableNulls
+	}
+	for opt, s := range opts {
+		col := strings.TrimPrefix(opt, nullOption)
+		if opt != "null" && col == opt {
+			continue
+		}
+		if !d.text() {
+			return fmt.Errorf("%s files have typed NULL values", d.Format)
+		}
+		tokens, err := parseNulls(s)
+		if err != nil {
+			return err
+		}
+		if opt == "null" {
+			d.Nulls = tokens
+			continue
+		}
+		if d.ColumnNulls == nil {
+			d.ColumnNulls = make(map[string][]string)
+		}
+		d.ColumnNulls[col] = tokens
+	}
+	return nil
+}
+
+// nullTokens returns the values read as NULL in each of the given columns, or
+// nil if there are none.
+func (d Dialect) nullTokens(cols []string) [][]string {
+	if d.Nulls == nil && d.ColumnNulls == nil {
+		return nil
+	}
+	tokens := make([][]string, len(cols))
+	for i, col := range cols {
+		tokens[i] = d.Nulls
+		if t, ok := d.ColumnNulls[col]; ok {
+			tokens[i] = t
+		}
+	}
+	return tokens
+}
+
+// checkNulls checks that the columns given NULL values are in the table.
+func (t *table) checkNulls() error {
+	for col := range t.dialect.ColumnNulls {
+		if sql.Schema(t.schema).IndexOf(col, t.name) < 0 {
+			return fmt.Errorf("could not add table %s: option %s%s of an unknown column", t.name, nullOption, col)
+		}
+	}
+	return nil
+}
+
+func isNull(v string, tokens []string) bool {
+	v = strings.TrimSpace(v)
+	for _, token := range tokens {
+		if v == token {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenReader reads the values of a record that are NULL tokens, such as \N,
+// as NULL.
+type tokenReader struct {
+	recordReader
+	tokens [][]string
+	null   []bool
+}
+
+func (r *tokenReader) Read() ([]string, error) {
+	record, err := r.recordReader.Read()
+	if err != nil {
+		return record, err
+	}
+	var nulls []bool
+	if nr, ok := r.recordReader.(nullReader); ok {
+		nulls = nr.nulls()
+	}
+	r.null = r.null[:0]
+	for i, v := range record {
+		null := nulls != nil && nulls[i] || i < len(r.tokens) && isNull(v, r.tokens[i])
+		r.null = append(r.null, null)
+	}
+	return record, nil
+}
+
+func (r *tokenReader) nulls() []bool { return r.null }
+
+// tokenWriter writes the NULL values of a record as the first NULL token of
+// their columns.
+type tokenWriter struct {
+	recordWriter
+	tokens [][]string
+}
+
+func (w *tokenWriter) writeNulls(record []string, nulls []bool) error {
+	for i, null := range nulls {
+		if null && i < len(w.tokens) && len(w.tokens[i]) > 0 {
+			record[i] = w.tokens[i][0]
+		}
+	}
+	return w.Write(record)
+}
@@ -0,0 +1,148 @@
+package csvql
+
+import (
+	"io"
+	"runtime"
+	"sync"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// workers bounds the goroutines reading rows concurrently, across every
+// query run by the process.
+var workers = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// producerRows is the number of rows a worker reads ahead of the consumer.
+const producerRows = 1024
+
+// opener opens a sequence of rows, when it starts being read.
+type opener func(ctx *sql.Context) (sql.RowIter, error)
+
+// parallelIter returns the rows of several sources, one after the other,
+// reading the ones ahead in the background while there are workers left.
+// The sources without a worker are read by the consumer when it reaches
+// them, so nested parallel iterators can not run out of workers.
+type parallelIter struct {
+	ctx       *sql.Context
+	sources   []opener
+	producers []*producer
+	// next is the first source neither started by a worker nor being read
+	// by the consumer.
+	next   int
+	cur    int
+	inline sql.RowIter
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newParallelIter(ctx *sql.Context, sources []opener) *parallelIter {
+	return &parallelIter{
+		ctx:       ctx,
+		sources:   sources,
+		producers: make([]*producer, len(sources)),
+		done:      make(chan struct{}),
+	}
+}
+
+// producer is a source being read by a worker.
+type producer struct {
+	rows chan sql.Row
+	// err is set before rows are closed.
+	err error
+}
+
+// start starts reading the sources ahead of the current one, for as long as
+// there are workers available.
+func (it *parallelIter) start() {
+	for ; it.next < len(it.sources); it.next++ {
+		select {
+		case workers <- struct{}{}:
+		default:
+			return
+		}
+		p := &producer{rows: make(chan sql.Row, producerRows)}
+		it.producers[it.next] = p
+		go it.produce(it.sources[it.next], p)
+	}
+}
+
+func (it *parallelIter) produce(open opener, p *producer) {
+	defer func() { <-workers }()
+	defer close(p.rows)
+
+	rows, err := open(it.ctx)
+	if err != nil {
+		p.err = err
+		return
+	}
+	defer func() {
+		if err := rows.Close(); err != nil && p.err == nil {
+			p.err = err
+		}
+	}()
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			p.err = err
+			return
+		}
+		select {
+		case p.rows <- row:
+		case <-it.done:
+			return
+		case <-it.ctx.Done():
+			p.err = it.ctx.Err()
+			return
+		}
+	}
+}
+
+func (it *parallelIter) Next() (sql.Row, error) {
+	for it.cur < len(it.sources) {
+		if it.cur == it.next && it.inline == nil {
+			rows, err := it.sources[it.cur](it.ctx)
+			if err != nil {
+				return nil, err
+			}
+			it.inline = rows
+			it.next++
+		}
+		it.start()
+
+		if p := it.producers[it.cur]; p != nil {
+			row, ok := <-p.rows
+			if ok {
+				return row, nil
+			}
+			if p.err != nil {
+				return nil, p.err
+			}
+		} else {
+			row, err := it.inline.Next()
+			if err != io.EOF {
+				return row, err
+			}
+			err = it.inline.Close()
+			it.inline = nil
+			if err != nil {
+				return nil, err
+			}
+		}
+		it.cur++
+	}
+	return nil, io.EOF
+}
+
+// Close stops the workers, which close their sources once they notice.
+func (it *parallelIter) Close() error {
+	it.once.Do(func() { close(it.done) })
+	if it.inline != nil {
+		err := it.inline.Close()
+		it.inline = nil
+		return err
+	}
+	return nil
+}
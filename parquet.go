@@ -0,0 +1,516 @@
+package csvql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// This file contains a minimal Parquet writer: every column is OPTIONAL and
+// stored in a single uncompressed, PLAIN encoded data page per row group,
+// along with its minimum and maximum values so readers can skip row groups.
+// See https://github.com/apache/parquet-format for the details of the format.
+
+const parquetMagic = "PAR1"
+
+// parquetRowGroupSize is the number of rows buffered before a row group is
+// flushed to the output.
+const parquetRowGroupSize = 1 << 16
+
+// Parquet physical types.
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetFloat     = 4
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// Parquet converted (logical) types.
+const (
+	parquetNoConvertedType = -1
+	parquetUTF8            = 0
+	parquetDate            = 6
+	parquetTimestampMillis = 9
+	parquetJSON            = 19
+)
+
+// Parquet encodings, page types and repetition types used by the writer.
+const (
+	parquetPlain    = 0
+	parquetRLE      = 3
+	parquetDataPage = 0
+	parquetOptional = 1
+)
+
+type parquetColumn struct {
+	name      string
+	physical  int32
+	converted int32
+	levels    []byte
+	values    bytes.Buffer
+	bools     []bool
+	stats     parquetStats
+}
+
+// parquetStats are the statistics of the values in a column chunk.
+type parquetStats struct {
+	nulls    int64
+	bounded  bool
+	min, max []byte
+}
+
+// parquetType returns the physical and converted Parquet types used to store
+// values of the given SQL type.
+func parquetType(t sql.Type) (physical, converted int32) {
+	switch t {
+	case sql.Boolean:
+		return parquetBoolean, parquetNoConvertedType
+	case sql.Int32:
+		return parquetInt32, parquetNoConvertedType
+	case sql.Int64, sql.Uint32, sql.Uint64:
+		return parquetInt64, parquetNoConvertedType
+	case sql.Float32:
+		return parquetFloat, parquetNoConvertedType
+	case sql.Float64:
+		return parquetDouble, parquetNoConvertedType
+	case sql.Date:
+		return parquetInt32, parquetDate
+	case sql.Timestamp:
+		return parquetInt64, parquetTimestampMillis
+	case sql.Blob:
+		return parquetByteArray, parquetNoConvertedType
+	case sql.JSON:
+		return parquetByteArray, parquetJSON
+	default:
+		return parquetByteArray, parquetUTF8
+	}
+}
+
+func (c *parquetColumn) add(v interface{}) error {
+	if v == nil {
+		c.levels = append(c.levels, 0)
+		c.stats.nulls++
+		return nil
+	}
+	c.levels = append(c.levels, 1)
+
+	var b [8]byte
+	switch c.physical {
+	case parquetBoolean:
+		v, err := sql.Boolean.Convert(v)
+		if err != nil {
+			return err
+		}
+		c.bools = append(c.bools, v.(bool))
+	case parquetInt32:
+		var n int32
+		if c.converted == parquetDate {
+			t, err := sql.Date.Convert(v)
+			if err != nil {
+				return err
+			}
+			n = int32(t.(time.Time).Unix() / (24 * 60 * 60))
+		} else {
+			i, err := sql.Int32.Convert(v)
+			if err != nil {
+				return err
+			}
+			n = i.(int32)
+		}
+		binary.LittleEndian.PutUint32(b[:4], uint32(n))
+		c.values.Write(b[:4])
+		c.bound(b[:4])
+	case parquetInt64:
+		var n int64
+		if c.converted == parquetTimestampMillis {
+			t, err := sql.Timestamp.Convert(v)
+			if err != nil {
+				return err
+			}
+			n = t.(time.Time).UnixNano() / int64(time.Millisecond)
+		} else {
+			i, err := sql.Int64.Convert(v)
+			if err != nil {
+				return err
+			}
+			n = i.(int64)
+		}
+		binary.LittleEndian.PutUint64(b[:], uint64(n))
+		c.values.Write(b[:])
+		c.bound(b[:])
+	case parquetFloat:
+		f, err := sql.Float32.Convert(v)
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(b[:4], math.Float32bits(f.(float32)))
+		c.values.Write(b[:4])
+		c.bound(b[:4])
+	case parquetDouble:
+		f, err := sql.Float64.Convert(v)
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(f.(float64)))
+		c.values.Write(b[:])
+		c.bound(b[:])
+	default:
+		var s []byte
+		switch v := v.(type) {
+		case []byte:
+			s = v
+		default:
+			s = []byte(formatValue(v))
+		}
+		binary.LittleEndian.PutUint32(b[:4], uint32(len(s)))
+		c.values.Write(b[:4])
+		c.values.Write(s)
+		c.bound(s)
+	}
+	return nil
+}
+
+// bound widens the minimum and maximum values of the column to include b,
+// which is a PLAIN encoded value or the contents of a byte array.
+func (c *parquetColumn) bound(b []byte) {
+	if !c.stats.bounded || parquetLess(c.physical, b, c.stats.min) {
+		c.stats.min = append([]byte(nil), b...)
+	}
+	if !c.stats.bounded || parquetLess(c.physical, c.stats.max, b) {
+		c.stats.max = append([]byte(nil), b...)
+	}
+	c.stats.bounded = true
+}
+
+func parquetLess(physical int32, a, b []byte) bool {
+	switch physical {
+	case parquetInt32:
+		return int32(binary.LittleEndian.Uint32(a)) < int32(binary.LittleEndian.Uint32(b))
+	case parquetInt64:
+		return int64(binary.LittleEndian.Uint64(a)) < int64(binary.LittleEndian.Uint64(b))
+	case parquetFloat:
+		return math.Float32frombits(binary.LittleEndian.Uint32(a)) < math.Float32frombits(binary.LittleEndian.Uint32(b))
+	case parquetDouble:
+		return math.Float64frombits(binary.LittleEndian.Uint64(a)) < math.Float64frombits(binary.LittleEndian.Uint64(b))
+	default:
+		return bytes.Compare(a, b) < 0
+	}
+}
+
+// page returns the content of a data page containing the buffered values:
+// the RLE encoded definition levels followed by the PLAIN encoded values.
+func (c *parquetColumn) page() []byte {
+	var levels bytes.Buffer
+	for i := 0; i < len(c.levels); {
+		j := i
+		for j < len(c.levels) && c.levels[j] == c.levels[i] {
+			j++
+		}
+		writeUvarint(&levels, uint64(j-i)<<1)
+		levels.WriteByte(c.levels[i])
+		i = j
+	}
+
+	var page bytes.Buffer
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(levels.Len()))
+	page.Write(b[:])
+	page.Write(levels.Bytes())
+
+	if c.physical == parquetBoolean {
+		packed := make([]byte, (len(c.bools)+7)/8)
+		for i, v := range c.bools {
+			if v {
+				packed[i/8] |= 1 << uint(i%8)
+			}
+		}
+		page.Write(packed)
+	} else {
+		page.Write(c.values.Bytes())
+	}
+	return page.Bytes()
+}
+
+func (c *parquetColumn) reset() {
+	c.levels = c.levels[:0]
+	c.values.Reset()
+	c.bools = c.bools[:0]
+	c.stats = parquetStats{}
+}
+
+type parquetChunk struct {
+	offset int64
+	size   int64
+	values int64
+	stats  parquetStats
+}
+
+type parquetRowGroup struct {
+	chunks []parquetChunk
+	rows   int64
+	size   int64
+}
+
+type parquetWriter struct {
+	w       io.Writer
+	offset  int64
+	columns []*parquetColumn
+	rows    int
+	groups  []parquetRowGroup
+}
+
+func newParquetWriter(w io.Writer, schema sql.Schema) *parquetWriter {
+	pw := &parquetWriter{w: w}
+	for _, col := range schema {
+		physical, converted := parquetType(col.Type)
+		pw.columns = append(pw.columns, &parquetColumn{
+			name:      col.Name,
+			physical:  physical,
+			converted: converted,
+		})
+	}
+	return pw
+}
+
+func (w *parquetWriter) write(b []byte) error {
+	n, err := w.w.Write(b)
+	w.offset += int64(n)
+	return err
+}
+
+func (w *parquetWriter) Write(row sql.Row) error {
+	if w.offset == 0 {
+		if err := w.write([]byte(parquetMagic)); err != nil {
+			return err
+		}
+	}
+
+	for i, v := range row {
+		if err := w.columns[i].add(v); err != nil {
+			return err
+		}
+	}
+	w.rows++
+	if w.rows == parquetRowGroupSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a new row group.
+func (w *parquetWriter) flush() error {
+	group := parquetRowGroup{rows: int64(w.rows)}
+	for _, c := range w.columns {
+		page := c.page()
+
+		var header thriftWriter
+		header.beginStruct()
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.beginField(5)
+		header.i32(1, int32(len(c.levels)))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.endStruct()
+		header.endStruct()
+
+		chunk := parquetChunk{offset: w.offset, values: int64(len(c.levels)), stats: c.stats}
+		if err := w.write(header.Bytes()); err != nil {
+			return err
+		}
+		if err := w.write(page); err != nil {
+			return err
+		}
+		chunk.size = w.offset - chunk.offset
+		group.size += chunk.size
+		group.chunks = append(group.chunks, chunk)
+		c.reset()
+	}
+	w.groups = append(w.groups, group)
+	w.rows = 0
+	return nil
+}
+
+// Close flushes any pending rows and writes the file footer.
+func (w *parquetWriter) Close() error {
+	if w.offset == 0 {
+		if err := w.write([]byte(parquetMagic)); err != nil {
+			return err
+		}
+	}
+	if w.rows > 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+
+	var numRows int64
+	for _, g := range w.groups {
+		numRows += g.rows
+	}
+
+	var meta thriftWriter
+	meta.beginStruct()
+	meta.i32(1, 1)
+	meta.beginList(2, thriftStruct, len(w.columns)+1)
+	meta.beginStruct()
+	meta.binary(4, []byte("schema"))
+	meta.i32(5, int32(len(w.columns)))
+	meta.endStruct()
+	for _, c := range w.columns {
+		meta.beginStruct()
+		meta.i32(1, c.physical)
+		meta.i32(3, parquetOptional)
+		meta.binary(4, []byte(c.name))
+		if c.converted != parquetNoConvertedType {
+			meta.i32(6, c.converted)
+		}
+		meta.endStruct()
+	}
+	meta.i64(3, numRows)
+	meta.beginList(4, thriftStruct, len(w.groups))
+	for _, g := range w.groups {
+		meta.beginStruct()
+		meta.beginList(1, thriftStruct, len(g.chunks))
+		for i, chunk := range g.chunks {
+			c := w.columns[i]
+			meta.beginStruct()
+			meta.i64(2, chunk.offset)
+			meta.beginField(3)
+			meta.i32(1, c.physical)
+			meta.beginList(2, thriftI32, 2)
+			meta.listI32(parquetPlain)
+			meta.listI32(parquetRLE)
+			meta.beginList(3, thriftBinary, 1)
+			meta.listBinary([]byte(c.name))
+			meta.i32(4, 0) // uncompressed
+			meta.i64(5, chunk.values)
+			meta.i64(6, chunk.size)
+			meta.i64(7, chunk.size)
+			meta.i64(9, chunk.offset)
+			meta.beginField(12)
+			meta.i64(3, chunk.stats.nulls)
+			if chunk.stats.bounded {
+				meta.binary(5, chunk.stats.max)
+				meta.binary(6, chunk.stats.min)
+			}
+			meta.endStruct()
+			meta.endStruct()
+			meta.endStruct()
+		}
+		meta.i64(2, g.size)
+		meta.i64(3, g.rows)
+		meta.endStruct()
+	}
+	meta.binary(6, []byte("csvql"))
+	meta.endStruct()
+
+	if err := w.write(meta.Bytes()); err != nil {
+		return err
+	}
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(meta.Len()))
+	if err := w.write(b[:]); err != nil {
+		return err
+	}
+	return w.write([]byte(parquetMagic))
+}
+
+// Thrift compact protocol types.
+const (
+	thriftStop      = 0
+	thriftBoolTrue  = 1
+	thriftBoolFalse = 2
+	thriftByte      = 3
+	thriftI16       = 4
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftDouble    = 7
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftSet       = 10
+	thriftMap       = 11
+	thriftStruct    = 12
+)
+
+// thriftWriter encodes structs using the Thrift compact protocol, which is
+// used by Parquet for all its metadata.
+type thriftWriter struct {
+	bytes.Buffer
+	last []int16
+}
+
+// beginStruct starts a top level struct or an element of a list of structs.
+func (w *thriftWriter) beginStruct() { w.last = append(w.last, 0) }
+
+// beginField starts a struct nested in the given field of the current one.
+func (w *thriftWriter) beginField(id int16) {
+	w.fieldHeader(id, thriftStruct)
+	w.beginStruct()
+}
+
+func (w *thriftWriter) endStruct() {
+	w.WriteByte(thriftStop)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *thriftWriter) fieldHeader(id int16, typ byte) {
+	last := &w.last[len(w.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.WriteByte(typ)
+		writeUvarint(w, zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.fieldHeader(id, thriftI32)
+	writeUvarint(w, zigzag(int64(v)))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.fieldHeader(id, thriftI64)
+	writeUvarint(w, zigzag(v))
+}
+
+func (w *thriftWriter) binary(id int16, b []byte) {
+	w.fieldHeader(id, thriftBinary)
+	w.listBinary(b)
+}
+
+func (w *thriftWriter) beginList(id int16, elem byte, size int) {
+	w.fieldHeader(id, thriftList)
+	if size < 15 {
+		w.WriteByte(byte(size)<<4 | elem)
+	} else {
+		w.WriteByte(0xf0 | elem)
+		writeUvarint(w, uint64(size))
+	}
+}
+
+func (w *thriftWriter) listI32(v int32) { writeUvarint(w, zigzag(int64(v))) }
+
+func (w *thriftWriter) listBinary(b []byte) {
+	writeUvarint(w, uint64(len(b)))
+	w.Write(b)
+}
+
+func zigzag(v int64) uint64 { return uint64(v<<1) ^ uint64(v>>63) }
+
+func writeUvarint(w io.ByteWriter, v uint64) {
+	for v >= 0x80 {
+		w.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	w.WriteByte(byte(v))
+}
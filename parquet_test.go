@@ -0,0 +1,202 @@
+package csvql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// writeParquet writes the rows to a new Parquet file in dir, returning its
+// path.
+func writeParquet(t *testing.T, dir string, schema sql.Schema, rows []sql.Row) string {
+	t.Helper()
+	path := filepath.Join(dir, "t.parquet")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := newParquetWriter(f, schema)
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	schema := sql.Schema{
+		{Name: "b", Type: sql.Boolean},
+		{Name: "i", Type: sql.Int32},
+		{Name: "l", Type: sql.Int64},
+		{Name: "u", Type: sql.Uint64},
+		{Name: "f", Type: sql.Float32},
+		{Name: "d", Type: sql.Float64},
+		{Name: "day", Type: sql.Date},
+		{Name: "ts", Type: sql.Timestamp},
+		{Name: "s", Type: sql.Text},
+		{Name: "bin", Type: sql.Blob},
+		{Name: "j", Type: sql.JSON},
+	}
+	day := time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)
+	ts := time.Date(1969, 12, 31, 23, 59, 59, 123000000, time.UTC)
+	rows := []sql.Row{
+		{true, int32(-7), int64(1) << 40, uint64(3), float32(1.5), 2.25, day, ts, "héllo", []byte{0, 1, 2}, `{"a":[1,2]}`},
+		{nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil},
+		{false, int32(2147483647), int64(-1), uint64(0), float32(-0.5), -1e300, day.AddDate(0, 0, -20000), ts.Add(time.Hour), "", []byte{}, `null`},
+	}
+	path := writeParquet(t, t.TempDir(), schema, rows)
+
+	// The unsigned integers are written as signed ones, and JSON as text.
+	want := []sql.Row{
+		{true, int32(-7), int64(1) << 40, int64(3), float32(1.5), 2.25, day, ts, "héllo", []byte{0, 1, 2}, `{"a":[1,2]}`},
+		{nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil},
+		{false, int32(2147483647), int64(-1), int64(0), float32(-0.5), -1e300, day.AddDate(0, 0, -20000), ts.Add(time.Hour), "", []byte{}, `null`},
+	}
+	if got := tableRows(t, path, "SELECT * FROM t"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got rows\n%#v\nwant\n%#v", got, want)
+	}
+}
+
+func TestParquetRowGroups(t *testing.T) {
+	rows := make([]sql.Row, parquetRowGroupSize+3)
+	for i := range rows {
+		var name interface{} = "even"
+		if i%2 == 1 {
+			name = nil
+		}
+		rows[i] = sql.Row{int64(i), name}
+	}
+	path := writeParquet(t, t.TempDir(), sql.Schema{{Name: "id", Type: sql.Int64}, {Name: "name", Type: sql.Text}}, rows)
+
+	n := int64(len(rows))
+	want := []sql.Row{{int32(n), float64(n * (n - 1) / 2), int32((n + 1) / 2)}}
+	if got := tableRows(t, path, "SELECT COUNT(*), SUM(id), COUNT(name) FROM t"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// The first row group is skipped, given its statistics.
+	before := BytesRead.Value()
+	want = []sql.Row{{n - 2, nil}, {n - 1, "even"}}
+	if got := tableRows(t, path, "SELECT id, name FROM t WHERE id >= "+formatValue(n-2)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if read := BytesRead.Value() - before; read >= 8*parquetRowGroupSize {
+		t.Errorf("read %d bytes, want only the ones of the last row group", read)
+	}
+}
+
+// TestParquetFixtures reads files of the parquet-testing repository of the
+// Apache Parquet project, written by parquet-mr, Impala, Spark and others.
+func TestParquetFixtures(t *testing.T) {
+	alltypes := "SELECT id, bool_col, tinyint_col, bigint_col, float_col, double_col, date_string_col, string_col, timestamp_col FROM t WHERE id = "
+	testCases := []struct {
+		file  string
+		query string
+		want  []sql.Row
+	}{
+		{
+			// PLAIN encoding, INT96 timestamps and strings without UTF8.
+			"alltypes_plain.parquet", alltypes + "1",
+			[]sql.Row{{int32(1), false, int32(1), int64(10), float32(1.1), 10.1, []byte("01/01/09"), []byte("1"), time.Date(2009, 1, 1, 0, 1, 0, 0, time.UTC)}},
+		},
+		{
+			"alltypes_plain.parquet", "SELECT COUNT(*), SUM(id), MIN(timestamp_col), MAX(timestamp_col) FROM t",
+			[]sql.Row{{int32(8), float64(28), time.Date(2009, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2009, 4, 1, 0, 1, 0, 0, time.UTC)}},
+		},
+		{
+			"alltypes_dictionary.parquet", alltypes + "1",
+			[]sql.Row{{int32(1), false, int32(1), int64(10), float32(1.1), 10.1, []byte("01/01/09"), []byte("1"), time.Date(2009, 1, 1, 0, 1, 0, 0, time.UTC)}},
+		},
+		{
+			"alltypes_plain.snappy.parquet", alltypes + "7",
+			[]sql.Row{{int32(7), false, int32(1), int64(10), float32(1.1), 10.1, []byte("04/01/09"), []byte("1"), time.Date(2009, 4, 1, 0, 1, 0, 0, time.UTC)}},
+		},
+		{
+			// Booleans with the RLE encoding.
+			"rle_boolean_encoding.parquet", "SELECT datatype_boolean, COUNT(*) FROM t GROUP BY datatype_boolean ORDER BY datatype_boolean",
+			[]sql.Row{{nil, int32(6)}, {false, int32(26)}, {true, int32(36)}},
+		},
+		{
+			"int32_decimal.parquet", "SELECT COUNT(*), MIN(value), MAX(value), SUM(value) FROM t",
+			[]sql.Row{{int32(24), 1.0, 24.0, 300.0}},
+		},
+		{
+			"int64_decimal.parquet", "SELECT COUNT(*), MIN(value), MAX(value), SUM(value) FROM t",
+			[]sql.Row{{int32(24), 1.0, 24.0, 300.0}},
+		},
+		{
+			"byte_array_decimal.parquet", "SELECT COUNT(*), MIN(value), MAX(value), SUM(value) FROM t",
+			[]sql.Row{{int32(24), 1.0, 24.0, 300.0}},
+		},
+		{
+			"fixed_length_decimal.parquet", "SELECT COUNT(*), MIN(value), MAX(value), SUM(value) FROM t",
+			[]sql.Row{{int32(24), 1.0, 24.0, 300.0}},
+		},
+		{
+			// Logical types, with a DECIMAL(38,0) in a fixed length byte array.
+			"cluster_test_table_1.snappy.parquet", "SELECT timestamp_tz, varchar, `boolean`, `int` FROM t",
+			[]sql.Row{
+				{time.Date(2022, 1, 17, 10, 44, 9, 291000000, time.UTC), "first", true, 42.0},
+				{time.Date(2022, 1, 17, 10, 44, 23, 571000000, time.UTC), "second", false, 99.0},
+				{nil, "third", nil, 11.0},
+			},
+		},
+		{
+			"data_index_bloom_encoding_stats.parquet", "SELECT COUNT(*), MIN(string), MAX(string) FROM t",
+			[]sql.Row{{int32(14), "Hello", "today"}},
+		},
+		{
+			// The dictionary page is at offset 0 in the metadata of the chunk.
+			"dict-page-offset-zero.parquet", "SELECT COUNT(*), MIN(l_partkey), MAX(l_partkey) FROM t",
+			[]sql.Row{{int32(39), int32(1552), int32(1552)}},
+		},
+		{
+			"single_nan.parquet", "SELECT * FROM t",
+			[]sql.Row{{nil}},
+		},
+		{
+			// A required column, and decimals in byte arrays of 7 bytes.
+			"dms_test_table_LOAD00000001.parquet", "SELECT dms_timestamp, my_smallint, my_numeric, my_money, my_bytea, my_timestamp, my_uuid, my_bool FROM t WHERE id = 1",
+			[]sql.Row{{"2022-07-26 06:46:18.616807", int32(15887), 1427131847.59, 47632969758.54, []byte("whthctcu"), time.Date(2021, 10, 31, 15, 34, 12, 458000000, time.UTC), "181e9c5d-9b55-4535-8c6e-6c8c1234b807", false}},
+		},
+	}
+	for _, tc := range testCases {
+		got := tableRows(t, filepath.Join("testdata", tc.file), tc.query)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: %s: got rows\n%#v\nwant\n%#v", tc.file, tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestParquetInvalidFiles(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "alltypes_plain.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for name, content := range map[string][]byte{
+		"text.parquet":      []byte("id,name\n1,ana\n"),
+		"magic.parquet":     []byte("PAR1PAR1"),
+		"truncated.parquet": b[:len(b)/2],
+		"footer.parquet":    append(b[:4:4], b[len(b)-8:]...),
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := tableError(t, path); err == nil || !strings.Contains(err.Error(), errParquet.Error()) {
+			t.Errorf("%s: got error %v, want %v", name, err, errParquet)
+		}
+	}
+}
@@ -0,0 +1,1077 @@
+package csvql
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/expression"
+)
+
+// This file contains a Parquet reader for flat schemas, whose columns are
+// REQUIRED or OPTIONAL primitive values stored with the PLAIN or dictionary
+// encodings, either uncompressed or compressed with snappy, gzip, or zstd.
+
+// Parquet physical types read, besides the ones written.
+const (
+	parquetInt96             = 3
+	parquetFixedLenByteArray = 7
+)
+
+// Parquet converted types read, besides the ones written.
+const (
+	parquetEnum            = 4
+	parquetDecimal         = 5
+	parquetTimestampMicros = 10
+	parquetUint8           = 11
+	parquetUint16          = 12
+	parquetUint32          = 13
+	parquetUint64          = 14
+)
+
+// Parquet encodings, page types and repetition types used by the reader.
+const (
+	parquetPlainDictionary = 2
+	parquetRLEDictionary   = 8
+	parquetDictionaryPage  = 2
+	parquetDataPageV2      = 3
+	parquetRequired        = 0
+	parquetRepeated        = 2
+)
+
+// parquetCodecs are the names of the compressions of column chunks.
+var parquetCodecs = map[int64]string{
+	0: "uncompressed",
+	1: "snappy",
+	2: "gzip",
+	3: "lzo",
+	4: "brotli",
+	5: "lz4",
+	6: "zstd",
+	7: "lz4_raw",
+}
+
+var errParquet = errors.New("invalid parquet file")
+
+// parquetField is a column of a Parquet file.
+type parquetField struct {
+	name     string
+	physical int32
+	optional bool
+	typ      sql.Type
+	// convert returns the value of the column given the physical one, which
+	// is a bool, int32, int64, float32, float64, or []byte.
+	convert func(v interface{}) interface{}
+	// length is the size of the values of fixed length byte arrays.
+	length int
+}
+
+// parquetMeta is the metadata in the footer of a Parquet file.
+type parquetMeta struct {
+	fields []*parquetField
+	groups []thriftFields
+}
+
+func readParquetMeta(f *os.File) (*parquetMeta, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var tail [8]byte
+	if fi.Size() < 12 {
+		return nil, errParquet
+	}
+	if _, err := f.ReadAt(tail[:], fi.Size()-8); err != nil {
+		return nil, err
+	}
+	n := int64(binary.LittleEndian.Uint32(tail[:4]))
+	if string(tail[4:]) != parquetMagic || n > fi.Size()-12 {
+		return nil, errParquet
+	}
+	b := make([]byte, n)
+	if _, err := f.ReadAt(b, fi.Size()-8-n); err != nil {
+		return nil, err
+	}
+	fields, err := (&thriftReader{b: b}).readStruct()
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &parquetMeta{}
+	schema := fields.list(2)
+	if len(schema) == 0 {
+		return nil, errParquet
+	}
+	for _, e := range schema[1:] {
+		e, _ := e.(thriftFields)
+		field, err := newParquetField(e)
+		if err != nil {
+			return nil, err
+		}
+		meta.fields = append(meta.fields, field)
+	}
+	for _, g := range fields.list(4) {
+		g, _ := g.(thriftFields)
+		if len(g.list(1)) != len(meta.fields) {
+			return nil, errParquet
+		}
+		meta.groups = append(meta.groups, g)
+	}
+	return meta, nil
+}
+
+func newParquetField(e thriftFields) (*parquetField, error) {
+	f := &parquetField{
+		name:     strings.ToLower(string(e.bytes(4))),
+		physical: int32(e.int(1)),
+		optional: e.int(3) != parquetRequired,
+		length:   int(e.int(2)),
+	}
+	if e.int(5) > 0 {
+		return nil, fmt.Errorf("nested column %s not supported", f.name)
+	}
+	if e.int(3) == parquetRepeated {
+		return nil, fmt.Errorf("repeated column %s not supported", f.name)
+	}
+
+	converted := int64(parquetNoConvertedType)
+	if _, ok := e[6]; ok {
+		converted = e.int(6)
+	}
+	scale := e.int(7)
+	unit := int64(time.Millisecond)
+	signed, uuid := true, false
+	// The logical types replace the converted ones in newer files.
+	if lt := e.fields(10); lt != nil {
+		switch {
+		case lt.fields(1) != nil:
+			converted = parquetUTF8
+		case lt.fields(4) != nil:
+			converted = parquetEnum
+		case lt.fields(5) != nil:
+			converted, scale = parquetDecimal, lt.fields(5).int(1)
+		case lt.fields(6) != nil:
+			converted = parquetDate
+		case lt.fields(8) != nil:
+			converted = parquetTimestampMillis
+			if u := lt.fields(8).fields(2); u.fields(2) != nil {
+				unit = int64(time.Microsecond)
+			} else if u.fields(3) != nil {
+				unit = int64(time.Nanosecond)
+			}
+		case lt.fields(10) != nil:
+			signed, _ = lt.fields(10).bool(2)
+		case lt.fields(12) != nil:
+			converted = parquetJSON
+		case lt.fields(14) != nil:
+			uuid = true
+		}
+	}
+	if converted >= parquetUint8 && converted <= parquetUint64 {
+		signed = false
+	}
+	if converted == parquetTimestampMicros {
+		converted, unit = parquetTimestampMillis, int64(time.Microsecond)
+	}
+
+	same := func(v interface{}) interface{} { return v }
+	switch {
+	case converted == parquetDecimal:
+		f.typ, f.convert = sql.Float64, func(v interface{}) interface{} { return decimalValue(v, scale) }
+	case f.physical == parquetBoolean:
+		f.typ, f.convert = sql.Boolean, same
+	case f.physical == parquetInt32 && converted == parquetDate:
+		f.typ, f.convert = sql.Date, func(v interface{}) interface{} {
+			return time.Unix(int64(v.(int32))*24*60*60, 0).UTC()
+		}
+	case f.physical == parquetInt32 && !signed:
+		f.typ, f.convert = sql.Uint32, func(v interface{}) interface{} { return uint32(v.(int32)) }
+	case f.physical == parquetInt32:
+		f.typ, f.convert = sql.Int32, same
+	case f.physical == parquetInt64 && converted == parquetTimestampMillis:
+		f.typ, f.convert = sql.Timestamp, func(v interface{}) interface{} {
+			n := v.(int64)
+			per := int64(time.Second) / unit
+			return time.Unix(n/per, n%per*unit).UTC()
+		}
+	case f.physical == parquetInt64 && !signed:
+		f.typ, f.convert = sql.Uint64, func(v interface{}) interface{} { return uint64(v.(int64)) }
+	case f.physical == parquetInt64:
+		f.typ, f.convert = sql.Int64, same
+	case f.physical == parquetInt96:
+		// Legacy timestamps, with the nanoseconds in the day followed by the
+		// Julian day.
+		f.typ, f.convert = sql.Timestamp, func(v interface{}) interface{} {
+			b := v.([]byte)
+			nanos := int64(binary.LittleEndian.Uint64(b))
+			days := int64(binary.LittleEndian.Uint32(b[8:])) - 2440588
+			return time.Unix(days*24*60*60, nanos).UTC()
+		}
+	case f.physical == parquetFloat:
+		f.typ, f.convert = sql.Float32, same
+	case f.physical == parquetDouble:
+		f.typ, f.convert = sql.Float64, same
+	case uuid && f.length == 16:
+		f.typ, f.convert = sql.Text, func(v interface{}) interface{} {
+			b := v.([]byte)
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[:4], b[4:6], b[6:8], b[8:10], b[10:])
+		}
+	case converted == parquetUTF8 || converted == parquetEnum || converted == parquetJSON:
+		f.typ, f.convert = sql.Text, func(v interface{}) interface{} { return string(v.([]byte)) }
+	case f.physical == parquetByteArray || f.physical == parquetFixedLenByteArray:
+		f.typ, f.convert = sql.Blob, func(v interface{}) interface{} {
+			return append([]byte{}, v.([]byte)...)
+		}
+	default:
+		return nil, fmt.Errorf("column %s has unknown type %d", f.name, f.physical)
+	}
+	return f, nil
+}
+
+// decimalValue returns the number stored as an unscaled integer in a
+// DECIMAL column, which is a big endian two's complement in byte arrays.
+func decimalValue(v interface{}, scale int64) interface{} {
+	var n float64
+	switch v := v.(type) {
+	case int32:
+		n = float64(v)
+	case int64:
+		n = float64(v)
+	case []byte:
+		i := new(big.Int).SetBytes(v)
+		if len(v) > 0 && v[0]&0x80 != 0 {
+			i.Sub(i, new(big.Int).Lsh(big.NewInt(1), uint(len(v)*8)))
+		}
+		n, _ = new(big.Float).SetInt(i).Float64()
+	}
+	return n / math.Pow10(int(scale))
+}
+
+// plainValues decodes n PLAIN encoded values from b.
+func (f *parquetField) plainValues(b []byte, n int) ([]interface{}, error) {
+	values := make([]interface{}, 0, n)
+	if f.physical == parquetBoolean {
+		if len(b)*8 < n {
+			return nil, errParquet
+		}
+		for i := 0; i < n; i++ {
+			values = append(values, b[i/8]>>uint(i%8)&1 != 0)
+		}
+		return values, nil
+	}
+
+	size := map[int32]int{
+		parquetInt32:             4,
+		parquetInt64:             8,
+		parquetInt96:             12,
+		parquetFloat:             4,
+		parquetDouble:            8,
+		parquetFixedLenByteArray: f.length,
+	}[f.physical]
+	for i := 0; i < n; i++ {
+		if f.physical == parquetByteArray {
+			if len(b) < 4 {
+				return nil, errParquet
+			}
+			size = int(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		}
+		if size < 0 || len(b) < size {
+			return nil, errParquet
+		}
+		v := f.physicalValue(b[:size])
+		values = append(values, f.convert(v))
+		b = b[size:]
+	}
+	return values, nil
+}
+
+// physicalValue returns the value of a fixed size PLAIN encoded value, or
+// the contents of a byte array.
+func (f *parquetField) physicalValue(b []byte) interface{} {
+	switch f.physical {
+	case parquetInt32:
+		return int32(binary.LittleEndian.Uint32(b))
+	case parquetInt64:
+		return int64(binary.LittleEndian.Uint64(b))
+	case parquetFloat:
+		return math.Float32frombits(binary.LittleEndian.Uint32(b))
+	case parquetDouble:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	default:
+		return b
+	}
+}
+
+// readChunk returns the values of the column in a row group.
+func (f *parquetField) readChunk(r io.ReaderAt, chunk thriftFields, rows int) ([]interface{}, error) {
+	meta := chunk.fields(3)
+	start := meta.int(9)
+	if off := meta.int(11); off > 0 && off < start {
+		start = off
+	}
+	size := meta.int(7)
+	if size < 0 || size > math.MaxInt32 {
+		return nil, errParquet
+	}
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, start); err != nil {
+		return nil, err
+	}
+	BytesRead.Add(size)
+
+	codec := meta.int(4)
+	values := make([]interface{}, 0, rows)
+	var dict []interface{}
+	for len(values) < rows && len(data) > 0 {
+		tr := &thriftReader{b: data}
+		h, err := tr.readStruct()
+		if err != nil {
+			return nil, err
+		}
+		data = data[tr.pos:]
+		n := h.int(3)
+		if n < 0 || n > int64(len(data)) {
+			return nil, errParquet
+		}
+		page := data[:n]
+		data = data[n:]
+
+		switch h.int(1) {
+		case parquetDictionaryPage:
+			b, err := decompress(codec, page, h.int(2))
+			if err != nil {
+				return nil, err
+			}
+			if dict, err = f.plainValues(b, int(h.fields(7).int(1))); err != nil {
+				return nil, err
+			}
+		case parquetDataPage:
+			b, err := decompress(codec, page, h.int(2))
+			if err != nil {
+				return nil, err
+			}
+			dh := h.fields(5)
+			count := int(dh.int(1))
+			var levels []uint32
+			if f.optional {
+				if len(b) < 4 || int(binary.LittleEndian.Uint32(b)) > len(b)-4 {
+					return nil, errParquet
+				}
+				n := int(binary.LittleEndian.Uint32(b))
+				if levels, err = readHybrid(b[4:4+n], 1, count); err != nil {
+					return nil, err
+				}
+				b = b[4+n:]
+			}
+			decoded, err := f.pageValues(b, dh.int(2), levels, count, dict)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, decoded...)
+		case parquetDataPageV2:
+			dh := h.fields(8)
+			count := int(dh.int(1))
+			reps, defs := dh.int(6), dh.int(5)
+			if reps < 0 || defs < 0 || reps+defs > int64(len(page)) {
+				return nil, errParquet
+			}
+			b := page[reps+defs:]
+			if compressed, ok := dh.bool(7); !ok || compressed {
+				if b, err = decompress(codec, b, h.int(2)-reps-defs); err != nil {
+					return nil, err
+				}
+			}
+			var levels []uint32
+			if f.optional {
+				if levels, err = readHybrid(page[reps:reps+defs], 1, count); err != nil {
+					return nil, err
+				}
+			}
+			decoded, err := f.pageValues(b, dh.int(4), levels, count, dict)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, decoded...)
+		}
+	}
+	if len(values) != rows {
+		return nil, fmt.Errorf("column %s has %d values in a row group of %d rows", f.name, len(values), rows)
+	}
+	return values, nil
+}
+
+// pageValues decodes the values of a data page, which are NULL where their
+// definition level is 0.
+func (f *parquetField) pageValues(b []byte, encoding int64, levels []uint32, count int, dict []interface{}) ([]interface{}, error) {
+	n := count
+	if levels != nil {
+		n = 0
+		for _, l := range levels {
+			n += int(l)
+		}
+	}
+
+	var values []interface{}
+	switch encoding {
+	case parquetPlain:
+		var err error
+		if values, err = f.plainValues(b, n); err != nil {
+			return nil, err
+		}
+	case parquetPlainDictionary, parquetRLEDictionary:
+		if dict == nil && n > 0 {
+			return nil, fmt.Errorf("column %s has no dictionary", f.name)
+		}
+		if len(b) < 1 && n > 0 {
+			return nil, errParquet
+		}
+		var indexes []uint32
+		if n > 0 {
+			var err error
+			if indexes, err = readHybrid(b[1:], uint(b[0]), n); err != nil {
+				return nil, err
+			}
+		}
+		for _, i := range indexes {
+			if int(i) >= len(dict) {
+				return nil, errParquet
+			}
+			values = append(values, dict[i])
+		}
+	case parquetRLE:
+		if f.physical != parquetBoolean || len(b) < 4 {
+			return nil, fmt.Errorf("encoding %d of column %s not supported", encoding, f.name)
+		}
+		bits, err := readHybrid(b[4:], 1, n)
+		if err != nil {
+			return nil, err
+		}
+		for _, bit := range bits {
+			values = append(values, bit == 1)
+		}
+	default:
+		return nil, fmt.Errorf("encoding %d of column %s not supported", encoding, f.name)
+	}
+
+	if levels == nil {
+		return values, nil
+	}
+	page := make([]interface{}, count)
+	j := 0
+	for i, l := range levels {
+		if l == 1 {
+			page[i] = values[j]
+			j++
+		}
+	}
+	return page, nil
+}
+
+// readHybrid decodes n values of the given bit width written with the RLE and
+// bit packing hybrid encoding.
+func readHybrid(b []byte, width uint, n int) ([]uint32, error) {
+	if width > 32 {
+		return nil, errParquet
+	}
+	values := make([]uint32, 0, n)
+	size := int(width+7) / 8
+	for len(values) < n {
+		h, k := binary.Uvarint(b)
+		if k <= 0 {
+			return nil, errParquet
+		}
+		b = b[k:]
+		if h&1 == 0 {
+			// A run of the same value.
+			if len(b) < size {
+				return nil, errParquet
+			}
+			var v uint32
+			for i := 0; i < size; i++ {
+				v |= uint32(b[i]) << (8 * uint(i))
+			}
+			b = b[size:]
+			for i := uint64(0); i < h>>1 && len(values) < n; i++ {
+				values = append(values, v)
+			}
+			continue
+		}
+		// Groups of 8 bit packed values.
+		packed := int(h>>1) * int(width)
+		if packed < 0 || len(b) < packed {
+			return nil, errParquet
+		}
+		for i := 0; i < int(h>>1)*8 && len(values) < n; i++ {
+			var v uint32
+			for j := uint(0); j < width; j++ {
+				bit := uint(i)*width + j
+				v |= uint32(b[bit/8]>>(bit%8)&1) << j
+			}
+			values = append(values, v)
+		}
+		b = b[packed:]
+	}
+	return values, nil
+}
+
+func decompress(codec int64, b []byte, size int64) ([]byte, error) {
+	switch codec {
+	case 0:
+		return b, nil
+	case 1:
+		return snappy.Decode(nil, b)
+	case 2:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	case 6:
+		return decodeZstd(b, size)
+	}
+	if name, ok := parquetCodecs[codec]; ok {
+		return nil, fmt.Errorf("%s compression not supported", name)
+	}
+	return nil, fmt.Errorf("unknown compression %d", codec)
+}
+
+// parquetSchema returns the columns of a table made of Parquet files, which
+// must all have the same ones.
+func parquetSchema(name string, paths []string) ([]*sql.Column, error) {
+	var schema []*sql.Column
+	for i, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not open %s: %v", path, err)
+		}
+		meta, err := readParquetMeta(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %v", path, err)
+		}
+		if i > 0 && !sameParquetFields(schema, meta.fields) {
+			return nil, fmt.Errorf("could not add %s to table %s: its columns differ from the ones of %s", path, name, paths[0])
+		}
+		if i > 0 {
+			continue
+		}
+		for _, field := range meta.fields {
+			schema = append(schema, &sql.Column{
+				Name:     field.name,
+				Type:     field.typ,
+				Nullable: field.optional,
+				Source:   name,
+			})
+		}
+	}
+	return schema, nil
+}
+
+func sameParquetFields(schema []*sql.Column, fields []*parquetField) bool {
+	if len(schema) < len(fields) {
+		return false
+	}
+	for i, f := range fields {
+		if schema[i].Name != f.name || schema[i].Type != f.typ {
+			return false
+		}
+	}
+	return true
+}
+
+// WithProjection returns a copy of the table that only reads the given
+// columns. The rest are NULL in the rows returned.
+func (t *table) WithProjection(cols []string) sql.Table {
+	c := *t
+	c.projection = append([]string{}, cols...)
+	return &c
+}
+
+func (t *table) Projection() []string { return t.projection }
+
+// HandledFilters returns the filters that the table applies to its rows,
+// which are all of them for the Parquet tables, since they skip the row
+// groups whose statistics show that no row passes them, and the ones on the
+// partition keys of partitioned tables, which skip the files of the
+// partitions instead.
+func (t *table) HandledFilters(filters []sql.Expression) []sql.Expression {
+	if t.dialect.Format == "parquet" {
+		return filters
+	}
+	var handled []sql.Expression
+	for _, f := range filters {
+		if t.partitionFilter(f) {
+			handled = append(handled, f)
+		}
+	}
+	return handled
+}
+
+// WithFilters returns a copy of the table that only returns the rows passing
+// the given filters, as well as the ones it already had.
+func (t *table) WithFilters(filters []sql.Expression) sql.Table {
+	c := *t
+	c.filters = append(t.filters[:len(t.filters):len(t.filters)], filters...)
+	return &c
+}
+
+func (t *table) Filters() []sql.Expression { return t.filters }
+
+// matches reports whether a row passes the filters of the table.
+func (t *table) matches(ctx *sql.Context, row sql.Row) (bool, error) {
+	for _, f := range t.filters {
+		v, err := f.Eval(ctx, row)
+		if err != nil || v != true {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// skipRowGroup reports whether the statistics of the columns in a row group
+// show that none of its rows pass the filters of the table.
+func (t *table) skipRowGroup(ctx *sql.Context, meta *parquetMeta, group thriftFields) bool {
+	rows := group.int(3)
+	for _, filter := range t.filters {
+		var (
+			field *expression.GetField
+			lit   *expression.Literal
+			left  bool
+			null  int
+		)
+		switch e := filter.(type) {
+		case *expression.IsNull:
+			field, null = parquetFilterField(e.Child), 1
+		case *expression.Not:
+			if isNull, ok := e.Child.(*expression.IsNull); ok {
+				field, null = parquetFilterField(isNull.Child), -1
+			}
+		case *expression.Equals, *expression.GreaterThan, *expression.GreaterThanOrEqual,
+			*expression.LessThan, *expression.LessThanOrEqual:
+			c := e.(expression.Comparer)
+			if field, left = c.Left().(*expression.GetField); left {
+				lit, _ = c.Right().(*expression.Literal)
+			} else {
+				field, _ = c.Right().(*expression.GetField)
+				lit, _ = c.Left().(*expression.Literal)
+			}
+		}
+		if field == nil || field.Index() >= len(meta.fields) {
+			continue
+		}
+		f := meta.fields[field.Index()]
+		stats := group.list(1)[field.Index()].(thriftFields).fields(3).fields(12)
+		if stats == nil {
+			continue
+		}
+
+		nulls, hasNulls := stats[3].(int64)
+		switch {
+		case null == 1 && hasNulls && nulls == 0:
+			return true
+		case null == -1 && hasNulls && nulls == rows:
+			return true
+		case lit == nil:
+			continue
+		case hasNulls && nulls == rows:
+			// Comparisons with NULL are never true.
+			return true
+		}
+
+		min, max, ok := f.bounds(stats)
+		if !ok || !comparableBounds(f.typ, lit.Type()) {
+			continue
+		}
+		row := make(sql.Row, len(t.schema))
+		possible := func(e sql.Expression, v interface{}) bool {
+			row[field.Index()] = v
+			res, err := e.Eval(ctx, row)
+			return err != nil || res != false
+		}
+		switch filter.(type) {
+		case *expression.Equals:
+			if !possible(expression.NewGreaterThanOrEqual(field, lit), max) ||
+				!possible(expression.NewLessThanOrEqual(field, lit), min) {
+				return true
+			}
+		case *expression.GreaterThan, *expression.GreaterThanOrEqual:
+			if left && !possible(filter, max) || !left && !possible(filter, min) {
+				return true
+			}
+		default:
+			if left && !possible(filter, min) || !left && !possible(filter, max) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parquetFilterField(e sql.Expression) *expression.GetField {
+	f, _ := e.(*expression.GetField)
+	return f
+}
+
+// bounds returns the minimum and maximum values of a column in a row group,
+// given its statistics.
+func (f *parquetField) bounds(stats thriftFields) (min, max interface{}, ok bool) {
+	lo, hi := stats.bytes(6), stats.bytes(5)
+	if lo == nil || hi == nil {
+		// The deprecated statistics are only sorted correctly for signed
+		// numbers.
+		switch f.typ {
+		case sql.Int32, sql.Int64, sql.Float32, sql.Float64, sql.Date, sql.Timestamp:
+		default:
+			return nil, nil, false
+		}
+		lo, hi = stats.bytes(2), stats.bytes(1)
+	}
+	if lo == nil || hi == nil || f.physical == parquetInt96 || f.physical == parquetBoolean {
+		return nil, nil, false
+	}
+	min, ok = f.statValue(lo)
+	if !ok {
+		return nil, nil, false
+	}
+	max, ok = f.statValue(hi)
+	return min, max, ok
+}
+
+func (f *parquetField) statValue(b []byte) (interface{}, bool) {
+	switch f.physical {
+	case parquetByteArray, parquetFixedLenByteArray:
+		return f.convert(b), true
+	}
+	values, err := f.plainValues(b, 1)
+	if err != nil {
+		return nil, false
+	}
+	switch v := values[0].(type) {
+	case float32:
+		return v, !math.IsNaN(float64(v))
+	case float64:
+		return v, !math.IsNaN(v)
+	}
+	return values[0], true
+}
+
+// comparableBounds reports whether comparing the values of a column with a
+// literal keeps their order, so that the values between the bounds of a row
+// group compare between the bounds too.
+func comparableBounds(column, literal sql.Type) bool {
+	switch {
+	case sql.IsNumber(column):
+		return sql.IsNumber(literal)
+	case column == sql.Text:
+		return sql.IsText(literal)
+	case column == sql.Date || column == sql.Timestamp:
+		return sql.IsText(literal) || literal == sql.Date || literal == sql.Timestamp
+	}
+	return false
+}
+
+// newParquetRowIter returns an iterator over the rows of a Parquet file
+// belonging to the table.
+func newParquetRowIter(ctx *sql.Context, t *table, path string) (sql.RowIter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := readParquetMeta(f)
+	if err == nil && !sameParquetFields(t.schema, meta.fields) {
+		err = fmt.Errorf("the columns of %s changed", path)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+	var src *rowSource
+	if len(t.pseudo) > 0 {
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		src = &rowSource{path: path, info: info}
+	}
+
+	// Only the projected columns and the ones the filters use are read.
+	read := make([]bool, len(meta.fields))
+	for i, field := range meta.fields {
+		read[i] = t.projection == nil
+		for _, col := range t.projection {
+			if col == field.name {
+				read[i] = true
+			}
+		}
+	}
+	for _, filter := range t.filters {
+		expression.Inspect(filter, func(e sql.Expression) bool {
+			if f, ok := e.(*expression.GetField); ok && f.Index() < len(read) {
+				read[f.Index()] = true
+			}
+			return true
+		})
+	}
+
+	return &parquetRowIter{
+		ctx:    ctx,
+		t:      t,
+		f:      f,
+		meta:   meta,
+		read:   read,
+		values: make([][]interface{}, len(meta.fields)),
+		src:    src,
+	}, nil
+}
+
+type parquetRowIter struct {
+	ctx  *sql.Context
+	t    *table
+	f    *os.File
+	meta *parquetMeta
+	read []bool
+	// group is the next row group to read, and values the ones of each
+	// column in the current one, which has rows rows.
+	group  int
+	values [][]interface{}
+	row    int
+	rows   int
+	// before is the number of rows in the row groups before the current one.
+	before int64
+	src    *rowSource
+	slab   []interface{}
+}
+
+func (r *parquetRowIter) Next() (sql.Row, error) {
+	for {
+		if r.row == r.rows {
+			if err := r.nextGroup(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		n := len(r.t.schema)
+		if len(r.slab) < n {
+			r.slab = make([]interface{}, n*rowSlab)
+		}
+		row := sql.Row(r.slab[:n:n])
+		r.slab = r.slab[n:]
+		for i, values := range r.values {
+			if values != nil {
+				row[i] = values[r.row]
+			}
+		}
+		if r.src != nil {
+			r.src.line = r.before + int64(r.row) + 1
+		}
+		for i, col := range r.t.pseudo {
+			row[len(r.values)+i] = col.value(r.src)
+		}
+		r.row++
+		RowsRead.Add(1)
+
+		ok, err := r.t.matches(r.ctx, row)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return row, nil
+		}
+	}
+}
+
+// nextGroup reads the next row group that can have rows passing the filters.
+func (r *parquetRowIter) nextGroup() error {
+	r.before += int64(r.rows)
+	r.rows = 0
+	for r.group < len(r.meta.groups) {
+		g := r.meta.groups[r.group]
+		r.group++
+		rows := int(g.int(3))
+		if rows <= 0 {
+			continue
+		}
+		if r.t.skipRowGroup(r.ctx, r.meta, g) {
+			r.before += int64(rows)
+			continue
+		}
+		for i, f := range r.meta.fields {
+			r.values[i] = nil
+			if !r.read[i] {
+				continue
+			}
+			values, err := f.readChunk(r.f, g.list(1)[i].(thriftFields), rows)
+			if err != nil {
+				return fmt.Errorf("could not read %s: %v", r.f.Name(), err)
+			}
+			r.values[i] = values
+		}
+		r.row, r.rows = 0, rows
+		return nil
+	}
+	return io.EOF
+}
+
+func (r *parquetRowIter) Close() error { return r.f.Close() }
+
+// thriftFields are the fields of a struct decoded by thriftReader, by their
+// ids. Integers are decoded as int64, binaries as []byte, lists and sets as
+// []interface{}, and maps are skipped.
+type thriftFields map[int16]interface{}
+
+func (f thriftFields) int(id int16) int64          { v, _ := f[id].(int64); return v }
+func (f thriftFields) bytes(id int16) []byte       { v, _ := f[id].([]byte); return v }
+func (f thriftFields) list(id int16) []interface{} { v, _ := f[id].([]interface{}); return v }
+func (f thriftFields) fields(id int16) thriftFields {
+	v, _ := f[id].(thriftFields)
+	return v
+}
+
+func (f thriftFields) bool(id int16) (v, ok bool) {
+	v, ok = f[id].(bool)
+	return v, ok
+}
+
+// thriftReader decodes structs encoded with the Thrift compact protocol.
+type thriftReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.pos >= len(r.b) {
+		return 0, errParquet
+	}
+	r.pos++
+	return r.b[r.pos-1], nil
+}
+
+func (r *thriftReader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		return 0, errParquet
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *thriftReader) varint() (int64, error) {
+	v, err := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (r *thriftReader) readStruct() (thriftFields, error) {
+	fields := make(thriftFields)
+	var id int16
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if b == thriftStop {
+			return fields, nil
+		}
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+
+		switch typ := b & 0x0f; typ {
+		case thriftBoolTrue, thriftBoolFalse:
+			fields[id] = typ == thriftBoolTrue
+		default:
+			if fields[id], err = r.value(typ); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (r *thriftReader) value(typ byte) (interface{}, error) {
+	switch typ {
+	case thriftBoolTrue, thriftBoolFalse:
+		// Booleans in lists take a byte each.
+		b, err := r.byte()
+		return b == thriftBoolTrue, err
+	case thriftByte:
+		b, err := r.byte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		return r.varint()
+	case thriftDouble:
+		if len(r.b)-r.pos < 8 {
+			return nil, errParquet
+		}
+		r.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(r.b[r.pos-8:])), nil
+	case thriftBinary:
+		n, err := r.uvarint()
+		if err != nil || n > uint64(len(r.b)-r.pos) {
+			return nil, errParquet
+		}
+		r.pos += int(n)
+		return r.b[r.pos-int(n) : r.pos], nil
+	case thriftList, thriftSet:
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(b >> 4)
+		if n == 15 {
+			if n, err = r.uvarint(); err != nil {
+				return nil, err
+			}
+		}
+		// Every element takes a byte at least.
+		if n > uint64(len(r.b)-r.pos) {
+			return nil, errParquet
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = r.value(b & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	case thriftMap:
+		n, err := r.uvarint()
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		types, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := r.value(types >> 4); err != nil {
+				return nil, err
+			}
+			if _, err := r.value(types & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case thriftStruct:
+		return r.readStruct()
+	}
+	return nil, errParquet
+}
@@ -0,0 +1,226 @@
+package csvql
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/expression"
+)
+
+// hiveDefaultPartition is the value Hive and Spark give to the partitions of
+// the rows whose keys are NULL.
+const hiveDefaultPartition = "__HIVE_DEFAULT_PARTITION__"
+
+// partitionDir returns the key and value of a partition directory, as in
+// date=2024-01-01, whose value can be escaped as in URLs.
+func partitionDir(name string) (key, value string, ok bool) {
+	eq := strings.Index(name, "=")
+	if eq <= 0 {
+		return "", "", false
+	}
+	key, value = strings.ToLower(name[:eq]), name[eq+1:]
+	if v, err := url.PathUnescape(value); err == nil {
+		value = v
+	}
+	return key, value, true
+}
+
+// isPartitioned reports whether a folder holds the files of a table
+// partitioned as in Hive, with a subfolder per value of its partition keys,
+// as in orders/date=2024-01-01/part-0.csv.
+func isPartitioned(dir string) bool {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, fi := range fis {
+		if _, _, ok := partitionDir(fi.Name()); ok && fi.IsDir() && !skippedFile(fi.Name()) {
+			return true
+		}
+	}
+	return false
+}
+
+// skippedFile reports whether a file in a partitioned folder is ignored, as
+// the hidden ones and the markers such as _SUCCESS are.
+func skippedFile(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+// partitionedFiles are the files of a partitioned table, in the order of
+// their paths, with the names of its partition keys and their values in each
+// file.
+type partitionedFiles struct {
+	paths  []string
+	keys   []string
+	values map[string][]interface{}
+}
+
+// readPartitions finds the files in a partitioned folder and its partitions,
+// which must all have the same keys. Files without the extension of a format
+// are skipped, unless format is set.
+func readPartitions(dir, format string) (*partitionedFiles, error) {
+	p := &partitionedFiles{values: make(map[string][]interface{})}
+	var first string
+	var walk func(dir string, keys []string, values []interface{}) error
+	walk = func(dir string, keys []string, values []interface{}) error {
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("could not read directory %s: %v", dir, err)
+		}
+		for _, fi := range fis {
+			path := filepath.Join(dir, fi.Name())
+			if skippedFile(fi.Name()) {
+				continue
+			}
+			if fi.IsDir() {
+				key, value, ok := partitionDir(fi.Name())
+				if !ok {
+					return fmt.Errorf("could not read partition %s: expected a name such as key=value", path)
+				}
+				var v interface{} = value
+				if value == hiveDefaultPartition {
+					v = nil
+				}
+				err := walk(path, append(keys[:len(keys):len(keys)], key), append(values[:len(values):len(values)], v))
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if _, f := splitFormat(fi.Name()); f == "" && format == "" && !hasLayout(path) {
+				continue
+			}
+			if first == "" {
+				first, p.keys = path, keys
+			} else if strings.Join(keys, "/") != strings.Join(p.keys, "/") {
+				return fmt.Errorf("could not add %s: its partition keys differ from the ones of %s", path, first)
+			}
+			p.paths = append(p.paths, path)
+			p.values[path] = values
+		}
+		return nil
+	}
+	if err := walk(dir, nil, nil); err != nil {
+		return nil, err
+	}
+	if len(p.paths) == 0 {
+		return nil, fmt.Errorf("no files in %s", dir)
+	}
+	return p, nil
+}
+
+// partitionFiles returns a function finding the files in a partitioned
+// folder, as readPartitions does.
+func partitionFiles(dir, format string) func() ([]string, error) {
+	return func() ([]string, error) {
+		p, err := readPartitions(dir, format)
+		if err != nil {
+			return nil, err
+		}
+		return p.paths, nil
+	}
+}
+
+// newPartitionedTable returns the table of the files in a partitioned folder,
+// read with the given options as in AddTable, whose partition keys are
+// columns after the ones in the files.
+func newPartitionedTable(name, dir string, opts map[string]string) (*table, error) {
+	p, err := readPartitions(dir, opts["format"])
+	if err != nil {
+		return nil, err
+	}
+	_, format := splitFormat(p.paths[0])
+	for _, path := range p.paths[1:] {
+		if _, f := splitFormat(path); f != format && opts["format"] == "" {
+			return nil, fmt.Errorf("could not add %s to table %s: its format differs from the one of %s", path, name, p.paths[0])
+		}
+	}
+	d, err := fileDialect(p.paths[0], opts)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newFileTable(name, d, p.paths...)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range p.keys {
+		if sql.Schema(t.schema).Contains(key, name) {
+			return nil, fmt.Errorf("partition key %s is also a column of the files", key)
+		}
+		i := i
+		t.schema = append(t.schema, &sql.Column{Name: key, Type: sql.Text, Nullable: true, Source: name})
+		t.pseudo = append(t.pseudo, pseudoColumn{key, sql.Text, func(src *rowSource) interface{} {
+			return p.values[src.path][i]
+		}})
+	}
+	t.partitions = p
+	return t, nil
+}
+
+// partitionFilter reports whether a filter only uses the partition keys of
+// the table, so it gives the same result for all the rows of a file. The
+// filters pushed down to a table only use its own columns.
+func (t *table) partitionFilter(filter sql.Expression) bool {
+	if t.partitions == nil {
+		return false
+	}
+	fields := 0
+	ok := true
+	expression.Inspect(filter, func(e sql.Expression) bool {
+		f, isField := e.(*expression.GetField)
+		if !isField {
+			return true
+		}
+		fields++
+		if !t.partitionKey(f.Name()) {
+			ok = false
+		}
+		return true
+	})
+	return ok && fields > 0
+}
+
+func (t *table) partitionKey(name string) bool {
+	for _, key := range t.partitions.keys {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// prunedFiles reports which files of the table have no rows passing its
+// filters on the partition keys, so they are not read.
+func (t *table) prunedFiles(ctx *sql.Context) ([]bool, error) {
+	var filters []sql.Expression
+	for _, f := range t.filters {
+		if t.partitionFilter(f) {
+			filters = append(filters, f)
+		}
+	}
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	pruned := make([]bool, len(t.files))
+	row := make(sql.Row, len(t.schema))
+	first := len(t.schema) - len(t.pseudo)
+	for i, path := range t.files {
+		copy(row[first:], t.partitions.values[path])
+		for _, f := range filters {
+			v, err := f.Eval(ctx, row)
+			if err != nil {
+				return nil, err
+			}
+			if v != true {
+				pruned[i] = true
+				break
+			}
+		}
+	}
+	return pruned, nil
+}
@@ -0,0 +1,190 @@
+package csvql
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/analyzer"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/expression"
+	"gopkg.in/src-d/go-vitess.v0/sqltypes"
+)
+
+// paramFunction is the function the placeholders of prepared statements are
+// replaced by when they are executed, called with the position of each one,
+// which the bind_parameters rule replaces by the value of its parameter.
+const paramFunction = "csvql_param"
+
+// executions are the values of the parameters of the prepared statements
+// being executed, by the id of their connections. They are set by the
+// connections, which then pass the statements to the handler to run.
+type executions struct {
+	mu     sync.Mutex
+	params map[uint32][]sqltypes.Value
+}
+
+func (e *executions) set(id uint32, params []sqltypes.Value) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.params == nil {
+		e.params = make(map[uint32][]sqltypes.Value)
+	}
+	e.params[id] = params
+}
+
+// take returns the values of the parameters of the statement the connection
+// is executing, if it is a prepared one, forgetting them.
+func (e *executions) take(id uint32) ([]sqltypes.Value, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	params, ok := e.params[id]
+	delete(e.params, id)
+	return params, ok
+}
+
+type paramsKey struct{}
+
+// withParams returns a context whose statement binds its placeholders to the
+// values of the given parameters.
+func withParams(ctx *sql.Context, params []sqltypes.Value) *sql.Context {
+	nc := *ctx
+	nc.Context = context.WithValue(ctx.Context, paramsKey{}, params)
+	return &nc
+}
+
+// paramCalls replaces the placeholders of a prepared statement by the calls
+// to paramFunction bound to its parameters.
+func paramCalls(q string) string {
+	parts := splitPlaceholders(q)
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for i, part := range parts[1:] {
+		fmt.Fprintf(&b, "%s(%d)", paramFunction, i)
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+// bindParameters is an analyzer rule replacing the placeholders of prepared
+// statements by literals of the values of their parameters, so the values
+// are never part of the text of the statements.
+func bindParameters(ctx *sql.Context, a *analyzer.Analyzer, n sql.Node) (sql.Node, error) {
+	params, ok := ctx.Value(paramsKey{}).([]sqltypes.Value)
+	if !ok {
+		return n, nil
+	}
+	return n.TransformUp(func(n sql.Node) (sql.Node, error) {
+		return n.TransformExpressionsUp(func(e sql.Expression) (sql.Expression, error) {
+			f, ok := e.(*expression.UnresolvedFunction)
+			if !ok || f.Name() != paramFunction || len(f.Arguments) != 1 {
+				return e, nil
+			}
+			l, ok := f.Arguments[0].(*expression.Literal)
+			if !ok {
+				return e, nil
+			}
+			i, err := sql.Int64.Convert(l.Value())
+			if err != nil || i.(int64) < 0 || i.(int64) >= int64(len(params)) {
+				return nil, fmt.Errorf("could not execute statement: it has no parameter %v", l.Value())
+			}
+			return paramLiteral(params[i.(int64)])
+		})
+	})
+}
+
+// paramLiteral returns the literal of the value of a parameter, typed as its
+// value is.
+func paramLiteral(v sqltypes.Value) (sql.Expression, error) {
+	s := v.ToString()
+	var value interface{}
+	var typ sql.Type
+	var err error
+	switch {
+	case v.IsNull():
+		return expression.NewLiteral(nil, sql.Null), nil
+	case v.IsSigned():
+		value, err = strconv.ParseInt(s, 10, 64)
+		typ = sql.Int64
+	case v.IsUnsigned():
+		value, err = strconv.ParseUint(s, 10, 64)
+		typ = sql.Uint64
+	case v.IsFloat():
+		value, err = strconv.ParseFloat(s, 64)
+		typ = sql.Float64
+	case v.Type() == sqltypes.Decimal:
+		if typ, err = literalDecimalType(s); err == nil {
+			value, err = typ.Convert(s)
+		}
+	case v.Type() == sqltypes.Date:
+		typ = sql.Date
+		value, err = typ.Convert(s)
+	case v.Type() == sqltypes.Datetime, v.Type() == sqltypes.Timestamp:
+		typ = sql.Timestamp
+		value, err = typ.Convert(s)
+	case v.IsBinary():
+		value, typ = v.ToBytes(), sql.Blob
+	default:
+		value, typ = s, sql.Text
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not bind parameter %q: %v", s, err)
+	}
+	return expression.NewLiteral(value, typ), nil
+}
+
+// literalDecimalType returns the type of a decimal literal, with as many
+// digits as it is written with.
+func literalDecimalType(s string) (sql.Type, error) {
+	digits := strings.TrimLeft(strings.TrimLeft(s, "+-"), "0")
+	scale := 0
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		scale = len(digits) - i - 1
+		digits = digits[:i] + digits[i+1:]
+	}
+	precision := len(digits)
+	if precision == 0 {
+		precision = 1
+	}
+	return Decimal(precision, scale)
+}
+
+// splitPlaceholders splits a statement at its ? placeholders, which are the
+// question marks outside of string literals, quoted identifiers and
+// comments.
+func splitPlaceholders(q string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(q); i++ {
+		switch c := q[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			// Skip to the closing quote. Doubled quotes are read as two
+			// literals next to each other, which is the same here.
+			j := i + 1
+			for ; j < len(q) && q[j] != c; j++ {
+				if c != '`' && q[j] == '\\' {
+					j++
+				}
+			}
+			i = j
+		case c == '#' || c == '-' && strings.HasPrefix(q[i:], "-- "):
+			if j := strings.IndexByte(q[i:], '\n'); j >= 0 {
+				i += j
+			} else {
+				i = len(q)
+			}
+		case c == '/' && strings.HasPrefix(q[i:], "/*"):
+			if j := strings.Index(q[i+2:], "*/"); j >= 0 {
+				i += j + 3
+			} else {
+				i = len(q)
+			}
+		case c == '?':
+			parts = append(parts, q[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, q[start:])
+}
@@ -0,0 +1,263 @@
+package csvql
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+
+	"gopkg.in/src-d/go-mysql-server.v0/server"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-vitess.v0/mysql"
+	"gopkg.in/src-d/go-vitess.v0/sqltypes"
+)
+
+func TestSplitPlaceholders(t *testing.T) {
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"SELECT * FROM t", 0},
+		{"SELECT * FROM t WHERE a = ? AND b > ?", 2},
+		{"SELECT '?', \"?\", `?` FROM t WHERE a = ?", 1},
+		{`SELECT 'it\'s ?' FROM t WHERE a = ?`, 1},
+		{"SELECT a -- why?\nFROM t # really?\nWHERE /* ? */ a = ?", 1},
+	}
+	for _, tt := range tests {
+		if got := len(splitPlaceholders(tt.query)) - 1; got != tt.want {
+			t.Errorf("%q has %d placeholders, want %d", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestBindParameters(t *testing.T) {
+	e, _ := testEngine(t, map[string]string{"people.csv": "name,age\nalice,30\nbob,25\ncarol,41\nit's,1\n"})
+	testCases := []struct {
+		query  string
+		params []sqltypes.Value
+		want   []sql.Row
+	}{
+		{
+			"SELECT name FROM people WHERE age > ? AND name <> ? ORDER BY name",
+			[]sqltypes.Value{sqltypes.NewInt64(26), sqltypes.NewVarChar("carol")},
+			[]sql.Row{{"alice"}},
+		},
+		{
+			// Values are never part of the text of the statement.
+			"SELECT name FROM people WHERE name = ?",
+			[]sqltypes.Value{sqltypes.NewVarChar("x' OR 'a' = 'a")},
+			nil,
+		},
+		{
+			"SELECT name FROM people WHERE name = ? AND age = ?",
+			[]sqltypes.Value{sqltypes.NewVarChar("it's"), sqltypes.MakeTrusted(sqltypes.Decimal, []byte("1.0"))},
+			[]sql.Row{{"it's"}},
+		},
+		{
+			"SELECT ?, ?, '?'",
+			[]sqltypes.Value{sqltypes.NULL, sqltypes.NewFloat64(1.5)},
+			[]sql.Row{{nil, 1.5, "?"}},
+		},
+	}
+	for _, tc := range testCases {
+		_, rows, err := e.Query(withParams(sql.NewEmptyContext(), tc.params), paramCalls(tc.query))
+		if err != nil {
+			t.Errorf("%s: %v", tc.query, err)
+			continue
+		}
+		got, err := sql.RowIterToRows(rows)
+		if err != nil {
+			t.Errorf("%s: %v", tc.query, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.query, got, tc.want)
+		}
+	}
+
+	if _, _, err := e.Query(sql.NewEmptyContext(), paramCalls("SELECT ?")); err == nil {
+		t.Error("a statement with placeholders ran without parameters")
+	}
+}
+
+// testClient is a client of the MySQL protocol speaking to a csvql server.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialTest connects to the server at addr, with the capability to end
+// results with OK packets if deprecateEOF is set.
+func dialTest(t *testing.T, addr string, deprecateEOF bool) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &testClient{t, conn, bufio.NewReader(conn)}
+	c.read()
+
+	flags := uint32(mysql.CapabilityClientProtocol41 | mysql.CapabilityClientSecureConnection | mysql.CapabilityClientPluginAuth)
+	if deprecateEOF {
+		flags |= mysql.CapabilityClientDeprecateEOF
+	}
+	b := make([]byte, 32)
+	binary.LittleEndian.PutUint32(b, flags)
+	b[8] = 33
+	b = append(b, "root\x00\x00mysql_native_password\x00"...)
+	c.write(1, b)
+	if p := c.read(); p[0] != mysql.OKPacket {
+		t.Fatalf("could not connect: %q", p)
+	}
+	return c
+}
+
+func (c *testClient) write(seq byte, payload []byte) {
+	c.t.Helper()
+	b, _ := appendPacket(nil, seq, payload)
+	if _, err := c.conn.Write(b); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) read() []byte {
+	c.t.Helper()
+	raw, err := readPacket(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	_, payload, _, _, _ := nextPacket(raw)
+	return payload
+}
+
+// prepare prepares a statement, returning its id and its number of
+// parameters.
+func (c *testClient) prepare(q string, deprecateEOF bool) (uint32, int) {
+	c.t.Helper()
+	c.write(0, append([]byte{comStmtPrepare}, q...))
+	p := c.read()
+	if p[0] != mysql.OKPacket {
+		c.t.Fatalf("could not prepare %s: %q", q, p)
+	}
+	params := int(binary.LittleEndian.Uint16(p[7:]))
+	for i := 0; i < params; i++ {
+		c.read()
+	}
+	if params > 0 && !deprecateEOF {
+		c.read()
+	}
+	return binary.LittleEndian.Uint32(p[1:]), params
+}
+
+// execute executes a prepared statement with parameters of the given types
+// and values, returning its rows, of BIGINT and text values, or the error
+// packet it fails with.
+func (c *testClient) execute(id uint32, types []byte, values []byte, deprecateEOF bool) ([][]interface{}, []byte) {
+	c.t.Helper()
+	b := []byte{comStmtExecute, 0, 0, 0, 0, 0, 1, 0, 0, 0}
+	binary.LittleEndian.PutUint32(b[1:], id)
+	if len(types) > 0 {
+		b = append(b, make([]byte, (len(types)+7)/8)...)
+		b = append(b, 1)
+		for _, typ := range types {
+			b = append(b, typ, 0)
+		}
+		b = append(b, values...)
+	}
+	c.write(0, b)
+
+	p := c.read()
+	if p[0] == mysql.ErrPacket {
+		return nil, p
+	}
+	var columns []bool
+	for i := 0; i < int(p[0]); i++ {
+		typ, err := definitionType(c.read())
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		columns = append(columns, typ == sqltypes.Int64)
+	}
+	if !deprecateEOF {
+		c.read()
+	}
+	var rows [][]interface{}
+	for {
+		p := c.read()
+		if p[0] == mysql.EOFPacket && len(p) < 9 {
+			return rows, nil
+		}
+		if p[0] != 0 {
+			c.t.Fatalf("got row %q, want one of the binary protocol", p)
+		}
+		pos := 1 + (len(columns)+7+2)/8
+		var row []interface{}
+		for i, integer := range columns {
+			switch {
+			case p[1+(i+2)/8]&(1<<uint((i+2)%8)) != 0:
+				row = append(row, nil)
+			case integer:
+				row = append(row, int64(binary.LittleEndian.Uint64(p[pos:])))
+				pos += 8
+			default:
+				n, next, _ := readLenEncInt(p, pos)
+				row = append(row, string(p[next:next+int(n)]))
+				pos = next + int(n)
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestPreparedStatements(t *testing.T) {
+	e, _ := testEngine(t, map[string]string{"people.csv": "name,age\nalice,30\nbob,25\ncarol,41\n"})
+	s, err := NewServer(server.Config{Protocol: "tcp", Address: "127.0.0.1:0", Auth: &mysql.AuthServerNone{}}, e, ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Close()
+
+	for _, deprecateEOF := range []bool{false, true} {
+		c := dialTest(t, s.Listener.Addr().String(), deprecateEOF)
+
+		id, params := c.prepare("SELECT name, age FROM people WHERE age > ? AND name <> ? ORDER BY name", deprecateEOF)
+		if params != 2 {
+			t.Errorf("got %d parameters, want 2", params)
+		}
+		values := []byte{26, 0, 0, 0, 0, 0, 0, 0, 5, 'c', 'a', 'r', 'o', 'l'}
+		for i := 0; i < 2; i++ {
+			rows, errPacket := c.execute(id, []byte{binaryTypeLongLong, binaryTypeVarString}, values, deprecateEOF)
+			if want := [][]interface{}{{"alice", int64(30)}}; !reflect.DeepEqual(rows, want) {
+				t.Errorf("execution %d: got rows %v and error %q, want %v", i, rows, errPacket, want)
+			}
+		}
+
+		// The values of the parameters are not part of the statement run.
+		id, _ = c.prepare("SELECT name FROM people WHERE name = ?", deprecateEOF)
+		injection := "x' OR 'a' = 'a"
+		rows, errPacket := c.execute(id, []byte{binaryTypeVarString}, append([]byte{byte(len(injection))}, injection...), deprecateEOF)
+		if rows != nil || errPacket != nil {
+			t.Errorf("got rows %v and error %q, want no rows", rows, errPacket)
+		}
+
+		if _, errPacket := c.execute(99, nil, nil, deprecateEOF); errPacket == nil || binary.LittleEndian.Uint16(errPacket[1:]) != erUnknownStmtHandler {
+			t.Errorf("executing an unknown statement got error %q", errPacket)
+		}
+		c.write(0, []byte{comStmtExecute, 1})
+		if p := c.read(); p[0] != mysql.ErrPacket || binary.LittleEndian.Uint16(p[1:]) != erMalformedPacket {
+			t.Errorf("executing a malformed packet got %q", p)
+		}
+
+		// Statements can be closed, and the other commands keep working.
+		c.write(0, []byte{comStmtClose, byte(id), 0, 0, 0})
+		if _, errPacket := c.execute(id, []byte{binaryTypeNull}, nil, deprecateEOF); errPacket == nil {
+			t.Error("executing a closed statement did not fail")
+		}
+		c.write(0, append([]byte{comQuery}, "SELECT COUNT(*) FROM people"...))
+		if p := c.read(); p[0] != 1 {
+			t.Errorf("got %q, want one column", p)
+		}
+	}
+}
@@ -0,0 +1,834 @@
+package csvql
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"gopkg.in/src-d/go-vitess.v0/mysql"
+	"gopkg.in/src-d/go-vitess.v0/sqltypes"
+	"gopkg.in/src-d/go-vitess.v0/vt/proto/query"
+)
+
+// The commands for prepared statements, which the MySQL server of go-vitess
+// does not implement, and the errors they fail with.
+const (
+	comQuery            = 0x03
+	comStmtPrepare      = 0x16
+	comStmtExecute      = 0x17
+	comStmtSendLongData = 0x18
+	comStmtClose        = 0x19
+	comStmtReset        = 0x1a
+
+	erUnknownStmtHandler = 1243
+	erMalformedPacket    = 1835
+)
+
+// maxPacketSize is the size of the largest packet of the protocol, longer
+// payloads being split in several.
+const maxPacketSize = 1<<24 - 1
+
+// The types of the values of the binary protocol, which are the ones of
+// column definitions.
+const (
+	binaryTypeDecimal    = 0x00
+	binaryTypeTiny       = 0x01
+	binaryTypeShort      = 0x02
+	binaryTypeLong       = 0x03
+	binaryTypeFloat      = 0x04
+	binaryTypeDouble     = 0x05
+	binaryTypeNull       = 0x06
+	binaryTypeTimestamp  = 0x07
+	binaryTypeLongLong   = 0x08
+	binaryTypeInt24      = 0x09
+	binaryTypeDate       = 0x0a
+	binaryTypeTime       = 0x0b
+	binaryTypeDatetime   = 0x0c
+	binaryTypeYear       = 0x0d
+	binaryTypeVarString  = 0xfd
+	binaryTypeNewDecimal = 0xf6
+
+	// binaryUnsigned is the flag of the types of unsigned parameters.
+	binaryUnsigned = 0x80
+)
+
+// stmtListener is a listener whose connections run prepared statements.
+type stmtListener struct {
+	net.Listener
+	executions *executions
+}
+
+func (l *stmtListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &stmtConn{Conn: c, r: bufio.NewReader(c), executions: l.executions}, nil
+}
+
+// stmtConn is the connection of a client which handles the commands for
+// prepared statements itself, passing the other packets to the server
+// reading from it. Prepared statements are executed by passing the server
+// COM_QUERY with their text, once the values of their parameters are kept
+// for the handler, and their results are translated to the binary protocol
+// as the server writes them.
+type stmtConn struct {
+	net.Conn
+	r          *bufio.Reader
+	executions *executions
+
+	// id is the id of the connection, sent by the server in its handshake,
+	// and deprecateEOF is set if the client answered it with the capability
+	// to end results with OK packets.
+	id           uint32
+	greeted      bool
+	answered     bool
+	deprecateEOF bool
+
+	// in are the bytes of the client that the server has not read yet, and
+	// out the ones of the server not written to the client yet, as they do
+	// not make a whole packet.
+	in, out []byte
+	// result is the result of the prepared statement being executed.
+	result *binaryResult
+
+	last       uint32
+	statements map[uint32]*preparedStatement
+}
+
+// preparedStatement is a statement prepared by a connection.
+type preparedStatement struct {
+	query  string
+	params int
+	// types are the types of the parameters, which clients only send on
+	// the first execution of the statement, or when they change.
+	types []uint16
+	// long are the values of the parameters sent with
+	// COM_STMT_SEND_LONG_DATA since the last execution.
+	long map[int][]byte
+}
+
+func (c *stmtConn) Read(p []byte) (int, error) {
+	for len(c.in) == 0 {
+		if err := c.receive(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.in)
+	c.in = c.in[n:]
+	return n, nil
+}
+
+// receive reads a packet of the client, keeping it for the server unless
+// it is a command for prepared statements.
+func (c *stmtConn) receive() error {
+	raw, err := readPacket(c.r)
+	if err != nil {
+		return err
+	}
+	_, payload, _, _, _ := nextPacket(raw)
+	switch {
+	case !c.answered:
+		// The first packet answers the handshake of the server, starting
+		// with the capabilities of the client.
+		c.answered = true
+		if len(payload) >= 4 {
+			c.deprecateEOF = binary.LittleEndian.Uint32(payload)&mysql.CapabilityClientDeprecateEOF != 0
+		}
+	case raw[3] == 0 && len(payload) > 0 && payload[0] >= comStmtPrepare && payload[0] <= comStmtReset:
+		// Commands are the packets starting a sequence.
+		return c.command(payload)
+	}
+	c.in = raw
+	return nil
+}
+
+// command runs a command for prepared statements.
+func (c *stmtConn) command(data []byte) error {
+	if c.statements == nil {
+		c.statements = make(map[uint32]*preparedStatement)
+	}
+	switch data[0] {
+	case comStmtPrepare:
+		q := string(data[1:])
+		c.last++
+		stmt := &preparedStatement{query: q, params: len(splitPlaceholders(q)) - 1}
+		c.statements[c.last] = stmt
+		return c.writePrepareOK(c.last, stmt.params)
+	case comStmtExecute:
+		stmt, params, err := c.parseExecute(data)
+		if err != nil {
+			return c.writeError(err)
+		}
+		c.executions.set(c.id, params)
+		c.result = &binaryResult{deprecateEOF: c.deprecateEOF}
+		c.in, _ = appendPacket(nil, 0, append([]byte{comQuery}, stmt.query...))
+	case comStmtSendLongData:
+		// There is no response to this command, nor to COM_STMT_CLOSE:
+		// errors are reported when the statement is executed.
+		if len(data) < 7 {
+			return nil
+		}
+		id, param := binary.LittleEndian.Uint32(data[1:]), int(binary.LittleEndian.Uint16(data[5:]))
+		if stmt := c.statements[id]; stmt != nil && param < stmt.params {
+			if stmt.long == nil {
+				stmt.long = make(map[int][]byte)
+			}
+			stmt.long[param] = append(stmt.long[param], data[7:]...)
+		}
+	case comStmtClose:
+		if len(data) >= 5 {
+			delete(c.statements, binary.LittleEndian.Uint32(data[1:]))
+		}
+	case comStmtReset:
+		var id uint32
+		if len(data) >= 5 {
+			id = binary.LittleEndian.Uint32(data[1:])
+		}
+		stmt := c.statements[id]
+		if stmt == nil {
+			return c.writeError(mysql.NewSQLError(erUnknownStmtHandler, mysql.SSUnknownSQLState, "unknown prepared statement handler (%d) given to mysqld_stmt_reset", id))
+		}
+		stmt.long = nil
+		return c.write([]byte{mysql.OKPacket, 0, 0, mysql.ServerStatusAutocommit, 0, 0, 0})
+	}
+	return nil
+}
+
+// write writes the packets of the response to a command, starting with the
+// given payload.
+func (c *stmtConn) write(payloads ...[]byte) error {
+	var b []byte
+	for i, p := range payloads {
+		b, _ = appendPacket(b, byte(i+1), p)
+	}
+	_, err := c.Conn.Write(b)
+	return err
+}
+
+func (c *stmtConn) writeError(err *mysql.SQLError) error {
+	b := []byte{mysql.ErrPacket, byte(err.Num), byte(err.Num >> 8), '#'}
+	b = append(b, err.State...)
+	return c.write(append(b, err.Message...))
+}
+
+// writePrepareOK writes the response to COM_STMT_PREPARE, with the
+// definitions of the parameters of the statement. The columns of its
+// results are only sent when it is executed.
+func (c *stmtConn) writePrepareOK(id uint32, params int) error {
+	ok := []byte{mysql.OKPacket, 0, 0, 0, 0, 0, 0, byte(params), byte(params >> 8), 0, 0, 0}
+	binary.LittleEndian.PutUint32(ok[1:], id)
+	payloads := [][]byte{ok}
+	if params > 0 {
+		for i := 0; i < params; i++ {
+			payloads = append(payloads, paramDefinition)
+		}
+		if !c.deprecateEOF {
+			payloads = append(payloads, []byte{mysql.EOFPacket, 0, 0, mysql.ServerStatusAutocommit, 0})
+		}
+	}
+	return c.write(payloads...)
+}
+
+// paramDefinition is the column definition of the parameters of prepared
+// statements, as binary strings named ?.
+var paramDefinition = []byte{
+	3, 'd', 'e', 'f', 0, 0, 0, 1, '?', 0,
+	0x0c, 63, 0, 0, 0, 0, 0, binaryTypeVarString, 0x80, 0, 0, 0, 0,
+}
+
+// parseExecute parses COM_STMT_EXECUTE, returning the statement to run and
+// the values of its parameters.
+func (c *stmtConn) parseExecute(data []byte) (*preparedStatement, []sqltypes.Value, *mysql.SQLError) {
+	malformed := mysql.NewSQLError(erMalformedPacket, mysql.SSUnknownSQLState, "malformed COM_STMT_EXECUTE packet")
+	if len(data) < 5 {
+		return nil, nil, malformed
+	}
+	id := binary.LittleEndian.Uint32(data[1:])
+	stmt := c.statements[id]
+	if stmt == nil {
+		return nil, nil, mysql.NewSQLError(erUnknownStmtHandler, mysql.SSUnknownSQLState, "unknown prepared statement handler (%d) given to mysqld_stmt_execute", id)
+	}
+	long := stmt.long
+	stmt.long = nil
+
+	// The flags, for cursors, and the iteration count, which is always 1.
+	pos := 10
+	if pos > len(data) {
+		return nil, nil, malformed
+	}
+	if stmt.params == 0 {
+		return stmt, nil, nil
+	}
+
+	nulls := (stmt.params + 7) / 8
+	if pos+nulls+1 > len(data) {
+		return nil, nil, malformed
+	}
+	bitmap := data[pos : pos+nulls]
+	pos += nulls
+	if bound := data[pos]; bound == 1 {
+		pos++
+		if pos+2*stmt.params > len(data) {
+			return nil, nil, malformed
+		}
+		stmt.types = make([]uint16, stmt.params)
+		for i := range stmt.types {
+			stmt.types[i] = binary.LittleEndian.Uint16(data[pos:])
+			pos += 2
+		}
+	} else {
+		pos++
+	}
+	if len(stmt.types) != stmt.params {
+		return nil, nil, mysql.NewSQLError(erMalformedPacket, mysql.SSUnknownSQLState, "the types of the parameters of the statement were not sent")
+	}
+
+	params := make([]sqltypes.Value, stmt.params)
+	for i, typ := range stmt.types {
+		if bitmap[i/8]&(1<<uint(i%8)) != 0 {
+			params[i] = sqltypes.NULL
+			continue
+		}
+		if v, ok := long[i]; ok {
+			params[i] = longDataValue(typ, v)
+			continue
+		}
+		var ok bool
+		if params[i], pos, ok = readBinaryValue(data, pos, typ); !ok {
+			return nil, nil, mysql.NewSQLError(erMalformedPacket, mysql.SSUnknownSQLState, "malformed value of type %d in COM_STMT_EXECUTE packet", typ&0xff)
+		}
+	}
+	return stmt, params, nil
+}
+
+// longDataValue returns the value of a parameter sent with
+// COM_STMT_SEND_LONG_DATA, which are strings or blobs.
+func longDataValue(typ uint16, data []byte) sqltypes.Value {
+	t, err := sqltypes.MySQLToType(int64(typ&0xff), 0)
+	if err != nil || !sqltypes.IsQuoted(t) {
+		t = sqltypes.VarBinary
+	}
+	return sqltypes.MakeTrusted(t, data)
+}
+
+// readBinaryValue reads a value of the given type in the binary protocol.
+func readBinaryValue(data []byte, pos int, typ uint16) (sqltypes.Value, int, bool) {
+	unsigned := typ&(binaryUnsigned<<8) != 0
+	fixed := func(size int) ([]byte, bool) {
+		if pos+size > len(data) {
+			return nil, false
+		}
+		b := data[pos : pos+size]
+		pos += size
+		return b, true
+	}
+	integer := func(size int) (sqltypes.Value, int, bool) {
+		b, ok := fixed(size)
+		if !ok {
+			return sqltypes.Value{}, 0, false
+		}
+		var u uint64
+		for i := size - 1; i >= 0; i-- {
+			u = u<<8 | uint64(b[i])
+		}
+		if unsigned {
+			return sqltypes.NewUint64(u), pos, true
+		}
+		// Sign extend the value to 64 bits.
+		shift := uint(64 - 8*size)
+		return sqltypes.NewInt64(int64(u<<shift) >> shift), pos, true
+	}
+	// temporal reads the bytes of dates and times, prefixed by their
+	// length, filling in the ones omitted as zero.
+	temporal := func(size int) ([]byte, bool) {
+		n, ok := fixed(1)
+		if !ok || int(n[0]) > size {
+			return nil, false
+		}
+		b, ok := fixed(int(n[0]))
+		return append(append([]byte(nil), b...), make([]byte, size-len(b))...), ok
+	}
+
+	switch typ & 0xff {
+	case binaryTypeNull:
+		return sqltypes.NULL, pos, true
+	case binaryTypeTiny:
+		return integer(1)
+	case binaryTypeShort, binaryTypeYear:
+		return integer(2)
+	case binaryTypeLong, binaryTypeInt24:
+		return integer(4)
+	case binaryTypeLongLong:
+		return integer(8)
+	case binaryTypeFloat:
+		b, ok := fixed(4)
+		if !ok {
+			return sqltypes.Value{}, 0, false
+		}
+		f := math.Float32frombits(binary.LittleEndian.Uint32(b))
+		return sqltypes.MakeTrusted(sqltypes.Float32, strconv.AppendFloat(nil, float64(f), 'g', -1, 32)), pos, true
+	case binaryTypeDouble:
+		b, ok := fixed(8)
+		if !ok {
+			return sqltypes.Value{}, 0, false
+		}
+		return sqltypes.NewFloat64(math.Float64frombits(binary.LittleEndian.Uint64(b))), pos, true
+	case binaryTypeDate, binaryTypeDatetime, binaryTypeTimestamp:
+		b, ok := temporal(11)
+		if !ok {
+			return sqltypes.Value{}, 0, false
+		}
+		s := fmt.Sprintf("%04d-%02d-%02d", int(b[0])|int(b[1])<<8, b[2], b[3])
+		if typ&0xff == binaryTypeDate {
+			return sqltypes.MakeTrusted(sqltypes.Date, []byte(s)), pos, true
+		}
+		s += fmt.Sprintf(" %02d:%02d:%02d", b[4], b[5], b[6])
+		if micro := binary.LittleEndian.Uint32(b[7:]); micro != 0 {
+			s += fmt.Sprintf(".%06d", micro)
+		}
+		return sqltypes.MakeTrusted(sqltypes.Datetime, []byte(s)), pos, true
+	case binaryTypeTime:
+		b, ok := temporal(12)
+		if !ok {
+			return sqltypes.Value{}, 0, false
+		}
+		sign := ""
+		if b[0] == 1 {
+			sign = "-"
+		}
+		days := binary.LittleEndian.Uint32(b[1:])
+		s := fmt.Sprintf("%s%02d:%02d:%02d", sign, days*24+uint32(b[5]), b[6], b[7])
+		if micro := binary.LittleEndian.Uint32(b[8:]); micro != 0 {
+			s += fmt.Sprintf(".%06d", micro)
+		}
+		return sqltypes.MakeTrusted(sqltypes.Time, []byte(s)), pos, true
+	}
+
+	// Everything else is sent as a string.
+	n, p, ok := readLenEncInt(data, pos)
+	if !ok || uint64(len(data)-p) < n {
+		return sqltypes.Value{}, 0, false
+	}
+	b := append([]byte(nil), data[p:p+int(n)]...)
+	switch typ & 0xff {
+	case binaryTypeDecimal, binaryTypeNewDecimal:
+		return sqltypes.MakeTrusted(sqltypes.Decimal, b), p + int(n), true
+	}
+	t, err := sqltypes.MySQLToType(int64(typ&0xff), 0)
+	if err != nil || !sqltypes.IsQuoted(t) {
+		t = sqltypes.VarChar
+	}
+	return sqltypes.MakeTrusted(t, b), p + int(n), true
+}
+
+// Write writes the packets of the server, translating the results of the
+// prepared statement being executed, if any.
+func (c *stmtConn) Write(p []byte) (int, error) {
+	if c.greeted && c.result == nil && len(c.out) == 0 {
+		return c.Conn.Write(p)
+	}
+
+	c.out = append(c.out, p...)
+	var b []byte
+	for !c.greeted || c.result != nil {
+		seq, payload, n, count, ok := nextPacket(c.out)
+		if !ok {
+			break
+		}
+		if !c.greeted {
+			// The handshake has the id of the connection after the
+			// version of the protocol and the one of the server.
+			c.greeted = true
+			if i := bytes.IndexByte(payload, 0); i >= 0 && len(payload) >= i+5 {
+				c.id = binary.LittleEndian.Uint32(payload[i+1:])
+			}
+			b = append(b, c.out[:n]...)
+		} else {
+			var err error
+			if b, err = c.result.translate(b, seq, payload, count); err != nil {
+				return 0, fmt.Errorf("could not send results of prepared statement: %v", err)
+			}
+			if c.result.done {
+				c.result = nil
+			}
+		}
+		c.out = c.out[n:]
+	}
+	if c.greeted && c.result == nil {
+		b = append(b, c.out...)
+		c.out = nil
+	}
+	if _, err := c.Conn.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// binaryResult translates the results of a prepared statement, written by
+// the server as the ones of COM_QUERY, to the binary protocol.
+type binaryResult struct {
+	deprecateEOF bool
+	// columns is the number of columns of the results, and types the types
+	// of the ones whose definitions were read.
+	columns int
+	types   []query.Type
+	eof     bool
+	// delta is the difference between the sequence numbers of the packets
+	// written and the ones of the server, as rows are split differently.
+	delta byte
+	done  bool
+}
+
+// translate appends the translation of a packet of the results, which took
+// count packets of the server, to b.
+func (r *binaryResult) translate(b []byte, seq byte, payload []byte, count int) ([]byte, error) {
+	switch {
+	case len(payload) == 0:
+		return nil, fmt.Errorf("empty packet")
+	case r.columns == 0:
+		// The number of columns, unless there are no results.
+		if payload[0] == mysql.OKPacket || payload[0] == mysql.ErrPacket {
+			r.done = true
+			break
+		}
+		n, _, ok := readLenEncInt(payload, 0)
+		if !ok || n == 0 {
+			return nil, fmt.Errorf("malformed number of columns")
+		}
+		r.columns = int(n)
+	case len(r.types) < r.columns:
+		typ, err := definitionType(payload)
+		if err != nil {
+			return nil, err
+		}
+		r.types = append(r.types, typ)
+	case !r.deprecateEOF && !r.eof:
+		r.eof = true
+	case payload[0] == mysql.ErrPacket || payload[0] == mysql.EOFPacket && len(payload) < 9:
+		r.done = true
+	default:
+		var err error
+		if payload, err = r.binaryRow(payload); err != nil {
+			return nil, err
+		}
+	}
+	b, n := appendPacket(b, seq+r.delta, payload)
+	r.delta += byte(n - count)
+	return b, nil
+}
+
+// binaryRow translates a row of text values to the binary protocol, where
+// values are encoded by the types of their columns.
+func (r *binaryResult) binaryRow(row []byte) ([]byte, error) {
+	// The first two bits of the NULL bitmap of rows are reserved.
+	b := make([]byte, 1+(r.columns+7+2)/8)
+	pos := 0
+	for i, typ := range r.types {
+		if pos < len(row) && row[pos] == mysql.NullValue {
+			b[1+(i+2)/8] |= 1 << uint((i+2)%8)
+			pos++
+			continue
+		}
+		n, p, ok := readLenEncInt(row, pos)
+		if !ok || uint64(len(row)-p) < n {
+			return nil, fmt.Errorf("malformed row")
+		}
+		pos = p + int(n)
+		var err error
+		if b, err = appendBinaryValue(b, typ, string(row[p:pos])); err != nil {
+			return nil, fmt.Errorf("could not encode column %d: %v", i+1, err)
+		}
+	}
+	return b, nil
+}
+
+// definitionType returns the type of a column from its definition.
+func definitionType(def []byte) (query.Type, error) {
+	// The catalog, the schema, the table and the name of the column, as
+	// named and originally, and the length of the fixed fields.
+	pos := 0
+	for i := 0; i < 6; i++ {
+		n, p, ok := readLenEncInt(def, pos)
+		if !ok || uint64(len(def)-p) < n {
+			return 0, fmt.Errorf("malformed column definition")
+		}
+		pos = p + int(n)
+	}
+	// The character set and the length of the column come before its type
+	// and its flags.
+	if pos += 1 + 2 + 4; pos+3 > len(def) {
+		return 0, fmt.Errorf("malformed column definition")
+	}
+	t, err := sqltypes.MySQLToType(int64(def[pos]), int64(binary.LittleEndian.Uint16(def[pos+1:])))
+	if err != nil {
+		return sqltypes.VarBinary, nil
+	}
+	return t, nil
+}
+
+// appendBinaryValue appends a value of a column of the given type, in the
+// binary protocol, to a row.
+func appendBinaryValue(b []byte, typ query.Type, raw string) ([]byte, error) {
+	integer := func(size int) ([]byte, error) {
+		var u uint64
+		if sqltypes.IsUnsigned(typ) {
+			n, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			u = n
+		} else {
+			n, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			u = uint64(n)
+		}
+		for i := 0; i < size; i++ {
+			b = append(b, byte(u>>uint(8*i)))
+		}
+		return b, nil
+	}
+
+	switch typ {
+	case sqltypes.Int8, sqltypes.Uint8:
+		return integer(1)
+	case sqltypes.Int16, sqltypes.Uint16, sqltypes.Year:
+		return integer(2)
+	case sqltypes.Int24, sqltypes.Uint24, sqltypes.Int32, sqltypes.Uint32:
+		return integer(4)
+	case sqltypes.Int64, sqltypes.Uint64:
+		return integer(8)
+	case sqltypes.Float32:
+		f, err := strconv.ParseFloat(raw, 32)
+		if err != nil {
+			return nil, err
+		}
+		var v [4]byte
+		binary.LittleEndian.PutUint32(v[:], math.Float32bits(float32(f)))
+		return append(b, v[:]...), nil
+	case sqltypes.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, err
+		}
+		var v [8]byte
+		binary.LittleEndian.PutUint64(v[:], math.Float64bits(f))
+		return append(b, v[:]...), nil
+	case sqltypes.Date, sqltypes.Datetime, sqltypes.Timestamp:
+		return appendBinaryDatetime(b, raw)
+	case sqltypes.Time:
+		return appendBinaryTime(b, raw)
+	}
+	return append(appendLenEncInt(b, uint64(len(raw))), raw...), nil
+}
+
+// appendBinaryDatetime appends a date, or a date and time, such as
+// 2006-01-02 15:04:05.999999, in the binary protocol.
+func appendBinaryDatetime(b []byte, s string) ([]byte, error) {
+	var year, month, day, hour, minute, second, micro int
+	date, clock := s, ""
+	if i := strings.IndexAny(s, " T"); i >= 0 {
+		date, clock = s[:i], s[i+1:]
+	}
+	if _, err := fmt.Sscanf(date, "%d-%d-%d", &year, &month, &day); err != nil {
+		return nil, fmt.Errorf("could not parse date %q", s)
+	}
+	if clock != "" {
+		var err error
+		if hour, minute, second, micro, err = parseClock(clock); err != nil {
+			return nil, fmt.Errorf("could not parse date %q", s)
+		}
+	}
+
+	var n byte
+	switch {
+	case micro != 0:
+		n = 11
+	case hour != 0 || minute != 0 || second != 0:
+		n = 7
+	case year != 0 || month != 0 || day != 0:
+		n = 4
+	}
+	b = append(b, n)
+	if n >= 4 {
+		b = append(b, byte(year), byte(year>>8), byte(month), byte(day))
+	}
+	if n >= 7 {
+		b = append(b, byte(hour), byte(minute), byte(second))
+	}
+	if n == 11 {
+		b = append(b, byte(micro), byte(micro>>8), byte(micro>>16), byte(micro>>24))
+	}
+	return b, nil
+}
+
+// appendBinaryTime appends a time, such as -838:59:59.999999, in the binary
+// protocol.
+func appendBinaryTime(b []byte, s string) ([]byte, error) {
+	negative := strings.HasPrefix(s, "-")
+	hours, minute, second, micro, err := parseClock(strings.TrimPrefix(s, "-"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse time %q", s)
+	}
+
+	var n byte
+	switch {
+	case micro != 0:
+		n = 12
+	case hours != 0 || minute != 0 || second != 0:
+		n = 8
+	}
+	b = append(b, n)
+	if n == 0 {
+		return b, nil
+	}
+	var sign byte
+	if negative {
+		sign = 1
+	}
+	days := hours / 24
+	b = append(b, sign, byte(days), byte(days>>8), byte(days>>16), byte(days>>24), byte(hours%24), byte(minute), byte(second))
+	if n == 12 {
+		b = append(b, byte(micro), byte(micro>>8), byte(micro>>16), byte(micro>>24))
+	}
+	return b, nil
+}
+
+// parseClock parses the time of the day in a date, or a time, such as
+// 15:04:05.999999.
+func parseClock(s string) (hour, minute, second, micro int, err error) {
+	frac := ""
+	if i := strings.Index(s, "."); i >= 0 {
+		s, frac = s[:i], s[i+1:]
+	}
+	if _, err := fmt.Sscanf(s, "%d:%d:%d", &hour, &minute, &second); err != nil {
+		return 0, 0, 0, 0, err
+	}
+	if frac != "" {
+		if len(frac) > 6 {
+			frac = frac[:6]
+		}
+		if micro, err = strconv.Atoi(frac + strings.Repeat("0", 6-len(frac))); err != nil {
+			return 0, 0, 0, 0, err
+		}
+	}
+	return hour, minute, second, micro, nil
+}
+
+// readPacket reads a packet, with the ones continuing it if its payload is
+// too long for one.
+func readPacket(r io.Reader) ([]byte, error) {
+	var raw []byte
+	for {
+		start := len(raw)
+		raw = append(raw, 0, 0, 0, 0)
+		if _, err := io.ReadFull(r, raw[start:]); err != nil {
+			return nil, err
+		}
+		size := int(raw[start]) | int(raw[start+1])<<8 | int(raw[start+2])<<16
+		raw = append(raw, make([]byte, size)...)
+		if _, err := io.ReadFull(r, raw[start+4:]); err != nil {
+			return nil, err
+		}
+		if size < maxPacketSize {
+			return raw, nil
+		}
+	}
+}
+
+// nextPacket returns the sequence number and the payload of the first
+// packet in b, with the number of bytes and of packets it took, or false if
+// b does not hold all of it yet.
+func nextPacket(b []byte) (seq byte, payload []byte, n, count int, ok bool) {
+	for {
+		if len(b)-n < 4 {
+			return 0, nil, 0, 0, false
+		}
+		size := int(b[n]) | int(b[n+1])<<8 | int(b[n+2])<<16
+		if len(b)-n-4 < size {
+			return 0, nil, 0, 0, false
+		}
+		chunk := b[n+4 : n+4+size]
+		if count == 0 {
+			seq, payload = b[n+3], chunk
+		} else {
+			payload = append(payload[:len(payload):len(payload)], chunk...)
+		}
+		n += 4 + size
+		count++
+		if size < maxPacketSize {
+			return seq, payload, n, count, true
+		}
+	}
+}
+
+// appendPacket appends the packets of a payload to b, starting with the
+// given sequence number, returning how many they are.
+func appendPacket(b []byte, seq byte, payload []byte) ([]byte, int) {
+	for n := 1; ; n++ {
+		size := len(payload)
+		if size > maxPacketSize {
+			size = maxPacketSize
+		}
+		b = append(b, byte(size), byte(size>>8), byte(size>>16), seq)
+		b = append(b, payload[:size]...)
+		payload = payload[size:]
+		seq++
+		if size < maxPacketSize {
+			return b, n
+		}
+	}
+}
+
+// readLenEncInt reads a length encoded integer.
+func readLenEncInt(b []byte, pos int) (uint64, int, bool) {
+	if pos >= len(b) {
+		return 0, 0, false
+	}
+	size := 0
+	switch b[pos] {
+	case 0xfc:
+		size = 2
+	case 0xfd:
+		size = 3
+	case 0xfe:
+		size = 8
+	case 0xfb, 0xff:
+		return 0, 0, false
+	default:
+		return uint64(b[pos]), pos + 1, true
+	}
+	if pos+1+size > len(b) {
+		return 0, 0, false
+	}
+	var n uint64
+	for i := size; i > 0; i-- {
+		n = n<<8 | uint64(b[pos+i])
+	}
+	return n, pos + 1 + size, true
+}
+
+// appendLenEncInt appends a length encoded integer to b.
+func appendLenEncInt(b []byte, n uint64) []byte {
+	switch {
+	case n < 0xfb:
+		return append(b, byte(n))
+	case n < 1<<16:
+		return append(b, 0xfc, byte(n), byte(n>>8))
+	case n < 1<<24:
+		return append(b, 0xfd, byte(n), byte(n>>8), byte(n>>16))
+	}
+	b = append(b, 0xfe)
+	for i := uint(0); i < 8; i++ {
+		b = append(b, byte(n>>(8*i)))
+	}
+	return b
+}
@@ -0,0 +1,130 @@
+package csvql
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/analyzer"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/expression"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+// pseudoColumn is a column that can be selected from any CSV table, without
+// being part of its schema, describing where each row comes from.
+type pseudoColumn struct {
+	name  string
+	typ   sql.Type
+	value func(src *rowSource) interface{}
+}
+
+// rowSource describes the file a row was read from, and where in it.
+type rowSource struct {
+	path string
+	// abs is the absolute path of the file, once the _file column is read.
+	abs  string
+	info os.FileInfo
+	// line is the number of the line the row starts at, or of the row in the
+	// files that have no lines, such as Parquet ones.
+	line int64
+	// errors are the values of the row that could not be converted to the
+	// types of their columns, read as NULL when its _error column is used.
+	errors []string
+}
+
+var pseudoColumns = []pseudoColumn{
+	{"_file", sql.Text, func(src *rowSource) interface{} { return src.absPath() }},
+	{"_line", sql.Int64, func(src *rowSource) interface{} { return src.line }},
+	{"_error", sql.Text, func(src *rowSource) interface{} {
+		if len(src.errors) == 0 {
+			return nil
+		}
+		return strings.Join(src.errors, "; ")
+	}},
+	{"_file_mtime", sql.Timestamp, func(src *rowSource) interface{} { return src.info.ModTime() }},
+	{"_file_size", sql.Int64, func(src *rowSource) interface{} { return src.info.Size() }},
+}
+
+// absPath returns the absolute path of the file a row was read from, as the
+// ones listed by files(), so tables can be joined with them, or the URL of
+// the object it was streamed from.
+func (src *rowSource) absPath() string {
+	if src.abs == "" {
+		src.abs = src.path
+		if remoteScheme(src.path) != "" {
+			return src.abs
+		}
+		if abs, err := filepath.Abs(src.path); err == nil {
+			src.abs = abs
+		}
+	}
+	return src.abs
+}
+
+// addPseudoColumns is an analyzer rule adding the pseudo columns referenced
+// in a query to the schema of the CSV tables it uses. They are only added
+// when needed, so they do not appear in SELECT * otherwise.
+func addPseudoColumns(ctx *sql.Context, a *analyzer.Analyzer, n sql.Node) (sql.Node, error) {
+	used := map[string]bool{}
+	plan.InspectExpressions(n, func(e sql.Expression) bool {
+		if c, ok := e.(*expression.UnresolvedColumn); ok {
+			used[strings.ToLower(c.Name())] = true
+		}
+		return true
+	})
+
+	var cols []pseudoColumn
+	for _, col := range pseudoColumns {
+		if used[col.name] {
+			cols = append(cols, col)
+		}
+	}
+	if len(cols) == 0 {
+		return n, nil
+	}
+
+	return n.TransformUp(func(n sql.Node) (sql.Node, error) {
+		ut, ok := n.(*plan.UnresolvedTable)
+		if !ok {
+			return n, nil
+		}
+		t, err := catalogTable(a, ut.Name())
+		if err != nil {
+			return n, nil
+		}
+		ct, ok := t.(*table)
+		if !ok {
+			return n, nil
+		}
+		return plan.NewResolvedTable(ct.withPseudoColumns(cols)), nil
+	})
+}
+
+// hasErrorColumn reports whether the _error pseudo column is one of the given
+// ones, so the values that can not be converted are read as NULL.
+func hasErrorColumn(cols []pseudoColumn) bool {
+	for _, col := range cols {
+		if col.name == "_error" {
+			return true
+		}
+	}
+	return false
+}
+
+// withPseudoColumns returns a copy of the table whose schema includes the
+// given pseudo columns, unless it already has columns with the same names,
+// after the partition keys it has.
+func (t *table) withPseudoColumns(cols []pseudoColumn) *table {
+	c := *t
+	c.schema = append([]*sql.Column(nil), t.schema...)
+	c.pseudo = t.pseudo[:len(t.pseudo):len(t.pseudo)]
+	for _, col := range cols {
+		if sql.Schema(t.schema).Contains(col.name, t.name) {
+			continue
+		}
+		c.schema = append(c.schema, &sql.Column{Name: col.name, Type: col.typ, Source: t.name})
+		c.pseudo = append(c.pseudo, col)
+	}
+	return &c
+}
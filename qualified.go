@@ -0,0 +1,70 @@
+package csvql
+
+import (
+	"regexp"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/analyzer"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+// qualifiedTable matches the tables qualified with the name of their
+// database in a query, as in hr.people. Since the parser does not support
+// qualifiers, they are replaced with quoted table names, which are then
+// resolved by resolveQualifiedTables.
+var qualifiedTable = regexp.MustCompile(`(?i)\b(from|join|into)\s+(` + namePart + `(?:\.` + namePart + `)+)`)
+
+// namePart matches a part of a qualified name, which can be quoted.
+const namePart = "(?:\\w+|`[^`.]+`)"
+
+// rewriteQualifiedTables replaces the qualified tables in the query with the
+// quoted table names that resolveQualifiedTables understands.
+func rewriteQualifiedTables(query string) string {
+	return qualifiedTable.ReplaceAllStringFunc(query, func(s string) string {
+		m := qualifiedTable.FindStringSubmatch(s)
+		return m[1] + " " + quoteIdentifier(strings.Replace(m[2], "`", "", -1))
+	})
+}
+
+// catalogTable returns the table with the given name in the current database
+// or, if it has none, in the database the name is qualified with, as in
+// hr.people.
+func catalogTable(a *analyzer.Analyzer, name string) (sql.Table, error) {
+	t, err := a.Catalog.Table(a.CurrentDatabase, name)
+	if err == nil || !sql.ErrTableNotFound.Is(err) {
+		return t, err
+	}
+	// The tables of information_schema are often written in upper case, as
+	// in INFORMATION_SCHEMA.TABLES.
+	for _, name := range []string{name, strings.ToLower(name)} {
+		if dot := strings.Index(name, "."); dot > 0 {
+			if db, derr := a.Catalog.Database(name[:dot]); derr == nil {
+				if t, ok := db.Tables()[name[dot+1:]]; ok {
+					return t, nil
+				}
+			}
+		}
+	}
+	return nil, err
+}
+
+// resolveQualifiedTables is an analyzer rule resolving the tables of other
+// databases, named after them, which go-mysql-server looks for in the
+// current database only.
+func resolveQualifiedTables(ctx *sql.Context, a *analyzer.Analyzer, n sql.Node) (sql.Node, error) {
+	return n.TransformUp(func(n sql.Node) (sql.Node, error) {
+		ut, ok := n.(*plan.UnresolvedTable)
+		if !ok || !strings.Contains(ut.Name(), ".") {
+			return n, nil
+		}
+		if _, err := a.Catalog.Table(a.CurrentDatabase, ut.Name()); err == nil {
+			return n, nil
+		}
+		t, err := catalogTable(a, ut.Name())
+		if err != nil {
+			return n, nil
+		}
+		return plan.NewResolvedTable(t), nil
+	})
+}
@@ -0,0 +1,98 @@
+package csvql
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// queryHistorySize is the number of recent queries kept in memory by the
+// engine, as used by SHOW INDEX ADVICE.
+const queryHistorySize = 1000
+
+// QueryLogEntry is a statement run by the server, as written to the query
+// log.
+type QueryLogEntry struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user,omitempty"`
+	Query    string    `json:"query"`
+	Duration float64   `json:"duration_ms"`
+	Rows     int64     `json:"rows"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// queryLog keeps the recent queries run by the server and, if set, writes
+// every one of them as a JSON object per line.
+type queryLog struct {
+	mu      sync.Mutex
+	w       io.Writer
+	history []string
+	next    int
+}
+
+func (l *queryLog) add(entry *QueryLogEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.history) < queryHistorySize {
+		l.history = append(l.history, entry.Query)
+	} else {
+		l.history[l.next] = entry.Query
+		l.next = (l.next + 1) % queryHistorySize
+	}
+
+	if l.w == nil {
+		return nil
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = l.w.Write(append(b, '\n'))
+	return err
+}
+
+// queries returns the recent queries, from the oldest to the newest.
+func (l *queryLog) queries() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append(append([]string(nil), l.history[l.next:]...), l.history[:l.next]...)
+}
+
+// LogQueries makes the engine write the queries run by the server to w.
+func (e *Engine) LogQueries(w io.Writer) {
+	e.log.mu.Lock()
+	defer e.log.mu.Unlock()
+	e.log.w = w
+}
+
+// ReadQueryLog reads the queries in a log written by the server.
+func ReadQueryLog(path string) ([]*QueryLogEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open query log: %v", err)
+	}
+	defer f.Close()
+
+	var entries []*QueryLogEntry
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<24)
+	for line := 1; s.Scan(); line++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var entry QueryLogEntry
+		if err := json.Unmarshal(s.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("could not parse line %d of %s: %v", line, path, err)
+		}
+		entries = append(entries, &entry)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("could not read query log: %v", err)
+	}
+	return entries, nil
+}
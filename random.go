@@ -0,0 +1,124 @@
+package csvql
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+func init() {
+	functions = append(functions,
+		// RAND() returns a random number in [0, 1).
+		&scalar{name: "rand", typ: sql.Float64, min: 0, max: 0, eval: random},
+		// RANDOM_INT(min, max) returns a random integer in [min, max].
+		&scalar{name: "random_int", typ: sql.Int64, min: 2, max: 2, eval: randomInt},
+		// RANDOM_STRING(n[, alphabet]) returns n random characters from the
+		// given alphabet, ASCII letters and digits by default.
+		&scalar{name: "random_string", typ: sql.Text, min: 1, max: 2, eval: randomString},
+		// RANDOM_DATE(from, to) returns a random date in [from, to].
+		&scalar{name: "random_date", typ: sql.Date, min: 2, max: 2, eval: randomDate},
+	)
+
+	// generate_rows(n) returns a table with a single column n, with the
+	// integers from 1 to n.
+	tableFunctions["generate_rows"] = generateRows
+}
+
+func random(args []interface{}) (interface{}, error) { return rand.Float64(), nil }
+
+func randomInt(args []interface{}) (interface{}, error) {
+	min, err := intArg(args, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	max, err := intArg(args, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if max < min {
+		return nil, fmt.Errorf("max %d is smaller than min %d", max, min)
+	}
+	return min + rand.Int63n(max-min+1), nil
+}
+
+const alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func randomString(args []interface{}) (interface{}, error) {
+	n, err := intArg(args, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	alphabet, err := stringArg(args, 1, alphanumeric)
+	if err != nil {
+		return nil, err
+	}
+	chars := []rune(alphabet)
+	if n < 0 || len(chars) == 0 {
+		return nil, fmt.Errorf("need a non negative length and a non empty alphabet")
+	}
+
+	s := make([]rune, n)
+	for i := range s {
+		s[i] = chars[rand.Intn(len(chars))]
+	}
+	return string(s), nil
+}
+
+func randomDate(args []interface{}) (interface{}, error) {
+	var dates [2]time.Time
+	for i := range dates {
+		d, err := sql.Date.Convert(args[i])
+		if err != nil {
+			return nil, err
+		}
+		dates[i] = d.(time.Time)
+	}
+	days := int64(dates[1].Sub(dates[0]) / (24 * time.Hour))
+	if days < 0 {
+		return nil, fmt.Errorf("%s is before %s", formatValue(dates[1]), formatValue(dates[0]))
+	}
+	return dates[0].AddDate(0, 0, int(rand.Int63n(days+1))), nil
+}
+
+func generateRows(args []string) (sql.Table, error) {
+	if len(args) != 1 {
+		return nil, sql.ErrInvalidArgumentNumber.New(1, len(args))
+	}
+	n, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("number of rows must be a non negative integer, got %q", args[0])
+	}
+	return &generatedTable{n}, nil
+}
+
+// generatedTable is the table returned by generate_rows.
+type generatedTable struct{ n int64 }
+
+func (t *generatedTable) Name() string   { return "generate_rows" }
+func (t *generatedTable) String() string { return fmt.Sprintf("generate_rows(%d)", t.n) }
+func (t *generatedTable) Schema() sql.Schema {
+	return sql.Schema{{Name: "n", Type: sql.Int64, Source: t.Name()}}
+}
+
+func (t *generatedTable) Partitions(ctx *sql.Context) (sql.PartitionIter, error) {
+	return &partitionIter{}, nil
+}
+
+func (t *generatedTable) PartitionRows(ctx *sql.Context, p sql.Partition) (sql.RowIter, error) {
+	return &generatedRows{n: t.n}, nil
+}
+
+type generatedRows struct{ i, n int64 }
+
+func (r *generatedRows) Close() error { return nil }
+func (r *generatedRows) Next() (sql.Row, error) {
+	if r.i == r.n {
+		return nil, io.EOF
+	}
+	r.i++
+	return sql.NewRow(r.i), nil
+}
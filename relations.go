@@ -0,0 +1,109 @@
+package csvql
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// ForeignKey is a relationship between a column and the column of another
+// table whose values it refers to, declared with references in a schema.
+// csvql does not enforce them, but uses them to estimate the size of joins.
+type ForeignKey struct {
+	Table, Column       string
+	RefTable, RefColumn string
+}
+
+func (k *ForeignKey) String() string {
+	return fmt.Sprintf("%s.%s -> %s.%s", k.Table, k.Column, k.RefTable, k.RefColumn)
+}
+
+// ForeignKeys returns the foreign keys declared in the schema sidecars of the
+// tables in the database, checking that the columns they refer to exist.
+func ForeignKeys(db sql.Database) ([]*ForeignKey, error) {
+	tables := db.Tables()
+	var names []string
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var keys []*ForeignKey
+	for _, name := range names {
+		t, ok := tables[name].(*table)
+		if !ok {
+			continue
+		}
+		path := SchemaPath(t.path)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		s, err := LoadSchema(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, col := range s.Columns {
+			if col.References == "" {
+				continue
+			}
+			k, err := parseReference(name, col)
+			if err != nil {
+				return nil, fmt.Errorf("column %s in schema %s: %v", col.Name, path, err)
+			}
+			ref, ok := tables[k.RefTable]
+			if !ok {
+				return nil, fmt.Errorf("column %s in schema %s references unknown table %s", col.Name, path, k.RefTable)
+			}
+			if !ref.Schema().Contains(k.RefColumn, k.RefTable) {
+				return nil, fmt.Errorf("column %s in schema %s references unknown column %s", col.Name, path, col.References)
+			}
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// parseReference parses a reference of the form table.column.
+func parseReference(table string, col *ColumnSchema) (*ForeignKey, error) {
+	i := strings.LastIndex(col.References, ".")
+	if i <= 0 || i == len(col.References)-1 {
+		return nil, fmt.Errorf("invalid reference %q, expected table.column", col.References)
+	}
+	return &ForeignKey{
+		Table:     table,
+		Column:    strings.ToLower(col.Name),
+		RefTable:  col.References[:i],
+		RefColumn: strings.ToLower(col.References[i+1:]),
+	}, nil
+}
+
+// JoinSide is one of the sides of an equality join: a column in a table
+// with the given number of rows.
+type JoinSide struct {
+	Table, Column string
+	Rows          int64
+}
+
+// EstimateJoinRows estimates the number of rows of an equality join between
+// two columns. When one refers to the other through a foreign key, every row
+// of the referring table matches at most one row, so there are as many rows
+// as in that table; otherwise the estimate assumes the values of the smaller
+// table are spread evenly on the larger one. It also reports whether the
+// estimate is backed by a foreign key.
+func EstimateJoinRows(keys []*ForeignKey, a, b JoinSide) (rows int64, fk bool) {
+	for _, k := range keys {
+		if k.Table == a.Table && k.Column == a.Column && k.RefTable == b.Table && k.RefColumn == b.Column {
+			return a.Rows, true
+		}
+		if k.Table == b.Table && k.Column == b.Column && k.RefTable == a.Table && k.RefColumn == a.Column {
+			return b.Rows, true
+		}
+	}
+	if a.Rows > b.Rows {
+		return a.Rows, false
+	}
+	return b.Rows, false
+}
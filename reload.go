@@ -0,0 +1,184 @@
+package csvql
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watcher builds a table again once its files change, for WatchTables.
+type watcher struct {
+	// find returns the files of the table, found again as when it was built,
+	// or nil if they are always the same.
+	find   func() ([]string, error)
+	reload func() (*table, error)
+}
+
+// watch makes WatchTables build the table again with reload whenever its
+// files, found with find if they can change, differ from the current ones.
+func (t *table) watch(find func() ([]string, error), reload func() (*table, error)) {
+	t.watcher = &watcher{find, reload}
+	t.states, _ = t.watchedStates()
+}
+
+// watchedStates returns the current state of the files of a watched table.
+func (t *table) watchedStates() ([]fileState, error) {
+	if t.watcher.find == nil {
+		return fileStates(t)
+	}
+	paths, err := t.watcher.find()
+	if err != nil {
+		return nil, err
+	}
+	return pathStates(paths)
+}
+
+// reloadDelay is how long WatchTables waits for more changes after one is
+// notified, so the files being written are not read again for every write.
+const reloadDelay = 100 * time.Millisecond
+
+// WatchTables builds the tables whose files changed again, so their columns
+// are read again from them, and the ones of patterns and folders have the
+// files found in them then. The changes of the files in the folders of the
+// tables are notified by the file system, while the tables whose folders can
+// not be watched, such as the ones of remote files, or all of them where the
+// notifications are not supported, are checked every interval. The
+// statistics and indexes of the tables already tell when their files change,
+// and rows are always read from the files. It returns once ctx is done, so
+// it is usually run in its own goroutine.
+func (e *Engine) WatchTables(ctx context.Context, interval time.Duration) {
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("could not watch the folders of the tables, checking them every %v: %v", interval, err)
+	} else {
+		defer w.Close()
+		events, errs = w.Events, w.Errors
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	delay := time.NewTimer(reloadDelay)
+	delay.Stop()
+
+	watched := make(map[string]bool)
+	poll := e.watchFolders(w, watched)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-events:
+			// The watches of removed folders are removed, and added again
+			// if they are created again.
+			if ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				delete(watched, ev.Name)
+			}
+			delay.Reset(reloadDelay)
+			continue
+		case err := <-errs:
+			log.Printf("could not watch the folders of the tables: %v", err)
+			continue
+		case <-delay.C:
+		case <-ticker.C:
+			if !poll {
+				continue
+			}
+		}
+		e.reloadTables()
+		poll = e.watchFolders(w, watched)
+	}
+}
+
+// reloadTables builds again the tables of the databases whose files changed.
+func (e *Engine) reloadTables() {
+	for _, db := range e.Catalog.Databases {
+		if cdb, ok := db.(*database); ok {
+			cdb.reloadTables()
+		}
+	}
+}
+
+// watchFolders adds the folders of the files of the watched tables to w, if
+// not in watched already, reporting whether the files of some tables can not
+// be watched, and must be checked instead.
+func (e *Engine) watchFolders(w *fsnotify.Watcher, watched map[string]bool) bool {
+	poll := false
+	for _, db := range e.Catalog.Databases {
+		cdb, ok := db.(*database)
+		if !ok {
+			continue
+		}
+		for _, t := range cdb.Tables() {
+			ct, ok := t.(*table)
+			if !ok || ct.watcher == nil {
+				continue
+			}
+			if w == nil || remoteScheme(ct.path) != "" {
+				poll = true
+				continue
+			}
+			// The tables of folders and patterns also change when files are
+			// added to their folders.
+			folders := []string{ct.path}
+			if fi, err := os.Stat(ct.path); err != nil || !fi.IsDir() {
+				folders[0] = filepath.Dir(ct.path)
+			}
+			for _, st := range ct.states {
+				folders = append(folders, filepath.Dir(st.Path))
+			}
+			for _, folder := range folders {
+				if watched[folder] {
+					continue
+				}
+				if err := w.Add(folder); err != nil {
+					poll = true
+					continue
+				}
+				watched[folder] = true
+			}
+		}
+	}
+	return poll
+}
+
+// reloadTables builds again the tables whose files changed. The ones that can
+// not be built then, such as while their files are being written, are kept
+// until their files change again.
+func (db *database) reloadTables() {
+	// Commits change the files, so they are only checked between them.
+	db.wmu.Lock()
+	defer db.wmu.Unlock()
+	for name, t := range db.Tables() {
+		ct, ok := t.(*table)
+		if !ok || ct.watcher == nil {
+			continue
+		}
+		// Tables whose files were removed are built again too, which only
+		// succeeds for the ones of patterns and folders that still have some.
+		states, _ := ct.watchedStates()
+		if sameFiles(states, ct.states) {
+			continue
+		}
+		nt, err := ct.watcher.reload()
+		if err != nil {
+			log.Printf("could not reload table %s: %v", name, err)
+			ct.states = states
+			continue
+		}
+		if ct.db != nil && nt.seekable() {
+			nt.db = ct.db
+		}
+		nt.named = ct.named
+		nt.watch(ct.watcher.find, ct.watcher.reload)
+
+		db.mu.Lock()
+		if db.tables[name] == t {
+			db.tables[name] = nt
+		}
+		db.mu.Unlock()
+	}
+}
@@ -0,0 +1,44 @@
+package csvql
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+func TestWatchTables(t *testing.T) {
+	e, dir := testEngine(t, map[string]string{"people.csv": "name\nalice\n"})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		// The tables in folders are never checked, only notified.
+		e.WatchTables(ctx, time.Hour)
+		close(done)
+	}()
+	// The folders are watched once WatchTables starts.
+	time.Sleep(100 * time.Millisecond)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "people.csv"), []byte("name,age\nalice,30\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if _, _, err = e.Query(sql.NewEmptyContext(), "SELECT age FROM people"); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Errorf("the table was not built again once its file changed: %v", err)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("WatchTables did not return once its context was done")
+	}
+}
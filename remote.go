@@ -0,0 +1,419 @@
+package csvql
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// object is an object in a cloud storage service, with a version that
+// changes whenever its contents do, such as its ETag or generation, and its
+// size and modification time, if known.
+type object struct {
+	key, version string
+	size         int64
+	modified     time.Time
+}
+
+// objectStore reads the objects in the buckets of a cloud storage service.
+type objectStore interface {
+	// list returns the objects in a bucket whose keys start with prefix.
+	list(bucket, prefix string) ([]object, error)
+	// stat returns the object with the given key.
+	stat(bucket, key string) (object, error)
+	// open returns a reader of the contents of an object.
+	open(bucket, key string) (io.ReadCloser, error)
+}
+
+// objectStores returns the store of the objects in the URLs of each scheme.
+var objectStores = map[string]func() (objectStore, error){
+	"s3":    newS3Store,
+	"gs":    newGCSStore,
+	"az":    newAzureStore,
+	"sftp":  newSFTPStore,
+	"http":  newHTTPStore("http"),
+	"https": newHTTPStore("https"),
+}
+
+// remoteTimeout is how long the requests to cloud storage services wait for
+// them to connect, to answer, or to send more of the objects read, before
+// failing.
+const remoteTimeout = 30 * time.Second
+
+// remoteClient sends the requests to cloud storage services. Their responses
+// have no deadline as a whole, since big objects take long to download.
+var remoteClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: remoteTimeout, KeepAlive: remoteTimeout}).DialContext,
+		TLSHandshakeTimeout:   remoteTimeout,
+		ResponseHeaderTimeout: remoteTimeout,
+		ExpectContinueTimeout: time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          100,
+	},
+}
+
+// sendRemote sends a request with remoteClient, failing the reads of the
+// body of its response if they wait for more than remoteTimeout.
+func sendRemote(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	res, err := remoteClient.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	timer := time.AfterFunc(remoteTimeout, cancel)
+	timer.Stop()
+	res.Body = &stalledBody{ReadCloser: res.Body, timer: timer, cancel: cancel}
+	return res, nil
+}
+
+// stalledBody is the body of a response whose request is canceled if a read
+// waits for too long. The time between reads is not counted.
+type stalledBody struct {
+	io.ReadCloser
+	timer  *time.Timer
+	cancel func()
+}
+
+func (b *stalledBody) Read(p []byte) (int, error) {
+	b.timer.Reset(remoteTimeout)
+	defer b.timer.Stop()
+	return b.ReadCloser.Read(p)
+}
+
+func (b *stalledBody) Close() error {
+	b.timer.Stop()
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// remoteCache is the directory where the objects read are kept, if any, and
+// remoteTTL how long the objects of a URL are read from it without checking
+// whether they changed.
+var (
+	remoteCache string
+	remoteTTL   time.Duration
+)
+
+// SetRemoteCache sets the directory where the objects read from cloud storage
+// services are kept, so they are downloaded again only once they change,
+// rather than to temporary files every time.
+func SetRemoteCache(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not create cache directory: %v", err)
+	}
+	remoteCache = dir
+	return nil
+}
+
+// SetRemoteCacheTTL sets how long the objects kept in the cache set by
+// SetRemoteCache are read again without asking the service whether they
+// changed, which is done every time by default.
+func SetRemoteCacheTTL(ttl time.Duration) {
+	remoteTTL = ttl
+}
+
+// remoteScheme returns the scheme of a path if it is the URL of objects in a
+// cloud storage service, such as s3://bucket/key, or "" otherwise.
+func remoteScheme(path string) string {
+	i := strings.Index(path, "://")
+	if i <= 0 {
+		return ""
+	}
+	scheme := strings.ToLower(path[:i])
+	if _, ok := objectStores[scheme]; !ok {
+		return ""
+	}
+	return scheme
+}
+
+// fetchObjects returns the paths of the files of the objects a URL points to,
+// such as s3://bucket/exports/2024-01-01.csv, in the order of their keys.
+// Keys can have the wildcards of path.Match, which do not match slashes, as
+// in s3://bucket/exports/2024-*.csv, to read several objects.
+//
+// With stream, the paths are the URLs of the objects, which openFile reads
+// from their service every time. Otherwise, or for files on SFTP servers, the
+// objects are downloaded to the cache set by SetRemoteCache, if any, or to a
+// temporary directory, removed by CleanTempDir once the process exits.
+//
+// Files served over HTTP or HTTPS are read the same way, with URLs such as
+// https://example.com/orders.csv, without wildcards.
+func fetchObjects(uri string, stream bool) ([]string, error) {
+	if remoteCache != "" && remoteTTL > 0 {
+		if paths, ok := checkedObjects(uri); ok {
+			return paths, nil
+		}
+	}
+	scheme := remoteScheme(uri)
+	web := scheme == "http" || scheme == "https"
+	bucket, key, err := splitObjectURL(uri)
+	if err != nil {
+		return nil, err
+	}
+	store, err := objectStores[scheme]()
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := store.(io.Closer); ok {
+		// Connections to SFTP servers are not kept open between queries.
+		stream = false
+		defer c.Close()
+	}
+	stream = stream && remoteCache == ""
+
+	objects := []object{{key: key}}
+	if meta := strings.IndexAny(key, `*?[\`); meta >= 0 && !web {
+		if _, err := path.Match(key, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", key, err)
+		}
+		found, err := store.list(bucket, key[:meta])
+		if err != nil {
+			return nil, fmt.Errorf("could not list %s://%s/%s: %v", scheme, bucket, key[:meta], err)
+		}
+		objects = nil
+		for _, o := range found {
+			if ok, _ := path.Match(key, o.key); ok {
+				objects = append(objects, o)
+			}
+		}
+		if len(objects) == 0 {
+			return nil, fmt.Errorf("no objects match %s", uri)
+		}
+		sort.Slice(objects, func(i, j int) bool { return objects[i].key < objects[j].key })
+	} else if remoteCache != "" || stream && !web {
+		o, err := store.stat(bucket, key)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %v", uri, err)
+		}
+		objects = []object{o}
+	} else if stream {
+		// Servers answering GET requests, such as for presigned URLs, do
+		// not always answer HEAD ones, so the files are only checked when
+		// they are read.
+		if o, err := store.stat(bucket, key); err == nil {
+			objects = []object{o}
+		}
+	}
+
+	if stream {
+		var paths []string
+		streamedObjects.Lock()
+		defer streamedObjects.Unlock()
+		if streamedObjects.stores[scheme] == nil {
+			streamedObjects.stores[scheme] = store
+		}
+		for _, o := range objects {
+			p := scheme + "://" + bucket + "/" + o.key
+			streamedObjects.objects[p] = o
+			paths = append(paths, p)
+		}
+		return paths, nil
+	}
+
+	// The files keep the names of the objects, whose extensions tell their
+	// formats and compressions.
+	dir := remoteCache
+	if dir == "" {
+		if dir, err = tempDirectory(scheme); err != nil {
+			return nil, err
+		}
+	}
+	var paths []string
+	for _, o := range objects {
+		name := scheme + "://" + bucket + "/" + o.key
+		file := o.key
+		if web {
+			file = strings.SplitN(file, "?", 2)[0]
+		}
+		p := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+file)))
+		if remoteCache != "" {
+			// Cached files are kept by version, so the ones of objects that
+			// changed are downloaded again.
+			sum := sha256.Sum256([]byte(name + "\n" + o.version))
+			p = filepath.Join(dir, hex.EncodeToString(sum[:8]), path.Base(file))
+		}
+		if o.version == "" || !fileExists(p) {
+			if err := fetchObject(store, bucket, o.key, p); err != nil {
+				if remoteCache == "" {
+					os.RemoveAll(dir)
+				}
+				return nil, fmt.Errorf("could not read %s: %v", name, err)
+			}
+		}
+		paths = append(paths, p)
+	}
+	if remoteCache != "" && remoteTTL > 0 {
+		keepChecked(uri, paths)
+	}
+	return paths, nil
+}
+
+// splitObjectURL returns the bucket and the key of the URL of an object.
+func splitObjectURL(uri string) (bucket, key string, err error) {
+	scheme := remoteScheme(uri)
+	rest := uri[len(scheme)+len("://"):]
+	slash := strings.Index(rest, "/")
+	if slash <= 0 || slash == len(rest)-1 {
+		return "", "", fmt.Errorf("invalid URL %q, expected %s://bucket/key", uri, scheme)
+	}
+	return rest[:slash], rest[slash+1:], nil
+}
+
+// streamedObjects are the objects whose URLs were returned by fetchObjects
+// to be streamed, and the stores they are read from, by scheme.
+var streamedObjects = struct {
+	sync.Mutex
+	objects map[string]object
+	stores  map[string]objectStore
+}{objects: make(map[string]object), stores: make(map[string]objectStore)}
+
+// streamedFormat reports whether the objects of a table read with the given
+// options can be streamed, which the formats read in any order, such as
+// Parquet files and workbooks, can not.
+func streamedFormat(uri string, opts map[string]string) bool {
+	format := tableFormat
+	if f, ok := opts["format"]; ok {
+		format = strings.ToLower(f)
+	}
+	if format == "" {
+		_, format = splitFormat(uri)
+	}
+	return archiveFormat(format)
+}
+
+// openObject opens the object at a URL returned by fetchObjects to be read
+// as it is received from its service.
+func openObject(uri string) (readFile, error) {
+	streamedObjects.Lock()
+	o, ok := streamedObjects.objects[uri]
+	store := streamedObjects.stores[remoteScheme(uri)]
+	streamedObjects.Unlock()
+	if !ok || store == nil {
+		return nil, fmt.Errorf("could not open %s: it was not added as a table", uri)
+	}
+	bucket, key, err := splitObjectURL(uri)
+	if err != nil {
+		return nil, err
+	}
+	r, err := store.open(bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", uri, err)
+	}
+	return &archivedFile{ReadCloser: r, name: uri, info: objectInfo(o)}, nil
+}
+
+// objectInfo is the information of a streamed object, as the one of a file.
+type objectInfo object
+
+func (o objectInfo) Name() string       { return path.Base(o.key) }
+func (o objectInfo) Size() int64        { return o.size }
+func (o objectInfo) Mode() os.FileMode  { return 0444 }
+func (o objectInfo) ModTime() time.Time { return o.modified }
+func (o objectInfo) IsDir() bool        { return false }
+func (o objectInfo) Sys() interface{}   { return nil }
+
+// headerObject returns the object with the given key and version, with the
+// size and modification time in the headers of a response for it.
+func headerObject(key, version string, h http.Header) object {
+	o := object{key: key, version: version}
+	o.size, _ = strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	o.modified, _ = http.ParseTime(h.Get("Last-Modified"))
+	return o
+}
+
+// objectName returns the URL of an object without the query of the ones
+// served over HTTP or HTTPS, so their extensions tell their formats.
+func objectName(uri string) string {
+	if scheme := remoteScheme(uri); scheme == "http" || scheme == "https" {
+		return strings.SplitN(uri, "?", 2)[0]
+	}
+	return uri
+}
+
+// checkedDir is the directory, in the cache set by SetRemoteCache, where the
+// files of each URL are listed with the time their objects were checked.
+const checkedDir = "checked"
+
+type checkedFiles struct {
+	Checked time.Time `json:"checked"`
+	Paths   []string  `json:"paths"`
+}
+
+func checkedPath(uri string) string {
+	sum := sha256.Sum256([]byte(uri))
+	return filepath.Join(remoteCache, checkedDir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// checkedObjects returns the files of the objects of a URL kept in the
+// cache, if they were checked less than the TTL ago.
+func checkedObjects(uri string) ([]string, bool) {
+	b, err := ioutil.ReadFile(checkedPath(uri))
+	if err != nil {
+		return nil, false
+	}
+	var c checkedFiles
+	if err := json.Unmarshal(b, &c); err != nil || time.Since(c.Checked) >= remoteTTL {
+		return nil, false
+	}
+	for _, p := range c.Paths {
+		if !fileExists(p) {
+			return nil, false
+		}
+	}
+	return c.Paths, true
+}
+
+// keepChecked records that the objects of a URL were just checked, which
+// is only an optimization, so errors are ignored.
+func keepChecked(uri string, paths []string) {
+	b, err := json.Marshal(checkedFiles{time.Now(), paths})
+	if err == nil {
+		writeAtomic(checkedPath(uri), b)
+	}
+}
+
+// fetchObject copies the contents of an object to a file at the given path,
+// which is replaced once it is complete.
+func fetchObject(store objectStore, bucket, key, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	r, err := store.open(bucket, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
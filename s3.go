@@ -0,0 +1,136 @@
+package csvql
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// awsMFAPrompt is whether the codes of the MFA devices of the AWS roles that
+// require them are asked for on the standard input.
+var awsMFAPrompt bool
+
+// SetAWSMFAPrompt sets whether the codes of the MFA devices of the AWS roles
+// assumed to read the objects in S3 are asked for on the standard input.
+// Without them, the profiles of roles requiring MFA can not be used.
+func SetAWSMFAPrompt(prompt bool) { awsMFAPrompt = prompt }
+
+// s3Store reads the objects in Amazon S3, or in a service compatible with it
+// at the endpoint given by AWS_ENDPOINT_URL, such as MinIO.
+type s3Store struct {
+	client *s3.S3
+}
+
+// newS3Store returns a store of the objects in S3, with the region and the
+// credentials found by the AWS SDK: in the environment, in the shared
+// configuration and credentials files for the profile given by AWS_PROFILE,
+// including the roles they assume and the processes they run, from web
+// identity tokens, or from the ECS and EC2 metadata endpoints.
+func newS3Store() (objectStore, error) {
+	opts := session.Options{SharedConfigState: session.SharedConfigEnable}
+	if awsMFAPrompt {
+		opts.AssumeRoleTokenProvider = stscreds.StdinTokenProvider
+	}
+	sess, err := session.NewSessionWithOptions(opts)
+	if _, ok := err.(session.AssumeRoleTokenProviderNotSetError); ok {
+		return nil, fmt.Errorf("could not read AWS configuration: the profile assumes a role with MFA, whose codes are not asked for unless enabled")
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read AWS configuration: %v", err)
+	}
+
+	cfg := &aws.Config{HTTPClient: &http.Client{Transport: s3Transport{}}}
+	if aws.StringValue(sess.Config.Region) == "" {
+		cfg.Region = aws.String("us-east-1")
+	}
+	// Requests are not signed without credentials, which is enough for
+	// public buckets.
+	if _, err := sess.Config.Credentials.Get(); err != nil {
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "NoCredentialProviders" {
+			return nil, fmt.Errorf("could not get AWS credentials: %v", err)
+		}
+		cfg.Credentials = credentials.AnonymousCredentials
+	}
+	// Buckets are addressed by host name in AWS, unless their names have
+	// dots, which their certificates do not cover, and by path elsewhere.
+	for _, env := range []string{"AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"} {
+		if e := os.Getenv(env); e != "" {
+			if u, err := url.Parse(e); err != nil || u.Host == "" {
+				return nil, fmt.Errorf("invalid %s %q", env, e)
+			}
+			cfg.Endpoint, cfg.S3ForcePathStyle = aws.String(e), aws.Bool(true)
+			break
+		}
+	}
+	return &s3Store{client: s3.New(sess, cfg)}, nil
+}
+
+// s3Transport sends the requests of the AWS SDK with sendRemote, so they use
+// the proxy and the timeouts of the other cloud storage services.
+type s3Transport struct{}
+
+func (s3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return sendRemote(req)
+}
+
+func (s *s3Store) list(bucket, prefix string) ([]object, error) {
+	var objects []object
+	in := &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix)}
+	err := s.client.ListObjectsV2Pages(in, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, c := range page.Contents {
+			objects = append(objects, object{
+				key:      aws.StringValue(c.Key),
+				version:  aws.StringValue(c.ETag),
+				size:     aws.Int64Value(c.Size),
+				modified: aws.TimeValue(c.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return objects, nil
+}
+
+func (s *s3Store) stat(bucket, key string) (object, error) {
+	out, err := s.client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return object{}, s3Error(err)
+	}
+	return object{
+		key:      key,
+		version:  aws.StringValue(out.ETag),
+		size:     aws.Int64Value(out.ContentLength),
+		modified: aws.TimeValue(out.LastModified),
+	}, nil
+}
+
+func (s *s3Store) open(bucket, key string) (io.ReadCloser, error) {
+	// Objects stored compressed are read as they are, not decompressed.
+	in := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	out, err := s.client.GetObjectWithContext(aws.BackgroundContext(), in,
+		request.WithSetRequestHeaders(map[string]string{"Accept-Encoding": "identity"}))
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return out.Body, nil
+}
+
+// s3Error returns the error of a request to S3, with the code and message of
+// the service, if it answered with them.
+func s3Error(err error) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		return fmt.Errorf("%s: %s", aerr.Code(), aerr.Message())
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package csvql
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testS3 serves the objects of a bucket as S3 does, recording the
+// authorization of the requests, and points the AWS configuration to it.
+func testS3(t *testing.T, objects map[string]string) *[]string {
+	t.Helper()
+	var auths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths = append(auths, r.Header.Get("Authorization"))
+		if r.URL.Path == "/bucket" || r.URL.Path == "/bucket/" {
+			var keys []string
+			for key := range objects {
+				if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			fmt.Fprint(w, "<ListBucketResult>")
+			for _, key := range keys {
+				fmt.Fprintf(w, "<Contents><Key>%s</Key><ETag>\"%d\"</ETag><Size>%d</Size><LastModified>2024-01-02T03:04:05.000Z</LastModified></Contents>", key, len(objects[key]), len(objects[key]))
+			}
+			fmt.Fprint(w, "<IsTruncated>false</IsTruncated></ListBucketResult>")
+			return
+		}
+		body, ok := objects[strings.TrimPrefix(r.URL.Path, "/bucket/")]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf("\"%d\"", len(body)))
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	for _, env := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE", "AWS_REGION", "AWS_ENDPOINT_URL_S3",
+		"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_CREDENTIALS_FULL_URI"} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", dir+"/credentials")
+	t.Setenv("AWS_CONFIG_FILE", dir+"/config")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	return &auths
+}
+
+func TestS3Credentials(t *testing.T) {
+	auths := testS3(t, map[string]string{"a.csv": "x\n1\n"})
+	if err := ioutil.WriteFile(os.Getenv("AWS_SHARED_CREDENTIALS_FILE"), []byte("[other]\naws_access_key_id = AKIDOTHER\naws_secret_access_key = secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"anonymous", nil, ""},
+		{"environment", map[string]string{"AWS_ACCESS_KEY_ID": "AKIDENV", "AWS_SECRET_ACCESS_KEY": "secret"}, "Credential=AKIDENV/"},
+		{"profile", map[string]string{"AWS_PROFILE": "other", "AWS_REGION": "eu-west-1"}, "Credential=AKIDOTHER/"},
+	}
+	for _, tt := range tests {
+		for k, v := range tt.env {
+			t.Setenv(k, v)
+		}
+		store, err := newS3Store()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		r, err := store.open("bucket", "a.csv")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || string(b) != "x\n1\n" {
+			t.Errorf("%s: got %q, %v", tt.name, b, err)
+		}
+		got := (*auths)[len(*auths)-1]
+		if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+			t.Errorf("%s: got authorization %q, want %q", tt.name, got, tt.want)
+		}
+		for k := range tt.env {
+			t.Setenv(k, "")
+		}
+	}
+
+	store, err := newS3Store()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.open("bucket", "missing.csv"); err == nil || !strings.Contains(err.Error(), "NoSuchKey") {
+		t.Errorf("reading a missing object: got %v, want NoSuchKey", err)
+	}
+}
+
+func TestS3Objects(t *testing.T) {
+	testS3(t, map[string]string{"logs/a.csv": "x\n1\n", "logs/b.csv": "x\n22\n", "other.csv": "x\n"})
+	store, err := newS3Store()
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, err := store.list("bucket", "logs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].key != "logs/a.csv" || objects[1].size != 5 || objects[1].version != `"5"` || objects[1].modified.Year() != 2024 {
+		t.Errorf("got objects %+v, want the ones in logs/", objects)
+	}
+	o, err := store.stat("bucket", "logs/b.csv")
+	if err != nil || o.size != 5 || o.version != `"5"` {
+		t.Errorf("got object %+v and error %v, want logs/b.csv", o, err)
+	}
+	if _, err := store.stat("bucket", "missing.csv"); err == nil || !strings.Contains(err.Error(), "NotFound") {
+		t.Errorf("got error %v for a missing object, want NotFound", err)
+	}
+}
+
+func TestS3MFA(t *testing.T) {
+	testS3(t, nil)
+	config := "[profile mfa]\nrole_arn = arn:aws:iam::123456789012:role/reader\nsource_profile = base\nmfa_serial = arn:aws:iam::123456789012:mfa/user\n"
+	if err := ioutil.WriteFile(os.Getenv("AWS_CONFIG_FILE"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(os.Getenv("AWS_SHARED_CREDENTIALS_FILE"), []byte("[base]\naws_access_key_id = AKIDBASE\naws_secret_access_key = secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_PROFILE", "mfa")
+	// The codes are not asked for on the standard input unless enabled.
+	if _, err := newS3Store(); err == nil || !strings.Contains(err.Error(), "MFA") {
+		t.Errorf("got error %v, want the one of the MFA codes not asked for", err)
+	}
+}
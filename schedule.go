@@ -0,0 +1,313 @@
+package csvql
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config holds the settings of a server that are read from a YAML file with
+// LoadConfig:
+//
+//	schedules:
+//	  - name: sales_by_region
+//	    cron: "0 2 * * *"
+//	    query: SELECT region, SUM(amount) FROM orders GROUP BY region
+//	    output: rollups/sales_by_region.csv
+type Config struct {
+	Schedules []*Schedule `yaml:"schedules"`
+}
+
+// Schedule is a query run periodically, whose results replace the contents
+// of a file or are appended to a table of the current database.
+type Schedule struct {
+	Name string `yaml:"name"`
+	// Cron is when the query runs, as a cron expression with five fields
+	// (minute, hour, day of month, month, and day of week) in the local time
+	// zone, or as @hourly, @daily, @weekly, @monthly, or @every duration.
+	Cron  string `yaml:"cron"`
+	Query string `yaml:"query"`
+	// Output is the file written with the results, in the format given by
+	// its extension.
+	Output string `yaml:"output,omitempty"`
+	// Append is the table the results are appended to.
+	Append string `yaml:"append,omitempty"`
+	// User is the user running the query, whose grants apply.
+	User string `yaml:"user,omitempty"`
+
+	spec *cronSpec
+}
+
+// LoadConfig reads the configuration in the given YAML file, checking that
+// the schedules are valid.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config: %v", err)
+	}
+	var c Config
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return nil, fmt.Errorf("could not parse config %s: %v", path, err)
+	}
+
+	for i, s := range c.Schedules {
+		if s.Name == "" {
+			return nil, fmt.Errorf("schedule %d in config %s has no name", i+1, path)
+		}
+		if s.Query == "" {
+			return nil, fmt.Errorf("schedule %s has no query", s.Name)
+		}
+		if (s.Output == "") == (s.Append == "") {
+			return nil, fmt.Errorf("schedule %s must have either an output or a table to append to", s.Name)
+		}
+		s.Append = strings.ToLower(s.Append)
+		if s.spec, err = parseCron(s.Cron); err != nil {
+			return nil, fmt.Errorf("could not parse cron of schedule %s: %v", s.Name, err)
+		}
+	}
+	return &c, nil
+}
+
+// RunSchedules runs each of the given schedules in its own goroutine, logging
+// what they write and the errors found.
+func (e *Engine) RunSchedules(schedules []*Schedule) {
+	for _, s := range schedules {
+		go func(s *Schedule) {
+			for {
+				time.Sleep(time.Until(s.spec.next(time.Now())))
+				n, err := e.RunSchedule(s)
+				if err != nil {
+					log.Printf("could not run schedule %s: %v", s.Name, err)
+					continue
+				}
+				log.Printf("schedule %s wrote %d rows", s.Name, n)
+			}
+		}(s)
+	}
+}
+
+// RunSchedule runs the query of a schedule once, returning how many rows
+// were written.
+func (e *Engine) RunSchedule(s *Schedule) (int64, error) {
+	ctx := sql.NewContext(context.Background(), sql.WithSession(sql.NewSession("", s.User)))
+	schema, rows, err := e.Query(ctx, s.Query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	if s.Append != "" {
+		return e.appendRows(s.Append, schema, rows)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.Output), 0755); err != nil {
+		return 0, fmt.Errorf("could not create %s: %v", s.Output, err)
+	}
+	// The results are written to a temporary file first, so readers of the
+	// output never see it half written.
+	f, err := tempFile("schedule")
+	if err != nil {
+		return 0, fmt.Errorf("could not create %s: %v", s.Output, err)
+	}
+	n, err := writeCompressedRows(f, s.Output, exportFormat(s.Output), "", schema, rows)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = moveFile(f.Name(), s.Output)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return 0, fmt.Errorf("could not write %s: %v", s.Output, err)
+	}
+	return n, nil
+}
+
+// appendRows appends all the rows to a table of the current database, in a
+// single commit.
+func (e *Engine) appendRows(name string, schema sql.Schema, rows sql.RowIter) (int64, error) {
+	db, err := e.csvDatabase()
+	if err != nil {
+		return 0, err
+	}
+	t, ok := db.Tables()[name].(*table)
+	if !ok || t.db == nil {
+		return 0, fmt.Errorf("table %s does not exist or is read only", name)
+	}
+	if len(schema) != len(t.schema) {
+		return 0, fmt.Errorf("could not append to %s: the query returns %d columns but the table has %d", name, len(schema), len(t.schema))
+	}
+
+	tx := &transaction{rows: make(map[*table][]sql.Row)}
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		tx.add(t, row)
+	}
+	if len(tx.tables) == 0 {
+		return 0, nil
+	}
+	return db.commit(tx)
+}
+
+// cronSpec is a parsed cron expression: the sets of values of each field
+// that match, as bit masks, or the interval between runs.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny are set for the day fields that match any day.
+	domAny, dowAny bool
+	every          time.Duration
+}
+
+var cronAliases = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+}
+
+func parseCron(s string) (*cronSpec, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(s, "@every ")))
+		if err != nil {
+			return nil, err
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("the interval must be at least a second")
+		}
+		return &cronSpec{every: d}, nil
+	}
+	if alias, ok := cronAliases[s]; ok {
+		s = alias
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d in %q", len(fields), s)
+	}
+	var spec cronSpec
+	bounds := []struct {
+		mask     *uint64
+		min, max int
+	}{
+		{&spec.minute, 0, 59},
+		{&spec.hour, 0, 23},
+		{&spec.dom, 1, 31},
+		{&spec.month, 1, 12},
+		{&spec.dow, 0, 7},
+	}
+	for i, f := range fields {
+		mask, err := parseCronField(f, bounds[i].min, bounds[i].max)
+		if err != nil {
+			return nil, err
+		}
+		*bounds[i].mask = mask
+	}
+	// Sunday is both 0 and 7.
+	if spec.dow&(1<<7) != 0 {
+		spec.dow |= 1
+	}
+	spec.domAny = fields[2] == "*"
+	spec.dowAny = fields[4] == "*"
+	return &spec, nil
+}
+
+// parseCronField parses a field made of comma separated values, ranges like
+// 1-5, or *, optionally followed by a step like */15.
+func parseCronField(f string, min, max int) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(f, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step, part = n, part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value %q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+// next returns the first time after t matching the spec.
+func (c *cronSpec) next(t time.Time) time.Time {
+	if c.every > 0 {
+		return t.Truncate(c.every).Add(c.every)
+	}
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every combination of days repeats within a few years.
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); t = t.Add(time.Minute) {
+		if c.month&(1<<uint(t.Month())) == 0 || !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 59, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) != 0 {
+			return t
+		}
+	}
+	return t
+}
+
+// matchDay reports whether the day of t matches the spec. As in cron, when
+// both the day of the month and of the week are restricted, either can match.
+func (c *cronSpec) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
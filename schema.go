@@ -0,0 +1,193 @@
+package csvql
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// TableSchema describes the columns of a table.
+// It can be read from a YAML or JSON file with LoadSchema.
+type TableSchema struct {
+	Columns []*ColumnSchema `yaml:"columns" json:"columns"`
+}
+
+// ColumnSchema describes a single column, and the constraints its values
+// must satisfy.
+type ColumnSchema struct {
+	Name    string `yaml:"name" json:"name"`
+	Type    string `yaml:"type,omitempty" json:"type,omitempty"`
+	NotNull bool   `yaml:"not_null,omitempty" json:"not_null,omitempty"`
+	Unique  bool   `yaml:"unique,omitempty" json:"unique,omitempty"`
+	Pattern string `yaml:"pattern,omitempty" json:"pattern,omitempty"`
+	// Format is the layout used to parse dates and timestamps, as accepted by
+	// time.Parse, or with the directives of strftime, such as %d/%m/%Y. It
+	// defaults to the MySQL formats.
+	Format string `yaml:"format,omitempty" json:"format,omitempty"`
+	// TrueValues and FalseValues are the words accepted in boolean columns,
+	// compared case insensitively. They default to true/false, t/f, yes/no,
+	// y/n, on/off, and 1/0.
+	TrueValues  []string `yaml:"true_values,omitempty" json:"true_values,omitempty"`
+	FalseValues []string `yaml:"false_values,omitempty" json:"false_values,omitempty"`
+	// Symbols is set for numeric columns whose values can contain currency
+	// symbols and thousands separators, like $1,234.50, or end with a
+	// percent sign, which divides them by 100.
+	Symbols bool `yaml:"symbols,omitempty" json:"symbols,omitempty"`
+	// NaNAsNull is set for float columns where NaN values mean NULL, so they
+	// are ignored by aggregations. Scientific notation and infinities are
+	// always accepted.
+	NaNAsNull bool `yaml:"nan_as_null,omitempty" json:"nan_as_null,omitempty"`
+	// Locale is the locale the numbers of numeric columns are written in,
+	// such as de for 1.234,56.
+	Locale string `yaml:"locale,omitempty" json:"locale,omitempty"`
+	// References declares a foreign key, as table.column, to the column
+	// holding the values this one refers to.
+	References string `yaml:"references,omitempty" json:"references,omitempty"`
+	// Expression computes the values of a column that is not in the files
+	// from the ones of the columns before it, such as price * qty, while the
+	// rows are read. They are converted to its Type, if given.
+	Expression string `yaml:"expression,omitempty" json:"expression,omitempty"`
+}
+
+// format returns the format of the values in the column.
+func (c *ColumnSchema) format() valueFormat {
+	return valueFormat{
+		layout:      c.Format,
+		trueValues:  c.TrueValues,
+		falseValues: c.FalseValues,
+		symbols:     c.Symbols,
+		nanAsNull:   c.NaNAsNull,
+		locale:      c.Locale,
+	}
+}
+
+// LoadSchema reads a table schema from the given YAML or JSON file.
+func LoadSchema(path string) (*TableSchema, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read schema: %v", err)
+	}
+
+	// JSON is a subset of YAML, so the YAML decoder handles both.
+	var s TableSchema
+	if err := yaml.UnmarshalStrict(b, &s); err != nil {
+		return nil, fmt.Errorf("could not parse schema %s: %v", path, err)
+	}
+	for i, col := range s.Columns {
+		if col.Name == "" {
+			return nil, fmt.Errorf("column %d in schema %s has no name", i+1, path)
+		}
+		if _, err := ParseType(col.Type); err != nil {
+			return nil, fmt.Errorf("column %s in schema %s: %v", col.Name, path, err)
+		}
+		if col.Format, err = dateLayout(col.Format); err != nil {
+			return nil, fmt.Errorf("column %s in schema %s: %v", col.Name, path, err)
+		}
+		if col.Locale != "" {
+			l, err := parseLocale(col.Locale)
+			if err != nil {
+				return nil, fmt.Errorf("column %s in schema %s: %v", col.Name, path, err)
+			}
+			col.Locale = l
+		}
+	}
+	return &s, nil
+}
+
+// Column returns the schema of the column with the given name, or nil if
+// there is none.
+func (s *TableSchema) Column(name string) *ColumnSchema {
+	for _, col := range s.Columns {
+		if col.Name == name {
+			return col
+		}
+	}
+	return nil
+}
+
+// WriteFile writes the schema as YAML to the given path, with a comment
+// describing its origin at the top.
+func (s *TableSchema) WriteFile(path, comment string) error {
+	b, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if comment != "" {
+		b = append([]byte("# "+comment+"\n"), b...)
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// tableSchemas are the schemas of the tables of new databases, by name.
+var tableSchemas = map[string]*TableSchema{}
+
+// SetSchema sets the schema of the tables with the given name of the
+// databases created afterwards to the one in a YAML or JSON file, as read by
+// LoadSchema, instead of inferring the types of their columns, so they do not
+// change with the rows of their files. The tables must be read from delimited
+// or JSON lines files.
+func SetSchema(table, path string) error {
+	s, err := LoadSchema(path)
+	if err != nil {
+		return err
+	}
+	tableSchemas[strings.ToLower(table)] = s
+	return nil
+}
+
+// setSchema sets the schema of a table given by its schema option, a schema
+// file.
+func (d *Dialect) setSchema(opts map[string]string) error {
+	path, ok := opts["schema"]
+	if !ok {
+		return nil
+	}
+	if !d.lines() {
+		return fmt.Errorf("%s files have typed columns", d.Format)
+	}
+	s, err := LoadSchema(path)
+	if err != nil {
+		return err
+	}
+	d.Schema = s
+	return nil
+}
+
+// useSchema sets the columns of a table read from text files to the ones of
+// a schema. They must be the same as the ones of the files, which are named
+// by the schema if they have no header, besides the computed ones.
+func (t *table) useSchema(s *TableSchema) error {
+	var stored []*ColumnSchema
+	for _, col := range s.Columns {
+		if col.Expression == "" {
+			stored = append(stored, col)
+		}
+	}
+	if t.dialect.delimited() && t.dialect.NoHeader && t.dialect.Columns == nil {
+		if len(stored) != len(t.schema) {
+			return fmt.Errorf("could not use the schema of table %s: it has %d columns, and the files %d", t.name, len(stored), len(t.schema))
+		}
+		for i, col := range stored {
+			t.schema[i].Name = strings.ToLower(col.Name)
+		}
+	}
+	cols := make([]*ColumnSchema, len(t.schema))
+	for _, col := range stored {
+		i := sql.Schema(t.schema).IndexOf(strings.ToLower(col.Name), t.name)
+		if i < 0 {
+			return fmt.Errorf("could not use the schema of table %s: its files have no column %s", t.name, col.Name)
+		}
+		cols[i] = col
+	}
+	for i, col := range cols {
+		if col == nil {
+			return fmt.Errorf("could not use the schema of table %s: column %s is not in it", t.name, t.schema[i].Name)
+		}
+		t.setColumnType(i, col)
+		t.schema[i].Nullable = !col.NotNull
+	}
+	return t.addComputedColumns(s)
+}
@@ -0,0 +1,125 @@
+package csvql
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// schemaCacheExt is the extension of the files keeping the types inferred for
+// the columns of the tables, next to their first file.
+const schemaCacheExt = ".csvql-schema"
+
+// schemaCacheVersion is the version of the inference of the schemas kept in
+// the sidecar files, whose previous ones are inferred again.
+const schemaCacheVersion = 2
+
+// tableSchemaCache tells whether the types inferred for the tables of new
+// databases are kept in sidecar files.
+var tableSchemaCache = true
+
+// SetSchemaCache sets whether the types inferred for the columns of the
+// delimited and JSON lines files of the databases created afterwards are kept
+// next to them, in a .csvql-schema file with the number of rows they were
+// inferred from, so they are not inferred again until the files, or the way
+// they are read, change. It is enabled by default.
+func SetSchemaCache(enabled bool) {
+	tableSchemaCache = enabled
+}
+
+// cachedSchema is the schema inferred for a table, the state of its files
+// and the dialect they were read with when it was, and the rows read to infer
+// it.
+type cachedSchema struct {
+	Version int             `json:"version"`
+	Files   []fileState     `json:"files"`
+	Dialect json.RawMessage `json:"dialect"`
+	// Rows is the number of rows read, which are all the ones of the files
+	// if Complete is set.
+	Rows     int64        `json:"rows"`
+	Complete bool         `json:"complete"`
+	Schema   *TableSchema `json:"schema"`
+}
+
+// schemaCachePath returns the path of the file keeping the types inferred for
+// the columns of a table whose first file is the given one: the same path with
+// the extensions replaced by .csvql-schema.
+func schemaCachePath(path string) string {
+	path, _ = SplitCompression(path)
+	return strings.TrimSuffix(path, filepath.Ext(path)) + schemaCacheExt
+}
+
+// sameColumns reports whether a schema has the columns of a table.
+func sameColumns(s *TableSchema, schema []*sql.Column) bool {
+	if len(s.Columns) != len(schema) {
+		return false
+	}
+	for i, col := range s.Columns {
+		if col.Name != schema[i].Name {
+			return false
+		}
+	}
+	return true
+}
+
+// cachedInference returns the schema inferred for a table, reusing the one
+// kept next to its files if they did not change since, and keeping it
+// otherwise.
+func (t *table) cachedInference(opts InferOptions) (*TableSchema, error) {
+	if !tableSchemaCache || t.archived() {
+		s, _, err := inferSchema(sql.NewEmptyContext(), t, opts)
+		return s, err
+	}
+	states, serr := fileStates(t)
+	dialect, derr := json.Marshal(t.dialect)
+	path := schemaCachePath(t.path)
+	if serr == nil && derr == nil {
+		if b, err := ioutil.ReadFile(path); err == nil {
+			var cached cachedSchema
+			var kept bytes.Buffer
+			if err := json.Unmarshal(b, &cached); err == nil && cached.Version == schemaCacheVersion && cached.Schema != nil && json.Compact(&kept, cached.Dialect) == nil &&
+				sameColumns(cached.Schema, t.schema) && bytes.Equal(kept.Bytes(), dialect) && sameFiles(cached.Files, states) {
+				return cached.Schema, nil
+			}
+		}
+	}
+
+	s, stats, err := inferSchema(sql.NewEmptyContext(), t, opts)
+	if err != nil || serr != nil || derr != nil {
+		return s, err
+	}
+	b, err := json.MarshalIndent(&cachedSchema{schemaCacheVersion, states, dialect, stats.rows, stats.complete, s}, "", "  ")
+	if err != nil {
+		return s, nil
+	}
+	// The types are inferred again next time if they can not be kept, such
+	// as when the directory is read only.
+	_ = writeAtomic(path, b)
+	return s, nil
+}
+
+// cachedRows returns the number of rows of a table, if the types inferred for
+// its columns were kept after reading all of them and its files did not
+// change since.
+func (t *table) cachedRows() (int64, bool) {
+	if t.archived() {
+		return 0, false
+	}
+	b, err := ioutil.ReadFile(schemaCachePath(t.path))
+	if err != nil {
+		return 0, false
+	}
+	var cached cachedSchema
+	if err := json.Unmarshal(b, &cached); err != nil || !cached.Complete {
+		return 0, false
+	}
+	states, err := fileStates(t)
+	if err != nil || !sameFiles(cached.Files, states) {
+		return 0, false
+	}
+	return cached.Rows, true
+}
@@ -0,0 +1,225 @@
+package csvql
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"gopkg.in/src-d/go-mysql-server.v0/server"
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-vitess.v0/mysql"
+	"gopkg.in/src-d/go-vitess.v0/sqltypes"
+	"gopkg.in/src-d/go-vitess.v0/vt/proto/query"
+)
+
+// rowsBatch is the number of rows sent to the client at once.
+const rowsBatch = 100
+
+// ServerOptions configures the behavior of a server.
+type ServerOptions struct {
+	// MaxResultRows and MaxResultBytes limit the number of rows, and bytes
+	// in their values, returned by each statement. Zero means no limit.
+	MaxResultRows  int64
+	MaxResultBytes int64
+	// SQLMode is the initial sql_mode of every session, such as ANSI_QUOTES.
+	SQLMode string
+}
+
+// NewServer returns a MySQL server running the queries it receives with the
+// given engine.
+func NewServer(cfg server.Config, e *Engine, opts ServerOptions) (*server.Server, error) {
+	var tracer opentracing.Tracer = opentracing.NoopTracer{}
+	if cfg.Tracer != nil {
+		tracer = cfg.Tracer
+	}
+
+	builder := func(c *mysql.Conn, addr string) sql.Session {
+		s := server.DefaultSessionBuilder(c, addr)
+		if opts.SQLMode != "" {
+			s.Set("sql_mode", sql.Text, opts.SQLMode)
+		}
+		return s
+	}
+	sm := server.NewSessionManager(builder, tracer, cfg.Address)
+	h := &handler{
+		Handler:    server.NewHandler(e.Engine, sm),
+		sm:         sm,
+		e:          e,
+		opts:       opts,
+		executions: new(executions),
+	}
+	nl, err := net.Listen(cfg.Protocol, cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	l, err := mysql.NewFromListener(&stmtListener{nl, h.executions}, cfg.Auth, h)
+	if err != nil {
+		return nil, err
+	}
+	return &server.Server{Listener: l}, nil
+}
+
+// handler runs queries with a csvql engine, relying on the go-mysql-server
+// handler for everything else.
+type handler struct {
+	*server.Handler
+	sm         *server.SessionManager
+	e          *Engine
+	opts       ServerOptions
+	executions *executions
+}
+
+// NewConnection counts the connection in the status of the engine.
+func (h *handler) NewConnection(c *mysql.Conn) {
+	h.e.status.connections.Add(1)
+	h.Handler.NewConnection(c)
+}
+
+// ConnectionClosed closes the cursors left open by the connection, and
+// discards its transaction in progress.
+func (h *handler) ConnectionClosed(c *mysql.Conn) {
+	h.e.status.closed.Add(1)
+	if ctx, done, err := h.sm.NewContext(c); err == nil {
+		h.e.CloseCursors(ctx.Session)
+		h.e.Rollback(ctx.Session)
+		done()
+	}
+	h.Handler.ConnectionClosed(c)
+}
+
+var killQuery = regexp.MustCompile(`^kill\s`)
+
+// ComQuery executes a query, sending its results to the callback in batches.
+func (h *handler) ComQuery(c *mysql.Conn, q string, callback func(*sqltypes.Result) error) error {
+	if killQuery.MatchString(strings.ToLower(q)) {
+		return h.Handler.ComQuery(c, q, callback)
+	}
+
+	ctx, done, err := h.sm.NewContext(c)
+	if err != nil {
+		return err
+	}
+	if ctx.Session.User() != c.User {
+		// Sessions are created before the handshake, when the user is not
+		// known yet, so the session is created again once it is.
+		done()
+		h.sm.NewSession(c)
+		if ctx, done, err = h.sm.NewContext(c); err != nil {
+			return err
+		}
+	}
+	defer done()
+
+	// Prepared statements are run with their placeholders bound to the
+	// values of their parameters.
+	run := q
+	if params, ok := h.executions.take(c.ConnectionID); ok {
+		ctx = withParams(ctx, params)
+		run = paramCalls(q)
+	}
+
+	h.e.status.questions.Add(1)
+	start := time.Now()
+	n, err := h.query(ctx, run, callback)
+	entry := &QueryLogEntry{
+		Time:     start,
+		User:     c.User,
+		Query:    q,
+		Duration: float64(time.Since(start)) / float64(time.Millisecond),
+		Rows:     n,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if lerr := h.e.log.add(entry); lerr != nil {
+		log.Printf("could not log query: %v", lerr)
+	}
+	return err
+}
+
+// query runs a query, sending its results to the callback in batches, and
+// returns the number of rows sent.
+func (h *handler) query(ctx *sql.Context, q string, callback func(*sqltypes.Result) error) (int64, error) {
+	schema, rows, err := h.e.Query(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	// With limits, rows are not sent until the statement finishes or goes
+	// over them, since an error can not be sent once rows have been.
+	limited := h.opts.MaxResultRows > 0 || h.opts.MaxResultBytes > 0
+	var total, size int64
+
+	r := &sqltypes.Result{Fields: schemaToFields(schema)}
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return total, err
+		}
+
+		values := rowToSQL(schema, row)
+		total++
+		for _, v := range values {
+			size += int64(v.Len())
+		}
+		if max := h.opts.MaxResultRows; max > 0 && total > max {
+			return total, fmt.Errorf("result exceeds the limit of %d rows", max)
+		}
+		if max := h.opts.MaxResultBytes; max > 0 && size > max {
+			return total, fmt.Errorf("result exceeds the limit of %d bytes", max)
+		}
+
+		r.Rows = append(r.Rows, values)
+		r.RowsAffected++
+		if r.RowsAffected == rowsBatch && !limited {
+			if err := callback(r); err != nil {
+				return total, err
+			}
+			r = &sqltypes.Result{Fields: r.Fields}
+		}
+	}
+
+	// The last batch is always sent, even if empty, so the client knows the
+	// query has finished.
+	return total, callback(r)
+}
+
+func rowToSQL(s sql.Schema, row sql.Row) []sqltypes.Value {
+	o := make([]sqltypes.Value, len(row))
+	for i, v := range row {
+		// The SQL method of the number types truncates floats to integers,
+		// so they are formatted as MySQL does, with as many digits as needed.
+		switch f := v.(type) {
+		case nil:
+			o[i] = sqltypes.NULL
+		case float32:
+			o[i] = sqltypes.MakeTrusted(s[i].Type.Type(), strconv.AppendFloat(nil, float64(f), 'f', -1, 32))
+		case float64:
+			o[i] = sqltypes.MakeTrusted(s[i].Type.Type(), strconv.AppendFloat(nil, f, 'f', -1, 64))
+		default:
+			o[i] = s[i].Type.SQL(v)
+		}
+	}
+	return o
+}
+
+func schemaToFields(s sql.Schema) []*query.Field {
+	fields := make([]*query.Field, len(s))
+	for i, c := range s {
+		fields[i] = &query.Field{
+			Name: c.Name,
+			Type: c.Type.Type(),
+		}
+	}
+	return fields
+}
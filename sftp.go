@@ -0,0 +1,545 @@
+package csvql
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshKey is the private key authenticating to SFTP servers, besides the
+// default ones, if set.
+var sshKey string
+
+// SetSSHKey sets the private key authenticating to the SFTP servers the
+// tables are read from, instead of the ones in the SSH agent and in ~/.ssh.
+func SetSSHKey(path string) error {
+	if _, err := ioutil.ReadFile(path); err != nil {
+		return fmt.Errorf("could not read SSH key: %v", err)
+	}
+	sshKey = path
+	return nil
+}
+
+// sftpStore reads the files on SFTP servers, whose "buckets" are the users
+// and hosts of the URLs, as in sftp://user@host:2222/path. It authenticates
+// with keys, as ssh does: the ones in the SSH agent at SSH_AUTH_SOCK and the
+// default ones in ~/.ssh, or the one set with SetSSHKey. The keys of the
+// servers must be in ~/.ssh/known_hosts.
+type sftpStore struct {
+	mu      sync.Mutex
+	clients map[string]*sftpClient
+}
+
+func newSFTPStore() (objectStore, error) {
+	return &sftpStore{clients: make(map[string]*sftpClient)}, nil
+}
+
+// sftpPath returns the path of a file on the server given its key, which is
+// absolute unless it starts with ~/, in the home directory of the user.
+func sftpPath(key string) string {
+	if strings.HasPrefix(key, "~/") {
+		return key[2:]
+	}
+	return "/" + key
+}
+
+func (s *sftpStore) list(host, prefix string) ([]object, error) {
+	c, err := s.client(host)
+	if err != nil {
+		return nil, err
+	}
+	// The wildcards can match any file in the directory of the prefix, and
+	// in its subdirectories starting with it.
+	dir := path.Dir(prefix)
+	if !strings.Contains(prefix, "/") {
+		dir = ""
+	}
+	var objects []object
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		p := sftpPath(dir)
+		if dir == "" {
+			p = "/"
+		} else if dir == "~" {
+			p = "."
+		}
+		entries, err := c.readDir(p)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			key := e.name
+			if dir != "" {
+				key = dir + "/" + e.name
+			}
+			if !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key+"/") {
+				continue
+			}
+			if e.attrs.dir() {
+				if err := walk(key); err != nil {
+					return err
+				}
+			} else if e.attrs.regular() {
+				objects = append(objects, object{key: key, version: e.attrs.version()})
+			}
+		}
+		return nil
+	}
+	if err := walk(strings.TrimSuffix(dir, "/")); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func (s *sftpStore) stat(host, key string) (object, error) {
+	c, err := s.client(host)
+	if err != nil {
+		return object{}, err
+	}
+	attrs, err := c.stat(sftpPath(key))
+	if err != nil {
+		return object{}, err
+	}
+	return object{key: key, version: attrs.version()}, nil
+}
+
+func (s *sftpStore) open(host, key string) (io.ReadCloser, error) {
+	c, err := s.client(host)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := c.openFile(sftpPath(key))
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFile{c: c, handle: handle}, nil
+}
+
+// Close closes the connections to the servers.
+func (s *sftpStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for host, c := range s.clients {
+		c.conn.Close()
+		delete(s.clients, host)
+	}
+	return nil
+}
+
+// client returns the client of the server of a host, such as user@host:22,
+// connecting to it if needed.
+func (s *sftpStore) client(host string) (*sftpClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.clients[host]; ok {
+		return c, nil
+	}
+	c, err := dialSFTP(host)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %v", host, err)
+	}
+	s.clients[host] = c
+	return c, nil
+}
+
+// dialSFTP connects to the SFTP server of a host, as the user it is prefixed
+// with, or the current one.
+func dialSFTP(host string) (*sftpClient, error) {
+	name := ""
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		name, host = host[:at], host[at+1:]
+	}
+	if name == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		name = u.Username
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "22")
+	}
+
+	home, _ := os.UserHomeDir()
+	known := filepath.Join(home, ".ssh", "known_hosts")
+	hostKeys, err := knownhosts.New(known)
+	if err != nil {
+		return nil, fmt.Errorf("could not read known hosts: %v", err)
+	}
+	signers, closeAgent := sshSigners(home)
+	defer closeAgent()
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no SSH keys found, in the SSH agent nor in %s", filepath.Join(home, ".ssh"))
+	}
+	conn, err := ssh.Dial("tcp", host, &ssh.ClientConfig{
+		User:            name,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: hostKeys,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c, err := newSFTPClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// sshSigners returns the keys authenticating to the servers, and a function
+// closing the connection to the SSH agent once they are used. Keys
+// protected by passphrases are skipped, since they must be added to the
+// agent instead.
+func sshSigners(home string) ([]ssh.Signer, func()) {
+	var signers []ssh.Signer
+	closeAgent := func() {}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			closeAgent = func() { conn.Close() }
+			if s, err := agent.NewClient(conn).Signers(); err == nil {
+				signers = append(signers, s...)
+			}
+		}
+	}
+	paths := []string{sshKey}
+	if sshKey == "" {
+		paths = nil
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			paths = append(paths, filepath.Join(home, ".ssh", name))
+		}
+	}
+	for _, p := range paths {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		if s, err := ssh.ParsePrivateKey(b); err == nil {
+			signers = append(signers, s)
+		}
+	}
+	return signers, closeAgent
+}
+
+// The types of the packets of the SFTP protocol, version 3, used by
+// sftpClient.
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpOpenDir  = 11
+	sftpReadDir  = 12
+	sftpStat     = 17
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpName     = 104
+	sftpAttrs    = 105
+	sftpEOF      = 1
+	sftpReadFlag = 1
+)
+
+// sftpChunk is the number of bytes read from a file by each request, the
+// largest every server accepts.
+const sftpChunk = 32 << 10
+
+// sftpClient reads files with the SFTP protocol, sending one request at a
+// time.
+type sftpClient struct {
+	conn *ssh.Client
+	mu   sync.Mutex
+	w    io.Writer
+	r    io.Reader
+	id   uint32
+}
+
+func newSFTPClient(conn *ssh.Client) (*sftpClient, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return nil, fmt.Errorf("could not start SFTP: %v", err)
+	}
+	c := &sftpClient{conn: conn, w: w, r: r}
+
+	var b sftpBuffer
+	b.putByte(sftpInit)
+	b.putUint32(3)
+	if err := c.send(b); err != nil {
+		return nil, err
+	}
+	typ, _, err := c.receive()
+	if err != nil {
+		return nil, err
+	}
+	if typ != sftpVersion {
+		return nil, fmt.Errorf("unexpected SFTP packet %d", typ)
+	}
+	return c, nil
+}
+
+// sftpBuffer is a packet of the protocol being written or read.
+type sftpBuffer []byte
+
+func (b *sftpBuffer) putByte(v byte) { *b = append(*b, v) }
+func (b *sftpBuffer) putUint32(v uint32) {
+	*b = append(*b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+func (b *sftpBuffer) putUint64(v uint64) { b.putUint32(uint32(v >> 32)); b.putUint32(uint32(v)) }
+func (b *sftpBuffer) putString(s string) { b.putUint32(uint32(len(s))); *b = append(*b, s...) }
+
+func (b *sftpBuffer) readUint32() uint32 {
+	if len(*b) < 4 {
+		*b = nil
+		return 0
+	}
+	v := binary.BigEndian.Uint32(*b)
+	*b = (*b)[4:]
+	return v
+}
+
+func (b *sftpBuffer) readUint64() uint64 {
+	return uint64(b.readUint32())<<32 | uint64(b.readUint32())
+}
+
+func (b *sftpBuffer) readString() string {
+	n := b.readUint32()
+	if uint32(len(*b)) < n {
+		*b = nil
+		return ""
+	}
+	s := string((*b)[:n])
+	*b = (*b)[n:]
+	return s
+}
+
+// sftpAttributes are the attributes of a file the client uses.
+type sftpAttributes struct {
+	size        uint64
+	mode, mtime uint32
+}
+
+func (b *sftpBuffer) readAttrs() sftpAttributes {
+	var a sftpAttributes
+	flags := b.readUint32()
+	if flags&0x1 != 0 {
+		a.size = b.readUint64()
+	}
+	if flags&0x2 != 0 {
+		b.readUint32() // uid
+		b.readUint32() // gid
+	}
+	if flags&0x4 != 0 {
+		a.mode = b.readUint32()
+	}
+	if flags&0x8 != 0 {
+		b.readUint32() // atime
+		a.mtime = b.readUint32()
+	}
+	if flags&0x80000000 != 0 {
+		for n := b.readUint32(); n > 0 && len(*b) > 0; n-- {
+			b.readString()
+			b.readString()
+		}
+	}
+	return a
+}
+
+func (a sftpAttributes) dir() bool     { return a.mode&0170000 == 0040000 }
+func (a sftpAttributes) regular() bool { return a.mode&0170000 == 0100000 }
+
+// version tells whether a file changed, by its size and modification time.
+func (a sftpAttributes) version() string {
+	return strconv.FormatUint(a.size, 10) + "-" + strconv.FormatUint(uint64(a.mtime), 10)
+}
+
+func (c *sftpClient) send(b sftpBuffer) error {
+	var length sftpBuffer
+	length.putUint32(uint32(len(b)))
+	_, err := c.w.Write(append(length, b...))
+	return err
+}
+
+func (c *sftpClient) receive() (byte, sftpBuffer, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(c.r, length[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n == 0 || n > 1<<20 {
+		return 0, nil, fmt.Errorf("invalid SFTP packet of %d bytes", n)
+	}
+	b := make(sftpBuffer, n)
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		return 0, nil, err
+	}
+	return b[0], b[1:], nil
+}
+
+// request sends a request of the given type, whose arguments are written by
+// args, and returns its response, or the error of its status.
+func (c *sftpClient) request(typ byte, args func(b *sftpBuffer)) (byte, sftpBuffer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.id++
+	var b sftpBuffer
+	b.putByte(typ)
+	b.putUint32(c.id)
+	args(&b)
+	if err := c.send(b); err != nil {
+		return 0, nil, err
+	}
+	typ, res, err := c.receive()
+	if err != nil {
+		return 0, nil, err
+	}
+	if id := res.readUint32(); id != c.id {
+		return 0, nil, fmt.Errorf("unexpected SFTP response %d to request %d", id, c.id)
+	}
+	if typ == sftpStatus {
+		code := res.readUint32()
+		if code == sftpEOF {
+			return typ, nil, io.EOF
+		}
+		msg := res.readString()
+		if code == 2 {
+			return typ, nil, os.ErrNotExist
+		}
+		if msg == "" {
+			msg = fmt.Sprintf("SFTP error %d", code)
+		}
+		return typ, nil, fmt.Errorf("%s", msg)
+	}
+	return typ, res, nil
+}
+
+func (c *sftpClient) handle(typ byte, p string) (string, error) {
+	res, b, err := c.request(typ, func(b *sftpBuffer) {
+		b.putString(p)
+		if typ == sftpOpen {
+			b.putUint32(sftpReadFlag)
+			b.putUint32(0) // no attributes
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if res != sftpHandle {
+		return "", fmt.Errorf("unexpected SFTP packet %d", res)
+	}
+	return b.readString(), nil
+}
+
+func (c *sftpClient) openFile(p string) (string, error) { return c.handle(sftpOpen, p) }
+
+func (c *sftpClient) closeHandle(handle string) error {
+	_, _, err := c.request(sftpClose, func(b *sftpBuffer) { b.putString(handle) })
+	return err
+}
+
+func (c *sftpClient) stat(p string) (sftpAttributes, error) {
+	res, b, err := c.request(sftpStat, func(b *sftpBuffer) { b.putString(p) })
+	if err != nil {
+		return sftpAttributes{}, err
+	}
+	if res != sftpAttrs {
+		return sftpAttributes{}, fmt.Errorf("unexpected SFTP packet %d", res)
+	}
+	return b.readAttrs(), nil
+}
+
+// sftpEntry is a file in a directory.
+type sftpEntry struct {
+	name  string
+	attrs sftpAttributes
+}
+
+func (c *sftpClient) readDir(p string) ([]sftpEntry, error) {
+	handle, err := c.handle(sftpOpenDir, p)
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeHandle(handle)
+	var entries []sftpEntry
+	for {
+		res, b, err := c.request(sftpReadDir, func(b *sftpBuffer) { b.putString(handle) })
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if res != sftpName {
+			return nil, fmt.Errorf("unexpected SFTP packet %d", res)
+		}
+		for n := b.readUint32(); n > 0 && len(b) > 0; n-- {
+			name := b.readString()
+			b.readString() // long name
+			attrs := b.readAttrs()
+			if name != "." && name != ".." {
+				entries = append(entries, sftpEntry{name, attrs})
+			}
+		}
+	}
+}
+
+// sftpFile reads the contents of a file on the server.
+type sftpFile struct {
+	c      *sftpClient
+	handle string
+	offset uint64
+	buf    []byte
+	eof    bool
+}
+
+func (f *sftpFile) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		if f.eof {
+			return 0, io.EOF
+		}
+		res, b, err := f.c.request(sftpRead, func(b *sftpBuffer) {
+			b.putString(f.handle)
+			b.putUint64(f.offset)
+			b.putUint32(sftpChunk)
+		})
+		if err == io.EOF {
+			f.eof = true
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		if res != sftpData {
+			return 0, fmt.Errorf("unexpected SFTP packet %d", res)
+		}
+		f.buf = []byte(b.readString())
+		f.offset += uint64(len(f.buf))
+	}
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+func (f *sftpFile) Close() error { return f.c.closeHandle(f.handle) }
@@ -0,0 +1,115 @@
+package csvql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// tableSkipRows and tableSkipFooter are the number of lines skipped at the
+// start and the end of the text files of new databases.
+var (
+	tableSkipRows   int
+	tableSkipFooter int
+)
+
+// SetSkipRows sets the number of lines skipped at the start of the text files
+// of the databases created afterwards, before their headers, such as the
+// titles of reports.
+func SetSkipRows(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid number of rows to skip %d", n)
+	}
+	tableSkipRows = n
+	return nil
+}
+
+// SetSkipFooter sets the number of lines skipped at the end of the text files
+// of the databases created afterwards, such as totals, which makes them read
+// only.
+func SetSkipFooter(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid number of rows to skip %d", n)
+	}
+	tableSkipFooter = n
+	return nil
+}
+
+// setSkips sets the number of lines skipped at the start and the end of the
+// files, given by the skip-rows and skip-footer options of a table or by
+// SetSkipRows and SetSkipFooter.
+func (d *Dialect) setSkips(opts map[string]string) error {
+	if d.text() {
+		d.SkipRows, d.SkipFooter = tableSkipRows, tableSkipFooter
+	}
+	for opt, n := range map[string]*int{"skip-rows": &d.SkipRows, "skip-footer": &d.SkipFooter} {
+		s, ok := opts[opt]
+		if !ok {
+			continue
+		}
+		if !d.text() {
+			return fmt.Errorf("%s files have no lines to skip", d.Format)
+		}
+		v, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || v < 0 {
+			return fmt.Errorf("invalid %s %q, expected a number of rows", opt, s)
+		}
+		*n = v
+	}
+	return nil
+}
+
+// skipStart discards the byte order mark and the lines skipped at the start of
+// br, and returns their length.
+func (d Dialect) skipStart(br *bufio.Reader) int64 {
+	n := stripBOM(br)
+	for i := 0; i < d.SkipRows; i++ {
+		line, err := br.ReadString('\n')
+		n += int64(len(line))
+		if err != nil {
+			break
+		}
+	}
+	return n
+}
+
+// footerReader reads the lines of a file but the last n ones with some text,
+// so they are not read as rows.
+type footerReader struct {
+	io.ReadCloser
+	lr *lineReader
+	n  int
+	// lines are the lines read but not returned yet, of which held have some
+	// text, and buf the rest of the one being returned.
+	lines []string
+	held  int
+	buf   string
+}
+
+func newFooterReader(r io.ReadCloser, n int) *footerReader {
+	return &footerReader{ReadCloser: r, lr: &lineReader{r: bufio.NewReader(r)}, n: n}
+}
+
+func (r *footerReader) Read(b []byte) (int, error) {
+	for r.buf == "" {
+		for r.held <= r.n {
+			line, err := r.lr.readLine()
+			if err != nil {
+				return 0, err
+			}
+			r.lines = append(r.lines, line)
+			if trimEOL(line) != "" {
+				r.held++
+			}
+		}
+		r.buf, r.lines = r.lines[0], r.lines[1:]
+		if trimEOL(r.buf) != "" {
+			r.held--
+		}
+	}
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,227 @@
+package csvql
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// sniffSample is the number of bytes at the start of delimited files whose
+// lines are used to guess how they are written.
+const sniffSample = 64 << 10
+
+// sniffDelimiters are the delimiters that can be guessed, after the one of
+// the format, in order of preference.
+var sniffDelimiters = []string{",", "\t", ";", "|"}
+
+// sniffConsistency is the fraction of the lines that must have the same
+// number of delimiters to guess one other than the one of the format.
+const sniffConsistency = 0.9
+
+// sniffDialect guesses the delimiter, the quote, whether the first row has
+// the names of the columns, and the line endings of a delimited file, as
+// Python's csv.Sniffer does. The features given are kept as they are.
+func sniffDialect(path string, d Dialect, given map[string]bool) Dialect {
+	lines, cr := sampleLines(path, d)
+	if len(lines) == 0 {
+		return d
+	}
+	if cr {
+		d.LineEnd = "\r"
+	}
+	if !given["delimiter"] {
+		d.Delimiter = sniffDelimiter(lines, d.Delimiter)
+	}
+	if !given["quote"] {
+		d.Quote = sniffQuote(lines, d.Delimiter, d.Quote)
+	}
+	if !given["header"] {
+		d.NoHeader = !sniffHeader(lines, d)
+	}
+	return d
+}
+
+// sampleLines returns the first complete lines of a file with some text, and
+// whether they end with carriage returns only, as in old Mac files. It
+// returns no lines if the file can not be read, which is reported later.
+func sampleLines(path string, d Dialect) (lines []string, cr bool) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	r, err := decompressReader(f, d.compression(path))
+	if err != nil {
+		return nil, false
+	}
+	defer r.Close()
+	b := make([]byte, sniffSample)
+	n, err := io.ReadFull(r, b)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, false
+	}
+	truncated := n == len(b)
+	b = b[:n]
+
+	enc := d.Encoding
+	if enc == "" {
+		enc = sniffEncoding(b)
+	}
+	if enc != "" {
+		e, err := htmlindex.Get(enc)
+		if err != nil {
+			return nil, false
+		}
+		if b, err = e.NewDecoder().Bytes(b); err != nil {
+			return nil, false
+		}
+	}
+	b = bytes.TrimPrefix(b, []byte(utf8BOM))
+
+	text := string(b)
+	if !strings.Contains(text, "\n") && strings.Contains(text, "\r") {
+		text, cr = strings.Replace(text, "\r", "\n", -1), true
+	}
+	all := strings.SplitAfter(text, "\n")
+	if truncated {
+		all = all[:len(all)-1]
+	}
+	if len(all) < d.SkipRows {
+		return nil, cr
+	}
+	for _, line := range all[d.SkipRows:] {
+		if trimEOL(line) != "" && (d.Comment == "" || !strings.HasPrefix(line, d.Comment)) {
+			lines = append(lines, line)
+		}
+	}
+	if !truncated {
+		if len(lines) < d.SkipFooter {
+			return nil, cr
+		}
+		lines = lines[:len(lines)-d.SkipFooter]
+	}
+	return lines, cr
+}
+
+// sniffDelimiter returns the delimiter found the same number of times in the
+// most lines, outside quotes, or the one of the format unless another one is
+// consistent enough.
+func sniffDelimiter(lines []string, delim string) string {
+	best, bestScore := delim, sniffScore(lines, delim)
+	for _, c := range sniffDelimiters {
+		if score := sniffScore(lines, c); score > bestScore && score >= sniffConsistency {
+			best, bestScore = c, score
+		}
+	}
+	return best
+}
+
+// sniffScore returns the fraction of the lines with the most common number of
+// delimiters, or 0 if they have none.
+func sniffScore(lines []string, delim string) float64 {
+	counts := make(map[int]int)
+	for _, line := range lines {
+		n, quoted := 0, false
+		for i := 0; i < len(line); i++ {
+			switch {
+			case line[i] == '"':
+				quoted = !quoted
+			case !quoted && strings.HasPrefix(line[i:], delim):
+				n++
+				i += len(delim) - 1
+			}
+		}
+		counts[n]++
+	}
+	mode, most := 0, 0
+	for n, lines := range counts {
+		if lines > most || lines == most && n > mode {
+			mode, most = n, lines
+		}
+	}
+	if mode == 0 {
+		return 0
+	}
+	return float64(most) / float64(len(lines))
+}
+
+// sniffQuote returns ' if values are quoted with it and never with the
+// default quote, which is returned otherwise.
+func sniffQuote(lines []string, delim, quote string) string {
+	var double, single int
+	for _, line := range lines {
+		for _, v := range strings.Split(trimEOL(line), delim) {
+			v = strings.TrimSpace(v)
+			if len(v) < 2 {
+				continue
+			}
+			switch {
+			case v[0] == '"' && v[len(v)-1] == '"':
+				double++
+			case v[0] == '\'' && v[len(v)-1] == '\'':
+				single++
+			}
+		}
+	}
+	if single > 0 && double == 0 {
+		return "'"
+	}
+	return quote
+}
+
+// sniffHeader reports whether the first line has the names of the columns,
+// which it does unless it has numbers in the columns where the next lines do,
+// rather than names. Names with other lengths than the values of their
+// columns, which all have the same one, are more evidence of a header.
+func sniffHeader(lines []string, d Dialect) bool {
+	r := newDelimReader(strings.NewReader(strings.Join(lines, "")), d.Delimiter, d.Quote, d.Escape)
+	var rows [][]string
+	for {
+		record, err := r.Read()
+		if err == io.EOF || err != nil && record == nil {
+			break
+		}
+		rows = append(rows, append([]string(nil), record...))
+	}
+	if len(rows) < 2 {
+		return true
+	}
+
+	votes := 0
+	for col, name := range rows[0] {
+		numeric, length := true, -1
+		values := 0
+		for _, row := range rows[1:] {
+			if col >= len(row) || strings.TrimSpace(row[col]) == "" {
+				continue
+			}
+			v := strings.TrimSpace(row[col])
+			values++
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				numeric = false
+			}
+			if length == -1 {
+				length = len(v)
+			} else if length != len(v) {
+				length = -2
+			}
+		}
+		if values == 0 {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if numeric {
+			if _, err := strconv.ParseFloat(name, 64); err == nil {
+				votes--
+			} else {
+				votes++
+			}
+		} else if length >= 0 && len(name) != length {
+			votes++
+		}
+	}
+	return votes >= 0
+}
@@ -0,0 +1,323 @@
+package csvql
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/analyzer"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+// sortBufferRows is the number of rows a sort keeps in memory. Larger inputs
+// are sorted in runs of this size, written to the temporary directory, and
+// merged.
+const sortBufferRows = 1 << 16
+
+func init() {
+	// The types of the values in rows, so they can be written to runs.
+	gob.Register(time.Time{})
+	gob.Register(uuid{})
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+}
+
+// spillSorts is an analyzer rule replacing the sorts by ones that spill to
+// disk when their input is too large.
+func spillSorts(ctx *sql.Context, a *analyzer.Analyzer, n sql.Node) (sql.Node, error) {
+	return n.TransformUp(func(n sql.Node) (sql.Node, error) {
+		if s, ok := n.(*plan.Sort); ok {
+			return &spillSort{s}, nil
+		}
+		return n, nil
+	})
+}
+
+// spillSort is a sort that, once its input does not fit in sortBufferRows,
+// writes it in sorted runs to temporary files and merges them.
+type spillSort struct{ *plan.Sort }
+
+func (s *spillSort) TransformUp(f sql.TransformNodeFunc) (sql.Node, error) {
+	child, err := s.Child.TransformUp(f)
+	if err != nil {
+		return nil, err
+	}
+	return f(&spillSort{plan.NewSort(s.SortFields, child)})
+}
+
+func (s *spillSort) TransformExpressionsUp(f sql.TransformExprFunc) (sql.Node, error) {
+	n, err := s.Sort.TransformExpressionsUp(f)
+	if err != nil {
+		return nil, err
+	}
+	return &spillSort{n.(*plan.Sort)}, nil
+}
+
+func (s *spillSort) RowIter(ctx *sql.Context) (sql.RowIter, error) {
+	rows, err := s.Child.RowIter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	it := &mergeIter{}
+	var buf []sql.Row
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		buf = append(buf, row)
+		if len(buf) == sortBufferRows {
+			if err := s.spill(ctx, it, buf); err != nil {
+				it.Close()
+				return nil, err
+			}
+			buf = buf[:0]
+		}
+	}
+
+	if err := s.sort(ctx, buf); err != nil {
+		it.Close()
+		return nil, err
+	}
+	if len(it.runs) == 0 {
+		return sql.RowsToRowIter(buf...), nil
+	}
+	it.add(&memoryRun{rows: buf})
+	it.less = func(a, b sql.Row) bool {
+		c, err := s.compare(ctx, a, b)
+		if err != nil && it.err == nil {
+			it.err = err
+		}
+		return c < 0
+	}
+	if err := it.start(); err != nil {
+		it.Close()
+		return nil, err
+	}
+	return it, nil
+}
+
+// sort sorts the rows, keeping the order of the equal ones.
+func (s *spillSort) sort(ctx *sql.Context, rows []sql.Row) error {
+	var err error
+	sort.SliceStable(rows, func(i, j int) bool {
+		c, cerr := s.compare(ctx, rows[i], rows[j])
+		if cerr != nil && err == nil {
+			err = cerr
+		}
+		return c < 0
+	})
+	return err
+}
+
+// spill sorts the rows and writes them to a new run of the iterator.
+func (s *spillSort) spill(ctx *sql.Context, it *mergeIter, rows []sql.Row) error {
+	if err := s.sort(ctx, rows); err != nil {
+		return err
+	}
+	f, err := tempFile("sort")
+	if err != nil {
+		return err
+	}
+	r := &fileRun{f: f}
+	it.add(r)
+
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+	for _, row := range rows {
+		values := []interface{}(row)
+		if err := enc.Encode(&values); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r.dec = gob.NewDecoder(bufio.NewReader(f))
+	return nil
+}
+
+// compare compares two rows by the sort fields, in the same way as
+// go-mysql-server does.
+func (s *spillSort) compare(ctx *sql.Context, a, b sql.Row) (int, error) {
+	for _, f := range s.SortFields {
+		av, err := f.Column.Eval(ctx, a)
+		if err != nil {
+			return 0, plan.ErrUnableSort.Wrap(err)
+		}
+		bv, err := f.Column.Eval(ctx, b)
+		if err != nil {
+			return 0, plan.ErrUnableSort.Wrap(err)
+		}
+
+		switch {
+		case av == nil && bv == nil:
+			continue
+		case av == nil:
+			if f.NullOrdering == plan.NullsFirst {
+				return -1, nil
+			}
+			return 1, nil
+		case bv == nil:
+			if f.NullOrdering == plan.NullsFirst {
+				return 1, nil
+			}
+			return -1, nil
+		}
+
+		if f.Order == plan.Descending {
+			av, bv = bv, av
+		}
+		c, err := f.Column.Type().Compare(av, bv)
+		if err != nil {
+			return 0, err
+		}
+		if c != 0 {
+			return c, nil
+		}
+	}
+	return 0, nil
+}
+
+// run is a sequence of sorted rows.
+type run interface {
+	next() (sql.Row, error)
+	close() error
+}
+
+type memoryRun struct {
+	rows []sql.Row
+}
+
+func (r *memoryRun) next() (sql.Row, error) {
+	if len(r.rows) == 0 {
+		return nil, io.EOF
+	}
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+	return row, nil
+}
+
+func (r *memoryRun) close() error { return nil }
+
+// fileRun is a run written to a temporary file, which is removed once the
+// run is closed.
+type fileRun struct {
+	f   *os.File
+	dec *gob.Decoder
+}
+
+func (r *fileRun) next() (sql.Row, error) {
+	var values []interface{}
+	if err := r.dec.Decode(&values); err != nil {
+		return nil, err
+	}
+	return sql.NewRow(values...), nil
+}
+
+func (r *fileRun) close() error {
+	err := r.f.Close()
+	if rerr := os.Remove(r.f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// mergeIter merges sorted runs, returning rows from the earliest run first
+// when they are equal so the sort is stable.
+type mergeIter struct {
+	runs  []run
+	heads []mergeHead
+	less  func(a, b sql.Row) bool
+	err   error
+}
+
+type mergeHead struct {
+	row sql.Row
+	run int
+}
+
+func (it *mergeIter) add(r run) { it.runs = append(it.runs, r) }
+
+func (it *mergeIter) start() error {
+	for i, r := range it.runs {
+		row, err := r.next()
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		it.heads = append(it.heads, mergeHead{row, i})
+	}
+	heap.Init(it)
+	return it.err
+}
+
+func (it *mergeIter) Next() (sql.Row, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+	if len(it.heads) == 0 {
+		return nil, io.EOF
+	}
+
+	head := it.heads[0]
+	row, err := it.runs[head.run].next()
+	switch {
+	case err == io.EOF:
+		heap.Pop(it)
+	case err != nil:
+		return nil, err
+	default:
+		it.heads[0].row = row
+		heap.Fix(it, 0)
+	}
+	return head.row, it.err
+}
+
+func (it *mergeIter) Close() error {
+	var err error
+	for _, r := range it.runs {
+		if cerr := r.close(); err == nil {
+			err = cerr
+		}
+	}
+	it.runs = nil
+	return err
+}
+
+// The methods of heap.Interface.
+
+func (it *mergeIter) Len() int { return len(it.heads) }
+func (it *mergeIter) Less(i, j int) bool {
+	a, b := it.heads[i], it.heads[j]
+	if it.less(a.row, b.row) {
+		return true
+	}
+	if it.less(b.row, a.row) {
+		return false
+	}
+	return a.run < b.run
+}
+func (it *mergeIter) Swap(i, j int)      { it.heads[i], it.heads[j] = it.heads[j], it.heads[i] }
+func (it *mergeIter) Push(x interface{}) { it.heads = append(it.heads, x.(mergeHead)) }
+func (it *mergeIter) Pop() interface{} {
+	head := it.heads[len(it.heads)-1]
+	it.heads = it.heads[:len(it.heads)-1]
+	return head
+}
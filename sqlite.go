@@ -0,0 +1,773 @@
+package csvql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// This file contains a reader of the tables of SQLite databases, which are
+// read directly from their files. See https://www.sqlite.org/fileformat.html
+// for the details of the format.
+
+const sqliteMagic = "SQLite format 3\x00"
+
+var errSQLite = errors.New("invalid SQLite database")
+
+// NewSQLiteDatabase returns a database with the tables of a SQLite database,
+// with the given name, or the one of its file without its extension if
+// empty, so they can be joined with the ones of the other databases of the
+// same Engine with qualified names, as in ref.countries. The tables are read
+// only, and WITHOUT ROWID and virtual tables are skipped.
+func NewSQLiteDatabase(name, path string) (sql.Database, error) {
+	if name == "" {
+		name = strings.ToLower(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	}
+	f, err := openSQLite(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open SQLite database %s: %v", path, err)
+	}
+	defer f.Close()
+	tables, err := f.tables()
+	if err != nil {
+		return nil, fmt.Errorf("could not read SQLite database %s: %v", path, err)
+	}
+	db := &database{
+		name:   name,
+		path:   filepath.Clean(path),
+		tables: make(map[string]sql.Table),
+		views:  make(map[string]*view),
+		txs:    make(map[sql.Session]*transaction),
+	}
+	for _, t := range tables {
+		db.tables[t.name] = t
+	}
+	return db, nil
+}
+
+// sqliteFile is an open SQLite database, whose pages are read as needed.
+type sqliteFile struct {
+	f        *os.File
+	pageSize int
+	// usable is the size of the pages without the bytes reserved at their
+	// end, and utf16 the byte order of the text if it is not UTF-8.
+	usable int
+	utf16  binary.ByteOrder
+	// wal is the write-ahead log of a database in WAL mode, and frames the
+	// offsets in it of the last committed version of the pages it changed.
+	wal    *os.File
+	frames map[uint32]int64
+}
+
+func openSQLite(path string) (*sqliteFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	var hdr [100]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil || string(hdr[:16]) != sqliteMagic {
+		f.Close()
+		return nil, errSQLite
+	}
+	s := &sqliteFile{f: f, pageSize: int(binary.BigEndian.Uint16(hdr[16:]))}
+	if s.pageSize == 1 {
+		s.pageSize = 65536
+	}
+	s.usable = s.pageSize - int(hdr[20])
+	switch binary.BigEndian.Uint32(hdr[56:]) {
+	case 2:
+		s.utf16 = binary.LittleEndian
+	case 3:
+		s.utf16 = binary.BigEndian
+	}
+	// The read version is 2 in WAL mode, whose changes since the last
+	// checkpoint are only in the -wal file.
+	switch hdr[18] {
+	case 1:
+		// Rollback journal mode, whose changes are in the file when
+		// committed.
+	case 2:
+		if err := s.openWAL(path + "-wal"); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("could not read write-ahead log: %v", err)
+		}
+	default:
+		f.Close()
+		return nil, fmt.Errorf("unsupported file format version %d", hdr[18])
+	}
+	return s, nil
+}
+
+// walMagic is the magic number of write-ahead logs whose checksums are of
+// big-endian words, or of little-endian ones if its last bit is clear.
+const walMagic = 0x377f0683
+
+// openWAL reads the frames of the write-ahead log of a database, keeping the
+// offsets of the last version of the pages written by the transactions that
+// were committed. As in SQLite, the frames after the first one that does not
+// match the salts and checksums of the log are left out, as are all of them
+// if its header is not valid, or it is missing, as after a checkpoint.
+func (s *sqliteFile) openWAL(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var hdr [32]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil {
+		f.Close()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		return err
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	switch binary.BigEndian.Uint32(hdr[:]) {
+	case walMagic:
+	case walMagic &^ 1:
+		order = binary.LittleEndian
+	default:
+		f.Close()
+		return nil
+	}
+	s0, s1 := walChecksum(order, hdr[:24], 0, 0)
+	if int(binary.BigEndian.Uint32(hdr[8:])) != s.pageSize ||
+		s0 != binary.BigEndian.Uint32(hdr[24:]) || s1 != binary.BigEndian.Uint32(hdr[28:]) {
+		f.Close()
+		return nil
+	}
+
+	frames := make(map[uint32]int64)
+	pending := make(map[uint32]int64)
+	frame := make([]byte, 24+s.pageSize)
+	for off := int64(len(hdr)); ; off += int64(len(frame)) {
+		if _, err := f.ReadAt(frame, off); err == io.EOF {
+			break
+		} else if err != nil {
+			f.Close()
+			return err
+		}
+		if !bytes.Equal(frame[8:16], hdr[16:24]) {
+			break
+		}
+		s0, s1 = walChecksum(order, frame[:8], s0, s1)
+		s0, s1 = walChecksum(order, frame[24:], s0, s1)
+		if s0 != binary.BigEndian.Uint32(frame[16:]) || s1 != binary.BigEndian.Uint32(frame[20:]) {
+			break
+		}
+		pending[binary.BigEndian.Uint32(frame)] = off + 24
+		// The frames of commits have the size of the database after them.
+		if binary.BigEndian.Uint32(frame[4:]) != 0 {
+			for n, off := range pending {
+				frames[n] = off
+			}
+			pending = make(map[uint32]int64)
+		}
+	}
+	if len(frames) == 0 {
+		f.Close()
+		return nil
+	}
+	s.wal, s.frames = f, frames
+	return nil
+}
+
+// walChecksum adds the words of b, in the given byte order, to the running
+// checksum of a write-ahead log.
+func walChecksum(order binary.ByteOrder, b []byte, s0, s1 uint32) (uint32, uint32) {
+	for i := 0; i+8 <= len(b); i += 8 {
+		s0 += order.Uint32(b[i:]) + s1
+		s1 += order.Uint32(b[i+4:]) + s0
+	}
+	return s0, s1
+}
+
+func (s *sqliteFile) Close() error {
+	if s.wal != nil {
+		s.wal.Close()
+	}
+	return s.f.Close()
+}
+
+// page returns the contents of a page, numbered from 1, and the offset of
+// its b-tree header, after the header of the database in the first page.
+func (s *sqliteFile) page(n uint32) ([]byte, int, error) {
+	if n == 0 {
+		return nil, 0, errSQLite
+	}
+	b := make([]byte, s.pageSize)
+	f, off := s.f, int64(n-1)*int64(s.pageSize)
+	if woff, ok := s.frames[n]; ok {
+		f, off = s.wal, woff
+	}
+	if _, err := f.ReadAt(b, off); err != nil {
+		return nil, 0, err
+	}
+	if n == 1 {
+		return b, 100, nil
+	}
+	return b, 0, nil
+}
+
+// tables returns the tables of the database, described by the first one,
+// sqlite_master. Internal tables, such as sqlite_sequence, are skipped.
+func (s *sqliteFile) tables() ([]*sqliteTable, error) {
+	var tables []*sqliteTable
+	c := &sqliteCursor{f: s}
+	if err := c.push(1); err != nil {
+		return nil, err
+	}
+	for {
+		_, values, err := c.next()
+		if err == io.EOF {
+			return tables, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if len(values) < 5 || values[0] != "table" {
+			continue
+		}
+		name, _ := values[1].(string)
+		root, _ := values[3].(int64)
+		create, _ := values[4].(string)
+		// Virtual tables, such as the ones of full-text searches, have no
+		// b-tree, but the tables keeping their contents are read as the
+		// others.
+		if strings.HasPrefix(strings.ToLower(name), "sqlite_") || root == 0 {
+			continue
+		}
+		t, err := parseCreateTable(name, create)
+		if err != nil {
+			return nil, fmt.Errorf("could not read table %s: %v", name, err)
+		}
+		if t != nil {
+			t.path, t.root = s.f.Name(), uint32(root)
+			tables = append(tables, t)
+		}
+	}
+}
+
+// sqliteCursor reads the rows of a table in the order of their rowids,
+// walking down its b-tree.
+type sqliteCursor struct {
+	f     *sqliteFile
+	stack []*sqlitePage
+}
+
+// sqlitePage is a page of a b-tree being read, whose cell next is the
+// first one not read yet.
+type sqlitePage struct {
+	b        []byte
+	off      int
+	interior bool
+	cells    int
+	next     int
+}
+
+func (c *sqliteCursor) push(n uint32) error {
+	b, off, err := c.f.page(n)
+	if err != nil {
+		return err
+	}
+	if off+8 > len(b) || b[off] != 0x05 && b[off] != 0x0d {
+		return errSQLite
+	}
+	c.stack = append(c.stack, &sqlitePage{
+		b:        b,
+		off:      off,
+		interior: b[off] == 0x05,
+		cells:    int(binary.BigEndian.Uint16(b[off+3:])),
+	})
+	return nil
+}
+
+// next returns the rowid and the values of the next row, or io.EOF.
+func (c *sqliteCursor) next() (int64, []interface{}, error) {
+	for len(c.stack) > 0 {
+		p := c.stack[len(c.stack)-1]
+		if p.next > p.cells || !p.interior && p.next == p.cells {
+			c.stack = c.stack[:len(c.stack)-1]
+			continue
+		}
+		i := p.next
+		p.next++
+		if !p.interior {
+			return c.f.cell(p.b, int(binary.BigEndian.Uint16(p.b[p.off+8+2*i:])))
+		}
+		// Interior pages point to the pages with smaller rowids in each
+		// cell, and to the ones with the largest in their header.
+		child := binary.BigEndian.Uint32(p.b[p.off+8:])
+		if i < p.cells {
+			cell := int(binary.BigEndian.Uint16(p.b[p.off+12+2*i:]))
+			if cell+4 > len(p.b) {
+				return 0, nil, errSQLite
+			}
+			child = binary.BigEndian.Uint32(p.b[cell:])
+		}
+		if err := c.push(child); err != nil {
+			return 0, nil, err
+		}
+	}
+	return 0, nil, io.EOF
+}
+
+// cell returns the rowid and the values of the record in a cell of a leaf
+// page, whose end can be in overflow pages.
+func (s *sqliteFile) cell(b []byte, off int) (int64, []interface{}, error) {
+	size, n := sqliteVarint(b, off)
+	off += n
+	rowid, n := sqliteVarint(b, off)
+	off += n
+
+	// The payloads larger than a share of the page end in a list of overflow
+	// pages, with as few bytes in the page as possible.
+	payload := int(size)
+	local := payload
+	if max := s.usable - 35; payload > max {
+		min := (s.usable-12)*32/255 - 23
+		local = min + (payload-min)%(s.usable-4)
+		if local > max {
+			local = min
+		}
+	}
+	if off+local > len(b) || local < 0 {
+		return 0, nil, errSQLite
+	}
+	record := append([]byte(nil), b[off:off+local]...)
+	if local < payload {
+		if off+local+4 > len(b) {
+			return 0, nil, errSQLite
+		}
+		next := binary.BigEndian.Uint32(b[off+local:])
+		for len(record) < payload {
+			p, _, err := s.page(next)
+			if err != nil {
+				return 0, nil, err
+			}
+			n := s.usable - 4
+			if rest := payload - len(record); rest < n {
+				n = rest
+			}
+			record = append(record, p[4:4+n]...)
+			next = binary.BigEndian.Uint32(p)
+		}
+	}
+	values, err := s.record(record)
+	return int64(rowid), values, err
+}
+
+// record decodes the values of a record, given by their serial types.
+func (s *sqliteFile) record(b []byte) ([]interface{}, error) {
+	size, n := sqliteVarint(b, 0)
+	if int(size) > len(b) || n == 0 {
+		return nil, errSQLite
+	}
+	var values []interface{}
+	body := int(size)
+	for off := n; off < int(size); {
+		typ, n := sqliteVarint(b, off)
+		if n == 0 {
+			return nil, errSQLite
+		}
+		off += n
+		var v interface{}
+		var length int
+		switch {
+		case typ == 0:
+		case typ <= 6:
+			length = []int{0, 1, 2, 3, 4, 6, 8}[typ]
+		case typ == 7:
+			length = 8
+		case typ == 8, typ == 9:
+			v = int64(typ - 8)
+		case typ >= 12:
+			length = int(typ-12) / 2
+		default:
+			return nil, errSQLite
+		}
+		if body+length > len(b) {
+			return nil, errSQLite
+		}
+		field := b[body : body+length]
+		body += length
+		switch {
+		case typ >= 1 && typ <= 6:
+			i := int64(int8(field[0]))
+			for _, c := range field[1:] {
+				i = i<<8 | int64(c)
+			}
+			v = i
+		case typ == 7:
+			v = math.Float64frombits(binary.BigEndian.Uint64(field))
+		case typ >= 13 && typ%2 == 1:
+			v = s.text(field)
+		case typ >= 12:
+			v = string(field)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// text decodes the text of a value, which can be in UTF-16.
+func (s *sqliteFile) text(b []byte) string {
+	if s.utf16 == nil {
+		return string(b)
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = s.utf16.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// sqliteVarint decodes the variable length integer at an offset, returning
+// it and its length, or 0 if it is cut short.
+func sqliteVarint(b []byte, off int) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if off+i >= len(b) {
+			return 0, 0
+		}
+		c := b[off+i]
+		if i == 8 {
+			return v<<8 | uint64(c), 9
+		}
+		v = v<<7 | uint64(c&0x7f)
+		if c < 0x80 {
+			return v, i + 1
+		}
+	}
+	return v, 9
+}
+
+// parseCreateTable returns the table created by a CREATE TABLE statement,
+// with the types of its columns given by their affinities and without its
+// virtual generated columns, or nil if it is a WITHOUT ROWID table.
+func parseCreateTable(name, create string) (*sqliteTable, error) {
+	open, end := strings.Index(create, "("), strings.LastIndex(create, ")")
+	if open < 0 || end < open {
+		return nil, fmt.Errorf("could not parse %q", create)
+	}
+	if strings.Contains(strings.ToUpper(create[end:]), "WITHOUT") {
+		return nil, nil
+	}
+	t := &sqliteTable{name: strings.ToLower(name), rowid: -1}
+	var keys, types []string
+	for _, def := range splitDefinitions(create[open+1 : end]) {
+		words := strings.Fields(def)
+		if len(words) == 0 {
+			continue
+		}
+		switch strings.ToUpper(words[0]) {
+		case "CONSTRAINT", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		case "PRIMARY":
+			if p := strings.Index(def, "("); p >= 0 {
+				for _, k := range splitDefinitions(strings.TrimSuffix(strings.TrimSpace(def[p+1:]), ")")) {
+					keys = append(keys, unquoteSQLite(strings.TrimSpace(k)))
+				}
+			}
+			continue
+		}
+		col, rest := sqliteColumnName(def)
+		// The declared type is made of the words before the constraints.
+		var typ []string
+	words:
+		for _, w := range strings.Fields(rest) {
+			switch strings.ToUpper(w) {
+			case "PRIMARY", "NOT", "NULL", "UNIQUE", "CHECK", "DEFAULT", "COLLATE", "REFERENCES", "GENERATED", "AS", "CONSTRAINT":
+				break words
+			}
+			typ = append(typ, w)
+		}
+		// Virtual generated columns are computed when they are read, and
+		// are not in the records.
+		var def interface{}
+		generated, stored := false, false
+		tokens := sqliteTokens(rest)
+		for k, tok := range tokens {
+			switch strings.ToUpper(tok) {
+			case "AS":
+				generated = true
+			case "STORED":
+				stored = true
+			case "DEFAULT":
+				if k+1 < len(tokens) {
+					def = sqliteLiteral(tokens[k+1])
+				}
+			}
+		}
+		if generated && !stored {
+			continue
+		}
+		declared := strings.ToUpper(strings.Join(typ, " "))
+		types = append(types, declared)
+		t.defaults = append(t.defaults, def)
+		if declared == "INTEGER" && strings.Contains(strings.ToUpper(rest), "PRIMARY KEY") {
+			t.rowid = len(t.schema)
+		}
+		t.schema = append(t.schema, &sql.Column{
+			Name:     strings.ToLower(col),
+			Type:     sqliteType(declared),
+			Nullable: true,
+			Source:   t.name,
+		})
+	}
+	// A single primary key declared as INTEGER, and not as INT or others,
+	// aliases the rowid, which the records do not repeat.
+	if len(keys) == 1 && t.rowid < 0 {
+		if i := sql.Schema(t.schema).IndexOf(strings.ToLower(keys[0]), t.name); i >= 0 && types[i] == "INTEGER" {
+			t.rowid = i
+		}
+	}
+	return t, nil
+}
+
+// splitDefinitions splits the definitions of the columns and constraints of
+// a table at the commas outside parentheses and quotes.
+func splitDefinitions(s string) []string {
+	var defs []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			defs = append(defs, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(defs, strings.TrimSpace(s[start:]))
+}
+
+// sqliteTokens splits a column definition into its words, quoted strings
+// and parenthesized expressions.
+func sqliteTokens(def string) []string {
+	var tokens []string
+	depth, start := 0, -1
+	var quote byte
+	for i := 0; i < len(def); i++ {
+		c := def[i]
+		switch {
+		case quote != 0:
+			if c == quote && i+1 < len(def) && def[i+1] == quote {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			if depth == 0 && start >= 0 {
+				tokens = append(tokens, def[start:i])
+				start = -1
+			}
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 && start >= 0 {
+				tokens = append(tokens, def[start:i+1])
+				start = -1
+			}
+			continue
+		case depth == 0 && strings.IndexByte(" \t\r\n", c) >= 0:
+			if start >= 0 {
+				tokens = append(tokens, def[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, def[start:])
+	}
+	return tokens
+}
+
+// sqliteLiteral returns the value of a literal given as the default value of
+// a column, or nil if it is NULL or an expression.
+func sqliteLiteral(lit string) interface{} {
+	if strings.HasPrefix(lit, "(") && strings.HasSuffix(lit, ")") {
+		return sqliteLiteral(strings.TrimSpace(lit[1 : len(lit)-1]))
+	}
+	if len(lit) >= 2 && lit[0] == '\'' && lit[len(lit)-1] == '\'' {
+		return strings.Replace(lit[1:len(lit)-1], "''", "'", -1)
+	}
+	switch strings.ToUpper(lit) {
+	case "TRUE":
+		return int64(1)
+	case "FALSE":
+		return int64(0)
+	}
+	if i, err := strconv.ParseInt(lit, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(lit, 64); err == nil {
+		return f
+	}
+	return nil
+}
+
+// sqliteColumnName returns the name of the column a definition starts with,
+// which can be quoted, and the rest of the definition.
+func sqliteColumnName(def string) (string, string) {
+	if closing := map[byte]byte{'"': '"', '\'': '\'', '`': '`', '[': ']'}[def[0]]; closing != 0 {
+		if end := strings.IndexByte(def[1:], closing); end >= 0 {
+			return def[1 : end+1], def[end+2:]
+		}
+	}
+	if i := strings.IndexAny(def, " \t\r\n"); i >= 0 {
+		return def[:i], def[i:]
+	}
+	return def, ""
+}
+
+func unquoteSQLite(name string) string {
+	col, _ := sqliteColumnName(name)
+	return col
+}
+
+// sqliteType returns the type of the columns declared with a type, following
+// the rules of the affinities of SQLite. Columns with the NUMERIC affinity,
+// often dates, and the ones with none are read as text.
+func sqliteType(declared string) sql.Type {
+	switch {
+	case strings.Contains(declared, "INT"):
+		return sql.Int64
+	case strings.Contains(declared, "CHAR"), strings.Contains(declared, "CLOB"), strings.Contains(declared, "TEXT"):
+		return sql.Text
+	case strings.Contains(declared, "REAL"), strings.Contains(declared, "FLOA"), strings.Contains(declared, "DOUB"):
+		return sql.Float64
+	}
+	return sql.Text
+}
+
+// sqliteTable is a table of a SQLite database.
+type sqliteTable struct {
+	name, path string
+	root       uint32
+	schema     sql.Schema
+	// rowid is the index of the column aliasing the rowid, or -1.
+	rowid int
+	// defaults are the values of the columns missing in the records written
+	// before they were added with ALTER TABLE.
+	defaults []interface{}
+}
+
+func (t *sqliteTable) Name() string       { return t.name }
+func (t *sqliteTable) String() string     { return t.path + "/" + t.name }
+func (t *sqliteTable) Schema() sql.Schema { return t.schema }
+
+func (t *sqliteTable) Partitions(ctx *sql.Context) (sql.PartitionIter, error) {
+	return &partitionIter{}, nil
+}
+
+func (t *sqliteTable) PartitionRows(ctx *sql.Context, p sql.Partition) (sql.RowIter, error) {
+	f, err := openSQLite(t.path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open SQLite database %s: %v", t.path, err)
+	}
+	c := &sqliteCursor{f: f}
+	if err := c.push(t.root); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not read table %s of %s: %v", t.name, t.path, err)
+	}
+	return &sqliteRowIter{t: t, c: c}, nil
+}
+
+type sqliteRowIter struct {
+	t *sqliteTable
+	c *sqliteCursor
+}
+
+func (i *sqliteRowIter) Next() (sql.Row, error) {
+	rowid, values, err := i.c.next()
+	if err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read table %s of %s: %v", i.t.name, i.t.path, err)
+	}
+	row := make(sql.Row, len(i.t.schema))
+	for j, col := range i.t.schema {
+		v := i.t.defaults[j]
+		if j < len(values) {
+			v = values[j]
+		}
+		if j == i.t.rowid {
+			v = rowid
+		}
+		row[j] = sqliteValue(v, col.Type)
+	}
+	return row, nil
+}
+
+func (i *sqliteRowIter) Close() error { return i.c.f.Close() }
+
+// sqliteValue converts a value to the type of its column, since SQLite
+// columns can hold values of any type. Blobs are read as text, and the
+// values that are not numbers in numeric columns are NULL.
+func sqliteValue(v interface{}, typ sql.Type) interface{} {
+	switch v := v.(type) {
+	case int64:
+		switch typ {
+		case sql.Float64:
+			return float64(v)
+		case sql.Text:
+			return strconv.FormatInt(v, 10)
+		}
+	case float64:
+		switch typ {
+		case sql.Int64:
+			if v == math.Trunc(v) {
+				return int64(v)
+			}
+			return nil
+		case sql.Text:
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+	case string:
+		switch typ {
+		case sql.Int64:
+			if i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+				return i
+			}
+			return nil
+		case sql.Float64:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f
+			}
+			return nil
+		}
+	}
+	return v
+}
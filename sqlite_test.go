@@ -0,0 +1,231 @@
+package csvql
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// sqliteRows returns the rows of the results of a query on the tables of a
+// SQLite database in testdata, or at an absolute path, attached as ref.
+func sqliteRows(t *testing.T, file, query string) []sql.Row {
+	t.Helper()
+	if !filepath.IsAbs(file) {
+		file = filepath.Join("testdata", file)
+	}
+	db, err := NewSQLiteDatabase("ref", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queryRows(t, NewEngine(db), query)
+}
+
+// TestSQLiteTypes reads the database written by the sqlite3 shell from
+// types.sql.
+func TestSQLiteTypes(t *testing.T) {
+	db, err := NewSQLiteDatabase("ref", filepath.Join("testdata", "types.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range db.Tables() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// The views, the indexes, the internal tables, and the WITHOUT ROWID and
+	// virtual ones are skipped.
+	want := []string{"altered", "copy", "docs_content", "docs_data", "docs_docsize", "gen", "items", "keyed", "order items", "seq"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got tables %v, want %v", names, want)
+	}
+
+	testCases := []struct {
+		query string
+		want  []sql.Row
+	}{
+		{
+			// Integers of every size, and the values converted to the
+			// types of their columns.
+			"SELECT * FROM ref.items",
+			[]sql.Row{
+				{int64(1), "Ana", int64(0), 3.0, "2024-02-29", "\x00\xff", "x"},
+				{int64(2), "Ção", int64(-1), 2.5, "20240229", nil, "1.5"},
+				{int64(3), "", int64(127), nil, nil, "", nil},
+				{int64(4), "big", int64(math.MaxInt64), 0.0, "1000", "text", "7"},
+				{int64(5), "min", int64(math.MinInt64), 0.125, nil, nil, nil},
+				{int64(6), "sizes", int64(128), 32768.0, nil, nil, nil},
+				{int64(7), "n/a", nil, 1.25, nil, nil, nil},
+				{int64(8), "text int", int64(12), nil, nil, nil, nil},
+				{int64(9), "i48", int64(1<<47 - 1), nil, nil, nil, nil},
+				{int64(10), "i24", int64(-1 << 23), nil, nil, nil, nil},
+				{int64(100), "gap", int64(1 << 31), 1.0, nil, nil, nil},
+			},
+		},
+		{
+			// Quoted names, and a primary key aliasing the rowid given
+			// after the columns.
+			"SELECT `item id`, qty, `x y` FROM ref.`order items`",
+			[]sql.Row{{int64(5), int64(2), "a"}, {int64(9), nil, "b"}},
+		},
+		{
+			// A primary key which is not an alias of the rowid.
+			"SELECT * FROM ref.keyed",
+			[]sql.Row{{int64(7), "seven"}, {int64(3), "three"}},
+		},
+		{
+			"SELECT * FROM ref.seq",
+			[]sql.Row{{int64(1), "a"}, {int64(2), "b"}},
+		},
+		{
+			// The first row was written before the columns with their
+			// defaults were added.
+			"SELECT * FROM ref.altered",
+			[]sql.Row{{int64(1), "old", int64(5), "it's", -1.5, nil}, {int64(2), "new", int64(6), "some", 1.5, "e"}},
+		},
+		{
+			// The virtual generated column is not stored, and the stored
+			// one is.
+			"SELECT * FROM ref.gen",
+			[]sql.Row{{int64(1), int64(2), "one"}, {int64(2), int64(3), "two"}},
+		},
+		{
+			"SELECT * FROM ref.docs_content",
+			[]sql.Row{{int64(1), "hello", "world"}},
+		},
+		{
+			"SELECT * FROM ref.copy",
+			[]sql.Row{{int64(1), "Ana", 3.0}, {int64(2), "Ção", 2.5}},
+		},
+	}
+	for _, tc := range testCases {
+		if got := sqliteRows(t, "types.db", tc.query); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got rows\n%#v\nwant\n%#v", tc.query, got, tc.want)
+		}
+	}
+}
+
+// TestSQLitePages reads tables whose rows are in several levels of b-tree
+// pages, with the ones that do not fit in a page ending in overflow pages,
+// with the smallest pages and the largest.
+func TestSQLitePages(t *testing.T) {
+	var want []sql.Row
+	for i := 1; i <= 500; i++ {
+		if i%7 != 0 {
+			want = append(want, sql.Row{int64(i), fmt.Sprintf("%d:%s", i, strings.Repeat("x", i*37%1201)), float64(i) / 4})
+		}
+	}
+	if got := sqliteRows(t, "pages-512.db", "SELECT * FROM ref.big"); !reflect.DeepEqual(got, want) {
+		t.Errorf("pages-512.db: got %d rows, want %d", len(got), len(want))
+		for i := range got {
+			if i < len(want) && !reflect.DeepEqual(got[i], want[i]) {
+				t.Fatalf("pages-512.db: got row %d %.40q, want %.40q", i, got[i], want[i])
+			}
+		}
+	}
+
+	want = nil
+	for i := 1; i <= 3; i++ {
+		want = append(want, sql.Row{int64(i), fmt.Sprintf("%d:%s", i, strings.Repeat("x", i*70000)), float64(i) / 4})
+	}
+	if got := sqliteRows(t, "pages-65536.db", "SELECT * FROM ref.big"); !reflect.DeepEqual(got, want) {
+		t.Errorf("pages-65536.db: got rows that differ from the ones written")
+	}
+}
+
+func TestSQLiteUTF16(t *testing.T) {
+	want := []sql.Row{{"Portugal", int64(351)}, {"日本", int64(81)}, {strings.Repeat("é", 3000), int64(0)}}
+	if got := sqliteRows(t, "utf16be.db", "SELECT nome, `código` FROM ref.`países`"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got rows %.60q, want %.60q", got, want)
+	}
+}
+
+// TestSQLiteWAL reads the database written in WAL mode by the sqlite3 shell
+// from wal.sql, whose last changes are only in its -wal file.
+func TestSQLiteWAL(t *testing.T) {
+	stock := "SELECT * FROM ref.stock ORDER BY id"
+	want := []sql.Row{{int64(1), "apples", int64(7)}, {int64(2), "pears", int64(5)}, {int64(4), "figs", int64(12)}}
+	if got := sqliteRows(t, "wal.db", stock); !reflect.DeepEqual(got, want) {
+		t.Errorf("got rows %v, want %v", got, want)
+	}
+	moves := "SELECT * FROM ref.moves"
+	if got, want := sqliteRows(t, "wal.db", moves), []sql.Row{{int64(1), int64(1), int64(-3)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got moves %v, want %v", got, want)
+	}
+
+	// The frames after a damaged one are not read, as the transactions in
+	// them may not have been written whole.
+	dir := t.TempDir()
+	db, err := ioutil.ReadFile(filepath.Join("testdata", "wal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal, err := ioutil.ReadFile(filepath.Join("testdata", "wal.db-wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal[len(wal)-1] ^= 0xff
+	if err := ioutil.WriteFile(filepath.Join(dir, "wal.db"), db, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "wal.db-wal"), wal, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := sqliteRows(t, filepath.Join(dir, "wal.db"), moves); len(got) != 0 {
+		t.Errorf("got moves %v of a damaged frame, want none", got)
+	}
+
+	// Without its log, the database is read as of its last checkpoint.
+	if err := os.Remove(filepath.Join(dir, "wal.db-wal")); err != nil {
+		t.Fatal(err)
+	}
+	want = []sql.Row{{int64(1), "apples", int64(10)}, {int64(2), "pears", int64(5)}, {int64(3), "plums", int64(0)}}
+	if got := sqliteRows(t, filepath.Join(dir, "wal.db"), stock); !reflect.DeepEqual(got, want) {
+		t.Errorf("got rows %v without the log, want %v", got, want)
+	}
+}
+
+func TestSQLiteInvalidFiles(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "types.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The size of the pages is a power of two from 512 to 65536, written as 1.
+	pageSize := append([]byte(nil), b...)
+	pageSize[16], pageSize[17] = 0, 0
+	dir := t.TempDir()
+	for name, content := range map[string][]byte{
+		"text.db":      []byte("id,name\n1,ana\n"),
+		"magic.db":     []byte(sqliteMagic),
+		"pagesize.db":  pageSize,
+		"truncated.db": b[:len(b)/2],
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		// The tables in the pages that are missing can not be read.
+		db, err := NewSQLiteDatabase("ref", path)
+		if err == nil {
+			for table := range db.Tables() {
+				_, rows, qerr := NewEngine(db).Query(sql.NewEmptyContext(), "SELECT * FROM ref."+table)
+				if qerr == nil {
+					_, qerr = sql.RowIterToRows(rows)
+				}
+				if qerr != nil {
+					err = qerr
+				}
+			}
+		}
+		if err == nil {
+			t.Errorf("%s: read without errors", name)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package csvql
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+// ColumnProfile summarizes the values found in a column.
+type ColumnProfile struct {
+	Name string
+	// Count is the number of rows, and Nulls how many of them have no value.
+	Count, Nulls int64
+	// Distinct is an estimate of the number of distinct non null values.
+	Distinct int64
+	// Numeric is true if all the non null values are numbers, in which case
+	// Min, Max, and Mean are computed numerically.
+	Numeric   bool
+	Min, Max  interface{}
+	Mean      float64
+	TopValues []ValueCount
+
+	sum      float64
+	distinct *hll
+	top      *spaceSaving
+}
+
+// ValueCount is a value and the number of times it was found.
+type ValueCount struct {
+	Value string
+	Count int64
+}
+
+// NullRatio returns the fraction of the rows with no value in the column.
+func (p *ColumnProfile) NullRatio() float64 {
+	if p.Count == 0 {
+		return 0
+	}
+	return float64(p.Nulls) / float64(p.Count)
+}
+
+// Profile scans all the rows in the given table and returns a profile for each
+// of its columns, including up to topK of their most frequent values.
+func Profile(ctx *sql.Context, t sql.Table, topK int) ([]*ColumnProfile, error) {
+	profiles := make([]*ColumnProfile, len(t.Schema()))
+	for i, col := range t.Schema() {
+		profiles[i] = &ColumnProfile{
+			Name:     col.Name,
+			Numeric:  true,
+			distinct: newHLL(),
+			top:      newSpaceSaving(topK * 20),
+		}
+	}
+
+	rows, err := plan.NewResolvedTable(t).RowIter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range row {
+			profiles[i].add(v)
+		}
+	}
+
+	for _, p := range profiles {
+		p.finish(topK)
+	}
+	return profiles, nil
+}
+
+func (p *ColumnProfile) add(v interface{}) {
+	p.Count++
+	s := formatValue(v)
+	if v == nil || s == "" {
+		p.Nulls++
+		return
+	}
+
+	p.distinct.add(s)
+	p.top.add(s)
+
+	if p.Numeric {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			p.sum += f
+			if p.Min == nil || f < p.Min.(float64) {
+				p.Min = f
+			}
+			if p.Max == nil || f > p.Max.(float64) {
+				p.Max = f
+			}
+			return
+		}
+		// The column is not numeric, so start over with textual values.
+		p.Numeric = false
+		p.Min, p.Max = nil, nil
+	}
+	if p.Min == nil || s < p.Min.(string) {
+		p.Min = s
+	}
+	if p.Max == nil || s > p.Max.(string) {
+		p.Max = s
+	}
+}
+
+func (p *ColumnProfile) finish(topK int) {
+	p.Distinct = p.distinct.count()
+	p.TopValues = p.top.top(topK)
+	if values := p.Count - p.Nulls; values == 0 {
+		p.Numeric = false
+	} else if p.Numeric {
+		p.Mean = p.sum / float64(values)
+	}
+}
+
+// hll is a HyperLogLog sketch estimating the number of distinct values.
+type hll struct {
+	registers []uint8
+}
+
+const hllPrecision = 14
+
+func newHLL() *hll { return &hll{registers: make([]uint8, 1<<hllPrecision)} }
+
+func (h *hll) add(s string) {
+	x := hash64(s)
+	idx := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+func (h *hll) count() int64 {
+	m := float64(len(h.registers))
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += math.Pow(2, -float64(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// Use linear counting for small cardinalities.
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(estimate + 0.5)
+}
+
+// hash64 returns a well distributed 64 bit hash of the given string.
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	io.WriteString(h, s)
+	x := h.Sum64()
+	// Finalizer from splitmix64, improving the avalanche of FNV.
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// spaceSaving implements the Space-Saving algorithm, which finds the most
+// frequent values in a stream using a bounded number of counters.
+type spaceSaving struct {
+	size   int
+	counts map[string]*ssCounter
+	// min is a heap of the counters by count, so the smallest one is found
+	// quickly when it is replaced.
+	min ssHeap
+}
+
+type ssCounter struct {
+	value string
+	count int64
+	// err is the maximum over-estimation of count.
+	err int64
+	// index is the position of the counter in the heap.
+	index int
+}
+
+func newSpaceSaving(size int) *spaceSaving {
+	if size < 1 {
+		size = 1
+	}
+	return &spaceSaving{size: size, counts: make(map[string]*ssCounter, size)}
+}
+
+func (s *spaceSaving) add(v string) {
+	if c, ok := s.counts[v]; ok {
+		c.count++
+		heap.Fix(&s.min, c.index)
+		return
+	}
+	if len(s.counts) < s.size {
+		c := &ssCounter{value: v, count: 1}
+		s.counts[v] = c
+		heap.Push(&s.min, c)
+		return
+	}
+
+	// Replace the value with the smallest count.
+	c := s.min[0]
+	delete(s.counts, c.value)
+	c.value, c.err = v, c.count
+	c.count++
+	s.counts[v] = c
+	heap.Fix(&s.min, 0)
+}
+
+// merge adds the counts of another sketch, keeping the values with the
+// highest counts if there are more than fit.
+func (s *spaceSaving) merge(o *spaceSaving) {
+	for v, oc := range o.counts {
+		if c, ok := s.counts[v]; ok {
+			c.count += oc.count
+			c.err += oc.err
+			heap.Fix(&s.min, c.index)
+			continue
+		}
+		c := &ssCounter{value: v, count: oc.count, err: oc.err}
+		s.counts[v] = c
+		heap.Push(&s.min, c)
+	}
+	for len(s.counts) > s.size {
+		delete(s.counts, heap.Pop(&s.min).(*ssCounter).value)
+	}
+}
+
+// top returns up to k values with the highest counts, in descending order.
+// The counts returned are guaranteed lower bounds of the real ones.
+func (s *spaceSaving) top(k int) []ValueCount {
+	values := make([]ValueCount, 0, len(s.counts))
+	for v, c := range s.counts {
+		values = append(values, ValueCount{v, c.count - c.err})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Value < values[j].Value
+	})
+	if len(values) > k {
+		values = values[:k]
+	}
+	return values
+}
+
+// ssHeap orders counters by count, and then value, so the same values are
+// replaced regardless of the order of the map.
+type ssHeap []*ssCounter
+
+func (h ssHeap) Len() int { return len(h) }
+func (h ssHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].value < h[j].value
+}
+func (h ssHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *ssHeap) Push(x interface{}) {
+	c := x.(*ssCounter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+func (h *ssHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,101 @@
+package csvql
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/parse"
+)
+
+// status holds the counters of an engine reported by SHOW STATUS.
+type status struct {
+	started time.Time
+	// queries counts every statement run, and questions only the ones sent
+	// by clients of the server.
+	queries, questions Counter
+	parseErrors        Counter
+	// connections counts every connection to the server, and closed the
+	// ones already closed.
+	connections, closed Counter
+}
+
+func init() {
+	statements = append(statements, statement{
+		re:  regexp.MustCompile(`(?is)^\s*show\s+(?:(?:global|session)\s+)?status(?:\s+like\s+` + quotedString + `)?\s*;?\s*$`),
+		run: (*Engine).showStatus,
+	})
+}
+
+// showStatus returns the status variables of the engine, using the same
+// names as MySQL where possible. Only the ones matching the LIKE pattern, if
+// any, are returned.
+func (e *Engine) showStatus(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	vars := []struct {
+		name  string
+		value int64
+	}{
+		{"Bytes_read", BytesRead.Value()},
+		{"Connections", e.status.connections.Value()},
+		{"Parse_errors", e.status.parseErrors.Value()},
+		// csvql has no query cache, but monitoring scripts expect it.
+		{"Qcache_hits", 0},
+		{"Queries", e.status.queries.Value()},
+		{"Questions", e.status.questions.Value()},
+		{"Rows_invalid", RowsInvalid.Value()},
+		{"Rows_read", RowsRead.Value()},
+		{"Rows_written", RowsWritten.Value()},
+		{"Threads_connected", e.status.connections.Value() - e.status.closed.Value()},
+		{"Uptime", int64(time.Since(e.status.started) / time.Second)},
+	}
+
+	var like *regexp.Regexp
+	if m[1] != "" {
+		like = likePattern(unquote(m[1]))
+	}
+	var rows []sql.Row
+	for _, v := range vars {
+		if like == nil || like.MatchString(v.name) {
+			rows = append(rows, sql.NewRow(v.name, strconv.FormatInt(v.value, 10)))
+		}
+	}
+	schema := sql.Schema{
+		{Name: "Variable_name", Type: sql.Text},
+		{Name: "Value", Type: sql.Text},
+	}
+	return schema, sql.RowsToRowIter(rows...), nil
+}
+
+// likePattern returns a case insensitive regular expression matching the same
+// strings as the given LIKE pattern.
+func likePattern(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	escaped := false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '%':
+			b.WriteString(".*")
+		case c == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// isParseError reports whether the error is due to a query that could not be
+// parsed.
+func isParseError(err error) bool {
+	return parse.ErrUnsupportedSyntax.Is(err) || parse.ErrUnsupportedFeature.Is(err) ||
+		strings.HasPrefix(err.Error(), "syntax error")
+}
@@ -0,0 +1,141 @@
+package csvql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// isFIFO reports whether a path is the one of a named pipe.
+func isFIFO(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode()&os.ModeNamedPipe != 0
+}
+
+// stream copies the lines written to a named pipe by the processes producing
+// the rows of a table, one after the other, to the file the table is read
+// from, so every query reads the rows received until it starts.
+type stream struct {
+	fifo, spool string
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// size is the length of the complete lines copied, which are the only
+	// ones read, and err the error that stopped the copy, if any.
+	size int64
+	err  error
+}
+
+// newStreamTable returns a table whose rows are the lines written to a named
+// pipe, read with the given options as in AddTable. It waits for the first
+// line, with the names of the columns or the first row, to be written.
+func newStreamTable(name, path string, opts map[string]string) (*table, error) {
+	dir, err := tempDirectory("stream")
+	if err != nil {
+		return nil, err
+	}
+	// The file keeps the name of the pipe, whose extension tells its format.
+	spool := filepath.Join(dir, filepath.Base(path))
+	f, err := os.OpenFile(spool, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &stream{fifo: path, spool: spool}
+	s.cond = sync.NewCond(&s.mu)
+	go s.copy(f)
+
+	s.mu.Lock()
+	for s.size == 0 && s.err == nil {
+		s.cond.Wait()
+	}
+	err = s.err
+	s.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+
+	// Producers write the names of the columns first, unless they are given.
+	format := opts["format"]
+	if format == "" {
+		_, format = splitFormat(path)
+	}
+	if _, ok := opts["header"]; !ok && !tableNoHeader && format != "jsonl" {
+		given := map[string]string{"header": "1"}
+		for k, v := range opts {
+			given[k] = v
+		}
+		opts = given
+	}
+	d, err := fileDialect(spool, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !d.lines() || d.compression(spool) != "" || d.Encoding != "" || d.SkipFooter > 0 {
+		return nil, fmt.Errorf("could not stream %s: only uncompressed UTF-8 delimited files and JSON lines can be streamed, without footers", path)
+	}
+	t, err := newFileTable(name, d, spool)
+	if err != nil {
+		return nil, err
+	}
+	t.stream = s
+	return t, nil
+}
+
+// copy appends the lines written to the pipe to f, opening it again whenever
+// the process writing to it closes it, so another one can start writing.
+func (s *stream) copy(f *os.File) {
+	defer f.Close()
+	var err error
+	for err == nil {
+		err = s.copyWriter(f)
+	}
+	s.mu.Lock()
+	s.err = err
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// copyWriter appends the lines written by a process, until it closes the
+// pipe. A last line without a new line is complete once it does.
+func (s *stream) copyWriter(f *os.File) error {
+	in, err := os.Open(s.fifo)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	r := bufio.NewReader(in)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			if err == io.EOF {
+				line = append(line, '\n')
+			}
+			if _, werr := f.Write(line); werr != nil {
+				return werr
+			}
+			s.mu.Lock()
+			s.size += int64(len(line))
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// received returns the length of the lines received, or the error that
+// stopped the copy.
+func (s *stream) received() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, fmt.Errorf("could not read %s: %v", s.fifo, s.err)
+	}
+	return s.size, nil
+}
@@ -0,0 +1,87 @@
+package csvql
+
+import (
+	"fmt"
+	"math/big"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// newSum returns the SUM(expr) aggregation, replacing the one of
+// go-mysql-server so the sums of decimals are exact decimals, with the scale
+// of the values summed, instead of floats.
+func newSum(arg sql.Expression) sql.Expression {
+	return &sum{arg}
+}
+
+type sum struct {
+	arg sql.Expression
+}
+
+func (s *sum) Resolved() bool             { return s.arg.Resolved() }
+func (s *sum) String() string             { return fmt.Sprintf("SUM(%s)", s.arg) }
+func (s *sum) IsNullable() bool           { return true }
+func (s *sum) Children() []sql.Expression { return []sql.Expression{s.arg} }
+
+func (s *sum) Type() sql.Type {
+	if d, ok := s.arg.Type().(decimalT); ok {
+		return decimalT{maxDecimalPrecision, d.scale}
+	}
+	return sql.Float64
+}
+
+func (s *sum) TransformUp(fn sql.TransformExprFunc) (sql.Expression, error) {
+	arg, err := s.arg.TransformUp(fn)
+	if err != nil {
+		return nil, err
+	}
+	return fn(&sum{arg})
+}
+
+// NewBuffer returns a buffer holding the sum, a float, or the exact number
+// for decimals, which is NULL until a value is not.
+func (s *sum) NewBuffer() sql.Row { return sql.NewRow(nil) }
+
+func (s *sum) Update(ctx *sql.Context, buffer, row sql.Row) error {
+	v, err := s.arg.Eval(ctx, row)
+	if err != nil || v == nil {
+		return err
+	}
+	if _, ok := s.arg.Type().(decimalT); ok {
+		r, err := decimalRat(v)
+		if err != nil {
+			return fmt.Errorf("SUM: %v", err)
+		}
+		return s.Merge(ctx, buffer, sql.NewRow(r))
+	}
+	// As in MySQL, text that is not a number counts as 0.
+	f, err := sql.Float64.Convert(v)
+	if err != nil {
+		f = float64(0)
+	}
+	return s.Merge(ctx, buffer, sql.NewRow(f))
+}
+
+func (s *sum) Merge(ctx *sql.Context, buffer, partial sql.Row) error {
+	switch p := partial[0].(type) {
+	case nil:
+	case *big.Rat:
+		if buffer[0] == nil {
+			buffer[0] = new(big.Rat)
+		}
+		buffer[0].(*big.Rat).Add(buffer[0].(*big.Rat), p)
+	case float64:
+		if buffer[0] == nil {
+			buffer[0] = float64(0)
+		}
+		buffer[0] = buffer[0].(float64) + p
+	}
+	return nil
+}
+
+func (s *sum) Eval(ctx *sql.Context, buffer sql.Row) (interface{}, error) {
+	if r, ok := buffer[0].(*big.Rat); ok {
+		return s.Type().(decimalT).format(r)
+	}
+	return buffer[0], nil
+}
@@ -0,0 +1,105 @@
+package csvql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/analyzer"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+// tableFunction returns the table computed by a table function from the
+// given arguments, which can be used in the FROM clause of a query.
+type tableFunction func(args []string) (sql.Table, error)
+
+// tableFunctions maps the name of each table function to its implementation.
+var tableFunctions = map[string]tableFunction{}
+
+// tableFunctionCall matches the calls to table functions in a query. Since
+// the parser does not support them, they are replaced with quoted table names
+// containing the call, which are then resolved by resolveTableFunctions.
+var tableFunctionCall = regexp.MustCompile(`(?i)\b(from|join)\s+(\w+)\s*\(((?:[^()']|'(?:[^']|'')*')*)\)`)
+
+// rewriteTableFunctions replaces the calls to table functions in the query
+// with the quoted table names that resolveTableFunctions understands.
+func rewriteTableFunctions(query string) string {
+	return tableFunctionCall.ReplaceAllStringFunc(query, func(call string) string {
+		m := tableFunctionCall.FindStringSubmatch(call)
+		name := strings.ToLower(m[2])
+		if _, ok := tableFunctions[name]; !ok {
+			return call
+		}
+		return m[1] + " " + quoteIdentifier(name+"("+m[3]+")")
+	})
+}
+
+var (
+	quotedCall    = regexp.MustCompile(`^(\w+)\((.*)\)$`)
+	quotedLiteral = regexp.MustCompile(`^` + quotedString)
+)
+
+// resolveTableFunctions is an analyzer rule replacing the tables named after
+// a table function call with the table it returns.
+func resolveTableFunctions(ctx *sql.Context, a *analyzer.Analyzer, n sql.Node) (sql.Node, error) {
+	return n.TransformUp(func(n sql.Node) (sql.Node, error) {
+		t, ok := n.(*plan.UnresolvedTable)
+		if !ok {
+			return n, nil
+		}
+		m := quotedCall.FindStringSubmatch(t.Name())
+		if m == nil {
+			return n, nil
+		}
+		fn, ok := tableFunctions[m[1]]
+		if !ok {
+			return n, nil
+		}
+
+		args, err := splitArgs(m[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", m[1], err)
+		}
+		table, err := fn(args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", m[1], err)
+		}
+		return plan.NewResolvedTable(table), nil
+	})
+}
+
+// splitArgs splits the arguments of a table function call, which must be
+// string or number literals.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	for s = strings.TrimSpace(s); s != ""; {
+		var arg string
+		if m := quotedLiteral.FindStringSubmatch(s); m != nil {
+			arg, s = unquote(m[1]), s[len(m[0]):]
+		} else {
+			i := strings.Index(s, ",")
+			if i < 0 {
+				i = len(s)
+			}
+			arg, s = strings.TrimSpace(s[:i]), s[i:]
+			if strings.ContainsAny(arg, "'() ") || arg == "" {
+				return nil, fmt.Errorf("arguments must be literals, got %q", arg)
+			}
+		}
+		args = append(args, arg)
+
+		s = strings.TrimSpace(s)
+		if s == "" {
+			break
+		}
+		if s[0] != ',' {
+			return nil, fmt.Errorf("expected comma after argument %q", arg)
+		}
+		s = strings.TrimSpace(s[1:])
+		if s == "" {
+			return nil, fmt.Errorf("missing argument after comma")
+		}
+	}
+	return args, nil
+}
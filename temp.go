@@ -0,0 +1,154 @@
+package csvql
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// tempDir is the directory where temporary files are written, such as the
+// runs of large sorts and the new versions of files being rewritten.
+var tempDir = os.TempDir()
+
+// tempPrefix starts the names of the temporary files, which are followed by
+// the id of the process creating them so orphaned files can be recognized.
+const tempPrefix = "csvql-"
+
+// SetTempDir sets the directory where temporary files are written, creating
+// it if needed, and removes the files left there by processes that are no
+// longer running.
+func SetTempDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not create temporary directory: %v", err)
+	}
+	tempDir = dir
+	return CleanTempDir()
+}
+
+// CleanTempDir removes the temporary files and directories created by csvql
+// processes that are no longer running.
+func CleanTempDir() error {
+	fis, err := ioutil.ReadDir(tempDir)
+	if err != nil {
+		return fmt.Errorf("could not read temporary directory: %v", err)
+	}
+	for _, fi := range fis {
+		name := fi.Name()
+		if !strings.HasPrefix(name, tempPrefix) || !strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimPrefix(name, tempPrefix), "-", 2)
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil || running(pid) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(tempDir, name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not remove orphaned temporary file: %v", err)
+		}
+	}
+	return nil
+}
+
+// running reports whether a process with the given id is running.
+func running(pid int) bool {
+	if pid == os.Getpid() {
+		return true
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// FindProcess only succeeds for running processes on Windows.
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// tempFile creates a new temporary file, whose name includes the given kind.
+func tempFile(kind string) (*os.File, error) {
+	return ioutil.TempFile(tempDir, fmt.Sprintf("%s%d-%s-*.tmp", tempPrefix, os.Getpid(), kind))
+}
+
+// tempDirectory creates a new temporary directory, whose name includes the
+// given kind, for files whose names matter, such as their extensions.
+func tempDirectory(kind string) (string, error) {
+	return ioutil.TempDir(tempDir, fmt.Sprintf("%s%d-%s-*.tmp", tempPrefix, os.Getpid(), kind))
+}
+
+// moveFile atomically replaces dst with the temporary file src, waiting for
+// the file and its directory to be on disk. When they are in different file
+// systems, src is copied next to dst first.
+func moveFile(src, dst string) error {
+	if err := syncFile(src); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return syncDir(filepath.Dir(dst))
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := ioutil.TempFile(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Chmod(fi.Mode())
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), dst)
+	}
+	if err == nil {
+		err = syncDir(filepath.Dir(dst))
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Remove(src)
+}
+
+// syncFile waits for the contents of the file at path to be on disk.
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// syncDir waits for the entries of a directory to be on disk, so the files
+// renamed into it stay there after a crash. Directories can not be synced on
+// Windows, where renames are written through.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	return syncFile(dir)
+}
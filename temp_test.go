@@ -0,0 +1,35 @@
+package csvql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveFile(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "new.tmp"), filepath.Join(dir, "t.csv")
+	for _, f := range []struct{ path, content string }{{src, "a\n2\n"}, {dst, "a\n1\n"}} {
+		if err := ioutil.WriteFile(f.path, []byte(f.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := moveFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(dst); err != nil || string(b) != "a\n2\n" {
+		t.Errorf("got %q and error %v, want the new file", b, err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("the temporary file was left, %v", err)
+	}
+
+	if err := moveFile(src, dst); err == nil {
+		t.Error("moving a missing file did not fail")
+	}
+	if b, _ := ioutil.ReadFile(dst); string(b) != "a\n2\n" {
+		t.Errorf("a failed move changed the file to %q", b)
+	}
+}
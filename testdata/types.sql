@@ -0,0 +1,34 @@
+CREATE TABLE items(id INTEGER PRIMARY KEY, name TEXT NOT NULL, qty INT, price REAL, added DATE, data BLOB, note);
+INSERT INTO items VALUES (1, 'Ana', 0, 3, '2024-02-29', x'00ff', 'x');
+INSERT INTO items VALUES (2, 'Ção', -1, 2.5, 20240229, NULL, 1.5);
+INSERT INTO items VALUES (3, '', 127, NULL, NULL, x'', NULL);
+INSERT INTO items VALUES (4, 'big', 9223372036854775807, -0.0, 1e3, 'text', 7);
+INSERT INTO items VALUES (5, 'min', -9223372036854775808, 0.125, NULL, NULL, NULL);
+INSERT INTO items VALUES (6, 'sizes', 128, 32768, NULL, NULL, NULL);
+INSERT INTO items VALUES (7, 'n/a', 'n/a', '1.25', NULL, NULL, NULL);
+INSERT INTO items VALUES (8, 'text int', '12', 'abc', NULL, NULL, NULL);
+INSERT INTO items VALUES (9, 'i48', 140737488355327, NULL, NULL, NULL, NULL);
+INSERT INTO items VALUES (10, 'i24', -8388608, NULL, NULL, NULL, NULL);
+INSERT INTO items VALUES (100, 'gap', 2147483648, 1, NULL, NULL, NULL);
+CREATE TABLE "Order Items"("Item Id" INTEGER, [Qty] int, `x y` text, PRIMARY KEY("Item Id"));
+INSERT INTO "Order Items" VALUES (5, 2, 'a'), (9, NULL, 'b');
+CREATE TABLE keyed(code INT PRIMARY KEY, label TEXT);
+INSERT INTO keyed VALUES (7, 'seven'), (3, 'three');
+CREATE TABLE seq(id INTEGER PRIMARY KEY AUTOINCREMENT, v TEXT);
+INSERT INTO seq(v) VALUES ('a'), ('b');
+CREATE TABLE wr(k TEXT PRIMARY KEY, v INT) WITHOUT ROWID;
+INSERT INTO wr VALUES ('a', 1);
+CREATE INDEX items_name ON items(name);
+CREATE VIEW cheap AS SELECT * FROM items WHERE price < 3;
+CREATE TABLE altered(id INTEGER PRIMARY KEY, a TEXT);
+INSERT INTO altered VALUES (1, 'old');
+ALTER TABLE altered ADD COLUMN b INT DEFAULT 5;
+ALTER TABLE altered ADD COLUMN c TEXT DEFAULT 'it''s';
+ALTER TABLE altered ADD COLUMN d REAL DEFAULT (-1.5);
+ALTER TABLE altered ADD COLUMN e TEXT;
+INSERT INTO altered VALUES (2, 'new', 6, 'some', 1.5, 'e');
+CREATE TABLE gen(a INT, b INT GENERATED ALWAYS AS (a * 2) VIRTUAL, c INT AS (a + 1) STORED, d TEXT CHECK (CAST(d AS TEXT) <> ''));
+INSERT INTO gen(a, d) VALUES (1, 'one'), (2, 'two');
+CREATE VIRTUAL TABLE docs USING fts5(title, body);
+INSERT INTO docs VALUES ('hello', 'world');
+CREATE TABLE copy AS SELECT id, name, price FROM items WHERE id < 3;
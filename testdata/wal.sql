@@ -0,0 +1,15 @@
+-- Written by sqlite3 wal-new.db < wal.sql && rm wal-new.db*, leaving the
+-- changes made after the checkpoint in wal.db-wal only.
+PRAGMA journal_mode=WAL;
+PRAGMA wal_autocheckpoint=0;
+CREATE TABLE stock(id INTEGER PRIMARY KEY, item TEXT, qty INT);
+INSERT INTO stock VALUES (1, 'apples', 10), (2, 'pears', 5), (3, 'plums', 0);
+PRAGMA wal_checkpoint(TRUNCATE);
+UPDATE stock SET qty = 7 WHERE id = 1;
+DELETE FROM stock WHERE id = 3;
+INSERT INTO stock VALUES (4, 'figs', 12);
+CREATE TABLE moves(id INTEGER PRIMARY KEY, stock INT, qty INT);
+INSERT INTO moves VALUES (1, 1, -3);
+-- The files are copied before the shell closes the database, which would
+-- write the changes to it.
+.shell cp wal-new.db wal.db && cp wal-new.db-wal wal.db-wal
@@ -0,0 +1,247 @@
+package csvql
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+func init() {
+	functions = append(functions,
+		// NOW() and CURRENT_TIMESTAMP() return the time the query started at,
+		// and CURDATE() and CURRENT_DATE() its date, in the time zone of the
+		// session.
+		&scalar{name: "now", typ: sql.Timestamp, sessionEval: now},
+		&scalar{name: "current_timestamp", typ: sql.Timestamp, sessionEval: now},
+		&scalar{name: "curdate", typ: sql.Date, sessionEval: curdate},
+		&scalar{name: "current_date", typ: sql.Date, sessionEval: curdate},
+		// UTC_TIMESTAMP() returns the time the query started at in UTC.
+		&scalar{name: "utc_timestamp", typ: sql.Timestamp, sessionEval: utcTimestamp},
+		// CONVERT_TZ(ts, from, to) converts a timestamp from a time zone to
+		// another, such as UTC, Europe/Madrid, or +02:00.
+		&scalar{name: "convert_tz", typ: sql.Timestamp, min: 3, max: 3, eval: convertTZ},
+		// UNIX_TIMESTAMP([ts]) returns the seconds since the Unix epoch of a
+		// timestamp, in the time zone of the session, or of the time the query
+		// started at, and FROM_UNIXTIME(n) the timestamp of a number of them.
+		&scalar{name: "unix_timestamp", typ: sql.Int64, max: 1, sessionEval: unixTimestamp},
+		&scalar{name: "from_unixtime", typ: sql.Timestamp, min: 1, max: 1, sessionEval: fromUnixtime},
+	)
+}
+
+// timeZone is the time zone of the sessions that do not set their time_zone
+// variable, and of the times written with their offsets in the text files of
+// new databases, if set.
+var timeZone *time.Location
+
+// SetTimeZone sets the time zone of the sessions that do not set their
+// time_zone variable, as in SET time_zone = 'Europe/Madrid', which NOW() and
+// the other time functions use, and the one the timestamps written with their
+// offsets in the text files of the databases created afterwards are read in,
+// such as Europe/Madrid, UTC, +02:00, or SYSTEM, the one of the machine.
+// Without it, sessions use the one of the machine, and those timestamps are
+// read in UTC.
+func SetTimeZone(name string) error {
+	if name == "" {
+		timeZone = nil
+		return nil
+	}
+	loc, err := loadTimeZone(name)
+	if err != nil {
+		return err
+	}
+	timeZone = loc
+	return nil
+}
+
+// setTimeZone sets the time zone of the timestamps of a table, given by its
+// timezone option or SetTimeZone.
+func (d *Dialect) setTimeZone(opts map[string]string) error {
+	if d.lines() {
+		d.TimeZone = timeZone
+	}
+	s, ok := opts["timezone"]
+	if !ok {
+		return nil
+	}
+	if !d.lines() {
+		return fmt.Errorf("%s files have typed dates", d.Format)
+	}
+	loc, err := loadTimeZone(s)
+	if err != nil {
+		return err
+	}
+	d.TimeZone = loc
+	return nil
+}
+
+// timeZones caches the time zones loaded by name.
+var timeZones sync.Map
+
+// loadTimeZone returns the time zone with a name of the IANA database, or an
+// offset from UTC, as +02:00. SYSTEM is the one of the machine.
+func loadTimeZone(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.EqualFold(name, "system") {
+		return time.Local, nil
+	}
+	if loc, ok := timeZones.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := parseOffset(name)
+	if err != nil {
+		if loc, err = time.LoadLocation(name); err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", name)
+		}
+	}
+	timeZones.Store(name, loc)
+	return loc, nil
+}
+
+// parseOffset returns the time zone of an offset from UTC, as -05:00.
+func parseOffset(s string) (*time.Location, error) {
+	if len(s) != 6 || (s[0] != '+' && s[0] != '-') || s[3] != ':' {
+		return nil, fmt.Errorf("invalid offset %q", s)
+	}
+	h, err := strconv.Atoi(s[1:3])
+	if err != nil || h > 14 {
+		return nil, fmt.Errorf("invalid offset %q", s)
+	}
+	m, err := strconv.Atoi(s[4:])
+	if err != nil || m > 59 {
+		return nil, fmt.Errorf("invalid offset %q", s)
+	}
+	offset := h*3600 + m*60
+	if s[0] == '-' {
+		offset = -offset
+	}
+	return time.FixedZone(s, offset), nil
+}
+
+// sessionTimeZone returns the time zone of a session, given by its time_zone
+// variable, or by SetTimeZone if it is SYSTEM or the one sessions start with.
+func sessionTimeZone(ctx *sql.Context) (*time.Location, error) {
+	_, v := ctx.Session.Get("time_zone")
+	name := fmt.Sprint(v)
+	if v == nil || name == time.Local.String() || strings.EqualFold(name, "system") {
+		if timeZone == nil {
+			return time.Local, nil
+		}
+		return timeZone, nil
+	}
+	return loadTimeZone(name)
+}
+
+// wallClock returns the time shown by clocks in the time zone of a time, as
+// the times of queries are, which are always in UTC.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// inZone returns the instant of the time shown by clocks in a time zone.
+func inZone(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+// hasZone reports whether the times with a layout tell their time zone.
+func hasZone(layout string) bool {
+	t := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	return t.Format(layout) != inZone(t, time.FixedZone("X", 3600)).Format(layout)
+}
+
+type queryTimeKey struct{}
+
+// withQueryTime returns a context for a query keeping the time it starts at,
+// so the time functions return the same one for all of its rows.
+func withQueryTime(ctx *sql.Context) *sql.Context {
+	nc := *ctx
+	nc.Context = context.WithValue(ctx.Context, queryTimeKey{}, time.Now())
+	return &nc
+}
+
+func queryTime(ctx *sql.Context) time.Time {
+	if t, ok := ctx.Value(queryTimeKey{}).(time.Time); ok {
+		return t
+	}
+	return time.Now()
+}
+
+func now(ctx *sql.Context, args []interface{}) (interface{}, error) {
+	loc, err := sessionTimeZone(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return wallClock(queryTime(ctx).In(loc).Truncate(time.Second)), nil
+}
+
+func curdate(ctx *sql.Context, args []interface{}) (interface{}, error) {
+	t, err := now(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return sql.Date.Convert(t)
+}
+
+func utcTimestamp(ctx *sql.Context, args []interface{}) (interface{}, error) {
+	return queryTime(ctx).UTC().Truncate(time.Second), nil
+}
+
+// timestampArg returns the time of a timestamp or a date.
+func timestampArg(v interface{}) (time.Time, error) {
+	t, err := sql.Timestamp.Convert(v)
+	if err != nil {
+		if t, err = sql.Date.Convert(v); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return t.(time.Time), nil
+}
+
+func convertTZ(args []interface{}) (interface{}, error) {
+	v, err := timestampArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	var zones [2]*time.Location
+	for i := range zones {
+		name, err := stringArg(args, i+1, "")
+		if err != nil {
+			return nil, err
+		}
+		if zones[i], err = loadTimeZone(name); err != nil {
+			return nil, err
+		}
+	}
+	return wallClock(inZone(v, zones[0]).In(zones[1])), nil
+}
+
+func unixTimestamp(ctx *sql.Context, args []interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return queryTime(ctx).Unix(), nil
+	}
+	v, err := timestampArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	loc, err := sessionTimeZone(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return inZone(v, loc).Unix(), nil
+}
+
+func fromUnixtime(ctx *sql.Context, args []interface{}) (interface{}, error) {
+	n, err := intArg(args, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := sessionTimeZone(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return wallClock(time.Unix(n, 0).In(loc)), nil
+}
@@ -0,0 +1,137 @@
+package csvql
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+)
+
+// topKCounters is the number of counters kept by TOPK per value returned,
+// with at least minTopKCounters. The counts are off by at most the number of
+// rows divided by the number of counters.
+const (
+	topKCounters    = 10
+	minTopKCounters = 1000
+)
+
+// defaultTopK is the number of values returned by TOPK if none is given.
+const defaultTopK = 10
+
+func init() {
+	// The parser only groups rows for the aggregations it knows.
+	sqlparser.Aggregates["topk"] = true
+}
+
+// newTopK returns the TOPK(expr[, k]) aggregation, which finds the k most
+// frequent values of an expression in a single pass and bounded memory. It
+// returns them as a JSON array of objects with the value and its count,
+// from the most frequent, with counts that are lower bounds of the real
+// ones.
+func newTopK(args ...sql.Expression) (sql.Expression, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, sql.ErrInvalidArgumentNumber.New("1 to 2", len(args))
+	}
+	return &topK{args}, nil
+}
+
+type topK struct {
+	args []sql.Expression
+}
+
+func (t *topK) Resolved() bool {
+	for _, arg := range t.args {
+		if !arg.Resolved() {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *topK) String() string {
+	if len(t.args) == 1 {
+		return fmt.Sprintf("TOPK(%s)", t.args[0])
+	}
+	return fmt.Sprintf("TOPK(%s, %s)", t.args[0], t.args[1])
+}
+
+func (t *topK) Type() sql.Type             { return sql.Text }
+func (t *topK) IsNullable() bool           { return true }
+func (t *topK) Children() []sql.Expression { return t.args }
+
+func (t *topK) TransformUp(fn sql.TransformExprFunc) (sql.Expression, error) {
+	args := make([]sql.Expression, len(t.args))
+	for i, arg := range t.args {
+		arg, err := arg.TransformUp(fn)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = arg
+	}
+	return fn(&topK{args})
+}
+
+// NewBuffer returns a buffer holding the number of values to return and the
+// sketch counting them, which are set by the first row.
+func (t *topK) NewBuffer() sql.Row { return sql.NewRow(nil, nil) }
+
+func (t *topK) Update(ctx *sql.Context, buffer, row sql.Row) error {
+	v, err := t.args[0].Eval(ctx, row)
+	if err != nil || v == nil {
+		return err
+	}
+	if buffer[1] == nil {
+		k := int64(defaultTopK)
+		if len(t.args) > 1 {
+			v, err := t.args[1].Eval(ctx, row)
+			if err != nil {
+				return err
+			}
+			if k, err = intArg([]interface{}{v}, 0, defaultTopK); err != nil {
+				return fmt.Errorf("TOPK: %v", err)
+			}
+			if k < 1 {
+				return fmt.Errorf("TOPK: the number of values must be positive, got %d", k)
+			}
+		}
+		counters := int(k) * topKCounters
+		if counters < minTopKCounters {
+			counters = minTopKCounters
+		}
+		buffer[0], buffer[1] = int(k), newSpaceSaving(counters)
+	}
+	buffer[1].(*spaceSaving).add(formatValue(v))
+	return nil
+}
+
+func (t *topK) Merge(ctx *sql.Context, buffer, partial sql.Row) error {
+	if partial[1] == nil {
+		return nil
+	}
+	if buffer[1] == nil {
+		buffer[0], buffer[1] = partial[0], partial[1]
+		return nil
+	}
+	buffer[1].(*spaceSaving).merge(partial[1].(*spaceSaving))
+	return nil
+}
+
+func (t *topK) Eval(ctx *sql.Context, buffer sql.Row) (interface{}, error) {
+	if buffer[1] == nil {
+		return nil, nil
+	}
+	type valueCount struct {
+		Value string `json:"value"`
+		Count int64  `json:"count"`
+	}
+	var values []valueCount
+	for _, v := range buffer[1].(*spaceSaving).top(buffer[0].(int)) {
+		values = append(values, valueCount{v.Value, v.Count})
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,207 @@
+package csvql
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// types maps the type names accepted in schema files to SQL types.
+var types = map[string]sql.Type{
+	"":          sql.Text,
+	"text":      sql.Text,
+	"string":    sql.Text,
+	"varchar":   sql.Text,
+	"int":       sql.Int64,
+	"integer":   sql.Int64,
+	"int64":     sql.Int64,
+	"bigint":    sql.Int64,
+	"float":     sql.Float64,
+	"float64":   sql.Float64,
+	"double":    sql.Float64,
+	"bool":      sql.Boolean,
+	"boolean":   sql.Boolean,
+	"date":      sql.Date,
+	"timestamp": sql.Timestamp,
+	"datetime":  sql.Timestamp,
+	"uuid":      UUID,
+}
+
+// ParseType returns the SQL type with the given name, as used in schema files.
+// An empty name corresponds to the text type, and decimal(p,s) to a decimal
+// one, as returned by Decimal.
+func ParseType(name string) (sql.Type, error) {
+	if t, ok, err := parseDecimalType(name); ok {
+		return t, err
+	}
+	t, ok := types[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown type %q", name)
+	}
+	return t, nil
+}
+
+// TypeName returns the name used in schema files for the given type.
+func TypeName(t sql.Type) string {
+	if d, ok := t.(decimalT); ok {
+		return fmt.Sprintf("decimal(%d,%d)", d.precision, d.scale)
+	}
+	switch t {
+	case sql.Int32, sql.Int64, sql.Uint32, sql.Uint64:
+		return "int"
+	case sql.Float32, sql.Float64:
+		return "float"
+	case sql.Boolean:
+		return "bool"
+	case sql.Date:
+		return "date"
+	case sql.Timestamp:
+		return "timestamp"
+	case UUID:
+		return "uuid"
+	default:
+		return "text"
+	}
+}
+
+// valueFormat describes how the values in a column are written.
+type valueFormat struct {
+	// layout is used to parse dates and timestamps, the MySQL one if empty.
+	layout string
+	// trueValues and falseValues are the words accepted as booleans, compared
+	// case insensitively. They default to the ones in boolValues.
+	trueValues, falseValues []string
+	// symbols is set for numbers written with currency symbols, thousands
+	// separators, or a trailing percent sign.
+	symbols bool
+	// nanAsNull is set for floating point columns where NaN means NULL.
+	nanAsNull bool
+	// locale is the one numbers are written in, if any, as in 1.234,56.
+	locale string
+	// zone is the one timestamps written with their offsets are read in, if
+	// their layout has them.
+	zone *time.Location
+}
+
+// boolValues maps the words accepted by default in boolean columns to the
+// value they represent.
+var boolValues = map[string]bool{
+	"true": true, "t": true, "yes": true, "y": true, "on": true, "1": true,
+	"false": false, "f": false, "no": false, "n": false, "off": false, "0": false,
+}
+
+// parseBool parses a boolean with the words accepted by the format.
+func (f valueFormat) parseBool(s string) (bool, error) {
+	if f.trueValues == nil && f.falseValues == nil {
+		if b, ok := boolValues[strings.ToLower(s)]; ok {
+			return b, nil
+		}
+		return false, fmt.Errorf("invalid boolean %q", s)
+	}
+	for _, v := range f.trueValues {
+		if strings.EqualFold(s, v) {
+			return true, nil
+		}
+	}
+	for _, v := range f.falseValues {
+		if strings.EqualFold(s, v) {
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("invalid boolean %q", s)
+}
+
+// parseValue converts a textual value, written in the given format, to the
+// given type.
+func parseValue(t sql.Type, f valueFormat, s string) (interface{}, error) {
+	if isNumeric(t) {
+		s = localeValue(f.locale, s)
+	}
+	scale := 1.0
+	if f.symbols && isNumeric(t) {
+		s, scale = stripSymbols(s)
+	}
+	if d, ok := t.(decimalT); ok {
+		r, err := decimalRat(s)
+		if err != nil {
+			return nil, err
+		}
+		if scale != 1 {
+			r.Mul(r, big.NewRat(1, 100))
+		}
+		return d.format(r)
+	}
+
+	switch t {
+	case sql.Int32, sql.Int64, sql.Uint32, sql.Uint64:
+		if scale != 1 {
+			return nil, fmt.Errorf("percentage %q is not an integer", s)
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return t.Convert(n)
+	case sql.Float32, sql.Float64:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(v) && f.nanAsNull {
+			return nil, nil
+		}
+		return t.Convert(v * scale)
+	case sql.Boolean:
+		return f.parseBool(s)
+	case sql.Date, sql.Timestamp:
+		layout := f.layout
+		if layout == "" {
+			layout = sql.TimestampLayout
+			if t == sql.Date {
+				layout = sql.DateLayout
+			}
+		}
+		tm, err := time.Parse(layout, s)
+		if err != nil {
+			return nil, err
+		}
+		if f.zone != nil {
+			tm = wallClock(tm.In(f.zone))
+		}
+		return t.Convert(tm)
+	default:
+		return t.Convert(s)
+	}
+}
+
+// currencySymbols are the symbols removed from numbers with symbols.
+const currencySymbols = "$€£¥"
+
+// stripSymbols removes the currency symbols and thousands separators from a
+// number, returning it with the scale to apply: 0.01 for percentages, 1
+// otherwise. Negative amounts can be written in parentheses, as in
+// accounting.
+func stripSymbols(s string) (string, float64) {
+	scale := 1.0
+	if strings.HasSuffix(s, "%") {
+		s, scale = strings.TrimSpace(strings.TrimSuffix(s, "%")), 0.01
+	}
+	neg := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		s, neg = s[1:len(s)-1], true
+	}
+	if strings.HasPrefix(s, "-") {
+		s, neg = s[1:], !neg
+	}
+	s = strings.TrimSpace(strings.Trim(s, currencySymbols))
+	s = strings.Replace(s, ",", "", -1)
+	if neg {
+		s = "-" + s
+	}
+	return s, scale
+}
@@ -0,0 +1,360 @@
+package csvql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/expression"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/parse"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+// selectQuery runs a query supported by go-mysql-server, or a UNION of them,
+// which it does not support.
+func (e *Engine) selectQuery(ctx *sql.Context, query string) (sql.Schema, sql.RowIter, error) {
+	branches, distinct, tail := splitUnion(query)
+	if len(branches) == 1 {
+		return e.Engine.Query(ctx, rewrite(query))
+	}
+	node, err := e.union(ctx, branches, distinct, tail)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := node.RowIter(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return node.Schema(), rows, nil
+}
+
+// union returns the plan of a UNION of the given SELECT statements, where
+// distinct tells whether the duplicates of the rows before each of them are
+// removed, and tail holds the ORDER BY and LIMIT clauses of the result.
+// The branches of the UNION are run concurrently.
+func (e *Engine) union(ctx *sql.Context, branches []string, distinct []bool, tail string) (sql.Node, error) {
+	var nodes []sql.Node
+	for _, b := range branches {
+		parsed, err := parse.Parse(ctx, rewrite(b))
+		if err != nil {
+			return nil, err
+		}
+		n, err := e.Analyzer.Analyze(ctx, parsed)
+		if err != nil {
+			return nil, err
+		}
+		if len(nodes) > 0 && len(n.Schema()) != len(nodes[0].Schema()) {
+			return nil, fmt.Errorf("the SELECT statements of the UNION have a different number of columns")
+		}
+		nodes = append(nodes, n)
+	}
+
+	// As in MySQL, a UNION DISTINCT removes the duplicates of every row
+	// before it, even if they come from a UNION ALL.
+	var node sql.Node = &unionNode{nodes: nodes[:1]}
+	last := 0
+	for i, d := range distinct {
+		if d {
+			last = i + 1
+		}
+	}
+	if last > 0 {
+		node = plan.NewDistinct(&unionNode{nodes: nodes[:last+1]})
+	}
+	if last+1 < len(nodes) {
+		node = &unionNode{nodes: append([]sql.Node{node}, nodes[last+1:]...)}
+	}
+	return unionTail(node, tail)
+}
+
+// unionNode returns the rows of its nodes one after the other, reading them
+// concurrently, with the column names of the first.
+type unionNode struct {
+	nodes []sql.Node
+}
+
+func (u *unionNode) Resolved() bool       { return true }
+func (u *unionNode) Children() []sql.Node { return nil }
+func (u *unionNode) Schema() sql.Schema   { return u.nodes[0].Schema() }
+
+func (u *unionNode) String() string {
+	p := sql.NewTreePrinter()
+	_ = p.WriteNode("Union")
+	var children []string
+	for _, n := range u.nodes {
+		children = append(children, n.String())
+	}
+	_ = p.WriteChildren(children...)
+	return p.String()
+}
+
+func (u *unionNode) RowIter(ctx *sql.Context) (sql.RowIter, error) {
+	sources := make([]opener, len(u.nodes))
+	for i, n := range u.nodes {
+		sources[i] = n.RowIter
+	}
+	return newParallelIter(ctx, sources), nil
+}
+
+func (u *unionNode) TransformUp(f sql.TransformNodeFunc) (sql.Node, error) { return f(u) }
+func (u *unionNode) TransformExpressionsUp(f sql.TransformExprFunc) (sql.Node, error) {
+	return u, nil
+}
+
+var (
+	orderItem   = regexp.MustCompile("(?is)^\\s*(\\d+|`(?:[^`]|``)+`|[\\w$]+)(?:\\s+(asc|desc))?\\s*$")
+	limitClause = regexp.MustCompile(`(?is)^\s*limit\s+(\d+)(?:\s*,\s*(\d+)|\s+offset\s+(\d+))?\s*;?\s*$`)
+)
+
+// unionTail applies the ORDER BY and LIMIT clauses ending a UNION to its
+// rows. The columns are ordered by name or position, since the ones of the
+// tables are no longer available.
+func unionTail(node sql.Node, tail string) (sql.Node, error) {
+	tail = strings.TrimSuffix(strings.TrimSpace(tail), ";")
+	if tail == "" {
+		return node, nil
+	}
+
+	order, limit := tail, ""
+	if i, _ := topLevelKeyword(tail, 0, "limit"); i >= 0 {
+		order, limit = tail[:i], tail[i:]
+	}
+	if order = strings.TrimSpace(order); order != "" {
+		_, end := topLevelKeyword(order, 0, "order", "by")
+		if end < 0 {
+			return nil, fmt.Errorf("could not parse the end of the UNION: %s", tail)
+		}
+		var fields []plan.SortField
+		for _, item := range splitTopLevel(order[end:], ',') {
+			f, err := unionSortField(node.Schema(), item)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, f)
+		}
+		node = &spillSort{plan.NewSort(fields, node)}
+	}
+
+	if limit != "" {
+		m := limitClause.FindStringSubmatch(limit)
+		if m == nil {
+			return nil, fmt.Errorf("could not parse the end of the UNION: %s", tail)
+		}
+		size, _ := strconv.ParseInt(m[1], 10, 64)
+		var offset int64
+		switch {
+		case m[2] != "":
+			// LIMIT offset, count
+			offset = size
+			size, _ = strconv.ParseInt(m[2], 10, 64)
+		case m[3] != "":
+			offset, _ = strconv.ParseInt(m[3], 10, 64)
+		}
+		if offset > 0 {
+			node = plan.NewOffset(offset, node)
+		}
+		node = plan.NewLimit(size, node)
+	}
+	return node, nil
+}
+
+// unionSortField returns how to sort the rows of a UNION by an item of its
+// ORDER BY clause.
+func unionSortField(schema sql.Schema, item string) (plan.SortField, error) {
+	m := orderItem.FindStringSubmatch(item)
+	if m == nil {
+		return plan.SortField{}, fmt.Errorf("could not order UNION by %s: only columns of the result are supported", strings.TrimSpace(item))
+	}
+	idx := -1
+	if n, err := strconv.Atoi(m[1]); err == nil {
+		if n < 1 || n > len(schema) {
+			return plan.SortField{}, fmt.Errorf("could not order UNION by %d: there are %d columns", n, len(schema))
+		}
+		idx = n - 1
+	} else {
+		name := strings.Replace(strings.Trim(m[1], "`"), "``", "`", -1)
+		for i, col := range schema {
+			if strings.EqualFold(col.Name, name) {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return plan.SortField{}, fmt.Errorf("could not order UNION by %s: unknown column", name)
+		}
+	}
+
+	col := schema[idx]
+	f := plan.SortField{
+		Column:       expression.NewGetField(idx, col.Type, col.Name, col.Nullable),
+		Order:        plan.Ascending,
+		NullOrdering: plan.NullsFirst,
+	}
+	if strings.EqualFold(m[2], "desc") {
+		f.Order, f.NullOrdering = plan.Descending, plan.NullsLast
+	}
+	return f, nil
+}
+
+// splitUnion splits a query into the SELECT statements joined by UNION, if
+// any, telling for each UNION whether it is DISTINCT, and the ORDER BY and
+// LIMIT clauses following the last statement, which apply to the result.
+// Parenthesized statements are unwrapped.
+func splitUnion(query string) (branches []string, distinct []bool, tail string) {
+	start := 0
+	for {
+		i, end := topLevelKeyword(query, start, "union")
+		if i < 0 {
+			break
+		}
+		branches = append(branches, unparen(query[start:i]))
+		d := true
+		if j, e := topLevelKeyword(query, end, "all"); j >= 0 && strings.TrimSpace(query[end:j]) == "" {
+			d, end = false, e
+		} else if j, e := topLevelKeyword(query, end, "distinct"); j >= 0 && strings.TrimSpace(query[end:j]) == "" {
+			end = e
+		}
+		distinct = append(distinct, d)
+		start = end
+	}
+	if len(branches) == 0 {
+		return []string{query}, nil, ""
+	}
+
+	last := strings.TrimSuffix(strings.TrimSpace(query[start:]), ";")
+	i, _ := topLevelKeyword(last, 0, "order", "by")
+	if j, _ := topLevelKeyword(last, 0, "limit"); j >= 0 && (i < 0 || j < i) {
+		i = j
+	}
+	if i >= 0 {
+		last, tail = last[:i], last[i:]
+	}
+	return append(branches, unparen(last)), distinct, tail
+}
+
+// unparen removes the parentheses around a statement, if any.
+func unparen(s string) string {
+	s = strings.TrimSpace(s)
+	for strings.HasPrefix(s, "(") && closingParen(s) == len(s)-1 {
+		s = strings.TrimSpace(s[1 : len(s)-1])
+	}
+	return s
+}
+
+// closingParen returns the position of the parenthesis closing the one s
+// starts with, or -1 if there is none.
+func closingParen(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'', '"', '`':
+			i = skipQuoted(s, i)
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// topLevelKeyword returns the position of the first sequence of the given
+// keywords, separated by spaces, found in s from start outside of quotes and
+// parentheses, and the position after it, or -1 and -1 if there is none.
+func topLevelKeyword(s string, start int, words ...string) (int, int) {
+	found, end := -1, -1
+	scanTopLevel(s[start:], func(i int) bool {
+		i += start
+		if i > 0 && isWordByte(s[i-1]) {
+			return true
+		}
+		j := i
+		for k, w := range words {
+			if k > 0 {
+				n := j
+				for j < len(s) && isSpace(s[j]) {
+					j++
+				}
+				if j == n {
+					return true
+				}
+			}
+			if len(s)-j < len(w) || !strings.EqualFold(s[j:j+len(w)], w) {
+				return true
+			}
+			j += len(w)
+		}
+		if j < len(s) && isWordByte(s[j]) {
+			return true
+		}
+		found, end = i, j
+		return false
+	})
+	return found, end
+}
+
+// splitTopLevel splits s by the separator, when it is outside of quotes and
+// parentheses.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	last := 0
+	scanTopLevel(s, func(i int) bool {
+		if s[i] == sep {
+			parts = append(parts, s[last:i])
+			last = i + 1
+		}
+		return true
+	})
+	return append(parts, s[last:])
+}
+
+// scanTopLevel calls f with the position of every byte of s outside of
+// quotes and parentheses, until it returns false.
+func scanTopLevel(s string, f func(i int) bool) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'', '"', '`':
+			i = skipQuoted(s, i)
+		case '(':
+			depth++
+		case ')':
+			depth--
+		default:
+			if depth == 0 && !f(i) {
+				return
+			}
+		}
+	}
+}
+
+// skipQuoted returns the position of the quote closing the one at i, or the
+// end of s if there is none.
+func skipQuoted(s string, i int) int {
+	c := s[i]
+	for j := i + 1; j < len(s); j++ {
+		if c != '`' && s[j] == '\\' {
+			j++
+			continue
+		}
+		if s[j] == c {
+			if j+1 < len(s) && s[j+1] == c {
+				j++
+				continue
+			}
+			return j
+		}
+	}
+	return len(s)
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isSpace(c byte) bool { return c == ' ' || c == '\t' || c == '\n' || c == '\r' }
@@ -0,0 +1,375 @@
+package csvql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/parse"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+func init() {
+	statements = append(statements, statement{
+		re:  regexp.MustCompile(`(?is)^\s*update\s+(` + namePart + `(?:\.` + namePart + `)?)\s+set\s+(.+?)\s*;?\s*$`),
+		run: (*Engine).update,
+	})
+}
+
+// update runs UPDATE table SET column = value[, ...] [WHERE condition],
+// setting the columns of the rows of a table matching the condition, if any,
+// to the values computed from them. The rows the grants of the user do not
+// let them read are not updated, nor can the others be updated so they do
+// not.
+func (e *Engine) update(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	name := strings.ToLower(strings.Replace(m[1], "`", "", -1))
+	t, err := e.writableTable(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	set, where := m[2], ""
+	if i, end := topLevelKeyword(set, 0, "where"); i >= 0 {
+		set, where = set[:i], set[end:]
+	}
+
+	var columns []int
+	var values []string
+	for _, a := range splitTopLevel(set, ',') {
+		eq := strings.Index(a, "=")
+		if eq < 0 {
+			return nil, nil, fmt.Errorf("could not parse assignment %s", strings.TrimSpace(a))
+		}
+		col := strings.TrimSpace(strings.Replace(a[:eq], "`", "", -1))
+		if dot := strings.LastIndex(col, "."); dot >= 0 {
+			col = col[dot+1:]
+		}
+		i, err := t.updatedColumn(col)
+		if err != nil {
+			return nil, nil, err
+		}
+		columns = append(columns, i)
+		values = append(values, a[eq+1:])
+	}
+
+	exprs, cond, err := parseAssignments(values, where)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse UPDATE of %s: %v", name, err)
+	}
+	for i, ex := range exprs {
+		if exprs[i], err = resolvePredicate(e.Catalog, ex, t.Schema()); err != nil {
+			return nil, nil, fmt.Errorf("could not update %s: %v", name, err)
+		}
+	}
+	if cond != nil {
+		if cond, err = resolvePredicate(e.Catalog, cond, t.Schema()); err != nil {
+			return nil, nil, fmt.Errorf("could not update %s: %v", name, err)
+		}
+	}
+	granted, err := e.grantedRows(ctx, name, t)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n, err := t.db.rewrite(ctx, t, columns, func(row sql.Row) (sql.Row, bool, error) {
+		if ok, err := matches(ctx, row, granted, cond); err != nil || !ok {
+			return nil, true, err
+		}
+		updated := make(sql.Row, len(row))
+		copy(updated, row)
+		// As in standard SQL, the values are computed from the ones of the
+		// row before it is updated.
+		for k, i := range columns {
+			v, err := exprs[k].Eval(ctx, row)
+			if err != nil {
+				return nil, false, err
+			}
+			if updated[i], err = t.updatedValue(i, v); err != nil {
+				return nil, false, err
+			}
+		}
+		if ok, err := matches(ctx, updated, granted); err != nil {
+			return nil, false, err
+		} else if !ok {
+			return nil, false, fmt.Errorf("access denied for user %s to update rows so they can not read them", ctx.Session.User())
+		}
+		return updated, true, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return rowsAffected(n)
+}
+
+// matches reports whether a row satisfies all the given predicates, skipping
+// the nil ones.
+func matches(ctx *sql.Context, row sql.Row, predicates ...sql.Expression) (bool, error) {
+	for _, p := range predicates {
+		if p == nil {
+			continue
+		}
+		if v, err := p.Eval(ctx, row); err != nil || v != true {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// parseAssignments parses the values assigned by an UPDATE statement, and
+// the condition of its WHERE clause, if any.
+func parseAssignments(values []string, where string) ([]sql.Expression, sql.Expression, error) {
+	query := "SELECT " + strings.Join(values, ", ") + " FROM t"
+	if where != "" {
+		query += " WHERE " + where
+	}
+	n, err := parse.Parse(sql.NewEmptyContext(), query)
+	if err != nil {
+		return nil, nil, err
+	}
+	var exprs []sql.Expression
+	var cond sql.Expression
+	plan.Inspect(n, func(n sql.Node) bool {
+		switch n := n.(type) {
+		case *plan.Project:
+			exprs = n.Projections
+		case *plan.Filter:
+			cond = n.Expression
+		}
+		return true
+	})
+	if len(exprs) != len(values) {
+		return nil, nil, fmt.Errorf("could not parse the values assigned")
+	}
+	return exprs, cond, nil
+}
+
+// writableTable returns the table with the given name, qualified or not, if
+// its file can be rewritten, and the user of the session can change it.
+func (e *Engine) writableTable(ctx *sql.Context, name string) (*table, error) {
+	if err := e.checkWrite(ctx, name); err != nil {
+		return nil, err
+	}
+	t, err := catalogTable(e.Analyzer, name)
+	if err != nil {
+		return nil, err
+	}
+	ct, ok := t.(*table)
+	if !ok || ct.db == nil {
+		return nil, fmt.Errorf("table %s is read only", name)
+	}
+	// The records of other formats are not read as they are written, and
+	// the comments of delimited files are skipped, so they would be lost.
+	if !ct.dialect.delimited() || ct.dialect.Comment != "" {
+		return nil, fmt.Errorf("could not rewrite table %s: only delimited files without comments can be rewritten", name)
+	}
+	ct.db.mu.Lock()
+	_, ok = ct.db.txs[ctx.Session]
+	ct.db.mu.Unlock()
+	if ok {
+		return nil, fmt.Errorf("could not rewrite table %s: a transaction is in progress", name)
+	}
+	return ct, nil
+}
+
+// grantedRows returns the predicate the rows of a table must satisfy for the
+// user of the session to read them, or nil if there is none.
+func (e *Engine) grantedRows(ctx *sql.Context, name string, t *table) (sql.Expression, error) {
+	if e.grants == nil {
+		return nil, nil
+	}
+	user := ctx.Session.User()
+	u := e.grants.user(user)
+	if u == nil {
+		return nil, fmt.Errorf("access denied for user %s", user)
+	}
+	filter, ok := u.table(name)
+	if !ok {
+		return nil, fmt.Errorf("access denied for user %s to table %s", user, name)
+	}
+	if filter == nil {
+		return nil, nil
+	}
+	f, err := resolvePredicate(e.Catalog, filter, t.Schema())
+	if err != nil {
+		return nil, fmt.Errorf("could not apply grants of user %s on table %s: %v", user, name, err)
+	}
+	return f, nil
+}
+
+// updatedColumn returns the position of a column of a table that can be
+// updated, which excludes the computed and pseudo columns.
+func (t *table) updatedColumn(name string) (int, error) {
+	for i, col := range t.schema {
+		if !strings.EqualFold(col.Name, name) {
+			continue
+		}
+		if i >= len(t.schema)-len(t.computed)-len(t.pseudo) {
+			return 0, fmt.Errorf("column %s of table %s can not be updated", col.Name, t.name)
+		}
+		return i, nil
+	}
+	return 0, fmt.Errorf("unknown column %s in table %s", name, t.name)
+}
+
+// updatedValue returns a value set to the column i of a table, converted to
+// its type, so it can be read back.
+func (t *table) updatedValue(i int, v interface{}) (interface{}, error) {
+	col := t.schema[i]
+	if !t.typed(i) {
+		return v, nil
+	}
+	if v == nil {
+		if !col.Nullable {
+			return nil, fmt.Errorf("column %s of table %s can not be NULL", col.Name, t.name)
+		}
+		return nil, nil
+	}
+	typed, err := col.Type.Convert(v)
+	if err != nil {
+		return nil, fmt.Errorf("could not set column %s of %s to %v: %v", col.Name, t.name, v, err)
+	}
+	return typed, nil
+}
+
+// rewrite writes the rows of the file of a table to a temporary file, with
+// the given columns of the ones change returns a new row for set to its
+// values, without the ones it tells not to keep, and moves it in place of
+// the file, so its readers never see it half written. It returns how many
+// rows were changed or removed, and leaves the file as it was if there is
+// none.
+func (db *database) rewrite(ctx *sql.Context, t *table, columns []int, change func(sql.Row) (sql.Row, bool, error)) (int64, error) {
+	db.wmu.Lock()
+	defer db.wmu.Unlock()
+
+	in, err := os.Open(t.path)
+	if err != nil {
+		return 0, fmt.Errorf("could not open %s: %v", t.path, err)
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("could not open %s: %v", t.path, err)
+	}
+	f, err := tempFile("rewrite")
+	if err != nil {
+		return 0, fmt.Errorf("could not rewrite %s: %v", t.path, err)
+	}
+	n, err := t.rewriteRows(ctx, in, info, f, columns, change)
+	if err == nil {
+		err = f.Chmod(info.Mode())
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n > 0 {
+		if err = db.retain(t.name, t.path); err == nil {
+			err = moveFile(f.Name(), t.path)
+		}
+	}
+	if err != nil || n == 0 {
+		os.Remove(f.Name())
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not rewrite %s: %v", t.path, err)
+	}
+	RowsWritten.Add(n)
+	return n, nil
+}
+
+// rewriteRows writes the records of a file of the table to out, with the
+// changes of rewrite. The lines skipped at its start are written as they
+// are, followed by its header, and the values of the records not changed.
+func (t *table) rewriteRows(ctx *sql.Context, in *os.File, info os.FileInfo, out io.Writer, columns []int, change func(sql.Row) (sql.Row, bool, error)) (int64, error) {
+	br := bufio.NewReader(in)
+	start := t.dialect.skipStart(br)
+	bw := bufio.NewWriter(out)
+	if _, err := io.Copy(bw, io.NewSectionReader(in, 0, start)); err != nil {
+		return 0, err
+	}
+	r := t.reader(br, t.path)
+	w := t.writer(bw)
+	if t.dialect.header() {
+		if _, err := r.Read(); err != nil && err != io.EOF {
+			return 0, err
+		}
+		if err := w.Write(t.header); err != nil {
+			return 0, err
+		}
+	}
+
+	nr, _ := r.(nullReader)
+	nw, _ := w.(nullWriter)
+	n := len(t.schema) - len(t.computed) - len(t.pseudo)
+	src := &rowSource{path: t.path, info: info}
+	var changed int64
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		var nulls []bool
+		if nr != nil {
+			nulls = nr.nulls()
+		}
+		line := t.dialect.SkipRows + recordLine(r)
+		row := make(sql.Row, len(t.schema))
+		for i, s := range record {
+			if nulls != nil && nulls[i] {
+				continue
+			}
+			if row[i], err = t.columnValue(i, strings.TrimSpace(s)); err != nil {
+				return 0, fmt.Errorf("could not read column %s in line %d of %s: %v", t.schema[i].Name, line, t.path, err)
+			}
+		}
+		if len(t.computed) > 0 {
+			if errs := t.compute(ctx, row, n); len(errs) > 0 {
+				return 0, fmt.Errorf("could not compute column %s in line %d of %s", errs[0], line, t.path)
+			}
+		}
+		src.line = int64(line)
+		for i, col := range t.pseudo {
+			row[n+len(t.computed)+i] = col.value(src)
+		}
+
+		updated, keep, err := change(row)
+		if err != nil {
+			return 0, fmt.Errorf("could not change line %d of %s: %v", line, t.path, err)
+		}
+		if !keep {
+			changed++
+			continue
+		}
+		if updated != nil {
+			if nulls == nil {
+				nulls = make([]bool, len(record))
+			}
+			for _, i := range columns {
+				if i >= len(record) {
+					return 0, fmt.Errorf("line %d of %s has no column %s", line, t.path, t.schema[i].Name)
+				}
+				record[i] = t.formatColumn(i, updated[i])
+				nulls[i] = updated[i] == nil
+			}
+			changed++
+		}
+		if nw != nil && nulls != nil {
+			err = nw.writeNulls(record, nulls)
+		} else {
+			err = w.Write(record)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return 0, err
+	}
+	return changed, bw.Flush()
+}
@@ -0,0 +1,188 @@
+package csvql
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-vitess.v0/sqltypes"
+	"gopkg.in/src-d/go-vitess.v0/vt/proto/query"
+)
+
+func init() {
+	functions = append(functions,
+		// UUID() returns a random (version 4) UUID.
+		&scalar{name: "uuid", typ: sql.Text, min: 0, max: 0, eval: newUUID},
+		// IS_UUID(str) reports whether str is a UUID, with or without dashes
+		// and braces, as in MySQL.
+		&scalar{name: "is_uuid", typ: sql.Boolean, min: 1, max: 1, eval: isUUID},
+		// UUID_TO_BIN(str[, swap]) returns the 16 bytes of a UUID and
+		// BIN_TO_UUID(bytes[, swap]) its textual form. When swap is true, the
+		// time low and high parts are swapped, so time based UUIDs sort
+		// chronologically.
+		&scalar{name: "uuid_to_bin", typ: sql.Blob, min: 1, max: 2, eval: uuidToBin},
+		&scalar{name: "bin_to_uuid", typ: sql.Text, min: 1, max: 2, eval: binToUUID},
+	)
+}
+
+// UUID is the type of UUID columns, stored as 16 bytes and shown in their
+// canonical textual form.
+var UUID sql.Type = uuidT{}
+
+// uuid is a value of the UUID type.
+type uuid [16]byte
+
+func (u uuid) String() string {
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	hex.Encode(b[9:13], u[4:6])
+	hex.Encode(b[14:18], u[6:8])
+	hex.Encode(b[19:23], u[8:10])
+	hex.Encode(b[24:], u[10:])
+	b[8], b[13], b[18], b[23] = '-', '-', '-', '-'
+	return string(b[:])
+}
+
+// MarshalText makes UUIDs be encoded as strings in JSON.
+func (u uuid) MarshalText() ([]byte, error) { return []byte(u.String()), nil }
+
+// parseUUID parses a UUID in its canonical form, with dashes and no braces,
+// or, if lenient, also without dashes or within braces.
+func parseUUID(s string, lenient bool) (uuid, bool) {
+	var u uuid
+	if lenient {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			s = s[1 : len(s)-1]
+		}
+		if len(s) == 32 {
+			_, err := hex.Decode(u[:], []byte(s))
+			return u, err == nil
+		}
+	}
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, false
+	}
+	_, err := hex.Decode(u[:], []byte(strings.Replace(s, "-", "", -1)))
+	return u, err == nil
+}
+
+type uuidT struct{}
+
+func (uuidT) Type() query.Type { return sqltypes.Char }
+
+func (t uuidT) SQL(v interface{}) sqltypes.Value {
+	return sqltypes.MakeTrusted(sqltypes.Char, []byte(sql.MustConvert(t, v).(uuid).String()))
+}
+
+func (uuidT) Convert(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case uuid:
+		return v, nil
+	case string:
+		if u, ok := parseUUID(v, false); ok {
+			return u, nil
+		}
+		return nil, fmt.Errorf("invalid UUID %q", v)
+	case []byte:
+		if len(v) == 16 {
+			var u uuid
+			copy(u[:], v)
+			return u, nil
+		}
+		return nil, fmt.Errorf("invalid UUID of %d bytes", len(v))
+	default:
+		return nil, sql.ErrInvalidType.New(reflect.TypeOf(v))
+	}
+}
+
+func (t uuidT) Compare(a, b interface{}) (int, error) {
+	ua, err := t.Convert(a)
+	if err != nil {
+		return 0, err
+	}
+	ub, err := t.Convert(b)
+	if err != nil {
+		return 0, err
+	}
+	x, y := ua.(uuid), ub.(uuid)
+	return bytes.Compare(x[:], y[:]), nil
+}
+
+func newUUID(args []interface{}) (interface{}, error) {
+	var u uuid
+	if _, err := rand.Read(u[:]); err != nil {
+		return nil, err
+	}
+	u[6] = u[6]&0x0f | 0x40 // version 4
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+	return u.String(), nil
+}
+
+func isUUID(args []interface{}) (interface{}, error) {
+	s, err := stringArg(args, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	_, ok := parseUUID(s, true)
+	return ok, nil
+}
+
+// swapTime swaps the time low and time high parts of a UUID, as done by the
+// MySQL functions when their swap flag is set.
+func swapTime(u uuid) uuid {
+	var s uuid
+	copy(s[0:2], u[6:8])
+	copy(s[2:4], u[4:6])
+	copy(s[4:8], u[0:4])
+	copy(s[8:], u[8:])
+	return s
+}
+
+// unswapTime reverts swapTime.
+func unswapTime(s uuid) uuid {
+	var u uuid
+	copy(u[0:4], s[4:8])
+	copy(u[4:6], s[2:4])
+	copy(u[6:8], s[0:2])
+	copy(u[8:], s[8:])
+	return u
+}
+
+func uuidToBin(args []interface{}) (interface{}, error) {
+	s, err := stringArg(args, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	swap, err := intArg(args, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	u, ok := parseUUID(s, true)
+	if !ok {
+		return nil, fmt.Errorf("invalid UUID %q", s)
+	}
+	if swap != 0 {
+		u = swapTime(u)
+	}
+	return u[:], nil
+}
+
+func binToUUID(args []interface{}) (interface{}, error) {
+	v, err := UUID.Convert(args[0])
+	if err != nil {
+		return nil, err
+	}
+	swap, err := intArg(args, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	u := v.(uuid)
+	if swap != 0 {
+		u = unswapTime(u)
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,125 @@
+package csvql
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+// Violation describes a value in a CSV file not satisfying the constraints
+// declared in a schema.
+type Violation struct {
+	File string `json:"file"`
+	// Row is the number of the row containing the value, starting at 1 for the
+	// first row after the header. Violations found in the header have row 0.
+	Row    int    `json:"row"`
+	Column string `json:"column,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Error  string `json:"error"`
+}
+
+type columnValidator struct {
+	*ColumnSchema
+	index   int
+	typ     sql.Type
+	pattern *regexp.Regexp
+	seen    map[string]int
+}
+
+// Validate checks the rows in the given CSV file against the schema, calling
+// report for every violation found. The file is read in the dialect sniffed
+// from it, as the files of new databases are, but with its values as text, so
+// they are checked against the types of the schema.
+func Validate(path string, s *TableSchema, report func(Violation)) error {
+	d, err := fileDialect(path, nil)
+	if err != nil {
+		return err
+	}
+	d.InferRows, d.Schema = 0, nil
+	name, _ := splitFormat(path)
+	t, err := newFileTable(name, d, path)
+	if err != nil {
+		return err
+	}
+
+	var validators []*columnValidator
+	for _, col := range s.Columns {
+		if col.Expression != "" {
+			continue
+		}
+		idx := t.Schema().IndexOf(col.Name, t.Name())
+		if idx < 0 {
+			report(Violation{File: path, Column: col.Name, Error: "missing column"})
+			continue
+		}
+
+		v := &columnValidator{ColumnSchema: col, index: idx}
+		if v.typ, err = ParseType(col.Type); err != nil {
+			return fmt.Errorf("column %s: %v", col.Name, err)
+		}
+		if col.Pattern != "" {
+			if v.pattern, err = regexp.Compile(col.Pattern); err != nil {
+				return fmt.Errorf("column %s: invalid pattern: %v", col.Name, err)
+			}
+		}
+		if col.Unique {
+			v.seen = make(map[string]int)
+		}
+		validators = append(validators, v)
+	}
+
+	rows, err := plan.NewResolvedTable(t).RowIter(sql.NewEmptyContext())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for n := 1; ; n++ {
+		row, err := rows.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, v := range validators {
+			value := formatValue(row[v.index])
+			if msg := v.check(value, n); msg != "" {
+				report(Violation{File: path, Row: n, Column: v.Name, Value: value, Error: msg})
+			}
+		}
+	}
+}
+
+// check returns a description of the constraint violated by the value in the
+// given row, or an empty string if the value is valid.
+func (v *columnValidator) check(value string, row int) string {
+	var parsed interface{}
+	if value != "" {
+		var err error
+		if parsed, err = parseValue(v.typ, v.format(), value); err != nil {
+			return fmt.Sprintf("invalid %s value", TypeName(v.typ))
+		}
+	}
+	// Some values, like NaN in columns mapping it to NULL, parse as NULL.
+	if parsed == nil {
+		if v.NotNull {
+			return "null value in not null column"
+		}
+		return ""
+	}
+	if v.pattern != nil && !v.pattern.MatchString(value) {
+		return fmt.Sprintf("value does not match pattern %s", v.Pattern)
+	}
+	if v.seen != nil {
+		if first, ok := v.seen[value]; ok {
+			return fmt.Sprintf("duplicate value, first seen in row %d", first)
+		}
+		v.seen[value] = row
+	}
+	return ""
+}
@@ -0,0 +1,201 @@
+package csvql
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/analyzer"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+)
+
+// versionsDir is the directory, relative to the one of a database, where the
+// previous versions of its files are retained. Each version is named after
+// the time it was replaced, in nanoseconds since the Unix epoch.
+var versionsDir = filepath.Join(".csvql", "versions")
+
+// retainedVersions is the number of previous versions retained per table.
+var retainedVersions = 0
+
+// SetRetainedVersions sets how many previous versions of the files of a table
+// are retained when they are written, so they can be queried with AS OF.
+// No versions are retained by default.
+func SetRetainedVersions(n int) { retainedVersions = n }
+
+// timeTravel matches the tables read as they were at a given time. Since the
+// parser does not support AS OF, they are replaced with quoted table names,
+// which are then resolved by resolveTimeTravel.
+var timeTravel = regexp.MustCompile(`(?i)\b(from|join)\s+` + "`?(\\w+)`?" + `\s+as\s+of\s+` + quotedString)
+
+// asOfTable matches the table names written by rewriteTimeTravel.
+var asOfTable = regexp.MustCompile(`^(\w+) as of (.*)$`)
+
+// rewriteTimeTravel replaces the tables read AS OF a time in the query with
+// the quoted table names that resolveTimeTravel understands.
+func rewriteTimeTravel(query string) string {
+	return timeTravel.ReplaceAllStringFunc(query, func(s string) string {
+		m := timeTravel.FindStringSubmatch(s)
+		return m[1] + " " + quoteIdentifier(strings.ToLower(m[2])+" as of "+unquote(m[3]))
+	})
+}
+
+// asOfLayouts are the layouts accepted for the times given to AS OF, in the
+// time zone of the session unless they include one.
+var asOfLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func parseAsOf(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range asOfLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not parse time %q, expected YYYY-MM-DD hh:mm:ss", s)
+}
+
+// resolveTimeTravel is an analyzer rule replacing the tables read AS OF a
+// time with the version of their file that was current then.
+func resolveTimeTravel(ctx *sql.Context, a *analyzer.Analyzer, n sql.Node) (sql.Node, error) {
+	return n.TransformUp(func(n sql.Node) (sql.Node, error) {
+		ut, ok := n.(*plan.UnresolvedTable)
+		if !ok {
+			return n, nil
+		}
+		m := asOfTable.FindStringSubmatch(ut.Name())
+		if m == nil {
+			return n, nil
+		}
+		loc, err := sessionTimeZone(ctx)
+		if err != nil {
+			return nil, err
+		}
+		at, err := parseAsOf(m[2], loc)
+		if err != nil {
+			return nil, err
+		}
+		db, err := a.Catalog.Database(a.CurrentDatabase)
+		if err != nil {
+			return nil, err
+		}
+		cdb, ok := db.(*database)
+		if !ok {
+			return nil, fmt.Errorf("database %s keeps no versions of its tables", db.Name())
+		}
+		t, err := cdb.tableAsOf(m[1], at)
+		if err != nil {
+			return nil, err
+		}
+		return plan.NewResolvedTable(t), nil
+	})
+}
+
+// tableAsOf returns the table with the given name as it was at the given
+// time, or as close to it as the retained versions allow.
+func (db *database) tableAsOf(name string, at time.Time) (*table, error) {
+	t, ok := db.Tables()[name].(*table)
+	if !ok {
+		return nil, sql.ErrTableNotFound.New(name)
+	}
+	versions, err := db.versions(name)
+	if err != nil {
+		return nil, err
+	}
+	// The first version replaced after the time is the one current then.
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].After(at) })
+	if i == len(versions) {
+		return t, nil
+	}
+
+	return newFileTable(name, t.dialect, db.versionPath(name, versions[i]))
+}
+
+func (db *database) versionPath(name string, replaced time.Time) string {
+	return filepath.Join(db.path, versionsDir, name, strconv.FormatInt(replaced.UnixNano(), 10)+".csv")
+}
+
+// versions returns the times the retained versions of a table were replaced,
+// from the oldest to the newest.
+func (db *database) versions(name string) ([]time.Time, error) {
+	fis, err := ioutil.ReadDir(filepath.Join(db.path, versionsDir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read versions of %s: %v", name, err)
+	}
+	var times []time.Time
+	for _, fi := range fis {
+		ns, err := strconv.ParseInt(strings.TrimSuffix(fi.Name(), ".csv"), 10, 64)
+		if err != nil || filepath.Ext(fi.Name()) != ".csv" {
+			continue
+		}
+		times = append(times, time.Unix(0, ns))
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times, nil
+}
+
+// retain copies the file of a table before it is written, if versions are
+// retained, and removes the versions beyond the number retained.
+func (db *database) retain(name, path string) error {
+	if retainedVersions <= 0 {
+		return nil
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not retain version of %s: %v", name, err)
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("could not retain version of %s: %v", name, err)
+	}
+
+	dst := db.versionPath(name, time.Now())
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("could not retain version of %s: %v", name, err)
+	}
+	out, err := ioutil.TempFile(filepath.Dir(dst), "version.*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not retain version of %s: %v", name, err)
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	// The version keeps the modification time of the file, which is when it
+	// started being current.
+	if err == nil {
+		err = os.Chtimes(out.Name(), fi.ModTime(), fi.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return fmt.Errorf("could not retain version of %s: %v", name, err)
+	}
+
+	versions, err := db.versions(name)
+	if err != nil {
+		return err
+	}
+	for len(versions) > retainedVersions {
+		if err := os.Remove(db.versionPath(name, versions[0])); err != nil {
+			return fmt.Errorf("could not remove old version of %s: %v", name, err)
+		}
+		versions = versions[1:]
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+package csvql
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/parse"
+	"gopkg.in/src-d/go-mysql-server.v0/sql/plan"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// viewsDir is the directory, relative to the one of a database, where the
+// definitions and results of its materialized views are stored.
+const viewsDir = ".csvql/views"
+
+// view is a materialized view: a query whose results are stored in a CSV
+// file, read as any other table until the view is refreshed.
+type view struct {
+	Query string `yaml:"query"`
+	// AutoRefresh is set for views refreshed when their sources change.
+	AutoRefresh bool `yaml:"auto_refresh,omitempty"`
+	// User is the user that created the view, whose grants apply when it is
+	// refreshed.
+	User string `yaml:"user,omitempty"`
+
+	name string
+	// mtimes are the modification times of the sources when the view was
+	// last refreshed by this process.
+	mtimes map[string]time.Time
+}
+
+func init() {
+	statements = append(statements,
+		statement{
+			re:  regexp.MustCompile(`(?is)^\s*create\s+materialized\s+view\s+` + "`?(\\w+)`?" + `(\s+with\s+auto\s+refresh)?\s+as\s+(select\s.*?)\s*;?\s*$`),
+			run: (*Engine).createView,
+		},
+		statement{
+			re:  regexp.MustCompile(`(?is)^\s*refresh\s+materialized\s+view\s+` + "`?(\\w+)`?" + `\s*;?\s*$`),
+			run: (*Engine).refreshView,
+		},
+		statement{
+			re:  regexp.MustCompile(`(?is)^\s*drop\s+materialized\s+view\s+(if\s+exists\s+)?` + "`?(\\w+)`?" + `\s*;?\s*$`),
+			run: (*Engine).dropView,
+		},
+	)
+}
+
+// csvDatabase returns the current database, if it is a CSV one.
+func (e *Engine) csvDatabase() (*database, error) {
+	db, err := e.Catalog.Database(e.Analyzer.CurrentDatabase)
+	if err != nil {
+		return nil, err
+	}
+	cdb, ok := db.(*database)
+	if !ok {
+		return nil, fmt.Errorf("database %s is not a directory of CSV files", db.Name())
+	}
+	return cdb, nil
+}
+
+func (e *Engine) createView(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	db, err := e.csvDatabase()
+	if err != nil {
+		return nil, nil, err
+	}
+	name := strings.ToLower(m[1])
+	if err := e.checkWrite(ctx, name); err != nil {
+		return nil, nil, err
+	}
+	if _, ok := db.Tables()[name]; ok {
+		return nil, nil, fmt.Errorf("table %s already exists", name)
+	}
+
+	v := &view{Query: m[3], AutoRefresh: m[2] != "", User: ctx.Session.User(), name: name}
+	n, err := e.materialize(ctx, db, v)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ioutil.WriteFile(db.viewPath(name, ".yaml"), b, 0644); err != nil {
+		os.Remove(db.viewPath(name, ".csv"))
+		return nil, nil, fmt.Errorf("could not write view %s: %v", name, err)
+	}
+	db.mu.Lock()
+	db.views[name] = v
+	db.mu.Unlock()
+	return rowsAffected(n)
+}
+
+func (e *Engine) refreshView(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	db, err := e.csvDatabase()
+	if err != nil {
+		return nil, nil, err
+	}
+	name := strings.ToLower(m[1])
+	if err := e.checkWrite(ctx, name); err != nil {
+		return nil, nil, err
+	}
+	v, err := db.view(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	n, err := e.materialize(ctx, db, v)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rowsAffected(n)
+}
+
+func (e *Engine) dropView(ctx *sql.Context, m []string) (sql.Schema, sql.RowIter, error) {
+	db, err := e.csvDatabase()
+	if err != nil {
+		return nil, nil, err
+	}
+	name := strings.ToLower(m[2])
+	if err := e.checkWrite(ctx, name); err != nil {
+		return nil, nil, err
+	}
+	if _, err := db.view(name); err != nil {
+		if m[1] != "" {
+			return rowsAffected(0)
+		}
+		return nil, nil, err
+	}
+
+	db.mu.Lock()
+	delete(db.views, name)
+	delete(db.tables, name)
+	db.mu.Unlock()
+	for _, ext := range []string{".yaml", ".csv"} {
+		if err := os.Remove(db.viewPath(name, ext)); err != nil {
+			return nil, nil, fmt.Errorf("could not remove view %s: %v", name, err)
+		}
+	}
+	return rowsAffected(0)
+}
+
+// materialize runs the query of a view and replaces its results with the new
+// ones, returning how many rows there are.
+func (e *Engine) materialize(ctx *sql.Context, db *database, v *view) (int64, error) {
+	mtimes, err := db.sourceTimes(v)
+	if err != nil {
+		return 0, err
+	}
+	schema, rows, err := e.Query(ctx, v.Query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	if err := os.MkdirAll(filepath.Join(db.path, viewsDir), 0755); err != nil {
+		return 0, fmt.Errorf("could not create views directory: %v", err)
+	}
+	// The results are written to a temporary file first, so the view can be
+	// read while it is being refreshed.
+	f, err := tempFile("view")
+	if err != nil {
+		return 0, fmt.Errorf("could not create view %s: %v", v.name, err)
+	}
+	n, err := writeRows(f, "csv", "", schema, rows)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if _, serr := os.Stat(db.viewPath(v.name, ".csv")); err == nil && serr == nil {
+		err = db.retain(v.name, db.viewPath(v.name, ".csv"))
+	}
+	if err == nil {
+		err = moveFile(f.Name(), db.viewPath(v.name, ".csv"))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return 0, fmt.Errorf("could not materialize view %s: %v", v.name, err)
+	}
+
+	t, err := NewTable(db.viewPath(v.name, ".csv"))
+	if err != nil {
+		return 0, err
+	}
+	db.mu.Lock()
+	db.tables[v.name] = t
+	v.mtimes = mtimes
+	db.mu.Unlock()
+	return n, nil
+}
+
+// rowsAffected returns the result of a statement modifying n rows.
+func rowsAffected(n int64) (sql.Schema, sql.RowIter, error) {
+	return sql.Schema{{Name: "rows", Type: sql.Int64}}, sql.RowsToRowIter(sql.NewRow(n)), nil
+}
+
+// viewPath returns the path of the file with the given extension for a view.
+func (db *database) viewPath(name, ext string) string {
+	return filepath.Join(db.path, viewsDir, name+ext)
+}
+
+// view returns the materialized view with the given name.
+func (db *database) view(name string) (*view, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	v, ok := db.views[name]
+	if !ok {
+		return nil, fmt.Errorf("materialized view %s does not exist", name)
+	}
+	return v, nil
+}
+
+// loadViews adds the materialized views stored in the database directory to
+// its tables.
+func (db *database) loadViews() error {
+	paths, err := filepath.Glob(db.viewPath("*", ".yaml"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("could not read view: %v", err)
+		}
+		v := &view{name: strings.TrimSuffix(filepath.Base(path), ".yaml")}
+		if err := yaml.Unmarshal(b, v); err != nil {
+			return fmt.Errorf("could not parse view %s: %v", path, err)
+		}
+		t, err := NewTable(db.viewPath(v.name, ".csv"))
+		if err != nil {
+			return err
+		}
+		db.tables[v.name] = t
+		db.views[v.name] = v
+	}
+	return nil
+}
+
+// sourceTimes returns the modification times of the files read by the query
+// of a view.
+func (db *database) sourceTimes(v *view) (map[string]time.Time, error) {
+	tables := db.Tables()
+	var paths []string
+	branches, _, _ := splitUnion(v.Query)
+	for _, b := range branches {
+		n, err := parse.Parse(sql.NewEmptyContext(), rewrite(b))
+		if err != nil {
+			return nil, err
+		}
+		plan.Inspect(n, func(n sql.Node) bool {
+			if t, ok := n.(*plan.UnresolvedTable); ok {
+				if ct, ok := tables[t.Name()].(*table); ok {
+					paths = append(paths, ct.files...)
+				}
+			}
+			return true
+		})
+	}
+
+	mtimes := make(map[string]time.Time)
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		mtimes[path] = fi.ModTime()
+	}
+	return mtimes, nil
+}
+
+// stale reports whether any of the sources of a view changed after it was
+// last refreshed.
+func (db *database) stale(v *view) (bool, error) {
+	mtimes, err := db.sourceTimes(v)
+	if err != nil {
+		return false, err
+	}
+	db.mu.RLock()
+	last := v.mtimes
+	db.mu.RUnlock()
+
+	if last == nil {
+		// The view was refreshed by a previous process, so the best guess is
+		// the time its results were written.
+		fi, err := os.Stat(db.viewPath(v.name, ".csv"))
+		if err != nil {
+			return false, err
+		}
+		for _, mtime := range mtimes {
+			if mtime.After(fi.ModTime()) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	for path, mtime := range mtimes {
+		if !mtime.Equal(last[path]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// WatchViews checks periodically whether the sources of the materialized
+// views created with AUTO REFRESH changed, refreshing them if so. It never
+// returns, so it is usually run in its own goroutine.
+func (e *Engine) WatchViews(interval time.Duration) {
+	for range time.Tick(interval) {
+		for _, db := range e.Catalog.Databases {
+			cdb, ok := db.(*database)
+			if !ok {
+				continue
+			}
+			cdb.mu.RLock()
+			var views []*view
+			for _, v := range cdb.views {
+				if v.AutoRefresh {
+					views = append(views, v)
+				}
+			}
+			cdb.mu.RUnlock()
+
+			for _, v := range views {
+				stale, err := cdb.stale(v)
+				if err == nil && stale {
+					ctx := sql.NewContext(context.Background(), sql.WithSession(sql.NewSession("", v.User)))
+					_, err = e.materialize(ctx, cdb, v)
+				}
+				if err != nil {
+					log.Printf("could not refresh view %s: %v", v.name, err)
+				}
+			}
+		}
+	}
+}
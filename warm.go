@@ -0,0 +1,240 @@
+package csvql
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// statsDir is the directory, relative to the one of a database, where the
+// profiles of its tables are kept until their files change.
+var statsDir = filepath.Join(".csvql", "stats")
+
+// warmTopK is the number of most frequent values kept in the profiles
+// computed by Warm.
+const warmTopK = 5
+
+// cachedStats are the profiles of a table, and the state of its files when
+// they were computed.
+type cachedStats struct {
+	Files    []fileState      `json:"files"`
+	TopK     int              `json:"top_k"`
+	Profiles []*ColumnProfile `json:"profiles"`
+}
+
+type fileState struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// fileStates returns the current state of the files of a table.
+func fileStates(t *table) ([]fileState, error) {
+	return pathStates(t.files)
+}
+
+// pathStates returns the current state of the given files.
+func pathStates(paths []string) ([]fileState, error) {
+	var states []fileState
+	for _, path := range paths {
+		fi, err := statFile(path)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, fileState{path, fi.Size(), fi.ModTime()})
+	}
+	return states, nil
+}
+
+func sameFiles(a, b []fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Path != b[i].Path || a[i].Size != b[i].Size || !a[i].ModTime.Equal(b[i].ModTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// CachedProfile returns the same profiles as Profile, reusing the ones kept
+// in the database directory if the files of the table did not change since
+// they were computed, and keeping the new ones otherwise.
+func CachedProfile(ctx *sql.Context, db sql.Database, name string, topK int) ([]*ColumnProfile, error) {
+	t, ok := db.Tables()[name]
+	if !ok {
+		return nil, sql.ErrTableNotFound.New(name)
+	}
+	cdb, ok := db.(*database)
+	ct, isTable := t.(*table)
+	if !ok || !isTable || len(ct.pseudo) > 0 || ct.archived() {
+		return Profile(ctx, t, topK)
+	}
+
+	states, err := fileStates(ct)
+	if err != nil {
+		return nil, fmt.Errorf("could not profile %s: %v", name, err)
+	}
+	path := cdb.statsPath(name)
+	if b, err := ioutil.ReadFile(path); err == nil {
+		var cached cachedStats
+		if err := json.Unmarshal(b, &cached); err == nil && cached.TopK >= topK && sameFiles(cached.Files, states) {
+			for _, p := range cached.Profiles {
+				if len(p.TopValues) > topK {
+					p.TopValues = p.TopValues[:topK]
+				}
+			}
+			return cached.Profiles, nil
+		}
+	}
+
+	profiles, err := Profile(ctx, t, topK)
+	if err != nil {
+		return nil, err
+	}
+	// The profiles are still valid if they can not be kept, such as when the
+	// directory is read only.
+	if err := writeStats(path, &cachedStats{states, topK, profiles}); err != nil {
+		log.Printf("could not keep the profiles of %s: %v", name, err)
+	}
+	return profiles, nil
+}
+
+// statsPath returns the path of the file keeping the profiles of a table.
+func (db *database) statsPath(name string) string {
+	return filepath.Join(db.path, statsDir, name+".json")
+}
+
+// knownRows returns the number of rows of a table of a database when it is
+// known without reading them, from the types inferred for its columns or
+// its profiles, if they were computed from all the rows of its files as they
+// are now.
+func (t *table) knownRows(db sql.Database, name string) (int64, bool) {
+	if n, ok := t.cachedRows(); ok {
+		return n, true
+	}
+	cdb, ok := db.(*database)
+	if !ok {
+		return 0, false
+	}
+	profiles, ok := cdb.freshStats(name, t)
+	if !ok || len(profiles) == 0 {
+		return 0, false
+	}
+	return profiles[0].Count, true
+}
+
+// freshStats returns the profiles kept for a table of the database, by Warm
+// or ANALYZE TABLE, if its files did not change since they were computed.
+func (db *database) freshStats(name string, t *table) ([]*ColumnProfile, bool) {
+	if t.archived() {
+		return nil, false
+	}
+	b, err := ioutil.ReadFile(db.statsPath(name))
+	if err != nil {
+		return nil, false
+	}
+	var cached cachedStats
+	if err := json.Unmarshal(b, &cached); err != nil {
+		return nil, false
+	}
+	states, err := fileStates(t)
+	if err != nil || !sameFiles(cached.Files, states) {
+		return nil, false
+	}
+	return cached.Profiles, true
+}
+
+// writeStats atomically writes the profiles of a table.
+func writeStats(path string, stats *cachedStats) error {
+	b, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(path, b)
+}
+
+// writeAtomic writes a file through a temporary one, so it is never seen
+// half written, creating its directory if needed.
+func writeAtomic(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// WarmResult describes the work done by Warm on a table.
+type WarmResult struct {
+	Table string
+	// Refreshed is set for the materialized views whose sources changed.
+	Refreshed bool
+	Rows      int64
+	Duration  time.Duration
+}
+
+// Warm refreshes the stale materialized views of the current database and
+// computes the profiles of its tables ahead of the queries needing them, so
+// they are not slower for being the first ones. If no tables are given, all
+// of them are warmed.
+func (e *Engine) Warm(ctx *sql.Context, tables ...string) ([]*WarmResult, error) {
+	db, err := e.csvDatabase()
+	if err != nil {
+		return nil, err
+	}
+	if len(tables) == 0 {
+		for name := range db.Tables() {
+			tables = append(tables, name)
+		}
+		sort.Strings(tables)
+	}
+
+	var results []*WarmResult
+	for _, name := range tables {
+		start := time.Now()
+		res := &WarmResult{Table: name}
+		if v, err := db.view(name); err == nil {
+			stale, err := db.stale(v)
+			if err != nil {
+				return nil, fmt.Errorf("could not warm %s: %v", name, err)
+			}
+			if stale {
+				if _, err := e.materialize(ctx, db, v); err != nil {
+					return nil, err
+				}
+				res.Refreshed = true
+			}
+		}
+		profiles, err := CachedProfile(ctx, db, name, warmTopK)
+		if err != nil {
+			return nil, fmt.Errorf("could not warm %s: %v", name, err)
+		}
+		if len(profiles) > 0 {
+			res.Rows = profiles[0].Count
+		}
+		res.Duration = time.Since(start)
+		results = append(results, res)
+	}
+	return results, nil
+}
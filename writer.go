@@ -0,0 +1,176 @@
+package csvql
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// Formats lists the output formats supported by NewRowWriter.
+var Formats = []string{"csv", "tsv", "jsonl", "parquet", "table"}
+
+// RowWriter writes rows to an output in a specific file format.
+// Close must be called once all the rows have been written.
+type RowWriter interface {
+	Write(sql.Row) error
+	Close() error
+}
+
+// NewRowWriter returns a RowWriter that writes rows with the given schema to
+// w in the given format.
+func NewRowWriter(w io.Writer, format string, schema sql.Schema) (RowWriter, error) {
+	switch format {
+	case "csv":
+		return newCSVWriter(w, ',', schema)
+	case "tsv":
+		return newCSVWriter(w, '\t', schema)
+	case "jsonl":
+		return &jsonlWriter{w: bufio.NewWriter(w), schema: schema}, nil
+	case "parquet":
+		return newParquetWriter(w, schema), nil
+	case "table":
+		return newTableWriter(w, schema), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected one of %v", format, Formats)
+	}
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVWriter(w io.Writer, comma rune, schema sql.Schema) (*csvWriter, error) {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+
+	header := make([]string, len(schema))
+	for i, col := range schema {
+		header[i] = col.Name
+	}
+	if err := cw.Write(header); err != nil {
+		return nil, err
+	}
+	return &csvWriter{w: cw, record: make([]string, len(schema))}, nil
+}
+
+func (w *csvWriter) Write(row sql.Row) error {
+	for i, v := range row {
+		w.record[i] = formatValue(v)
+	}
+	return w.w.Write(w.record)
+}
+
+func (w *csvWriter) Close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+type jsonlWriter struct {
+	w      *bufio.Writer
+	schema sql.Schema
+}
+
+// Write writes the row as a JSON object, keeping the keys in schema order.
+func (w *jsonlWriter) Write(row sql.Row) error {
+	w.w.WriteByte('{')
+	for i, v := range row {
+		if i > 0 {
+			w.w.WriteByte(',')
+		}
+		name, err := json.Marshal(w.schema[i].Name)
+		if err != nil {
+			return err
+		}
+		w.w.Write(name)
+		w.w.WriteByte(':')
+
+		switch x := v.(type) {
+		case time.Time:
+			v = formatValue(x)
+		case float64:
+			// JSON has no representation for NaN and infinities.
+			if math.IsNaN(x) || math.IsInf(x, 0) {
+				v = formatValue(x)
+			}
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		w.w.Write(value)
+	}
+	w.w.WriteString("}\n")
+	return nil
+}
+
+func (w *jsonlWriter) Close() error { return w.w.Flush() }
+
+// tableWriter writes rows as a human readable table with aligned columns.
+type tableWriter struct {
+	w      *tabwriter.Writer
+	fields []string
+}
+
+func newTableWriter(w io.Writer, schema sql.Schema) *tableWriter {
+	tw := &tableWriter{
+		w:      tabwriter.NewWriter(w, 0, 8, 2, ' ', 0),
+		fields: make([]string, len(schema)),
+	}
+	for i, col := range schema {
+		tw.fields[i] = col.Name
+	}
+	tw.writeFields()
+	return tw
+}
+
+func (w *tableWriter) Write(row sql.Row) error {
+	for i, v := range row {
+		if v == nil {
+			w.fields[i] = "NULL"
+		} else {
+			w.fields[i] = strings.Replace(formatValue(v), "\t", " ", -1)
+		}
+	}
+	return w.writeFields()
+}
+
+func (w *tableWriter) writeFields() error {
+	_, err := io.WriteString(w.w, strings.Join(w.fields, "\t")+"\n")
+	return err
+}
+
+func (w *tableWriter) Close() error { return w.w.Flush() }
+
+// formatValue returns the textual representation of a value, with NULL
+// represented as an empty string.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case time.Time:
+		if v.Equal(v.Truncate(24 * time.Hour)) {
+			return v.Format(sql.DateLayout)
+		}
+		return v.Format(sql.TimestampLayout)
+	default:
+		return fmt.Sprint(v)
+	}
+}
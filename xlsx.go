@@ -0,0 +1,579 @@
+package csvql
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// This file contains a reader of the worksheets in Excel workbooks, which are
+// zip files of XML documents described in ECMA-376.
+
+// xlsxBook is an open workbook.
+type xlsxBook struct {
+	zr       *zip.ReadCloser
+	sheets   []xlsxSheet
+	strings  []string
+	date1904 bool
+	// dates are the styles of the cells whose numbers are dates.
+	dates map[int]bool
+}
+
+type xlsxSheet struct {
+	name string
+	path string
+}
+
+func openWorkbook(path string) (*xlsxBook, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %v", path, err)
+	}
+	b := &xlsxBook{zr: zr, dates: make(map[int]bool)}
+	if err := b.load(); err != nil {
+		zr.Close()
+		return nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+	return b, nil
+}
+
+func (b *xlsxBook) Close() error { return b.zr.Close() }
+
+func (b *xlsxBook) load() error {
+	var wb struct {
+		Pr struct {
+			Date1904 bool `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := b.readXML("xl/workbook.xml", &wb, false); err != nil {
+		return err
+	}
+	b.date1904 = wb.Pr.Date1904
+
+	var rels struct {
+		Rels []struct {
+			ID     string `xml:"Id,attr"`
+			Type   string `xml:"Type,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := b.readXML("xl/_rels/workbook.xml.rels", &rels, false); err != nil {
+		return err
+	}
+	// Only worksheets have cells, and not the sheets of charts, dialogs or
+	// macros.
+	targets := make(map[string]string)
+	for _, r := range rels.Rels {
+		if !strings.HasSuffix(r.Type, "/worksheet") {
+			continue
+		}
+		if strings.HasPrefix(r.Target, "/") {
+			targets[r.ID] = strings.TrimPrefix(r.Target, "/")
+		} else {
+			targets[r.ID] = path.Join("xl", r.Target)
+		}
+	}
+	for _, s := range wb.Sheets {
+		if p, ok := targets[s.ID]; ok {
+			b.sheets = append(b.sheets, xlsxSheet{name: s.Name, path: p})
+		}
+	}
+
+	var sst struct {
+		Items []xlsxText `xml:"si"`
+	}
+	if err := b.readXML("xl/sharedStrings.xml", &sst, true); err != nil {
+		return err
+	}
+	for _, si := range sst.Items {
+		b.strings = append(b.strings, si.text())
+	}
+
+	var styles struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		Xfs []struct {
+			NumFmt int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if err := b.readXML("xl/styles.xml", &styles, true); err != nil {
+		return err
+	}
+	custom := make(map[int]bool)
+	for _, f := range styles.NumFmts {
+		custom[f.ID] = isDateFormat(f.Code)
+	}
+	for i, xf := range styles.Xfs {
+		if date, ok := custom[xf.NumFmt]; ok {
+			b.dates[i] = date
+		} else {
+			b.dates[i] = xf.NumFmt >= 14 && xf.NumFmt <= 22 || xf.NumFmt >= 45 && xf.NumFmt <= 47
+		}
+	}
+	return nil
+}
+
+// readXML decodes a document of the workbook, which can be missing if it is
+// optional.
+func (b *xlsxBook) readXML(name string, v interface{}, optional bool) error {
+	for _, f := range b.zr.File {
+		if f.Name != name {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return xml.NewDecoder(r).Decode(v)
+	}
+	if optional {
+		return nil
+	}
+	return fmt.Errorf("missing %s", name)
+}
+
+// isDateFormat reports whether a number format shows dates or times, which
+// is when it has any of their placeholders outside of quotes and brackets.
+func isDateFormat(code string) bool {
+	quoted, bracket := false, false
+	for i := 0; i < len(code); i++ {
+		switch c := code[i]; {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '\\':
+			i++
+		case c == '[':
+			bracket = true
+		case c == ']':
+			bracket = false
+		case bracket:
+		case strings.IndexByte("dmyhsDMYHS", c) >= 0:
+			return true
+		}
+	}
+	return false
+}
+
+// sheet returns the worksheet with the given name, or the first one if the
+// name is empty.
+func (b *xlsxBook) sheet(name string) (xlsxSheet, error) {
+	for _, s := range b.sheets {
+		if name == "" || strings.EqualFold(s.name, name) {
+			return s, nil
+		}
+	}
+	if name == "" {
+		return xlsxSheet{}, fmt.Errorf("workbook has no worksheets")
+	}
+	return xlsxSheet{}, fmt.Errorf("workbook has no worksheet %s", name)
+}
+
+// xlsxText is a string in a worksheet, which is made of runs when parts of
+// it have different formats.
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t *xlsxText) text() string {
+	s := t.T
+	for _, r := range t.Runs {
+		s += r.T
+	}
+	return s
+}
+
+// xlsxCell is the value of a cell: nil, a float64, a bool, a time.Time, or a
+// string, along with its text.
+type xlsxCell struct {
+	value interface{}
+	text  string
+}
+
+// rows returns the cells in each row of a worksheet, from the first one.
+func (b *xlsxBook) rows(s xlsxSheet) ([][]xlsxCell, error) {
+	var f *zip.File
+	for _, zf := range b.zr.File {
+		if zf.Name == s.path {
+			f = zf
+		}
+	}
+	if f == nil {
+		return nil, fmt.Errorf("missing %s", s.path)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	BytesRead.Add(int64(f.UncompressedSize64))
+
+	var rows [][]xlsxCell
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return nil, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch se.Name.Local {
+		case "row":
+			n := len(rows) + 1
+			for _, a := range se.Attr {
+				if a.Name.Local == "r" {
+					if n, err = strconv.Atoi(a.Value); err != nil || n <= len(rows) {
+						return nil, fmt.Errorf("invalid row %q", a.Value)
+					}
+				}
+			}
+			for len(rows) < n {
+				rows = append(rows, nil)
+			}
+		case "c":
+			var c struct {
+				Ref    string    `xml:"r,attr"`
+				Type   string    `xml:"t,attr"`
+				Style  int       `xml:"s,attr"`
+				Value  string    `xml:"v"`
+				Inline *xlsxText `xml:"is"`
+			}
+			if err := dec.DecodeElement(&c, &se); err != nil {
+				return nil, err
+			}
+			if len(rows) == 0 {
+				rows = append(rows, nil)
+			}
+			row := &rows[len(rows)-1]
+			col := len(*row)
+			if c.Ref != "" {
+				if col = columnIndex(c.Ref); col < 0 {
+					return nil, fmt.Errorf("invalid cell %q", c.Ref)
+				}
+			}
+			cell, err := b.cell(c.Type, c.Style, c.Value, c.Inline)
+			if err != nil {
+				return nil, fmt.Errorf("could not read cell %s: %v", c.Ref, err)
+			}
+			for len(*row) <= col {
+				*row = append(*row, xlsxCell{})
+			}
+			(*row)[col] = cell
+		}
+	}
+}
+
+func (b *xlsxBook) cell(typ string, style int, v string, inline *xlsxText) (xlsxCell, error) {
+	switch typ {
+	case "s":
+		if v == "" {
+			return xlsxCell{}, nil
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 || i >= len(b.strings) {
+			return xlsxCell{}, fmt.Errorf("invalid shared string %q", v)
+		}
+		return xlsxCell{b.strings[i], b.strings[i]}, nil
+	case "str":
+		return xlsxCell{v, v}, nil
+	case "inlineStr":
+		if inline == nil {
+			return xlsxCell{}, nil
+		}
+		s := inline.text()
+		return xlsxCell{s, s}, nil
+	case "b":
+		return xlsxCell{v == "1", strconv.FormatBool(v == "1")}, nil
+	case "e":
+		// Errors, such as #DIV/0!, are NULL.
+		return xlsxCell{}, nil
+	case "d":
+		t, err := time.Parse("2006-01-02T15:04:05", strings.TrimSuffix(v, "Z"))
+		if err != nil {
+			return xlsxCell{}, err
+		}
+		return xlsxCell{t, formatValue(t)}, nil
+	}
+	if v == "" {
+		return xlsxCell{}, nil
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return xlsxCell{}, err
+	}
+	if b.dates[style] {
+		t := b.date(n)
+		return xlsxCell{t, formatValue(t)}, nil
+	}
+	return xlsxCell{n, v}, nil
+}
+
+// date returns the time of a date serial number, which counts the days since
+// the epoch of the workbook. Excel counts a February 29, 1900 that did not
+// exist, so before March 1900 the days are counted from December 31, 1899.
+func (b *xlsxBook) date(n float64) time.Time {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if b.date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	days := math.Floor(n)
+	ms := math.Round((n - days) * 24 * 60 * 60 * 1000)
+	if !b.date1904 && days >= 1 && days < 60 {
+		days++
+	}
+	return epoch.AddDate(0, 0, int(days)).Add(time.Duration(ms) * time.Millisecond)
+}
+
+// columnIndex returns the index of the column of a cell reference, such as 2
+// for C7.
+func columnIndex(ref string) int {
+	col := 0
+	i := 0
+	for ; i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z'; i++ {
+		col = col*26 + int(ref[i]-'A'+1)
+	}
+	if i == 0 || i == len(ref) {
+		return -1
+	}
+	return col - 1
+}
+
+// columnName returns the letters naming the column with the given index.
+func columnName(i int) string {
+	var name []byte
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = append([]byte{byte('a' + (i-1)%26)}, name...)
+	}
+	return string(name)
+}
+
+// workbookTables returns a table per worksheet of a workbook, named after
+// the workbook and the worksheet, as in book.sheet1.
+func workbookTables(name string, d Dialect, path string) ([]*table, error) {
+	b, err := openWorkbook(path)
+	if err != nil {
+		return nil, err
+	}
+	sheets := b.sheets
+	b.Close()
+
+	var tables []*table
+	for _, s := range sheets {
+		sd := d
+		sd.Sheet = s.name
+		t, err := newFileTable(name+"."+strings.ToLower(s.name), sd, path)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+// readSheet returns the rows of the worksheet a table reads from a workbook,
+// starting with the one with the names of the columns, and skipping the ones
+// that are empty, with their numbers in the worksheet.
+func readSheet(path string, d Dialect) ([][]xlsxCell, []int, error) {
+	b, err := openWorkbook(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer b.Close()
+	s, err := b.sheet(d.Sheet)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+	rows, err := b.rows(s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read worksheet %s of %s: %v", s.name, path, err)
+	}
+
+	header := d.HeaderRow
+	if header == 0 {
+		header = 1
+	}
+	if header > len(rows) {
+		return nil, nil, fmt.Errorf("could not read worksheet %s of %s: it has no row %d", s.name, path, header)
+	}
+	res, numbers := [][]xlsxCell{rows[header-1]}, []int{header}
+	for n, row := range rows[header:] {
+		for _, c := range row {
+			if c.value != nil {
+				res = append(res, row)
+				numbers = append(numbers, header+n+1)
+				break
+			}
+		}
+	}
+	return res, numbers, nil
+}
+
+// sheetSchema returns the columns of a table read from worksheets, whose
+// types are the ones of the values in their cells, unless they are read as
+// text.
+func sheetSchema(name string, d Dialect, paths []string) ([]*sql.Column, error) {
+	var schema []*sql.Column
+	for i, path := range paths {
+		rows, _, err := readSheet(path, d)
+		if err != nil {
+			return nil, err
+		}
+		width := 0
+		for _, row := range rows {
+			if len(row) > width {
+				width = len(row)
+			}
+		}
+
+		var cols []*sql.Column
+		for c := 0; c < width; c++ {
+			col := &sql.Column{Name: columnName(c), Type: sql.Text, Nullable: true, Source: name}
+			if c < len(rows[0]) && rows[0][c].text != "" {
+				col.Name = strings.ToLower(strings.TrimSpace(rows[0][c].text))
+			}
+			if !d.TextCells {
+				col.Type = cellsType(rows[1:], c)
+			}
+			cols = append(cols, col)
+		}
+		if i == 0 {
+			schema = cols
+			continue
+		}
+		if len(cols) != len(schema) {
+			return nil, fmt.Errorf("could not add %s to table %s: its columns differ from the ones of %s", path, name, paths[0])
+		}
+		for j, col := range cols {
+			if col.Name != schema[j].Name || col.Type != schema[j].Type {
+				return nil, fmt.Errorf("could not add %s to table %s: its columns differ from the ones of %s", path, name, paths[0])
+			}
+		}
+	}
+	return schema, nil
+}
+
+// cellsType returns the type of the values in a column of a worksheet:
+// BIGINT or DOUBLE for numbers, DATE or TIMESTAMP for dates, BOOLEAN, or
+// TEXT if there are several kinds of values or none.
+func cellsType(rows [][]xlsxCell, col int) sql.Type {
+	var typ sql.Type
+	for _, row := range rows {
+		if col >= len(row) || row[col].value == nil {
+			continue
+		}
+		var t sql.Type
+		switch v := row[col].value.(type) {
+		case float64:
+			t = sql.Int64
+			if v != math.Trunc(v) || math.Abs(v) >= 1<<53 {
+				t = sql.Float64
+			}
+		case time.Time:
+			t = sql.Date
+			if !v.Equal(v.Truncate(24 * time.Hour)) {
+				t = sql.Timestamp
+			}
+		case bool:
+			t = sql.Boolean
+		default:
+			return sql.Text
+		}
+		switch {
+		case typ == nil || typ == t:
+			typ = t
+		case typ == sql.Int64 && t == sql.Float64, typ == sql.Date && t == sql.Timestamp:
+			typ = t
+		case typ == sql.Float64 && t == sql.Int64, typ == sql.Timestamp && t == sql.Date:
+		default:
+			return sql.Text
+		}
+	}
+	if typ == nil {
+		return sql.Text
+	}
+	return typ
+}
+
+// newSheetRowIter returns an iterator over the rows of the worksheet a table
+// reads from a workbook.
+func newSheetRowIter(t *table, path string) (sql.RowIter, error) {
+	rows, numbers, err := readSheet(path, t.dialect)
+	if err != nil {
+		return nil, err
+	}
+	var src *rowSource
+	if len(t.pseudo) > 0 {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		src = &rowSource{path: path, info: info}
+	}
+	return &sheetRowIter{t: t, rows: rows[1:], numbers: numbers[1:], src: src}, nil
+}
+
+type sheetRowIter struct {
+	t    *table
+	rows [][]xlsxCell
+	// numbers are the ones of the rows in the worksheet.
+	numbers []int
+	src     *rowSource
+}
+
+func (i *sheetRowIter) Next() (sql.Row, error) {
+	if len(i.rows) == 0 {
+		return nil, io.EOF
+	}
+	cells, number := i.rows[0], i.numbers[0]
+	i.rows, i.numbers = i.rows[1:], i.numbers[1:]
+
+	cols := i.t.schema[:len(i.t.schema)-len(i.t.pseudo)]
+	row := make(sql.Row, len(i.t.schema))
+	for c, col := range cols {
+		if c >= len(cells) || cells[c].value == nil {
+			continue
+		}
+		if col.Type == sql.Text {
+			row[c] = cells[c].text
+			continue
+		}
+		v, err := col.Type.Convert(cells[c].value)
+		if err != nil {
+			return nil, fmt.Errorf("could not read column %s of %s: %v", col.Name, i.t.name, err)
+		}
+		row[c] = v
+	}
+	if i.src != nil {
+		i.src.line = int64(number)
+	}
+	for j, col := range i.t.pseudo {
+		row[len(cols)+j] = col.value(i.src)
+	}
+	RowsRead.Add(1)
+	return row, nil
+}
+
+func (i *sheetRowIter) Close() error { return nil }
@@ -0,0 +1,144 @@
+package csvql
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-mysql-server.v0/sql"
+)
+
+// TestXLSXRoundTrip reads the orders workbook, written by excelize from the
+// rows it expects, with a second worksheet written by its stream writer.
+func TestXLSXRoundTrip(t *testing.T) {
+	// The empty row between the orders is skipped, the number format of wait
+	// has quoted letters which are not the ones of dates, and the note of
+	// the first order is rich text.
+	want := []sql.Row{
+		{
+			int64(1), "Ana", 12.5, true,
+			time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 29, 13, 14, 15, 0, time.UTC),
+			1.5, "gift ",
+		},
+		{
+			int64(2), "Bo", -3.0, false,
+			time.Date(1900, 1, 15, 0, 0, 0, 0, time.UTC),
+			time.Date(1999, 12, 31, 23, 59, 59, 0, time.UTC),
+			0.25, "ok",
+		},
+		{
+			int64(3), "Ção & <Lda>", 1e15, nil,
+			time.Date(1900, 3, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC),
+			nil, nil,
+		},
+	}
+	path := filepath.Join("testdata", "orders.xlsx")
+	if got := tableRows(t, path, "SELECT * FROM t"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got rows\n%#v\nwant\n%#v", got, want)
+	}
+
+	got := tableRows(t, path+";sheet=big data", "SELECT COUNT(*), SUM(n), SUM(square), MAX(label) FROM t")
+	if want := []sql.Row{{int32(1000), float64(500500), float64(83458375), "row"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("big data worksheet: got %v, want %v", got, want)
+	}
+}
+
+// TestXLSXFixtures reads workbooks of the tests of excelize and tealeg/xlsx,
+// written by Excel, Excel for Mac and other programs.
+func TestXLSXFixtures(t *testing.T) {
+	testCases := []struct {
+		file  string
+		query string
+		want  []sql.Row
+	}{
+		{
+			// Rich text, an inline string, the cached values of formulas,
+			// and a header with empty cells.
+			"Book1.xlsx;sheet=Sheet2", "SELECT brand, d FROM t WHERE b IS NULL ORDER BY d",
+			[]sql.Row{{"Other", int64(37)}, {"SAMSUNG", int64(53)}, {"ASUS", int64(89)}, {"IBM", int64(127)}, {"Acer", int64(315)}, {"Apple", int64(348)}},
+		},
+		{
+			"Book1.xlsx;sheet=Sheet2", "SELECT COUNT(*), SUM(d), COUNT(monitor), COUNT(inlinestr), COUNT(f) FROM t",
+			[]sql.Row{{int32(10), float64(2329), int32(4), int32(0), int32(0)}},
+		},
+		{
+			"Book1.xlsx;header=19", "SELECT `total:`, `237`, c, d FROM t",
+			[]sql.Row{{nil, nil, "Column1", "Column2"}, {"GitHub", nil, nil, nil}},
+		},
+		{
+			// Excel for Mac dates, from 1904, and an error, in a column
+			// with several kinds of values.
+			"testcelltypes.xlsx", "SELECT * FROM t",
+			[]sql.Row{
+				{"日本語", "string"}, {"12345", "int"}, {"1.024", "float"}, {"2015-01-01", "date"},
+				{"true", "bool"}, {"30", "formula"}, {nil, "error"},
+			},
+		},
+		{
+			// Prefixed namespaces, and shared strings without values.
+			"namespaced.xlsx", "SELECT * FROM t",
+			[]sql.Row{{"w", "w", nil, nil, "w", "w", "w", "w", "w", "w", nil, "w", nil}},
+		},
+		{
+			// The first sheet holds a chart, and not cells.
+			"testchartsheet.xlsx", "SELECT COUNT(*), SUM(value) FROM t",
+			[]sql.Row{{int32(4), float64(10)}},
+		},
+	}
+	for _, tc := range testCases {
+		got := tableRows(t, filepath.Join("testdata", tc.file), tc.query)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: %s: got rows\n%#v\nwant\n%#v", tc.file, tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestXLSXChartsheets(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "testchartsheet.xlsx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, _ := testEngine(t, map[string]string{"charts.xlsx": string(b)})
+	if got, want := queryRows(t, e, "SELECT value FROM `charts.sheet1` ORDER BY value DESC LIMIT 1"), []sql.Row{{int64(4)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, _, err := e.Query(sql.NewEmptyContext(), "SELECT * FROM `charts.chart1`"); err == nil {
+		t.Error("got a table for the sheet of the chart")
+	}
+}
+
+func TestXLSXInvalidWorkbooks(t *testing.T) {
+	dir := t.TempDir()
+	var empty bytes.Buffer
+	if err := zip.NewWriter(&empty).Close(); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string][]byte{
+		"text.xlsx":  []byte("id,name\n1,ana\n"),
+		"empty.xlsx": empty.Bytes(),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orders := filepath.Join("testdata", "orders.xlsx")
+	for _, tt := range []struct{ spec, want string }{
+		{filepath.Join(dir, "text.xlsx"), "not a valid zip file"},
+		{filepath.Join(dir, "empty.xlsx"), "missing"},
+		{orders + ";sheet=missing", "no worksheet missing"},
+		{orders + ";header=1000", "has no row 1000"},
+		{orders + ";header=0", "invalid header row"},
+	} {
+		if err := tableError(t, tt.spec); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %s", tt.spec, err, tt.want)
+		}
+	}
+}